@@ -2,6 +2,7 @@ package ast
 
 import (
 	"bytes"
+	"strconv"
 	"strings"
 
 	"github.com/vegarsti/sql/token"
@@ -54,6 +55,7 @@ type SelectStatement struct {
 	Limit       *int
 	Offset      *int
 	Where       Expression
+	GroupBy     []Expression
 }
 
 func (es *SelectStatement) statementNode()       {}
@@ -73,6 +75,9 @@ type CreateTableStatement struct {
 	Name        string
 	ColumnNames []string
 	ColumnTypes []token.Token
+	// ColumnTypeModifiers are the numbers in parentheses after the type of each column, such as
+	// the precision 10 and scale 2 of NUMERIC(10, 2), or nil for a column without them
+	ColumnTypeModifiers [][]int64
 }
 
 func (cts *CreateTableStatement) statementNode()       {}
@@ -81,6 +86,13 @@ func (cts *CreateTableStatement) String() string {
 	columns := make([]string, len(cts.ColumnNames))
 	for i := range cts.ColumnNames {
 		columns[i] = cts.ColumnNames[i] + " " + cts.ColumnTypes[i].Literal
+		if i < len(cts.ColumnTypeModifiers) && cts.ColumnTypeModifiers[i] != nil {
+			modifiers := make([]string, len(cts.ColumnTypeModifiers[i]))
+			for j, m := range cts.ColumnTypeModifiers[i] {
+				modifiers[j] = strconv.FormatInt(m, 10)
+			}
+			columns[i] += "(" + strings.Join(modifiers, ", ") + ")"
+		}
 	}
 	return "CREATE TABLE " + cts.Name + " " + "(" + strings.Join(columns, ", ") + ")"
 }
@@ -104,6 +116,116 @@ func (is *InsertStatement) String() string {
 	return "INSERT INTO " + is.TableName + " VALUES " + strings.Join(rows, ", ")
 }
 
+// DeleteStatement deletes the rows in a table for which Where is true, or all rows if Where is nil
+type DeleteStatement struct {
+	TableName string
+	Where     Expression
+}
+
+func (ds *DeleteStatement) statementNode()       {}
+func (ds *DeleteStatement) TokenLiteral() string { return "DELETE" }
+func (ds *DeleteStatement) String() string {
+	if ds.Where == nil {
+		return "DELETE FROM " + ds.TableName
+	}
+	return "DELETE FROM " + ds.TableName + " WHERE " + ds.Where.String()
+}
+
+// ExplainStatement shows the plan of a statement, or runs it and shows statistics for the plan if Analyze is set
+type ExplainStatement struct {
+	Statement Statement
+	Analyze   bool
+}
+
+func (es *ExplainStatement) statementNode()       {}
+func (es *ExplainStatement) TokenLiteral() string { return "EXPLAIN" }
+func (es *ExplainStatement) String() string {
+	if es.Analyze {
+		return "EXPLAIN ANALYZE " + es.Statement.String()
+	}
+	return "EXPLAIN " + es.Statement.String()
+}
+
+// AnalyzeStatement computes statistics for a table, or for all tables if TableName is empty
+type AnalyzeStatement struct {
+	TableName string
+}
+
+func (as *AnalyzeStatement) statementNode()       {}
+func (as *AnalyzeStatement) TokenLiteral() string { return "ANALYZE" }
+func (as *AnalyzeStatement) String() string {
+	if as.TableName == "" {
+		return "ANALYZE"
+	}
+	return "ANALYZE " + as.TableName
+}
+
+// PrepareStatement saves a statement with parameters under a name, to be executed later
+type PrepareStatement struct {
+	Name      string
+	Statement Statement
+}
+
+func (ps *PrepareStatement) statementNode()       {}
+func (ps *PrepareStatement) TokenLiteral() string { return "PREPARE" }
+func (ps *PrepareStatement) String() string {
+	return "PREPARE " + ps.Name + " AS " + ps.Statement.String()
+}
+
+// ExecuteStatement executes a prepared statement with the arguments as the values of its parameters
+type ExecuteStatement struct {
+	Name      string
+	Arguments []Expression
+}
+
+func (es *ExecuteStatement) statementNode()       {}
+func (es *ExecuteStatement) TokenLiteral() string { return "EXECUTE" }
+func (es *ExecuteStatement) String() string {
+	if len(es.Arguments) == 0 {
+		return "EXECUTE " + es.Name
+	}
+	arguments := make([]string, len(es.Arguments))
+	for i, a := range es.Arguments {
+		arguments[i] = a.String()
+	}
+	return "EXECUTE " + es.Name + " (" + strings.Join(arguments, ", ") + ")"
+}
+
+// DeallocateStatement removes a prepared statement, or all of them if All is set
+type DeallocateStatement struct {
+	Name string
+	All  bool
+}
+
+func (ds *DeallocateStatement) statementNode()       {}
+func (ds *DeallocateStatement) TokenLiteral() string { return "DEALLOCATE" }
+func (ds *DeallocateStatement) String() string {
+	if ds.All {
+		return "DEALLOCATE ALL"
+	}
+	return "DEALLOCATE " + ds.Name
+}
+
+// SetStatement sets a configuration parameter of the session
+type SetStatement struct {
+	Name  string
+	Value Expression
+}
+
+func (ss *SetStatement) statementNode()       {}
+func (ss *SetStatement) TokenLiteral() string { return "SET" }
+func (ss *SetStatement) String() string       { return "SET " + ss.Name + " = " + ss.Value.String() }
+
+// TransactionStatement begins, commits or rolls back a transaction.
+// The token is BEGIN, COMMIT or ROLLBACK.
+type TransactionStatement struct {
+	Token token.Token
+}
+
+func (ts *TransactionStatement) statementNode()       {}
+func (ts *TransactionStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *TransactionStatement) String() string       { return ts.Token.Literal }
+
 type Program struct {
 	Statements []Statement
 }
@@ -159,6 +281,17 @@ func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
 func (sl *StringLiteral) String() string       { return "'" + sl.Token.Literal + "'" }
 
+// TypedLiteral is a string literal of a type, such as DATE '2024-01-01', where Token is the type.
+// The string is read as a value of the type when the literal is evaluated.
+type TypedLiteral struct {
+	Token token.Token
+	Value string
+}
+
+func (tl *TypedLiteral) expressionNode()      {}
+func (tl *TypedLiteral) TokenLiteral() string { return tl.Token.Literal }
+func (tl *TypedLiteral) String() string       { return tl.Token.Literal + " '" + tl.Value + "'" }
+
 type Null struct {
 	Token token.Token
 }
@@ -232,3 +365,34 @@ func (ie *InfixExpression) String() string {
 	out.WriteString(")")
 	return out.String()
 }
+
+type CallExpression struct {
+	Token     token.Token // the function name
+	Function  string
+	Arguments []Expression
+	Star      bool // count(*)
+}
+
+func (ce *CallExpression) expressionNode()      {}
+func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) String() string {
+	if ce.Star {
+		return ce.Function + "(*)"
+	}
+	arguments := make([]string, len(ce.Arguments))
+	for i, a := range ce.Arguments {
+		arguments[i] = a.String()
+	}
+	return ce.Function + "(" + strings.Join(arguments, ", ") + ")"
+}
+
+// Parameter is a placeholder for a value given when a prepared statement is executed.
+// Parameters are numbered from 1, both when written as $1 and when written as ?.
+type Parameter struct {
+	Token token.Token
+	Index int
+}
+
+func (p *Parameter) expressionNode()      {}
+func (p *Parameter) TokenLiteral() string { return p.Token.Literal }
+func (p *Parameter) String() string       { return "$" + strconv.Itoa(p.Index) }
@@ -0,0 +1,514 @@
+// Package backendtest checks that storage backends behave the same, by running the same
+// scenarios against any evaluator.Backend. A backend's tests call Run with a function returning
+// a new, opened and empty backend.
+package backendtest
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/vegarsti/sql/evaluator"
+	"github.com/vegarsti/sql/object"
+)
+
+// Run runs the scenarios as subtests, each against a backend returned by newBackend.
+// Scenarios for the optional interfaces of evaluator, such as evaluator.BatchWriter,
+// are skipped for backends that don't implement them.
+func Run(t *testing.T, newBackend func(t *testing.T) evaluator.Backend) {
+	t.Run("CreateTable", func(t *testing.T) { testCreateTable(t, newBackend(t)) })
+	t.Run("InsertAndScan", func(t *testing.T) { testInsertAndScan(t, newBackend(t)) })
+	t.Run("Errors", func(t *testing.T) { testErrors(t, newBackend(t)) })
+	t.Run("TypeRoundTrip", func(t *testing.T) { testTypeRoundTrip(t, newBackend(t)) })
+	t.Run("NullRoundTrip", func(t *testing.T) { testNullRoundTrip(t, newBackend(t)) })
+	t.Run("Reopen", func(t *testing.T) { testReopen(t, newBackend(t)) })
+	t.Run("WriteBatch", func(t *testing.T) { testWriteBatch(t, newBackend(t)) })
+	t.Run("RowCount", func(t *testing.T) { testRowCount(t, newBackend(t)) })
+	t.Run("TableNames", func(t *testing.T) { testTableNames(t, newBackend(t)) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, newBackend(t)) })
+	t.Run("StatisticsRoundTrip", func(t *testing.T) { testStatisticsRoundTrip(t, newBackend(t)) })
+}
+
+// columns are the columns of the table foo, which most scenarios use
+var columns = []object.Column{{Name: "a", Type: object.INTEGER}, {Name: "b", Type: object.STRING}}
+
+// fooRow returns the i'th row of the table foo
+func fooRow(i int) []object.Object {
+	return []object.Object{&object.Integer{Value: int64(i)}, &object.String{Value: fmt.Sprintf("row %d", i)}}
+}
+
+// createFoo creates the table foo with n rows
+func createFoo(t *testing.T, backend evaluator.Backend, n int) [][]object.Object {
+	t.Helper()
+	MustCreateTable(t, backend, "foo", columns)
+	var rows [][]object.Object
+	for i := 0; i < n; i++ {
+		MustInsert(t, backend, "foo", NewRow("foo", columns, fooRow(i)...))
+		rows = append(rows, fooRow(i))
+	}
+	return rows
+}
+
+// checkError checks that the error has the message
+func checkError(t *testing.T, doing string, err error, expected string) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("%s: expected error %q", doing, expected)
+	}
+	if err.Error() != expected {
+		t.Fatalf("%s: expected error %q. got=%q", doing, expected, err.Error())
+	}
+}
+
+// values are values of every data type, including the extremes of each type
+var values = map[object.DataType][]object.Object{
+	object.INTEGER: {
+		&object.Integer{Value: 0},
+		&object.Integer{Value: -1},
+		&object.Integer{Value: 1 << 40},
+		&object.Integer{Value: math.MaxInt64},
+		&object.Integer{Value: math.MinInt64},
+	},
+	object.FLOAT: {
+		&object.Float{Value: 0},
+		&object.Float{Value: -2.5},
+		&object.Float{Value: math.MaxFloat64},
+		&object.Float{Value: math.SmallestNonzeroFloat64},
+	},
+	object.STRING: {
+		&object.String{Value: ""},
+		&object.String{Value: "abc"},
+		&object.String{Value: "it's ✅"},
+	},
+	object.BOOLEAN: {
+		&object.Boolean{Value: true},
+		&object.Boolean{Value: false},
+	},
+	object.DATE: {
+		&object.Date{Value: 0},
+		&object.Date{Value: -719162},
+		&object.Date{Value: 19723},
+	},
+	object.TIME: {
+		&object.Time{Value: 0},
+		&object.Time{Value: object.MicrosecondsPerDay - 1},
+	},
+	object.TIMESTAMP: {
+		&object.Timestamp{Value: 0},
+		&object.Timestamp{Value: -1},
+		&object.Timestamp{Value: 1704103200123456},
+	},
+	object.TIMESTAMPTZ: {
+		&object.TimestampTZ{Value: 1704103200000000},
+		&object.TimestampTZ{Value: math.MinInt64},
+	},
+	object.INTERVAL: {
+		&object.Interval{},
+		&object.Interval{Months: 14, Days: -3, Microseconds: 3600 * object.MicrosecondsPerSecond},
+	},
+	object.NUMERIC: {
+		object.NumericOf(0),
+		&object.Numeric{Coefficient: big.NewInt(-1250), Scale: 2},
+		&object.Numeric{Coefficient: new(big.Int).Lsh(big.NewInt(1), 200), Scale: object.MaxNumericPrecision},
+	},
+}
+
+// dataTypes are the data types of values, in a fixed order
+var dataTypes = []object.DataType{
+	object.INTEGER, object.FLOAT, object.STRING, object.BOOLEAN,
+	object.DATE, object.TIME, object.TIMESTAMP, object.TIMESTAMPTZ, object.INTERVAL, object.NUMERIC,
+}
+
+// NewRow returns a row of the table with the values, as the evaluator inserts them
+func NewRow(table string, columns []object.Column, values ...object.Object) object.Row {
+	row := object.Row{
+		Values:    values,
+		Aliases:   make([]string, len(columns)),
+		TableName: make([]string, len(columns)),
+	}
+	for i, c := range columns {
+		row.Aliases[i] = c.Name
+		row.TableName[i] = table
+	}
+	return row
+}
+
+// Open opens the backend, and closes it when the test is done
+func Open(t *testing.T, backend evaluator.Backend) {
+	t.Helper()
+	if err := backend.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { backend.Close() })
+}
+
+// OpenWithFoo opens the backend as Open, and creates the table foo with a single INTEGER column a,
+// for the rows returned by IntegerRow
+func OpenWithFoo(t *testing.T, backend evaluator.Backend) {
+	t.Helper()
+	Open(t, backend)
+	MustCreateTable(t, backend, "foo", []object.Column{{Name: "a", Type: object.INTEGER}})
+}
+
+// IntegerRow returns a row of the table foo created by OpenWithFoo
+func IntegerRow(i int) object.Row {
+	return object.Row{Values: []object.Object{&object.Integer{Value: int64(i)}}}
+}
+
+// Count returns the number of rows in the table. It reports errors with t.Error rather than t.Fatal,
+// so that it can be called from other goroutines than the one running the test.
+func Count(t *testing.T, backend evaluator.Backend, name string) int {
+	t.Helper()
+	it, err := backend.Scan(name)
+	if err != nil {
+		t.Error(err)
+		return 0
+	}
+	rows, err := object.Collect(it)
+	if err != nil {
+		t.Error(err)
+	}
+	return len(rows)
+}
+
+func MustCreateTable(t *testing.T, backend evaluator.Backend, name string, columns []object.Column) {
+	t.Helper()
+	if err := backend.CreateTable(name, columns); err != nil {
+		t.Fatalf("create table %s: %v", name, err)
+	}
+}
+
+func MustInsert(t *testing.T, backend evaluator.Backend, name string, rows ...object.Row) {
+	t.Helper()
+	for _, row := range rows {
+		if err := backend.Insert(name, row); err != nil {
+			t.Fatalf("insert into %s: %v", name, err)
+		}
+	}
+}
+
+func MustScan(t *testing.T, backend evaluator.Backend, name string) []object.Row {
+	t.Helper()
+	it, err := backend.Scan(name)
+	if err != nil {
+		t.Fatalf("scan %s: %v", name, err)
+	}
+	rows, err := object.Collect(it)
+	if err != nil {
+		t.Fatalf("scan %s: %v", name, err)
+	}
+	return rows
+}
+
+// checkRows checks that the rows have the expected values, and the columns of the table as aliases and table names
+func checkRows(t *testing.T, table string, columns []object.Column, rows []object.Row, expected [][]object.Object) {
+	t.Helper()
+	if len(rows) != len(expected) {
+		t.Fatalf("expected %d rows. got=%d", len(expected), len(rows))
+	}
+	for i, row := range rows {
+		if len(row.Values) != len(columns) || len(row.Aliases) != len(columns) || len(row.TableName) != len(columns) {
+			t.Fatalf("row %d: expected %d columns. got=%+v", i, len(columns), row)
+		}
+		for j, c := range columns {
+			if row.Aliases[j] != c.Name || row.TableName[j] != table {
+				t.Fatalf("row %d: expected column %d to be %s.%s. got=%s.%s", i, j, table, c.Name, row.TableName[j], row.Aliases[j])
+			}
+			got, want := row.Values[j], expected[i][j]
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("row %d: expected %s %s in column %s. got=%s %s", i, want.Type(), want.Inspect(), c.Name, got.Type(), got.Inspect())
+			}
+		}
+	}
+}
+
+// testTypeRoundTrip inserts values of every data type, in a table for each type, and reads them back
+func testTypeRoundTrip(t *testing.T, backend evaluator.Backend) {
+	for _, dataType := range dataTypes {
+		table := fmt.Sprintf("t_%s", dataType)
+		columns := []object.Column{{Name: "v", Type: dataType}}
+		MustCreateTable(t, backend, table, columns)
+		var expected [][]object.Object
+		for _, v := range values[dataType] {
+			MustInsert(t, backend, table, NewRow(table, columns, v))
+			expected = append(expected, []object.Object{v})
+		}
+		checkRows(t, table, columns, MustScan(t, backend, table), expected)
+	}
+}
+
+// testNullRoundTrip inserts rows where each column is NULL in some row
+func testNullRoundTrip(t *testing.T, backend evaluator.Backend) {
+	var columns []object.Column
+	for _, dataType := range dataTypes {
+		columns = append(columns, object.Column{Name: string(dataType), Type: dataType})
+	}
+	MustCreateTable(t, backend, "nulls", columns)
+	var expected [][]object.Object
+	for i := 0; i <= len(columns); i++ {
+		row := make([]object.Object, len(columns))
+		for j, c := range columns {
+			if i == j || i == len(columns) {
+				row[j] = object.NULL
+			} else {
+				row[j] = values[c.Type][0]
+			}
+		}
+		MustInsert(t, backend, "nulls", NewRow("nulls", columns, row...))
+		expected = append(expected, row)
+	}
+	checkRows(t, "nulls", columns, MustScan(t, backend, "nulls"), expected)
+}
+
+// testStatisticsRoundTrip stores statistics with minimum and maximum values of every data type,
+// for backends that store statistics
+func testStatisticsRoundTrip(t *testing.T, backend evaluator.Backend) {
+	store, ok := backend.(evaluator.StatisticsStore)
+	if !ok {
+		t.Skip("backend doesn't store statistics")
+	}
+	var columns []object.Column
+	stats := &object.TableStatistics{RowCount: 2}
+	for _, dataType := range dataTypes {
+		c := object.Column{Name: string(dataType), Type: dataType}
+		columns = append(columns, c)
+		stats.Columns = append(stats.Columns, object.ColumnStatistics{
+			Name:          c.Name,
+			DistinctCount: 2,
+			NullFraction:  0.5,
+			Min:           values[dataType][1],
+			Max:           values[dataType][0],
+		})
+	}
+	// a column of only NULLs has no minimum or maximum
+	columns = append(columns, object.Column{Name: "nulls", Type: object.INTEGER})
+	stats.Columns = append(stats.Columns, object.ColumnStatistics{Name: "nulls", NullFraction: 1})
+	MustCreateTable(t, backend, "stats", columns)
+	if err := store.SetStatistics("stats", stats); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Statistics("stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.RowCount != stats.RowCount || len(got.Columns) != len(stats.Columns) {
+		t.Fatalf("expected statistics %+v. got=%+v", stats, got)
+	}
+	inspect := func(v object.Object) string {
+		if v == nil {
+			return "<nil>"
+		}
+		return string(v.Type()) + " " + v.Inspect()
+	}
+	for i, want := range stats.Columns {
+		c := got.Columns[i]
+		if !reflect.DeepEqual(c, want) {
+			t.Fatalf("expected statistics of column %s to be %s to %s. got=%s to %s", want.Name, inspect(want.Min), inspect(want.Max), inspect(c.Min), inspect(c.Max))
+		}
+	}
+}
+
+// testCreateTable creates tables, and checks that their columns are returned in order
+func testCreateTable(t *testing.T, backend evaluator.Backend) {
+	MustCreateTable(t, backend, "foo", columns)
+	MustCreateTable(t, backend, "bar", []object.Column{{Name: "b", Type: object.BOOLEAN}})
+	got, err := backend.Columns("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, columns) {
+		t.Fatalf("expected columns %v. got=%v", columns, got)
+	}
+	checkRows(t, "bar", nil, MustScan(t, backend, "bar"), nil)
+}
+
+// testInsertAndScan checks that rows are scanned in the order they were inserted,
+// and that a scan can be stopped before all rows have been read
+func testInsertAndScan(t *testing.T, backend evaluator.Backend) {
+	expected := createFoo(t, backend, 100)
+	checkRows(t, "foo", columns, MustScan(t, backend, "foo"), expected)
+	it, err := backend.Scan("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := it.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the backend can be written to after a scan has been closed
+	MustInsert(t, backend, "foo", NewRow("foo", columns, fooRow(100)...))
+	checkRows(t, "foo", columns, MustScan(t, backend, "foo"), append(expected, fooRow(100)))
+}
+
+// testErrors checks the errors for tables that don't exist or already exist, and for rows that don't fit the table
+func testErrors(t *testing.T, backend evaluator.Backend) {
+	createFoo(t, backend, 1)
+	checkError(t, "create existing table", backend.CreateTable("foo", columns), `relation "foo" already exists`)
+	checkError(t, "insert into missing table", backend.Insert("bar", NewRow("bar", columns, fooRow(1)...)), `relation "bar" does not exist`)
+	_, err := backend.Scan("bar")
+	checkError(t, "scan missing table", err, `relation "bar" does not exist`)
+	_, err = backend.Columns("bar")
+	checkError(t, "columns of missing table", err, `relation "bar" does not exist`)
+	checkError(
+		t, "insert too many values",
+		backend.Insert("foo", NewRow("foo", columns, append(fooRow(1), &object.Integer{Value: 1})...)),
+		`relation "foo" has 2 columns but the row has 3 values`,
+	)
+	checkError(
+		t, "insert value of the wrong type",
+		backend.Insert("foo", NewRow("foo", columns, &object.Integer{Value: 1}, &object.Float{Value: 1})),
+		`column "b" of relation "foo" is of type STRING but the value is of type FLOAT`,
+	)
+	// the failed statements didn't change anything
+	checkRows(t, "foo", columns, MustScan(t, backend, "foo"), [][]object.Object{fooRow(0)})
+	if got, err := backend.Columns("foo"); err != nil || !reflect.DeepEqual(got, columns) {
+		t.Fatalf("expected columns %v. got=%v, %v", columns, got, err)
+	}
+}
+
+// testReopen checks that the tables are kept when the backend is closed and opened again
+func testReopen(t *testing.T, backend evaluator.Backend) {
+	expected := createFoo(t, backend, 10)
+	prices := []object.Column{{Name: "price", Type: object.NUMERIC, Precision: 10, Scale: 2}}
+	MustCreateTable(t, backend, "prices", prices)
+	if err := backend.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := backend.Columns("foo"); err != nil || !reflect.DeepEqual(got, columns) {
+		t.Fatalf("expected columns %v. got=%v, %v", columns, got, err)
+	}
+	if got, err := backend.Columns("prices"); err != nil || !reflect.DeepEqual(got, prices) {
+		t.Fatalf("expected columns %v. got=%v, %v", prices, got, err)
+	}
+	checkRows(t, "foo", columns, MustScan(t, backend, "foo"), expected)
+	MustInsert(t, backend, "foo", NewRow("foo", columns, fooRow(10)...))
+	checkRows(t, "foo", columns, MustScan(t, backend, "foo"), append(expected, fooRow(10)))
+}
+
+// testWriteBatch checks that a batch creates tables and inserts rows, and that nothing is written if it fails
+func testWriteBatch(t *testing.T, backend evaluator.Backend) {
+	bw, ok := backend.(evaluator.BatchWriter)
+	if !ok {
+		t.Skip("backend doesn't write batches")
+	}
+	expected := createFoo(t, backend, 1)
+	bar := []object.Column{{Name: "c", Type: object.FLOAT}}
+	err := bw.WriteBatch(
+		[]object.Table{{Name: "bar", Columns: bar}},
+		map[string][]object.Row{
+			"foo": {NewRow("foo", columns, fooRow(1)...), NewRow("foo", columns, fooRow(2)...)},
+			"bar": {NewRow("bar", bar, &object.Float{Value: 0.5})},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = append(expected, fooRow(1), fooRow(2))
+	checkRows(t, "foo", columns, MustScan(t, backend, "foo"), expected)
+	checkRows(t, "bar", bar, MustScan(t, backend, "bar"), [][]object.Object{{&object.Float{Value: 0.5}}})
+
+	failures := []struct {
+		doing         string
+		tables        []object.Table
+		rows          map[string][]object.Row
+		expectedError string
+	}{
+		{
+			"create existing table",
+			[]object.Table{{Name: "baz", Columns: bar}, {Name: "foo", Columns: columns}},
+			map[string][]object.Row{"foo": {NewRow("foo", columns, fooRow(3)...)}},
+			`relation "foo" already exists`,
+		},
+		{
+			"insert into missing table",
+			[]object.Table{{Name: "baz", Columns: bar}},
+			map[string][]object.Row{"qux": {NewRow("qux", bar, &object.Float{Value: 1})}},
+			`relation "qux" does not exist`,
+		},
+		{
+			"insert value of the wrong type",
+			[]object.Table{{Name: "baz", Columns: bar}},
+			map[string][]object.Row{"baz": {NewRow("baz", bar, &object.Integer{Value: 1})}},
+			`column "c" of relation "baz" is of type FLOAT but the value is of type INTEGER`,
+		},
+	}
+	for _, f := range failures {
+		checkError(t, f.doing, bw.WriteBatch(f.tables, f.rows), f.expectedError)
+		if _, err := backend.Columns("baz"); err == nil {
+			t.Fatalf("%s: expected the table created in the failed batch not to exist", f.doing)
+		}
+		checkRows(t, "foo", columns, MustScan(t, backend, "foo"), expected)
+	}
+}
+
+// testRowCount checks the row count of backends that count rows without reading them
+func testRowCount(t *testing.T, backend evaluator.Backend) {
+	counter, ok := backend.(evaluator.RowCounter)
+	if !ok {
+		t.Skip("backend doesn't count rows")
+	}
+	createFoo(t, backend, 7)
+	if n, err := counter.RowCount("foo"); err != nil || n != 7 {
+		t.Fatalf("expected 7 rows. got=%d, %v", n, err)
+	}
+	_, err := counter.RowCount("bar")
+	checkError(t, "count rows of missing table", err, `relation "bar" does not exist`)
+}
+
+// testTableNames checks that the tables are listed in sorted order
+func testTableNames(t *testing.T, backend evaluator.Backend) {
+	lister, ok := backend.(evaluator.TableLister)
+	if !ok {
+		t.Skip("backend doesn't list tables")
+	}
+	names, err := lister.TableNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 0 {
+		t.Fatalf("expected no tables. got=%v", names)
+	}
+	for _, name := range []string{"foo", "bar", "baz"} {
+		MustCreateTable(t, backend, name, columns)
+	}
+	names, err = lister.TableNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"bar", "baz", "foo"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected tables %v. got=%v", expected, names)
+	}
+}
+
+// testDelete deletes some rows, and checks that a failing match deletes nothing
+func testDelete(t *testing.T, backend evaluator.Backend) {
+	deleter, ok := backend.(evaluator.Deleter)
+	if !ok {
+		t.Skip("backend doesn't delete rows")
+	}
+	createFoo(t, backend, 10)
+	isOdd := func(row object.Row) (bool, error) { return row.Values[0].(*object.Integer).Value%2 == 1, nil }
+	if n, err := deleter.Delete("foo", isOdd); err != nil || n != 5 {
+		t.Fatalf("expected 5 rows to be deleted. got=%d, %v", n, err)
+	}
+	expected := [][]object.Object{fooRow(0), fooRow(2), fooRow(4), fooRow(6), fooRow(8)}
+	checkRows(t, "foo", columns, MustScan(t, backend, "foo"), expected)
+	failAt := func(row object.Row) (bool, error) {
+		if row.Values[0].(*object.Integer).Value == 6 {
+			return false, fmt.Errorf("match failed")
+		}
+		return true, nil
+	}
+	_, err := deleter.Delete("foo", failAt)
+	checkError(t, "delete with failing match", err, "match failed")
+	checkRows(t, "foo", columns, MustScan(t, backend, "foo"), expected)
+	_, err = deleter.Delete("bar", isOdd)
+	checkError(t, "delete from missing table", err, `relation "bar" does not exist`)
+}
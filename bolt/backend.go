@@ -10,6 +10,8 @@ import (
 	"github.com/vegarsti/sql/object"
 )
 
+// Backend stores the tables in a Bolt file. It is safe for concurrent use, since Bolt
+// allows one read-write transaction at a time, alongside any number of read-only transactions.
 type Backend struct {
 	file string
 	db   *bolt.DB
@@ -35,29 +37,59 @@ func (b *Backend) Close() error {
 	return nil
 }
 
+// usageError is an error caused by the arguments rather than by the storage, such as a table that
+// doesn't exist. It is returned as it is, so that its message is the same as for other backends.
+type usageError struct {
+	err error
+}
+
+func (e *usageError) Error() string { return e.err.Error() }
+func (e *usageError) Unwrap() error { return e.err }
+
+func noSuchTable(tableName string) error {
+	return &usageError{object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, tableName)}
+}
+
+func tableExists(tableName string) error {
+	return &usageError{object.Errorf(object.CodeDuplicateTable, `relation "%s" already exists`, tableName)}
+}
+
+// wrap adds what was being done when the error happened, unless it is a usageError
+func wrap(doing string, err error) error {
+	var usageErr *usageError
+	if errors.As(err, &usageErr) {
+		return usageErr
+	}
+	return fmt.Errorf("%s: %w", doing, err)
+}
+
 // CreateTable creates a Bolt bucket with the table name in the b.file Bolt file.
 func (b *Backend) CreateTable(tableName string, columns []object.Column) error {
-	// Create a bucket for this table and insert columns as JSON
 	if err := b.db.Update(func(tx *bolt.Tx) error {
-		tableBucketName := []byte(tableName)
-		bucket, err := tx.CreateBucket(tableBucketName)
-		if err != nil {
-			return fmt.Errorf("create bucket: %w", err)
-		}
-		marshalledColumns, err := json.Marshal(columns)
-		if err != nil {
-			return fmt.Errorf("json marshal columns: %w", err)
-		}
-		if err := bucket.Put([]byte("columns"), marshalledColumns); err != nil {
-			return fmt.Errorf("bucket put columns: %w", err)
-		}
-		return nil
+		return createTable(tx, tableName, columns)
 	}); err != nil {
 		// return a nice error message if the table exists
 		if errors.Is(err, bolt.ErrBucketExists) {
-			return fmt.Errorf("table %s already exists", tableName)
+			return tableExists(tableName)
 		}
-		return fmt.Errorf("update: %w", err)
+		return wrap("update", err)
+	}
+	return nil
+}
+
+// createTable creates a bucket for the table and inserts the columns as JSON
+func createTable(tx *bolt.Tx, tableName string, columns []object.Column) error {
+	tableBucketName := []byte(tableName)
+	bucket, err := tx.CreateBucket(tableBucketName)
+	if err != nil {
+		return fmt.Errorf("create bucket: %w", err)
+	}
+	marshalledColumns, err := json.Marshal(columns)
+	if err != nil {
+		return fmt.Errorf("json marshal columns: %w", err)
+	}
+	if err := bucket.Put([]byte("columns"), marshalledColumns); err != nil {
+		return fmt.Errorf("bucket put columns: %w", err)
 	}
 	return nil
 }
@@ -66,77 +98,210 @@ func (b *Backend) CreateTable(tableName string, columns []object.Column) error {
 // When a row has been inserted, we increment the bucket sequence number.
 // This number thus shows how many rows there are in the table, and is used when iterating over the rows in Backend.Rows().
 // The n'th row is stored with the byte representation of n as its key, and
-// the bytes stored contain the marshalled JSON representation of the `object.Row`.
+// the bytes stored are the values of the row in the binary format of object.EncodeRow.
 func (b *Backend) Insert(tableName string, row object.Row) error {
 	if err := b.db.Update(func(tx *bolt.Tx) error {
-		tableBucketName := []byte(tableName)
-		bucket := tx.Bucket(tableBucketName)
-		if bucket == nil {
-			return fmt.Errorf("table %s doesn't exist", tableName)
+		return insert(tx, tableName, []object.Row{row})
+	}); err != nil {
+		return wrap("update", err)
+	}
+	return nil
+}
+
+func insert(tx *bolt.Tx, tableName string, rows []object.Row) error {
+	tableBucketName := []byte(tableName)
+	bucket := tx.Bucket(tableBucketName)
+	if bucket == nil {
+		return noSuchTable(tableName)
+	}
+	columns, err := bucketColumns(bucket, tableName)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := (object.Table{Name: tableName, Columns: columns}).CheckRow(row); err != nil {
+			return &usageError{err}
 		}
-		marshalledRow, err := json.Marshal(row)
+		encodedRow, err := object.EncodeRow(row, columns)
 		if err != nil {
-			return fmt.Errorf("json marshal row: %w", err)
+			return fmt.Errorf("encode row: %w", err)
 		}
 		id := itob(bucket.Sequence())
-		if err := bucket.Put(id, marshalledRow); err != nil {
+		if err := bucket.Put(id, encodedRow); err != nil {
 			return fmt.Errorf("bucket put row: %w", err)
 		}
 		if _, err := bucket.NextSequence(); err != nil {
 			return fmt.Errorf("bucket next sequence: %w", err)
 		}
+	}
+	return nil
+}
+
+// WriteBatch creates the tables and inserts the rows in a single Bolt transaction,
+// so that other sessions see all of the changes or none of them.
+func (b *Backend) WriteBatch(tables []object.Table, rows map[string][]object.Row) error {
+	if err := b.db.Update(func(tx *bolt.Tx) error {
+		for _, table := range tables {
+			if err := createTable(tx, table.Name, table.Columns); err != nil {
+				if errors.Is(err, bolt.ErrBucketExists) {
+					return tableExists(table.Name)
+				}
+				return err
+			}
+		}
+		for tableName, tableRows := range rows {
+			if err := insert(tx, tableName, tableRows); err != nil {
+				return err
+			}
+		}
 		return nil
 	}); err != nil {
-		return fmt.Errorf("update: %w", err)
+		return wrap("update", err)
 	}
 	return nil
 }
 
-// Rows returns a slice of all the rows in the table.
-func (b *Backend) Rows(tableName string) ([]object.Row, error) {
-	var rows []object.Row
-	if err := b.db.View(func(tx *bolt.Tx) error {
-		tableBucketName := []byte(tableName)
-		bucket := tx.Bucket(tableBucketName)
-		if bucket == nil {
-			return fmt.Errorf("table %s doesn't exist", tableName)
+// Scan returns an iterator over the rows in the table.
+// The iterator holds a read-only transaction open until it is exhausted or closed,
+// so rows are decoded one at a time as the caller asks for them, and the rows are
+// a snapshot of the table when the scan started.
+func (b *Backend) Scan(tableName string) (object.RowIterator, error) {
+	tx, err := b.db.Begin(false)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+	bucket := tx.Bucket([]byte(tableName))
+	if bucket == nil {
+		tx.Rollback()
+		return nil, noSuchTable(tableName)
+	}
+	columns, err := bucketColumns(bucket, tableName)
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("columns: %w", err)
+	}
+	it := &rowIterator{
+		tx:         tx,
+		cursor:     bucket.Cursor(),
+		columns:    columns,
+		aliases:    make([]string, len(columns)),
+		tableNames: make([]string, len(columns)),
+	}
+	for i, c := range columns {
+		it.aliases[i] = c.Name
+		it.tableNames[i] = tableName
+	}
+	return it, nil
+}
+
+type rowIterator struct {
+	tx      *bolt.Tx
+	cursor  *bolt.Cursor
+	columns []object.Column
+	// the aliases and table names of every row, which are the same for all rows
+	aliases    []string
+	tableNames []string
+	started    bool
+}
+
+func (it *rowIterator) Next() (*object.Row, error) {
+	if it.tx == nil {
+		return nil, nil
+	}
+	for {
+		var key, value []byte
+		if !it.started {
+			key, value = it.cursor.First()
+			it.started = true
+		} else {
+			key, value = it.cursor.Next()
 		}
-		columns, err := b.Columns(tableName)
+		if key == nil {
+			return nil, it.Close()
+		}
+		// the bucket also holds the column definitions; rows are the keys made by itob
+		if len(key) != 8 || value == nil {
+			continue
+		}
+		row, err := decodeRow(value, it.columns, it.aliases, it.tableNames)
 		if err != nil {
-			return fmt.Errorf("columns: %w", err)
-		}
-		for i := uint64(0); i < bucket.Sequence(); i++ {
-			var row object.Row
-			row.Values = make([]object.Object, len(columns))
-			// Since row.Values is a slice of interface values, we must indicate which implementation of
-			// the interface is used. Since tables cannot change, we know that the columns of the tables
-			// are static. This means that if the 2nd value in a row must be a value of the 2nd column type.
-			for i, v := range columns {
-				switch v.Type {
-				case object.STRING:
-					row.Values[i] = &object.String{}
-				case object.INTEGER:
-					row.Values[i] = &object.Integer{}
-				case object.FLOAT:
-					row.Values[i] = &object.Float{}
-				default:
-					panic(fmt.Sprintf("unknown type %s", v.Type))
-				}
+			it.Close()
+			return nil, fmt.Errorf("decode row: %w", err)
+		}
+		return row, nil
+	}
+}
+
+func (it *rowIterator) Close() error {
+	if it.tx == nil {
+		return nil
+	}
+	err := it.tx.Rollback()
+	it.tx = nil
+	if err != nil {
+		return fmt.Errorf("rollback: %w", err)
+	}
+	return nil
+}
+
+// Migrate rewrites the rows stored as JSON by earlier versions in the binary format, in a single
+// Bolt transaction, and returns the number of rows rewritten. Rows in either format can be read,
+// so migrating is only needed to make the file smaller and faster to read.
+func (b *Backend) Migrate() (int, error) {
+	migrated := 0
+	if err := b.db.Update(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+			tableName := string(name)
+			columns, err := bucketColumns(bucket, tableName)
+			if err != nil {
+				return err
 			}
-			marshalledRow := bucket.Get(itob(i))
-			if marshalledRow == nil {
-				return fmt.Errorf("row %d not found", i)
+			// the rows are put after iterating, since changing a bucket can invalidate its cursors
+			var keys, values [][]byte
+			cursor := bucket.Cursor()
+			for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+				if len(key) != 8 || value == nil || value[0] != '{' {
+					continue
+				}
+				row, err := unmarshalRow(value, columns)
+				if err != nil {
+					return fmt.Errorf("table %s: %w", tableName, err)
+				}
+				encodedRow, err := object.EncodeRow(*row, columns)
+				if err != nil {
+					return fmt.Errorf("table %s: encode row: %w", tableName, err)
+				}
+				keys = append(keys, key)
+				values = append(values, encodedRow)
 			}
-			if err := json.Unmarshal(marshalledRow, &row); err != nil {
-				return fmt.Errorf("json unmarshal row: %w", err)
+			for i, key := range keys {
+				if err := bucket.Put(key, values[i]); err != nil {
+					return fmt.Errorf("bucket put row: %w", err)
+				}
 			}
-			rows = append(rows, row)
+			migrated += len(keys)
+			return nil
+		})
+	}); err != nil {
+		return 0, wrap("update", err)
+	}
+	return migrated, nil
+}
+
+// RowCount returns the number of rows in the table, which is the bucket sequence number.
+func (b *Backend) RowCount(tableName string) (int, error) {
+	var n uint64
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(tableName))
+		if bucket == nil {
+			return noSuchTable(tableName)
 		}
+		n = bucket.Sequence()
 		return nil
 	}); err != nil {
-		return nil, fmt.Errorf("view: %w", err)
+		return 0, wrap("view", err)
 	}
-	return rows, nil
+	return int(n), nil
 }
 
 // Columns returns the column information for this table, if it exists
@@ -146,20 +311,154 @@ func (b *Backend) Columns(tableName string) ([]object.Column, error) {
 		tableBucketName := []byte(tableName)
 		bucket := tx.Bucket(tableBucketName)
 		if bucket == nil {
-			return fmt.Errorf("table %s doesn't exist", tableName)
+			return noSuchTable(tableName)
+		}
+		var err error
+		columns, err = bucketColumns(bucket, tableName)
+		return err
+	}); err != nil {
+		return nil, wrap("view", err)
+	}
+	return columns, nil
+}
+
+func bucketColumns(bucket *bolt.Bucket, tableName string) ([]object.Column, error) {
+	var columns []object.Column
+	marshalledColumns := bucket.Get([]byte("columns"))
+	if marshalledColumns == nil {
+		return nil, fmt.Errorf("no columns found for table %s", tableName)
+	}
+	if err := json.Unmarshal(marshalledColumns, &columns); err != nil {
+		return nil, fmt.Errorf("json unmarshal columns: %w", err)
+	}
+	return columns, nil
+}
+
+// TableNames returns the names of all tables, which are the names of the buckets, sorted.
+func (b *Backend) TableNames() ([]string, error) {
+	var names []string
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			names = append(names, string(name))
+			return nil
+		})
+	}); err != nil {
+		return nil, wrap("view", err)
+	}
+	return names, nil
+}
+
+// marshalledStatistics is how table statistics are stored as JSON.
+// The minimum and maximum values are unmarshalled by the column type, like the values of rows.
+type marshalledStatistics struct {
+	RowCount int
+	Columns  []marshalledColumnStatistics
+}
+
+type marshalledColumnStatistics struct {
+	Name          string
+	DistinctCount int
+	NullFraction  float64
+	Min           json.RawMessage
+	Max           json.RawMessage
+}
+
+// SetStatistics stores the statistics of the table as JSON under the "statistics" key in the table's bucket.
+func (b *Backend) SetStatistics(tableName string, stats *object.TableStatistics) error {
+	marshalled := marshalledStatistics{RowCount: stats.RowCount}
+	for _, c := range stats.Columns {
+		min, err := json.Marshal(c.Min)
+		if err != nil {
+			return fmt.Errorf("json marshal min: %w", err)
+		}
+		max, err := json.Marshal(c.Max)
+		if err != nil {
+			return fmt.Errorf("json marshal max: %w", err)
+		}
+		marshalled.Columns = append(marshalled.Columns, marshalledColumnStatistics{
+			Name:          c.Name,
+			DistinctCount: c.DistinctCount,
+			NullFraction:  c.NullFraction,
+			Min:           min,
+			Max:           max,
+		})
+	}
+	value, err := json.Marshal(marshalled)
+	if err != nil {
+		return fmt.Errorf("json marshal statistics: %w", err)
+	}
+	if err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(tableName))
+		if bucket == nil {
+			return noSuchTable(tableName)
 		}
-		marshalledColumns := bucket.Get([]byte("columns"))
-		if marshalledColumns == nil {
-			return fmt.Errorf("no columns found for table %s", tableName)
+		if err := bucket.Put([]byte("statistics"), value); err != nil {
+			return fmt.Errorf("bucket put statistics: %w", err)
 		}
-		if err := json.Unmarshal(marshalledColumns, &columns); err != nil {
-			return fmt.Errorf("json unmarshal columns: %w", err)
+		return nil
+	}); err != nil {
+		return wrap("update", err)
+	}
+	return nil
+}
+
+// Statistics returns the statistics of the table, or nil if the table hasn't been analyzed.
+func (b *Backend) Statistics(tableName string) (*object.TableStatistics, error) {
+	var stats *object.TableStatistics
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(tableName))
+		if bucket == nil {
+			return noSuchTable(tableName)
+		}
+		value := bucket.Get([]byte("statistics"))
+		if value == nil {
+			return nil
+		}
+		columns, err := bucketColumns(bucket, tableName)
+		if err != nil {
+			return err
+		}
+		var marshalled marshalledStatistics
+		if err := json.Unmarshal(value, &marshalled); err != nil {
+			return fmt.Errorf("json unmarshal statistics: %w", err)
+		}
+		stats = &object.TableStatistics{RowCount: marshalled.RowCount}
+		for _, c := range marshalled.Columns {
+			columnStats := object.ColumnStatistics{
+				Name:          c.Name,
+				DistinctCount: c.DistinctCount,
+				NullFraction:  c.NullFraction,
+			}
+			for _, column := range columns {
+				if column.Name != c.Name {
+					continue
+				}
+				if columnStats.Min, err = unmarshalValue(c.Min, column.Type); err != nil {
+					return err
+				}
+				if columnStats.Max, err = unmarshalValue(c.Max, column.Type); err != nil {
+					return err
+				}
+			}
+			stats.Columns = append(stats.Columns, columnStats)
 		}
 		return nil
 	}); err != nil {
-		return nil, fmt.Errorf("view: %w", err)
+		return nil, wrap("view", err)
 	}
-	return columns, nil
+	return stats, nil
+}
+
+// unmarshalValue unmarshals a value of the data type. JSON null is a nil value.
+func unmarshalValue(marshalled json.RawMessage, dataType object.DataType) (object.Object, error) {
+	if string(marshalled) == "null" {
+		return nil, nil
+	}
+	v := newValue(dataType)
+	if err := json.Unmarshal(marshalled, v); err != nil {
+		return nil, fmt.Errorf("json unmarshal value: %w", err)
+	}
+	return v, nil
 }
 
 // itob returns an 8-byte big endian representation of v.
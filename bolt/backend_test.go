@@ -0,0 +1,247 @@
+package bolt_test
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	boltdb "github.com/boltdb/bolt"
+	"github.com/vegarsti/sql/backendtest"
+	"github.com/vegarsti/sql/bolt"
+	"github.com/vegarsti/sql/evaluator"
+	"github.com/vegarsti/sql/object"
+)
+
+func openBackend(t *testing.T) *bolt.Backend {
+	backend := bolt.NewBackend(filepath.Join(t.TempDir(), "test.db"))
+	backendtest.OpenWithFoo(t, backend)
+	return backend
+}
+
+// TestConcurrentInsertAndScan is meant to be run with the race detector. Bolt trips the pointer checks
+// the race detector turns on, so run it with go test -race -gcflags=all=-d=checkptr=0.
+func TestConcurrentInsertAndScan(t *testing.T) {
+	backend := openBackend(t)
+	const writers, readers, rowsPerWriter = 4, 4, 25
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rowsPerWriter; i++ {
+				if err := backend.Insert("foo", backendtest.IntegerRow(w*rowsPerWriter+i)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			previous := 0
+			for i := 0; i < 20; i++ {
+				n := backendtest.Count(t, backend, "foo")
+				if n < previous {
+					t.Errorf("scan saw %d rows after seeing %d", n, previous)
+				}
+				previous = n
+			}
+		}()
+	}
+	wg.Wait()
+	if n := backendtest.Count(t, backend, "foo"); n != writers*rowsPerWriter {
+		t.Fatalf("expected %d rows, got %d", writers*rowsPerWriter, n)
+	}
+}
+
+func TestWriteBatch(t *testing.T) {
+	backend := openBackend(t)
+	const batches, batchSize = 10, 5
+	var wg sync.WaitGroup
+	for b := 0; b < batches; b++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			rows := make([]object.Row, batchSize)
+			for i := range rows {
+				rows[i] = backendtest.IntegerRow(i)
+			}
+			if err := backend.WriteBatch(nil, map[string][]object.Row{"foo": rows}); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			// a batch is seen all at once, or not at all
+			if n := backendtest.Count(t, backend, "foo"); n%batchSize != 0 {
+				t.Errorf("scan saw %d rows, which is not a whole number of batches", n)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := backendtest.Count(t, backend, "foo"); n != batches*batchSize {
+		t.Fatalf("expected %d rows, got %d", batches*batchSize, n)
+	}
+
+	err := backend.WriteBatch(
+		[]object.Table{{Name: "bar", Columns: []object.Column{{Name: "a", Type: object.INTEGER}}}},
+		map[string][]object.Row{"bar": {backendtest.IntegerRow(1)}, "baz": {backendtest.IntegerRow(1)}},
+	)
+	if err == nil {
+		t.Fatalf("expected error for inserting in a table that doesn't exist")
+	}
+	if _, err := backend.Columns("bar"); err == nil {
+		t.Fatalf("expected nothing to be written when the batch fails")
+	}
+}
+
+func scanRows(t *testing.T, backend *bolt.Backend, table string) []string {
+	it, err := backend.Scan(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := object.Collect(it)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inspected := make([]string, len(rows))
+	for i, row := range rows {
+		values := make([]string, len(row.Values))
+		for j, v := range row.Values {
+			values[j] = row.TableName[j] + "." + row.Aliases[j] + "=" + v.Inspect()
+		}
+		inspected[i] = strings.Join(values, " ")
+	}
+	return inspected
+}
+
+func TestRowEncoding(t *testing.T) {
+	backend := openBackend(t)
+	// more than 8 columns, so that the null bitmap takes two bytes
+	var columns []object.Column
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i"} {
+		columns = append(columns, object.Column{Name: name, Type: object.INTEGER})
+	}
+	columns[1].Type = object.FLOAT
+	columns[2].Type = object.STRING
+	if err := backend.CreateTable("bar", columns); err != nil {
+		t.Fatal(err)
+	}
+	rows := []object.Row{
+		{Values: []object.Object{
+			&object.Integer{Value: -1 << 62}, &object.Float{Value: -2.5}, &object.String{Value: "héllo"},
+			&object.Integer{Value: 0}, &object.Integer{Value: 1}, &object.Integer{Value: 127}, &object.Integer{Value: 128},
+			&object.Integer{Value: 1 << 40}, &object.Integer{Value: -1},
+		}},
+		{Values: []object.Object{
+			object.NULL, &object.Float{Value: 0}, &object.String{Value: ""},
+			object.NULL, object.NULL, object.NULL, object.NULL, object.NULL, object.NULL,
+		}},
+	}
+	if err := backend.WriteBatch(nil, map[string][]object.Row{"bar": rows}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"bar.a=-4611686018427387904 bar.b=-2.500000 bar.c='héllo' bar.d=0 bar.e=1 bar.f=127 bar.g=128 bar.h=1099511627776 bar.i=-1",
+		"bar.a=null bar.b=0.000000 bar.c='' bar.d=null bar.e=null bar.f=null bar.g=null bar.h=null bar.i=null",
+	}
+	if got := scanRows(t, backend, "bar"); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected rows\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+
+	err := backend.Insert("foo", object.Row{Values: []object.Object{&object.String{Value: "a"}}})
+	if err == nil || err.Error() != `column "a" of relation "foo" is of type INTEGER but the value is of type STRING` {
+		t.Fatalf("expected error for a value of the wrong type. got=%v", err)
+	}
+	err = backend.Insert("foo", object.Row{Values: []object.Object{&object.Integer{Value: 1}, &object.Integer{Value: 2}}})
+	if err == nil || err.Error() != `relation "foo" has 1 columns but the row has 2 values` {
+		t.Fatalf("expected error for too many values. got=%v", err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.db")
+	backend := bolt.NewBackend(file)
+	if err := backend.Open(); err != nil {
+		t.Fatal(err)
+	}
+	columns := []object.Column{{Name: "a", Type: object.INTEGER}, {Name: "b", Type: object.STRING}}
+	if err := backend.CreateTable("foo", columns); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// write rows as JSON, as earlier versions did
+	db, err := boltdb.Open(file, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *boltdb.Tx) error {
+		bucket := tx.Bucket([]byte("foo"))
+		for i := 1; i <= 2; i++ {
+			value, err := json.Marshal(object.Row{
+				Values:    []object.Object{&object.Integer{Value: int64(i)}, &object.String{Value: strings.Repeat("x", i)}},
+				Aliases:   []string{"a", "b"},
+				TableName: []string{"foo", "foo"},
+			})
+			if err != nil {
+				return err
+			}
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, bucket.Sequence())
+			if err := bucket.Put(key, value); err != nil {
+				return err
+			}
+			if _, err := bucket.NextSequence(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := backend.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	// rows in both formats are read
+	if err := backend.Insert("foo", object.Row{Values: []object.Object{&object.Integer{Value: 3}, &object.String{Value: "xxx"}}}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"foo.a=1 foo.b='x'", "foo.a=2 foo.b='xx'", "foo.a=3 foo.b='xxx'"}
+	if got := scanRows(t, backend, "foo"); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected rows\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+	n, err := backend.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 rows to be migrated. got=%d", n)
+	}
+	if got := scanRows(t, backend, "foo"); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected rows after migrating\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+	if n, err := backend.Migrate(); err != nil || n != 0 {
+		t.Fatalf("expected no rows to be migrated again. got=%d, %v", n, err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) evaluator.Backend {
+		backend := bolt.NewBackend(filepath.Join(t.TempDir(), "test.db"))
+		backendtest.Open(t, backend)
+		return backend
+	})
+}
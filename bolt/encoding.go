@@ -0,0 +1,65 @@
+package bolt
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/vegarsti/sql/object"
+)
+
+// Rows are stored in the binary format of object.EncodeRow. Earlier versions stored rows
+// as JSON objects, which start with '{'. They are still read, and Backend.Migrate rewrites
+// them in the binary format.
+
+// decodeRow decodes a row stored in either format. The aliases and table names are
+// given to every row, so they must not be changed.
+func decodeRow(data []byte, columns []object.Column, aliases []string, tableNames []string) (*object.Row, error) {
+	if len(data) > 0 && data[0] == '{' {
+		return unmarshalRow(data, columns)
+	}
+	return object.DecodeRow(data, columns, aliases, tableNames)
+}
+
+// unmarshalRow decodes a row stored as JSON by earlier versions
+func unmarshalRow(marshalledRow []byte, columns []object.Column) (*object.Row, error) {
+	var row object.Row
+	row.Values = make([]object.Object, len(columns))
+	// Since row.Values is a slice of interface values, we must indicate which implementation of
+	// the interface is used. Since tables cannot change, we know that the columns of the tables
+	// are static. This means that if the 2nd value in a row must be a value of the 2nd column type.
+	for i, v := range columns {
+		row.Values[i] = newValue(v.Type)
+	}
+	if err := json.Unmarshal(marshalledRow, &row); err != nil {
+		return nil, fmt.Errorf("json unmarshal row: %w", err)
+	}
+	return &row, nil
+}
+
+// newValue returns an empty value of the data type, for JSON to be unmarshalled into
+func newValue(dataType object.DataType) object.Object {
+	switch dataType {
+	case object.STRING:
+		return &object.String{}
+	case object.INTEGER:
+		return &object.Integer{}
+	case object.FLOAT:
+		return &object.Float{}
+	case object.BOOLEAN:
+		return &object.Boolean{}
+	case object.DATE:
+		return &object.Date{}
+	case object.TIME:
+		return &object.Time{}
+	case object.TIMESTAMP:
+		return &object.Timestamp{}
+	case object.TIMESTAMPTZ:
+		return &object.TimestampTZ{}
+	case object.INTERVAL:
+		return &object.Interval{}
+	case object.NUMERIC:
+		return &object.Numeric{}
+	default:
+		panic(fmt.Sprintf("unknown type %s", dataType))
+	}
+}
@@ -2,17 +2,24 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"flag"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"os/signal"
 	"strings"
 	"text/tabwriter"
 
 	"github.com/chzyer/readline"
 	"github.com/vegarsti/sql/bolt"
+	"github.com/vegarsti/sql/columnar"
 	"github.com/vegarsti/sql/evaluator"
+	"github.com/vegarsti/sql/httpapi"
 	"github.com/vegarsti/sql/inmemory"
 	"github.com/vegarsti/sql/lexer"
+	"github.com/vegarsti/sql/paged"
 	"github.com/vegarsti/sql/parser"
 )
 
@@ -25,6 +32,8 @@ func RunInteractive(backend evaluator.Backend) {
 	defer r.Close()
 
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	// prepared statements live until the interpreter exits
+	session := evaluator.NewSession(backend)
 
 	for {
 		line, err := r.Readline()
@@ -45,7 +54,10 @@ func RunInteractive(backend evaluator.Backend) {
 			continue
 		}
 
-		evaluated := evaluator.Eval(backend, program)
+		// Ctrl-C cancels the statement while it runs, instead of ending the interpreter
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		evaluated := session.EvalContext(ctx, program)
+		stop()
 		if evaluated != nil {
 			w.Write([]byte(evaluated.Inspect()))
 			w.Write([]byte("\n"))
@@ -63,7 +75,9 @@ func RunScript(backend evaluator.Backend, input string) {
 		printParserErrors(os.Stdout, p.Errors())
 		return
 	}
-	evaluated := evaluator.Eval(backend, program)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	evaluated := evaluator.EvalContext(ctx, backend, program)
 	if evaluated != nil {
 		w.Write([]byte(evaluated.Inspect()))
 		w.Write([]byte("\n"))
@@ -77,29 +91,67 @@ func printParserErrors(out io.Writer, errors []string) {
 	}
 }
 
+// Serve serves the backend over HTTP until the process is interrupted
+func Serve(backend evaluator.Backend, address string) {
+	server := &http.Server{Addr: address, Handler: httpapi.NewHandler(backend)}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		server.Shutdown(context.Background())
+	}()
+	fmt.Fprintf(os.Stderr, "listening on %s\n", address)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		fmt.Fprintf(os.Stderr, "serve: %v", err)
+		os.Exit(1)
+	}
+}
+
 func main() {
+	httpAddress := flag.String("http", "", "serve queries over HTTP on this address, such as localhost:8080")
+	migrate := flag.Bool("migrate", false, "rewrite the rows of the database file stored by earlier versions in the current format, and exit")
+	engine := flag.String("engine", "bolt", "the storage engine of the database file: bolt, paged for the native page-based engine, memory for tables in memory with a write-ahead log, or columnar for tables in memory stored by column, without a file")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: sql [-http host:port] [-engine bolt|paged|memory|columnar] [-migrate] [database file]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
 	fi, err := os.Stdin.Stat()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "os.Stdin.Stat(): %v", err)
 		os.Exit(1)
 	}
-	if len(os.Args) > 2 {
-		fmt.Fprint(os.Stderr, "usage: sql [database file]")
+	if flag.NArg() > 1 || *migrate && (flag.NArg() == 0 || *engine != "bolt") || *engine != "bolt" && *engine != "paged" && *engine != "memory" && *engine != "columnar" || *engine == "columnar" && flag.NArg() > 0 {
+		flag.Usage()
 		os.Exit(1)
 	}
 	var backend evaluator.Backend
-	if len(os.Args) == 1 {
+	if *engine == "columnar" {
+		backend = columnar.NewBackend()
+	} else if flag.NArg() == 0 {
 		backend = inmemory.NewBackend()
-	}
-	if len(os.Args) == 2 {
-		backend = bolt.NewBackend(os.Args[1])
+	} else if *engine == "paged" {
+		backend = paged.NewBackend(flag.Arg(0))
+	} else if *engine == "memory" {
+		backend = inmemory.NewDurableBackend(flag.Arg(0))
+	} else {
+		backend = bolt.NewBackend(flag.Arg(0))
 	}
 	if err := backend.Open(); err != nil {
 		fmt.Fprintf(os.Stderr, "backend open: %v", err)
 		os.Exit(1)
 	}
 	receivedInputFromStdin := (fi.Mode() & os.ModeCharDevice) == 0
-	if receivedInputFromStdin {
+	if *migrate {
+		n, err := backend.(*bolt.Backend).Migrate()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate: %v", err)
+			os.Exit(1)
+		}
+		fmt.Printf("migrated %d rows\n", n)
+	} else if *httpAddress != "" {
+		Serve(backend, *httpAddress)
+	} else if receivedInputFromStdin {
 		s := bufio.NewScanner(os.Stdin)
 		var lines []string
 		for s.Scan() {
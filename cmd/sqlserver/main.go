@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/vegarsti/sql/bolt"
+	"github.com/vegarsti/sql/evaluator"
+	"github.com/vegarsti/sql/inmemory"
+	"github.com/vegarsti/sql/pgwire"
+)
+
+func main() {
+	address := flag.String("addr", "localhost:5432", "address to listen on")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: sqlserver [-addr host:port] [database file]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	var backend evaluator.Backend
+	if flag.NArg() == 0 {
+		backend = inmemory.NewBackend()
+	} else {
+		backend = bolt.NewBackend(flag.Arg(0))
+	}
+	if err := backend.Open(); err != nil {
+		fmt.Fprintf(os.Stderr, "backend open: %v", err)
+		os.Exit(1)
+	}
+
+	server := pgwire.NewServer(backend)
+	// close the connections on interrupt, so the backend is closed when no statement is running
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		server.Close()
+	}()
+	fmt.Fprintf(os.Stderr, "listening on %s\n", *address)
+	if err := server.ListenAndServe(*address); err != pgwire.ErrServerClosed {
+		fmt.Fprintf(os.Stderr, "serve: %v", err)
+		os.Exit(1)
+	}
+	if err := backend.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "backend close: %v", err)
+		os.Exit(1)
+	}
+}
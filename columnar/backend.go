@@ -0,0 +1,482 @@
+// Package columnar keeps the tables in memory as columns, for queries that read many rows
+// but few of the columns of a table.
+//
+// Each column is a vector of the values of its type, such as a slice of int64 for an INTEGER column,
+// with a bitmap of which values are NULL. A NULL value has the zero value of the type in the vector,
+// so the position of a row is the same in every column. Scans only read the columns they are asked for,
+// see ScanColumns, and make the rows a batch at a time, so that a query reading one column of a wide table
+// doesn't make a value for each of the other columns of every row. ScanBatches returns the batches themselves,
+// for vectorized execution, without making the rows, and ScanPartitions splits a scan into parts that are
+// read by different goroutines.
+package columnar
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/vegarsti/sql/object"
+)
+
+// Backend stores the tables in memory as column vectors. It is safe for concurrent use.
+//
+// Vectors are appended to, and the values in them are never changed, so a scan reads the rows that were in
+// the table when it started without holding the lock. Delete makes new vectors without the deleted rows.
+type Backend struct {
+	// mu guards the tables, the vectors and the statistics
+	mu         sync.RWMutex
+	tables     map[string]*table
+	statistics map[string]*object.TableStatistics
+}
+
+type table struct {
+	columns []object.Column
+	vectors []*vector
+	// length is the number of rows
+	length int
+}
+
+// vector is the values of a column. Only the slice of the type of the column is used,
+// booleans are kept as a bitmap, and values of the other types, such as dates, as objects.
+type vector struct {
+	typ      object.DataType
+	nulls    bitmap
+	integers []int64
+	floats   []float64
+	strings  []string
+	booleans bitmap
+	objects  []object.Object
+}
+
+// bitmap is a bit for each row, set if the row is in the set
+type bitmap []uint64
+
+func (b bitmap) get(i int) bool {
+	return b[i/64]&(1<<(uint(i)%64)) != 0
+}
+
+// set sets or clears the bit of row i, which must be the next row, so the bitmap has room for i
+func (b *bitmap) set(i int, value bool) {
+	if i/64 == len(*b) {
+		*b = append(*b, 0)
+	}
+	if value {
+		(*b)[i/64] |= 1 << (uint(i) % 64)
+	}
+}
+
+// prefix returns the bits of the first n rows. The last word is copied, since
+// the bits of rows appended later are set in it.
+func (b bitmap) prefix(n int) bitmap {
+	full := n / 64
+	if n%64 == 0 {
+		return b[:full:full]
+	}
+	prefix := make(bitmap, full+1)
+	copy(prefix, b[:full+1])
+	return prefix
+}
+
+// appendValue appends the value, which is NULL or of the type of the vector, as row i
+func (v *vector) appendValue(i int, value object.Object) {
+	null := value.Type() == object.NULL_OBJ
+	v.nulls.set(i, null)
+	switch v.typ {
+	case object.INTEGER:
+		var n int64
+		if !null {
+			n = value.(*object.Integer).Value
+		}
+		v.integers = append(v.integers, n)
+	case object.FLOAT:
+		var f float64
+		if !null {
+			f = value.(*object.Float).Value
+		}
+		v.floats = append(v.floats, f)
+	case object.STRING:
+		var s string
+		if !null {
+			s = value.(*object.String).Value
+		}
+		v.strings = append(v.strings, s)
+	case object.BOOLEAN:
+		v.booleans.set(i, !null && value.(*object.Boolean).Value)
+	default:
+		v.objects = append(v.objects, value)
+	}
+}
+
+// snapshot returns the vector as of its first n rows, which can be read without holding the lock
+func (v *vector) snapshot(n int) *vector {
+	s := &vector{typ: v.typ, nulls: v.nulls.prefix(n)}
+	switch v.typ {
+	case object.INTEGER:
+		s.integers = v.integers[:n:n]
+	case object.FLOAT:
+		s.floats = v.floats[:n:n]
+	case object.STRING:
+		s.strings = v.strings[:n:n]
+	case object.BOOLEAN:
+		s.booleans = v.booleans.prefix(n)
+	default:
+		s.objects = v.objects[:n:n]
+	}
+	return s
+}
+
+// batch returns the values of the n rows from row from as a vector of a batch.
+// The values of columns that are not BOOLEAN columns are not copied.
+func (v *vector) batch(from int, n int) *object.Vector {
+	var out *object.Vector
+	switch v.typ {
+	case object.INTEGER:
+		out = &object.Vector{Type: object.INTEGER_OBJ, Integers: v.integers[from : from+n : from+n]}
+	case object.FLOAT:
+		out = &object.Vector{Type: object.FLOAT_OBJ, Floats: v.floats[from : from+n : from+n]}
+	case object.STRING:
+		out = &object.Vector{Type: object.STRING_OBJ, Strings: v.strings[from : from+n : from+n]}
+	case object.BOOLEAN:
+		out = &object.Vector{Type: object.BOOLEAN_OBJ, Booleans: make([]bool, n)}
+		for i := range out.Booleans {
+			out.Booleans[i] = v.booleans.get(from + i)
+		}
+	default:
+		out = &object.Vector{Type: object.ObjectType(v.typ), Objects: v.objects[from : from+n : from+n]}
+	}
+	for i := 0; i < n; i++ {
+		if v.nulls.get(from + i) {
+			if out.Nulls == nil {
+				out.Nulls = make([]bool, n)
+			}
+			out.Nulls[i] = true
+		}
+	}
+	return out
+}
+
+func NewBackend() *Backend {
+	return &Backend{
+		tables:     make(map[string]*table),
+		statistics: make(map[string]*object.TableStatistics),
+	}
+}
+
+func (b *Backend) Open() error  { return nil }
+func (b *Backend) Close() error { return nil }
+
+func (b *Backend) CreateTable(name string, columns []object.Column) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.tables[name]; ok {
+		return object.Errorf(object.CodeDuplicateTable, `relation "%s" already exists`, name)
+	}
+	b.createTable(name, columns)
+	return nil
+}
+
+func (b *Backend) createTable(name string, columns []object.Column) {
+	t := &table{columns: columns, vectors: make([]*vector, len(columns))}
+	for i, c := range columns {
+		t.vectors[i] = &vector{typ: c.Type}
+	}
+	b.tables[name] = t
+}
+
+func (b *Backend) Insert(name string, row object.Row) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, ok := b.tables[name]
+	if !ok {
+		return object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
+	}
+	if err := (object.Table{Name: name, Columns: t.columns}).CheckRow(row); err != nil {
+		return err
+	}
+	t.insert(row)
+	return nil
+}
+
+// insert appends the values of the row to the vectors
+func (t *table) insert(row object.Row) {
+	for i, v := range t.vectors {
+		v.appendValue(t.length, row.Values[i])
+	}
+	t.length++
+}
+
+// WriteBatch creates the tables and inserts the rows at once, so that other sessions see all of the changes or none of them.
+// Nothing is changed if a table already exists, or if a row can't be inserted.
+func (b *Backend) WriteBatch(tables []object.Table, rows map[string][]object.Row) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	created := make(map[string][]object.Column)
+	for _, table := range tables {
+		_, duplicate := created[table.Name]
+		if _, ok := b.tables[table.Name]; ok || duplicate {
+			return object.Errorf(object.CodeDuplicateTable, `relation "%s" already exists`, table.Name)
+		}
+		created[table.Name] = table.Columns
+	}
+	for name, tableRows := range rows {
+		columns, ok := created[name]
+		if t, exists := b.tables[name]; exists {
+			columns, ok = t.columns, true
+		}
+		if !ok {
+			return object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
+		}
+		for _, row := range tableRows {
+			if err := (object.Table{Name: name, Columns: columns}).CheckRow(row); err != nil {
+				return err
+			}
+		}
+	}
+	for _, table := range tables {
+		b.createTable(table.Name, table.Columns)
+	}
+	for name, tableRows := range rows {
+		t := b.tables[name]
+		for _, row := range tableRows {
+			t.insert(row)
+		}
+	}
+	return nil
+}
+
+// Delete deletes the rows in the table for which the function returns true, and returns the number of rows deleted.
+// If the function fails, no rows are deleted. The table gets new vectors, so scans that have started
+// keep reading the old ones.
+func (b *Backend) Delete(name string, match func(object.Row) (bool, error)) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, ok := b.tables[name]
+	if !ok {
+		return 0, object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
+	}
+	all := make([]int, len(t.columns))
+	for i := range all {
+		all[i] = i
+	}
+	it := &rowIterator{batches: t.scan(name, all)}
+	var kept []object.Row
+	for {
+		row, _ := it.Next()
+		if row == nil {
+			break
+		}
+		ok, err := match(*row)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			kept = append(kept, *row)
+		}
+	}
+	deleted := t.length - len(kept)
+	if deleted == 0 {
+		return 0, nil
+	}
+	b.createTable(name, t.columns)
+	for _, row := range kept {
+		b.tables[name].insert(row)
+	}
+	return deleted, nil
+}
+
+// Scan returns an iterator over the rows in the table.
+// Rows inserted or deleted after the scan has started are not seen by the iterator.
+func (b *Backend) Scan(name string) (object.RowIterator, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	t, ok := b.tables[name]
+	if !ok {
+		return nil, object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
+	}
+	all := make([]int, len(t.columns))
+	for i := range all {
+		all[i] = i
+	}
+	return &rowIterator{batches: t.scan(name, all)}, nil
+}
+
+// ScanColumns returns an iterator over the rows in the table, with the values of the columns
+// at the positions, in that order. The other columns are not read.
+func (b *Backend) ScanColumns(name string, columns []int) (object.RowIterator, error) {
+	batches, err := b.scanBatches(name, columns)
+	if err != nil {
+		return nil, err
+	}
+	return &rowIterator{batches: batches}, nil
+}
+
+// ScanBatches returns an iterator over the rows in the table a batch at a time, with vectors of the values
+// of the columns at the positions, in that order. The other columns are not read.
+func (b *Backend) ScanBatches(name string, columns []int) (object.BatchIterator, error) {
+	return b.scanBatches(name, columns)
+}
+
+// ScanPartitions returns n iterators over consecutive parts of the rows in the table, as of the same version
+// of the table, with the values of the columns at the positions, in that order. The iterators can be read
+// at the same time, and also return the rows a batch at a time, as object.BatchIterators.
+func (b *Backend) ScanPartitions(name string, columns []int, n int) ([]object.RowIterator, error) {
+	it, err := b.scanBatches(name, columns)
+	if err != nil {
+		return nil, err
+	}
+	partitions := make([]object.RowIterator, n)
+	for k := range partitions {
+		partition := *it
+		partition.position, partition.length = it.length*k/n, it.length*(k+1)/n
+		partitions[k] = &rowIterator{batches: &partition}
+	}
+	return partitions, nil
+}
+
+func (b *Backend) scanBatches(name string, columns []int) (*batchIterator, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	t, ok := b.tables[name]
+	if !ok {
+		return nil, object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
+	}
+	for _, i := range columns {
+		if i < 0 || i >= len(t.columns) {
+			return nil, fmt.Errorf(`relation "%s" has no column %d`, name, i)
+		}
+	}
+	return t.scan(name, columns), nil
+}
+
+// scan returns an iterator over the rows in the table as of now, which must be called with the lock held
+func (t *table) scan(name string, columns []int) *batchIterator {
+	it := &batchIterator{
+		vectors:    make([]*vector, len(columns)),
+		aliases:    make([]string, len(columns)),
+		tableNames: make([]string, len(columns)),
+		length:     t.length,
+	}
+	for j, i := range columns {
+		it.vectors[j] = t.vectors[i].snapshot(t.length)
+		it.aliases[j] = t.columns[i].Name
+		it.tableNames[j] = name
+	}
+	return it
+}
+
+// batchIterator returns the rows of the vectors, object.BatchSize rows at a time
+type batchIterator struct {
+	vectors []*vector
+	// the aliases and table names of every row, which are the same for all rows
+	aliases    []string
+	tableNames []string
+	// position is the first row that hasn't been returned, and length is the number of rows the scan sees
+	position, length int
+}
+
+func (it *batchIterator) NextBatch() (*object.Batch, error) {
+	n := it.length - it.position
+	if n <= 0 {
+		return nil, nil
+	}
+	if n > object.BatchSize {
+		n = object.BatchSize
+	}
+	b := &object.Batch{
+		Length:    n,
+		Vectors:   make([]*object.Vector, len(it.vectors)),
+		Selection: object.SelectAll(n),
+		Aliases:   it.aliases,
+		TableName: it.tableNames,
+	}
+	for j, v := range it.vectors {
+		b.Vectors[j] = v.batch(it.position, n)
+	}
+	it.position += n
+	return b, nil
+}
+
+func (it *batchIterator) Close() error {
+	it.position = it.length
+	return nil
+}
+
+// rowIterator makes the rows of the batches, a batch at a time
+type rowIterator struct {
+	batches *batchIterator
+	// rows is the rows made that haven't been returned
+	rows []object.Row
+}
+
+func (it *rowIterator) Next() (*object.Row, error) {
+	for len(it.rows) == 0 {
+		b, _ := it.batches.NextBatch()
+		if b == nil {
+			return nil, nil
+		}
+		it.rows = b.Rows()
+	}
+	row := &it.rows[0]
+	it.rows = it.rows[1:]
+	return row, nil
+}
+
+// NextBatch returns the next batch of rows, for reading the rows a batch at a time instead of with Next
+func (it *rowIterator) NextBatch() (*object.Batch, error) { return it.batches.NextBatch() }
+
+func (it *rowIterator) Close() error {
+	it.rows = nil
+	return it.batches.Close()
+}
+
+// RowCount returns the number of rows in the table.
+func (b *Backend) RowCount(name string) (int, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	t, ok := b.tables[name]
+	if !ok {
+		return 0, object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
+	}
+	return t.length, nil
+}
+
+func (b *Backend) Columns(name string) ([]object.Column, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if t, ok := b.tables[name]; ok {
+		return t.columns, nil
+	}
+	return nil, object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
+}
+
+// TableNames returns the names of all tables, sorted.
+func (b *Backend) TableNames() ([]string, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	names := make([]string, 0, len(b.tables))
+	for name := range b.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (b *Backend) SetStatistics(name string, stats *object.TableStatistics) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.tables[name]; !ok {
+		return object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
+	}
+	b.statistics[name] = stats
+	return nil
+}
+
+// Statistics returns the statistics of the table, or nil if the table hasn't been analyzed.
+func (b *Backend) Statistics(name string) (*object.TableStatistics, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if _, ok := b.tables[name]; !ok {
+		return nil, object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
+	}
+	return b.statistics[name], nil
+}
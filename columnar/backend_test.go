@@ -0,0 +1,204 @@
+package columnar_test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/vegarsti/sql/backendtest"
+	"github.com/vegarsti/sql/columnar"
+	"github.com/vegarsti/sql/evaluator"
+	"github.com/vegarsti/sql/inmemory"
+	"github.com/vegarsti/sql/lexer"
+	"github.com/vegarsti/sql/object"
+	"github.com/vegarsti/sql/parser"
+)
+
+func TestConformance(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) evaluator.Backend {
+		return columnar.NewBackend()
+	})
+}
+
+func eval(backend evaluator.Backend, input string) object.Object {
+	return evaluator.Eval(backend, parser.New(lexer.New(input)).ParseProgram())
+}
+
+func mustEval(t *testing.T, backend evaluator.Backend, input string) object.Object {
+	t.Helper()
+	result := eval(backend, input)
+	if err, ok := result.(*object.Error); ok {
+		t.Fatalf("%s: %s", input, err.Message)
+	}
+	return result
+}
+
+// createTables creates the same tables in both backends
+func createTables(t *testing.T, backends ...evaluator.Backend) {
+	for _, backend := range backends {
+		mustEval(t, backend, "create table foo (a integer, b text, c float, d boolean)")
+		mustEval(t, backend, "create table bar (a integer, e text)")
+		for i := 0; i < 100; i++ {
+			mustEval(t, backend, fmt.Sprintf("insert into foo values (%d, 'row %d', %d.5, %t)", i, i%7, i%3, i%2 == 0))
+		}
+		mustEval(t, backend, "insert into bar values (1, 'one'), (2, 'two'), (3, 'three')")
+	}
+}
+
+// TestScanColumns checks that queries reading some of the columns give the same results as in the in-memory backend
+func TestScanColumns(t *testing.T) {
+	backend := columnar.NewBackend()
+	reference := inmemory.NewBackend()
+	createTables(t, backend, reference)
+	queries := []string{
+		"select a from foo",
+		"select c, a from foo where d",
+		"select a from foo where b = 'row 3' order by c desc, a",
+		"select b, count(*), sum(c) from foo group by b order by b",
+		"select count(*) from foo",
+		"select 1 from bar",
+		"select foo.a, e from foo join bar on foo.a = bar.a where c > 1",
+		"select x.a, y.c from foo x join foo y on x.a = y.a where y.b = 'row 1' order by x.a limit 3",
+		"select c > 1, count(a) from foo group by c > 1 order by c > 1",
+	}
+	for _, query := range queries {
+		got := mustEval(t, backend, query).Inspect()
+		expected := mustEval(t, reference, query).Inspect()
+		if got != expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", query, expected, got)
+		}
+	}
+}
+
+func TestExplainScanColumns(t *testing.T) {
+	backend := columnar.NewBackend()
+	createTables(t, backend)
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"explain select a from foo where d", "Scan foo (a, d)"},
+		{"explain select count(*) from foo", "Scan foo ()"},
+		{"explain select c, b, a, d from foo", "Scan foo (a, b, c, d)"},
+	}
+	for _, tt := range tests {
+		result, ok := mustEval(t, backend, tt.input).(*object.Result)
+		if !ok {
+			t.Fatalf("%s: expected a result", tt.input)
+		}
+		last := result.Rows[len(result.Rows)-1].Values[0].Inspect()
+		if !strings.Contains(last, tt.expected) {
+			t.Errorf("%s: expected the plan to end with %q, got %s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestScanColumnsOrder(t *testing.T) {
+	backend := columnar.NewBackend()
+	createTables(t, backend)
+	it, err := backend.ScanColumns("foo", []int{2, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := object.Collect(it)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 100 {
+		t.Fatalf("expected 100 rows, got %d", len(rows))
+	}
+	row := rows[5]
+	if strings.Join(row.Aliases, ",") != "c,a" || len(row.Values) != 2 {
+		t.Errorf("unexpected row %+v", row)
+	}
+	if c, a := row.Values[0].(*object.Float), row.Values[1].(*object.Integer); c.Value != 2.5 || a.Value != 5 {
+		t.Errorf("expected c = 2.5 and a = 5, got %v and %v", c.Value, a.Value)
+	}
+	if _, err := backend.ScanColumns("foo", []int{4}); err == nil {
+		t.Errorf("expected an error for a column out of range")
+	}
+}
+
+// TestConcurrentInsertAndScan is meant to be run with the race detector
+func TestConcurrentInsertAndScan(t *testing.T) {
+	backend := columnar.NewBackend()
+	columns := []object.Column{{Name: "a", Type: object.INTEGER}, {Name: "b", Type: object.BOOLEAN}}
+	if err := backend.CreateTable("foo", columns); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			var b object.Object = &object.Boolean{Value: i%2 == 0}
+			if i%3 == 0 {
+				b = object.NULL
+			}
+			if err := backend.Insert("foo", object.Row{Values: []object.Object{&object.Integer{Value: int64(i)}, b}}); err != nil {
+				t.Error(err)
+			}
+			if i%100 == 99 {
+				if _, err := backend.Delete("foo", func(row object.Row) (bool, error) {
+					return row.Values[0].(*object.Integer).Value%100 == 0, nil
+				}); err != nil {
+					t.Error(err)
+				}
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			it, err := backend.ScanColumns("foo", []int{1})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := object.Collect(it); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	wg.Wait()
+}
+
+// BenchmarkScanOneColumn sums one column of a wide table, in the columnar and in the in-memory backend
+func BenchmarkScanOneColumn(b *testing.B) {
+	const width, rows = 20, 10000
+	columns := make([]object.Column, width)
+	for i := range columns {
+		columns[i] = object.Column{Name: fmt.Sprintf("c%c", 'a'+i), Type: object.INTEGER}
+	}
+	backends := []struct {
+		name    string
+		backend evaluator.Backend
+	}{
+		{"columnar", columnar.NewBackend()},
+		{"inmemory", inmemory.NewBackend()},
+	}
+	for _, bb := range backends {
+		if err := bb.backend.CreateTable("wide", columns); err != nil {
+			b.Fatal(err)
+		}
+		batch := make([]object.Row, rows)
+		for i := range batch {
+			row := object.Row{Values: make([]object.Object, width)}
+			for j := range row.Values {
+				row.Values[j] = &object.Integer{Value: int64(i * j)}
+			}
+			batch[i] = row
+		}
+		if err := bb.backend.(evaluator.BatchWriter).WriteBatch(nil, map[string][]object.Row{"wide": batch}); err != nil {
+			b.Fatal(err)
+		}
+		b.Run(bb.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if result, ok := eval(bb.backend, "select sum(ch) from wide").(*object.Error); ok {
+					b.Fatal(result.Message)
+				}
+			}
+		})
+	}
+}
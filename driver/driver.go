@@ -0,0 +1,361 @@
+// Package driver makes the database available through database/sql.
+//
+// The data source name selects the backend: "memory" for a database that lives in memory
+// until the sql.DB is closed, or "bolt:" followed by a path for a database stored in a Bolt file.
+//
+//	db, err := sql.Open("sql", "bolt:films.db")
+//	rows, err := db.Query("select title from films where year > $1", 2000)
+//
+// Parameters are written as $1, $2, ... or as ?. The errors of statements are *object.Error values
+// with the SQLSTATE code of the error, such as evaluator.ErrSerializationFailure.
+package driver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vegarsti/sql/bolt"
+	"github.com/vegarsti/sql/evaluator"
+	"github.com/vegarsti/sql/inmemory"
+	"github.com/vegarsti/sql/lexer"
+	"github.com/vegarsti/sql/object"
+	"github.com/vegarsti/sql/parser"
+)
+
+// DriverName is the name the driver is registered with in database/sql.
+const DriverName = "sql"
+
+func init() {
+	sql.Register(DriverName, &Driver{})
+}
+
+// Driver opens databases with the in-memory or Bolt backend.
+type Driver struct{}
+
+// Open opens a connection to a database of its own.
+// sql.Open uses OpenConnector instead, so that all connections of a sql.DB share the database.
+func (d *Driver) Open(dsn string) (driver.Conn, error) {
+	c, err := d.OpenConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return c.Connect(context.Background())
+}
+
+// OpenConnector parses the data source name. The backend is opened when the first connection is made.
+func (d *Driver) OpenConnector(dsn string) (driver.Connector, error) {
+	c := &connector{driver: d}
+	switch {
+	case dsn == "memory":
+		c.newBackend = func() evaluator.Backend { return inmemory.NewBackend() }
+	case strings.HasPrefix(dsn, "bolt:") && len(dsn) > len("bolt:"):
+		filename := strings.TrimPrefix(dsn, "bolt:")
+		c.newBackend = func() evaluator.Backend { return bolt.NewBackend(filename) }
+		// the file is closed when there are no connections, so other processes can open it
+		c.closeWhenIdle = true
+	default:
+		return nil, fmt.Errorf(`invalid data source name "%s": expected "memory" or "bolt:<path>"`, dsn)
+	}
+	return c, nil
+}
+
+// connector shares one backend between all connections of a sql.DB.
+type connector struct {
+	driver        *Driver
+	newBackend    func() evaluator.Backend
+	closeWhenIdle bool
+
+	mu          sync.Mutex
+	backend     evaluator.Backend
+	connections int
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.backend == nil {
+		backend := c.newBackend()
+		if err := backend.Open(); err != nil {
+			return nil, err
+		}
+		c.backend = backend
+	}
+	c.connections++
+	return &conn{connector: c, session: evaluator.NewSession(c.backend)}, nil
+}
+
+func (c *connector) Driver() driver.Driver { return c.driver }
+
+// release is called when a connection is closed
+func (c *connector) release() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connections--
+	if c.connections > 0 || !c.closeWhenIdle {
+		return nil
+	}
+	backend := c.backend
+	c.backend = nil
+	return backend.Close()
+}
+
+// conn is a connection, which has its own session, and thus its own prepared statements and transaction.
+type conn struct {
+	connector *connector
+	session   *evaluator.Session
+	closed    bool
+}
+
+// checkError returns an evaluated error as a Go error. The *object.Error is returned as it is, so that its code
+// can be read with errors.As, and errors.Is matches errors such as evaluator.ErrSerializationFailure.
+func checkError(evaluated object.Object) (object.Object, error) {
+	if errorObj, ok := evaluated.(*object.Error); ok {
+		return nil, errorObj
+	}
+	return evaluated, nil
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	prepared, err := c.session.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{conn: c, prepared: prepared}, nil
+}
+
+func (c *conn) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if c.session.InTransaction() {
+		c.session.Rollback()
+	}
+	return c.connector.release()
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx starts a transaction. The changes made in a transaction are not seen by other connections until it is committed.
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if opts.Isolation != driver.IsolationLevel(sql.LevelDefault) {
+		return nil, fmt.Errorf("isolation level %s is not supported", sql.IsolationLevel(opts.Isolation))
+	}
+	if opts.ReadOnly {
+		return nil, fmt.Errorf("read-only transactions are not supported")
+	}
+	if err := c.session.Begin(); err != nil {
+		return nil, err
+	}
+	return &tx{conn: c}, nil
+}
+
+// ExecContext runs queries without arguments directly, so that several statements can be run at once.
+// Queries with arguments are prepared by database/sql.
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if len(args) != 0 {
+		return nil, driver.ErrSkip
+	}
+	evaluated, err := c.evalProgram(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return newResult(evaluated), nil
+}
+
+// QueryContext runs queries without arguments directly. Queries with arguments are prepared by database/sql.
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if len(args) != 0 {
+		return nil, driver.ErrSkip
+	}
+	evaluated, err := c.evalProgram(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return newRows(evaluated), nil
+}
+
+func (c *conn) evalProgram(ctx context.Context, query string) (object.Object, error) {
+	p := parser.New(lexer.New(query))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, object.Errorf(object.CodeSyntaxError, "%s", strings.Join(p.Errors(), "; "))
+	}
+	return checkError(c.session.EvalContext(ctx, program))
+}
+
+type tx struct {
+	conn *conn
+}
+
+func (t *tx) Commit() error {
+	return t.conn.session.Commit()
+}
+
+func (t *tx) Rollback() error {
+	return t.conn.session.Rollback()
+}
+
+type stmt struct {
+	conn     *conn
+	prepared *evaluator.PreparedStatement
+}
+
+func (s *stmt) Close() error  { return nil }
+func (s *stmt) NumInput() int { return s.prepared.NumParameters() }
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	evaluated, err := s.execute(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return newResult(evaluated), nil
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	evaluated, err := s.execute(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return newRows(evaluated), nil
+}
+
+func (s *stmt) execute(ctx context.Context, args []driver.NamedValue) (object.Object, error) {
+	arguments := make([]interface{}, len(args))
+	for i, a := range args {
+		if a.Name != "" {
+			return nil, fmt.Errorf("named arguments are not supported: %s", a.Name)
+		}
+		switch v := a.Value.(type) {
+		case []byte:
+			arguments[i] = string(v)
+		default:
+			arguments[i] = v
+		}
+	}
+	return checkError(s.prepared.ExecuteContext(ctx, arguments...))
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return named
+}
+
+// newResult returns the number of inserted rows for an INSERT, and the number of returned rows for a SELECT
+func newResult(evaluated object.Object) driver.Result {
+	switch evaluated := evaluated.(type) {
+	case *object.OK:
+		return driver.RowsAffected(evaluated.RowsAffected)
+	case *object.Result:
+		return driver.RowsAffected(len(evaluated.Rows))
+	}
+	return driver.RowsAffected(0)
+}
+
+// rows returns the rows of a result. Statements that don't return rows have no columns.
+type rows struct {
+	result   *object.Result
+	position int
+}
+
+func newRows(evaluated object.Object) *rows {
+	result, ok := evaluated.(*object.Result)
+	if !ok {
+		result = &object.Result{}
+	}
+	return &rows{result: result}
+}
+
+func (r *rows) Columns() []string { return r.result.Aliases }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.position >= len(r.result.Rows) {
+		return io.EOF
+	}
+	row := r.result.Rows[r.position]
+	r.position++
+	for i, v := range row.Values {
+		switch v := v.(type) {
+		case *object.Integer:
+			dest[i] = v.Value
+		case *object.Float:
+			dest[i] = v.Value
+		case *object.String:
+			dest[i] = v.Value
+		case *object.Boolean:
+			dest[i] = v.Value
+		case *object.Date:
+			dest[i] = v.GoTime()
+		case *object.Timestamp:
+			dest[i] = v.GoTime()
+		case *object.TimestampTZ:
+			dest[i] = v.GoTime()
+		case *object.Time:
+			dest[i] = v.String()
+		case *object.Interval:
+			dest[i] = v.String()
+		case *object.Numeric:
+			// a numeric is scanned as its text, so that no digits are lost
+			dest[i] = v.String()
+		case *object.Null:
+			dest[i] = nil
+		default:
+			return fmt.Errorf("cannot convert %s to a Go value", v.Type())
+		}
+	}
+	return nil
+}
+
+func (r *rows) columnType(index int) object.DataType {
+	if index < len(r.result.Types) {
+		return r.result.Types[index]
+	}
+	return ""
+}
+
+// ColumnTypeDatabaseTypeName returns the type of the column, such as INTEGER, or an empty string if it isn't known.
+func (r *rows) ColumnTypeDatabaseTypeName(index int) string {
+	return string(r.columnType(index))
+}
+
+// ColumnTypeScanType returns the Go type of the values of the column, or interface{} if it isn't known.
+func (r *rows) ColumnTypeScanType(index int) reflect.Type {
+	switch r.columnType(index) {
+	case object.INTEGER:
+		return reflect.TypeOf(int64(0))
+	case object.FLOAT:
+		return reflect.TypeOf(float64(0))
+	case object.STRING, object.TIME, object.INTERVAL, object.NUMERIC:
+		return reflect.TypeOf("")
+	case object.BOOLEAN:
+		return reflect.TypeOf(false)
+	case object.DATE, object.TIMESTAMP, object.TIMESTAMPTZ:
+		return reflect.TypeOf(time.Time{})
+	}
+	return reflect.TypeOf((*interface{})(nil)).Elem()
+}
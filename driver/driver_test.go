@@ -0,0 +1,365 @@
+package driver_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/vegarsti/sql/driver"
+	"github.com/vegarsti/sql/evaluator"
+	"github.com/vegarsti/sql/object"
+)
+
+func openDatabases(t *testing.T) map[string]*sql.DB {
+	databases := make(map[string]*sql.DB)
+	for _, dsn := range []string{"memory", "bolt:" + filepath.Join(t.TempDir(), "test.db")} {
+		db, err := sql.Open("sql", dsn)
+		if err != nil {
+			t.Fatalf("open %s: %v", dsn, err)
+		}
+		t.Cleanup(func() { db.Close() })
+		databases[dsn[:4]] = db
+	}
+	return databases
+}
+
+func TestQueryAndExec(t *testing.T) {
+	for name, db := range openDatabases(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := db.Exec("create table films (title text, year integer, rating float)"); err != nil {
+				t.Fatalf("create table: %v", err)
+			}
+			result, err := db.Exec("insert into films values ('Alien', 1979, 8.5), ('Heat', 1995, 8.3)")
+			if err != nil {
+				t.Fatalf("insert: %v", err)
+			}
+			if n, err := result.RowsAffected(); err != nil || n != 2 {
+				t.Fatalf("expected 2 rows affected, got %d (%v)", n, err)
+			}
+			result, err = db.Exec("insert into films values ($1, $2, $3)", "Arrival", 2016, 7.9)
+			if err != nil {
+				t.Fatalf("insert with parameters: %v", err)
+			}
+			if n, err := result.RowsAffected(); err != nil || n != 1 {
+				t.Fatalf("expected 1 row affected, got %d (%v)", n, err)
+			}
+
+			rows, err := db.Query("select title, year, rating from films where year > $1 order by year", 1980)
+			if err != nil {
+				t.Fatalf("query: %v", err)
+			}
+			defer rows.Close()
+			columns, err := rows.Columns()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(columns, []string{"title", "year", "rating"}) {
+				t.Fatalf("unexpected columns %v", columns)
+			}
+			type film struct {
+				title  string
+				year   int
+				rating float64
+			}
+			var films []film
+			for rows.Next() {
+				var f film
+				if err := rows.Scan(&f.title, &f.year, &f.rating); err != nil {
+					t.Fatalf("scan: %v", err)
+				}
+				films = append(films, f)
+			}
+			if err := rows.Err(); err != nil {
+				t.Fatal(err)
+			}
+			expected := []film{{"Heat", 1995, 8.3}, {"Arrival", 2016, 7.9}}
+			if !reflect.DeepEqual(films, expected) {
+				t.Fatalf("expected %v, got %v", expected, films)
+			}
+		})
+	}
+}
+
+func TestQueryRow(t *testing.T) {
+	db, err := sql.Open("sql", "memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var (
+		s    string
+		b    bool
+		null sql.NullInt64
+	)
+	if err := db.QueryRow("select $1, 1 < 2, null", []byte("bytes")).Scan(&s, &b, &null); err != nil {
+		t.Fatal(err)
+	}
+	if s != "bytes" || !b || null.Valid {
+		t.Fatalf("unexpected values %q, %t, %v", s, b, null)
+	}
+}
+
+func TestColumnTypes(t *testing.T) {
+	db, err := sql.Open("sql", "memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("create table t (a integer, b float, c text); insert into t values (1, 1.5, 'x')"); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := db.Query("select a, b, c, a > 0 from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		databaseType string
+		scanType     reflect.Type
+	}{
+		{"INTEGER", reflect.TypeOf(int64(0))},
+		{"FLOAT", reflect.TypeOf(float64(0))},
+		{"STRING", reflect.TypeOf("")},
+		{"BOOLEAN", reflect.TypeOf(false)},
+	}
+	if len(types) != len(expected) {
+		t.Fatalf("expected %d column types, got %d", len(expected), len(types))
+	}
+	for i, tt := range expected {
+		if types[i].DatabaseTypeName() != tt.databaseType {
+			t.Errorf("column %d: expected database type %s, got %s", i, tt.databaseType, types[i].DatabaseTypeName())
+		}
+		if types[i].ScanType() != tt.scanType {
+			t.Errorf("column %d: expected scan type %s, got %s", i, tt.scanType, types[i].ScanType())
+		}
+	}
+}
+
+func TestTemporal(t *testing.T) {
+	db, err := sql.Open("sql", "memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("create table events (d date, z timestamptz, i interval)"); err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 1, 31, 10, 30, 0, 0, time.FixedZone("", 2*60*60))
+	if _, err := db.Exec("insert into events values ($1, $1, '1 month 2 days')", at); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		d, z time.Time
+		i    string
+	)
+	if err := db.QueryRow("select d, z, i from events").Scan(&d, &z, &i); err != nil {
+		t.Fatal(err)
+	}
+	if !d.Equal(time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)) || !z.Equal(at) || i != "1 mon 2 days" {
+		t.Fatalf("unexpected values %v, %v, %q", d, z, i)
+	}
+	rows, err := db.Query("select d, i from events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if types[0].ScanType() != reflect.TypeOf(time.Time{}) || types[1].ScanType() != reflect.TypeOf("") {
+		t.Fatalf("unexpected scan types %s, %s", types[0].ScanType(), types[1].ScanType())
+	}
+}
+
+func TestNumeric(t *testing.T) {
+	db, err := sql.Open("sql", "memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("create table prices (p numeric(20, 2))"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("insert into prices values ($1)", "123456789012345678.905"); err != nil {
+		t.Fatal(err)
+	}
+	var p string
+	if err := db.QueryRow("select p from prices").Scan(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p != "123456789012345678.91" {
+		t.Fatalf("unexpected value %q", p)
+	}
+}
+
+func TestTransactions(t *testing.T) {
+	for name, db := range openDatabases(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := db.Exec("create table t (a integer)"); err != nil {
+				t.Fatal(err)
+			}
+			count := func() int {
+				var n int
+				if err := db.QueryRow("select count(*) from t").Scan(&n); err != nil {
+					t.Fatal(err)
+				}
+				return n
+			}
+
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tx.Exec("insert into t values ($1)", 1); err != nil {
+				t.Fatal(err)
+			}
+			var n int
+			if err := tx.QueryRow("select count(*) from t").Scan(&n); err != nil {
+				t.Fatal(err)
+			}
+			if n != 1 {
+				t.Fatalf("expected the transaction to see its own row, got %d rows", n)
+			}
+			if n := count(); n != 0 {
+				t.Fatalf("expected uncommitted rows to be hidden, got %d rows", n)
+			}
+			if err := tx.Rollback(); err != nil {
+				t.Fatal(err)
+			}
+			if n := count(); n != 0 {
+				t.Fatalf("expected 0 rows after rollback, got %d", n)
+			}
+
+			tx, err = db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tx.Exec("insert into t values (1), (2)"); err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			if n := count(); n != 2 {
+				t.Fatalf("expected 2 rows after commit, got %d", n)
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	if _, err := sql.Open("sql", "postgres://localhost"); err == nil || err.Error() != `invalid data source name "postgres://localhost": expected "memory" or "bolt:<path>"` {
+		t.Fatalf("unexpected error %v", err)
+	}
+	db, err := sql.Open("sql", "memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("create table t (a integer)"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		query    string
+		args     []interface{}
+		expected string
+	}{
+		{"create table t (a integer)", nil, `relation "t" already exists`},
+		{"insert into t values ($1, $2)", []interface{}{1, 2}, `table "t" has 1 column but 2 values were supplied`},
+		{"select $1", []interface{}{1, 2}, "sql: expected 1 arguments, got 2"},
+		{"select $1", []interface{}{sql.Named("a", 1)}, "named arguments are not supported: a"},
+		{"select from", nil, "no prefix parse function for FROM token with literal 'FROM' found"},
+	}
+	for _, tt := range tests {
+		_, err := db.Exec(tt.query, tt.args...)
+		if err == nil {
+			t.Errorf("%s: expected error %q", tt.query, tt.expected)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("%s: expected error %q, got %q", tt.query, tt.expected, err.Error())
+		}
+	}
+}
+
+// TestErrorValues checks that errors keep their code and identity, so they can be matched with errors.Is and errors.As
+func TestErrorValues(t *testing.T) {
+	db, err := sql.Open("sql", "memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("create table t (a integer); insert into t values (1)"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("create table t (a integer)")
+	var errorObj *object.Error
+	if !errors.As(err, &errorObj) || errorObj.Code != object.CodeDuplicateTable {
+		t.Fatalf("expected an error with code %s, got %#v", object.CodeDuplicateTable, err)
+	}
+	ctx := context.Background()
+	a, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	for _, c := range []*sql.Conn{a, b} {
+		if _, err := c.ExecContext(ctx, "begin; delete from t where a = 1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := a.ExecContext(ctx, "commit"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.ExecContext(ctx, "commit"); !errors.Is(err, evaluator.ErrSerializationFailure) {
+		t.Fatalf("expected a serialization failure, got %v", err)
+	}
+}
+
+func TestContext(t *testing.T) {
+	db, err := sql.Open("sql", "memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	values := make([]string, 300)
+	for i := range values {
+		values[i] = fmt.Sprintf("(%d)", i)
+	}
+	if _, err := db.Exec("create table t (a integer); insert into t values " + strings.Join(values, ", ")); err != nil {
+		t.Fatal(err)
+	}
+	// the query is stopped when the context times out, long before the 8 billion rows of the cross join are counted
+	crossJoin := "select count(*) from t w, t x, t y, t z"
+	for _, query := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{crossJoin, nil},
+		{crossJoin + " where w.a >= $1", []interface{}{0}},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		var n int
+		err := db.QueryRowContext(ctx, query.query, query.args...).Scan(&n)
+		cancel()
+		if err == nil || err.Error() != "canceling statement due to statement timeout" {
+			t.Fatalf("expected the query to time out, got %v", err)
+		}
+	}
+}
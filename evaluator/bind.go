@@ -0,0 +1,143 @@
+package evaluator
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/vegarsti/sql/ast"
+	"github.com/vegarsti/sql/object"
+	"github.com/vegarsti/sql/token"
+)
+
+// binder copies a statement, replacing its parameters by literals with the values of the arguments.
+// The statement itself is not changed, since evaluating a statement changes its identifiers and aliases.
+// Without arguments, the parameters are kept, which is used to count them.
+// If now is set, calls of now() are replaced by it, so that now() is the same throughout a statement.
+// The columns of the tables in qualify are named by their table, as EXPLAIN shows them for plans of several tables.
+type binder struct {
+	arguments []object.Object
+	now       *object.TimestampTZ
+	qualify   map[string]bool
+	// parameters is the highest parameter number seen
+	parameters int
+}
+
+func (b *binder) statement(stmt ast.Statement) ast.Statement {
+	switch stmt := stmt.(type) {
+	case *ast.SelectStatement:
+		bound := *stmt
+		bound.Expressions = b.expressions(stmt.Expressions)
+		// the names of the columns are given by the statement, not by the arguments
+		bound.Aliases = make([]string, len(stmt.Aliases))
+		for i, alias := range stmt.Aliases {
+			if alias == "" {
+				alias = stmt.Expressions[i].String()
+			}
+			bound.Aliases[i] = alias
+		}
+		bound.From = make([]*ast.From, len(stmt.From))
+		for i, from := range stmt.From {
+			bound.From[i] = b.from(from)
+		}
+		bound.OrderBy = make([]*ast.OrderByExpression, len(stmt.OrderBy))
+		for i, o := range stmt.OrderBy {
+			bound.OrderBy[i] = &ast.OrderByExpression{Expression: b.expression(o.Expression), Descending: o.Descending}
+		}
+		if stmt.Where != nil {
+			bound.Where = b.expression(stmt.Where)
+		}
+		bound.GroupBy = b.expressions(stmt.GroupBy)
+		return &bound
+	case *ast.InsertStatement:
+		bound := &ast.InsertStatement{TableName: stmt.TableName, Rows: make([][]ast.Expression, len(stmt.Rows))}
+		for i, row := range stmt.Rows {
+			bound.Rows[i] = b.expressions(row)
+		}
+		return bound
+	case *ast.DeleteStatement:
+		bound := &ast.DeleteStatement{TableName: stmt.TableName}
+		if stmt.Where != nil {
+			bound.Where = b.expression(stmt.Where)
+		}
+		return bound
+	case *ast.ExplainStatement:
+		return &ast.ExplainStatement{Statement: b.statement(stmt.Statement), Analyze: stmt.Analyze}
+	}
+	return stmt
+}
+
+func (b *binder) from(from *ast.From) *ast.From {
+	bound := *from
+	if from.Join != nil {
+		bound.Join = &ast.Join{With: b.from(from.Join.With), JoinType: from.Join.JoinType}
+		if from.Join.Predicate != nil {
+			bound.Join.Predicate = b.expression(from.Join.Predicate)
+		}
+	}
+	return &bound
+}
+
+func (b *binder) expressions(expressions []ast.Expression) []ast.Expression {
+	bound := make([]ast.Expression, len(expressions))
+	for i, e := range expressions {
+		bound[i] = b.expression(e)
+	}
+	return bound
+}
+
+func (b *binder) expression(e ast.Expression) ast.Expression {
+	switch e := e.(type) {
+	case *ast.Parameter:
+		if e.Index > b.parameters {
+			b.parameters = e.Index
+		}
+		if b.arguments == nil {
+			return e
+		}
+		return literal(b.arguments[e.Index-1])
+	case *ast.Identifier:
+		bound := *e
+		if b.qualify[e.Table] {
+			bound.Value = e.Table + "." + e.Value
+		}
+		return &bound
+	case *ast.PrefixExpression:
+		return &ast.PrefixExpression{Token: e.Token, Operator: e.Operator, Right: b.expression(e.Right)}
+	case *ast.InfixExpression:
+		return &ast.InfixExpression{Token: e.Token, Left: b.expression(e.Left), Operator: e.Operator, Right: b.expression(e.Right)}
+	case *ast.PostfixExpression:
+		return &ast.PostfixExpression{Token: e.Token, Operator: e.Operator, Left: b.expression(e.Left)}
+	case *ast.CallExpression:
+		if e.Function == "now" && len(e.Arguments) == 0 && !e.Star && b.now != nil {
+			return literal(b.now)
+		}
+		return &ast.CallExpression{Token: e.Token, Function: e.Function, Arguments: b.expressions(e.Arguments), Star: e.Star}
+	}
+	// literals are never changed, so they can be shared
+	return e
+}
+
+// literal returns the literal expression for the value
+func literal(v object.Object) ast.Expression {
+	switch v := v.(type) {
+	case *object.Integer:
+		s := strconv.FormatInt(v.Value, 10)
+		return &ast.IntegerLiteral{Token: token.Token{Type: token.INT_LITERAL, Literal: s}, Value: v.Value}
+	case *object.Float:
+		s := strconv.FormatFloat(v.Value, 'f', -1, 64)
+		return &ast.FloatLiteral{Token: token.Token{Type: token.FLOAT_LITERAL, Literal: s}, Value: v.Value}
+	case *object.String:
+		return &ast.StringLiteral{Token: token.Token{Type: token.STRING_LITERAL, Literal: v.Value}, Value: v.Value}
+	case *object.Boolean:
+		if v.Value {
+			return &ast.BooleanLiteral{Token: token.Token{Type: token.TRUE, Literal: "TRUE"}, Value: true}
+		}
+		return &ast.BooleanLiteral{Token: token.Token{Type: token.FALSE, Literal: "FALSE"}, Value: false}
+	case *object.Date, *object.Time, *object.Timestamp, *object.TimestampTZ, *object.Interval:
+		t := string(v.Type())
+		return &ast.TypedLiteral{Token: token.Token{Type: token.TokenType(t), Literal: t}, Value: v.(fmt.Stringer).String()}
+	case *object.Numeric:
+		return &ast.TypedLiteral{Token: token.Token{Type: token.NUMERIC_TYPE, Literal: "NUMERIC"}, Value: v.String()}
+	}
+	return ast.NULL
+}
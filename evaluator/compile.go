@@ -0,0 +1,209 @@
+package evaluator
+
+import (
+	"github.com/vegarsti/sql/ast"
+	"github.com/vegarsti/sql/object"
+)
+
+// schema is the columns of the rows an operator returns, by alias and table name
+type schema struct {
+	aliases []string
+	tables  []string
+}
+
+func tableSchema(table string, columns []object.Column) schema {
+	s := schema{aliases: make([]string, len(columns)), tables: make([]string, len(columns))}
+	for i, c := range columns {
+		s.aliases[i] = c.Name
+		s.tables[i] = table
+	}
+	return s
+}
+
+// concat is the schema of the rows of a join
+func (s schema) concat(other schema) schema {
+	return schema{
+		aliases: append(append(make([]string, 0, len(s.aliases)+len(other.aliases)), s.aliases...), other.aliases...),
+		tables:  append(append(make([]string, 0, len(s.tables)+len(other.tables)), s.tables...), other.tables...),
+	}
+}
+
+// position returns the position of the column, or -1 if there is no such column
+func (s schema) position(identifier *ast.Identifier) int {
+	for i := range s.aliases {
+		if s.aliases[i] == identifier.Value && s.tables[i] == identifier.Table {
+			return i
+		}
+	}
+	return -1
+}
+
+// evalFunc evaluates an expression for a row
+type evalFunc func(row object.Row) object.Object
+
+// compiled is an expression compiled to a function, which is evaluated for rows of the schema it was compiled for.
+// It gives the same results as evalExpression, without walking the expression or looking up columns by name for each row.
+type compiled struct {
+	ast.Expression
+	eval evalFunc
+}
+
+func (c compiled) Eval(row object.Row) object.Object { return c.eval(row) }
+
+// compile compiles the expression for rows of the schema. The identifiers in the expression must have been normalized.
+func compile(node ast.Expression, s schema) compiled {
+	eval, _ := compileExpression(node, s)
+	return compiled{Expression: node, eval: eval}
+}
+
+// compileExpression returns the function evaluating the expression, and the value of the expression
+// if it is the same for all rows. Such constant parts are evaluated once, when they are compiled.
+func compileExpression(node ast.Expression, s schema) (evalFunc, object.Object) {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		i := s.position(node)
+		if i == -1 {
+			return constant(evalExpression(object.Row{}, node))
+		}
+		return func(row object.Row) object.Object { return row.Values[i] }, nil
+	case *ast.PrefixExpression:
+		right, rightValue := compileExpression(node.Right, s)
+		if rightValue != nil {
+			if isError(rightValue) {
+				return constant(rightValue)
+			}
+			return constant(evalPrefixExpression(node.Operator, rightValue))
+		}
+		return func(row object.Row) object.Object {
+			v := right(row)
+			if isError(v) {
+				return v
+			}
+			return evalPrefixExpression(node.Operator, v)
+		}, nil
+	case *ast.PostfixExpression:
+		left, leftValue := compileExpression(node.Left, s)
+		if leftValue != nil {
+			if isError(leftValue) {
+				return constant(leftValue)
+			}
+			return constant(evalPostfixExpression(leftValue, node.Operator))
+		}
+		return func(row object.Row) object.Object {
+			v := left(row)
+			if isError(v) {
+				return v
+			}
+			return evalPostfixExpression(v, node.Operator)
+		}, nil
+	case *ast.InfixExpression:
+		return compileInfixExpression(node, s)
+	case *ast.CallExpression:
+		if _, ok := scalarFunctions[node.Function]; ok {
+			return compileCallExpression(node, s)
+		}
+	}
+	// literals, and expressions that can only be errors here, such as unbound parameters
+	return constant(evalExpression(object.Row{}, node))
+}
+
+// compileCallExpression compiles a call of a scalar function, which is evaluated once if all the arguments are constant
+func compileCallExpression(node *ast.CallExpression, s schema) (evalFunc, object.Object) {
+	arguments := make([]evalFunc, len(node.Arguments))
+	values := make([]object.Object, len(node.Arguments))
+	isConstant := true
+	for i, a := range node.Arguments {
+		arguments[i], values[i] = compileExpression(a, s)
+		if values[i] == nil {
+			isConstant = false
+		} else if isError(values[i]) {
+			return constant(values[i])
+		}
+	}
+	if isConstant {
+		return constant(evalFunction(node, values))
+	}
+	return func(row object.Row) object.Object {
+		values := make([]object.Object, len(arguments))
+		for i, argument := range arguments {
+			values[i] = argument(row)
+			if isError(values[i]) {
+				return values[i]
+			}
+		}
+		return evalFunction(node, values)
+	}, nil
+}
+
+func constant(v object.Object) (evalFunc, object.Object) {
+	return func(object.Row) object.Object { return v }, v
+}
+
+func compileInfixExpression(node *ast.InfixExpression, s schema) (evalFunc, object.Object) {
+	left, leftValue := compileExpression(node.Left, s)
+	right, rightValue := compileExpression(node.Right, s)
+	if leftValue != nil && isError(leftValue) {
+		return constant(leftValue)
+	}
+	operator := node.Operator
+	if operator == "AND" || operator == "OR" {
+		decided := operator == "OR"
+		if decides(leftValue, decided) {
+			return constant(leftValue)
+		}
+		if leftValue == nil {
+			return func(row object.Row) object.Object {
+				l := left(row)
+				if isError(l) || decides(l, decided) {
+					return l
+				}
+				r := right(row)
+				if isError(r) {
+					return r
+				}
+				return evalInfixExpression(operator, l, r)
+			}, nil
+		}
+	}
+	switch {
+	case leftValue != nil && rightValue != nil:
+		if isError(rightValue) {
+			return constant(rightValue)
+		}
+		return constant(evalInfixExpression(operator, leftValue, rightValue))
+	case leftValue != nil:
+		return func(row object.Row) object.Object {
+			r := right(row)
+			if isError(r) {
+				return r
+			}
+			return evalInfixExpression(operator, leftValue, r)
+		}, nil
+	case rightValue != nil && isError(rightValue):
+		return func(row object.Row) object.Object {
+			if l := left(row); isError(l) {
+				return l
+			}
+			return rightValue
+		}, nil
+	case rightValue != nil:
+		return func(row object.Row) object.Object {
+			l := left(row)
+			if isError(l) {
+				return l
+			}
+			return evalInfixExpression(operator, l, rightValue)
+		}, nil
+	}
+	return func(row object.Row) object.Object {
+		l := left(row)
+		if isError(l) {
+			return l
+		}
+		r := right(row)
+		if isError(r) {
+			return r
+		}
+		return evalInfixExpression(operator, l, r)
+	}, nil
+}
@@ -0,0 +1,162 @@
+package evaluator
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/vegarsti/sql/ast"
+	"github.com/vegarsti/sql/lexer"
+	"github.com/vegarsti/sql/object"
+	"github.com/vegarsti/sql/parser"
+)
+
+// testSchema is the schema of the rows of testRow
+var testSchema = tableSchema("foo", []object.Column{
+	{Name: "a", Type: object.INTEGER},
+	{Name: "b", Type: object.FLOAT},
+	{Name: "c", Type: object.STRING},
+	{Name: "d", Type: object.BOOLEAN},
+})
+
+func testRow(a int64) object.Row {
+	return object.Row{
+		Values:    []object.Object{&object.Integer{Value: a}, &object.Float{Value: 1.5}, &object.String{Value: "abc"}, &object.False},
+		Aliases:   testSchema.aliases,
+		TableName: testSchema.tables,
+	}
+}
+
+// parseExpression parses the expression, with the identifiers referring to the columns of table foo
+func parseExpression(t testing.TB, input string) ast.Expression {
+	program := parser.New(lexer.New("select " + input)).ParseProgram()
+	e := program.Statements[0].(*ast.SelectStatement).Expressions[0]
+	identifiers, err := identifiersInExpression(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range identifiers {
+		if id.Table == "" {
+			id.Table = "foo"
+		}
+	}
+	return e
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a", "7"},
+		{"(a + 1) * 2", "16"},
+		{"-a", "-7"},
+		{"b * 2", "3.000000"},
+		{"c || 'd'", "'abcd'"},
+		{"not d", "true"},
+		{"a is null", "false"},
+		{"(1 + 2) * a", "21"},
+		{"a / (2 - 2)", "ERROR: division by zero"},
+		{"(a / 0) = 1 and true", "ERROR: division by zero"},
+		// the right side of AND and OR is not evaluated when the left side decides the result
+		{"d and (a / 0) = 1", "false"},
+		{"(a = 7) or (a / 0) = 1", "true"},
+		{"(a = 7) and d", "false"},
+		{"(1 = 1) or a", "true"},
+		{"a and true", "ERROR: unknown operator: INTEGER AND BOOLEAN"},
+		{"-c", "ERROR: unknown operator: -STRING"},
+		{"e + 1", "ERROR: column foo.e does not exist"},
+		{"a + foo.e", "ERROR: column foo.e does not exist"},
+		{"$1 + a", "ERROR: there is no parameter $1"},
+		{"sum(a) + 1", "ERROR: aggregate function calls cannot be nested"},
+	}
+	for _, tt := range tests {
+		e := parseExpression(t, tt.input)
+		got := compile(e, testSchema).Eval(testRow(7)).Inspect()
+		if got != tt.expected {
+			t.Fatalf("%s: expected %s. got=%s", tt.input, tt.expected, got)
+		}
+		if interpreted := evalExpression(testRow(7), e).Inspect(); got != interpreted {
+			t.Fatalf("%s: expected the same result as the interpreter, %s. got=%s", tt.input, interpreted, got)
+		}
+	}
+}
+
+func TestCompileFoldsConstants(t *testing.T) {
+	tests := []struct {
+		input    string
+		constant string
+	}{
+		{"1 + 2 * 3", "7"},
+		{"'a' || 'b'", "'ab'"},
+		{"false and (a = 1)", "false"},
+		{"true or (a = 1)", "true"},
+		{"1 / 0", "ERROR: division by zero"},
+		{"a + 1", ""},
+		{"(a = 1) and false", ""},
+	}
+	for _, tt := range tests {
+		_, v := compileExpression(parseExpression(t, tt.input), testSchema)
+		got := ""
+		if v != nil {
+			got = v.Inspect()
+		}
+		if got != tt.constant {
+			t.Fatalf("%s: expected constant %q. got=%q", tt.input, tt.constant, got)
+		}
+	}
+}
+
+var benchmarkExpressions = []string{
+	"a",
+	"((a % 7) = 3) and (b > 1.0)",
+	"(a * 2 + 1) * (3 + 4) - b",
+	"(c || 'x') = 'abcx'",
+}
+
+func BenchmarkInterpret(b *testing.B) {
+	for _, input := range benchmarkExpressions {
+		e := parseExpression(b, input)
+		row := testRow(7)
+		b.Run(input, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				evalExpression(row, e)
+			}
+		})
+	}
+}
+
+func BenchmarkCompiled(b *testing.B) {
+	for _, input := range benchmarkExpressions {
+		c := compile(parseExpression(b, input), testSchema)
+		row := testRow(7)
+		b.Run(input, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				c.Eval(row)
+			}
+		})
+	}
+}
+
+// BenchmarkCompiledWideRow shows the cost of finding columns by name, for a column at the end of a wide row
+func BenchmarkCompiledWideRow(b *testing.B) {
+	var columns []object.Column
+	row := object.Row{}
+	for i := 0; i < 50; i++ {
+		columns = append(columns, object.Column{Name: fmt.Sprintf("c%c%c", 'a'+i/26, 'a'+i%26), Type: object.INTEGER})
+		row.Values = append(row.Values, &object.Integer{Value: int64(i)})
+	}
+	s := tableSchema("foo", columns)
+	row.Aliases, row.TableName = s.aliases, s.tables
+	e := parseExpression(b, "(cbx + 1) > cbw")
+	b.Run("interpret", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			evalExpression(row, e)
+		}
+	})
+	b.Run("compiled", func(b *testing.B) {
+		c := compile(e, s)
+		for i := 0; i < b.N; i++ {
+			c.Eval(row)
+		}
+	})
+}
@@ -1,39 +1,39 @@
 package evaluator
 
 import (
+	"context"
 	"fmt"
 	"math"
-	"sort"
+	"strings"
+	"time"
 
 	"github.com/vegarsti/sql/ast"
+	"github.com/vegarsti/sql/executor"
 	"github.com/vegarsti/sql/object"
+	"github.com/vegarsti/sql/planner"
+	"github.com/vegarsti/sql/token"
 )
 
+// Backend stores the tables. Backends must be safe for concurrent use, since each session
+// evaluates statements against the same backend.
 type Backend interface {
 	CreateTable(string, []object.Column) error
 	Insert(string, object.Row) error
-	Rows(string) ([]object.Row, error)
+	Scan(string) (object.RowIterator, error)
 	Columns(string) ([]object.Column, error)
 	Open() error
 	Close() error
 }
 
+// Eval evaluates the node in a new session, so state such as prepared statements
+// only lives until the end of the node. Use a Session to keep it between calls.
 func Eval(backend Backend, node ast.Node) object.Object {
-	switch node := node.(type) {
-	case *ast.Program:
-		return evalStatements(backend, node.Statements)
-	case *ast.SelectStatement:
-		return evalSelectStatement(backend, node)
-	case *ast.CreateTableStatement:
-		return evalCreateTableStatement(backend, node)
-	case *ast.InsertStatement:
-		return evalInsertStatement(backend, node)
-	default:
-		if expression, ok := node.(ast.Expression); ok {
-			return evalExpression(object.Row{}, expression)
-		}
-		return newError("unknown node type %T", node)
-	}
+	return EvalContext(context.Background(), backend, node)
+}
+
+// EvalContext is like Eval, but stops evaluating a statement with an error when the context is done.
+func EvalContext(ctx context.Context, backend Backend, node ast.Node) object.Object {
+	return NewSession(backend).EvalContext(ctx, node)
 }
 
 func evalExpression(row object.Row, node ast.Expression) object.Object {
@@ -49,6 +49,9 @@ func evalExpression(row object.Row, node ast.Expression) object.Object {
 		if isError(left) {
 			return left
 		}
+		if (node.Operator == "AND" || node.Operator == "OR") && decides(left, node.Operator == "OR") {
+			return left
+		}
 		right := evalExpression(row, node.Right)
 		if isError(right) {
 			return right
@@ -71,8 +74,27 @@ func evalExpression(row object.Row, node ast.Expression) object.Object {
 		return &object.Float{Value: node.Value}
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
+	case *ast.TypedLiteral:
+		return evalTypedLiteral(node)
 	case *ast.Null:
 		return object.NULL
+	case *ast.Parameter:
+		return object.Errorf(object.CodeUndefinedParameter, "there is no parameter $%d", node.Index)
+	case *ast.CallExpression:
+		if executor.IsAggregateFunction(node.Function) {
+			return object.Errorf(object.CodeGroupingError, "aggregate function calls cannot be nested")
+		}
+		if _, ok := scalarFunctions[node.Function]; !ok {
+			return object.Errorf(object.CodeUndefinedFunction, "function %s does not exist", node.Function)
+		}
+		arguments := make([]object.Object, len(node.Arguments))
+		for i, a := range node.Arguments {
+			arguments[i] = evalExpression(row, a)
+			if isError(arguments[i]) {
+				return arguments[i]
+			}
+		}
+		return evalFunction(node, arguments)
 	case *ast.Identifier:
 		if row.Values != nil && row.Aliases != nil {
 			for i := range row.Values {
@@ -82,36 +104,19 @@ func evalExpression(row object.Row, node ast.Expression) object.Object {
 			}
 		}
 		if node.Table != "" {
-			return newError("column %s.%s does not exist", node.Table, node.Value)
+			return object.Errorf(object.CodeUndefinedColumn, "column %s.%s does not exist", node.Table, node.Value)
 		}
-		return newError("no such column: %s", node.Value)
+		return object.Errorf(object.CodeUndefinedColumn, "no such column: %s", node.Value)
 	default:
 		return newError("unknown expression type %T", node)
 	}
 }
 
-func evalStatements(backend Backend, stmts []ast.Statement) object.Object {
-	var result object.Object
-	for _, statement := range stmts {
-		result = Eval(backend, statement)
-		if isError(result) {
-			return result
-		}
-	}
-	return result
-}
-
-// concatenateRows by simply splicing the tuples together.
-// Used when joining tables
-func concatenateRows(row1 object.Row, row2 object.Row) object.Row {
-	aliases := append(row1.Aliases, row2.Aliases...)
-	values := append(row1.Values, row2.Values...)
-	tableNames := append(row1.TableName, row2.TableName...)
-	return object.Row{
-		Aliases:   aliases,
-		Values:    values,
-		TableName: tableNames,
-	}
+// decides reports whether the left side of AND or OR decides the result, so that the right side is not evaluated:
+// the result is false if the left side of AND is false, and true if the left side of OR is true
+func decides(left object.Object, decided bool) bool {
+	b, ok := left.(*object.Boolean)
+	return ok && b.Value == decided
 }
 
 // identifiersInExpression walks the node and returns a slice of all identifiers as strings
@@ -136,7 +141,23 @@ func identifiersInExpression(node ast.Expression) ([]*ast.Identifier, error) {
 		identifiers = append(identifiers, left...)
 		identifiers = append(identifiers, right...)
 		return identifiers, nil
-	case *ast.IntegerLiteral, *ast.BooleanLiteral, *ast.FloatLiteral, *ast.StringLiteral, *ast.PostfixExpression, *ast.Null:
+	case *ast.PostfixExpression:
+		left, err := identifiersInExpression(node.Left)
+		if err != nil {
+			return nil, err
+		}
+		return left, nil
+	case *ast.CallExpression:
+		var identifiers []*ast.Identifier
+		for _, argument := range node.Arguments {
+			ids, err := identifiersInExpression(argument)
+			if err != nil {
+				return nil, err
+			}
+			identifiers = append(identifiers, ids...)
+		}
+		return identifiers, nil
+	case *ast.IntegerLiteral, *ast.BooleanLiteral, *ast.FloatLiteral, *ast.StringLiteral, *ast.TypedLiteral, *ast.Null, *ast.Parameter:
 		return nil, nil
 	case *ast.Identifier:
 		return []*ast.Identifier{node}, nil
@@ -144,53 +165,62 @@ func identifiersInExpression(node ast.Expression) ([]*ast.Identifier, error) {
 	return nil, fmt.Errorf("unknown expression type %T", node)
 }
 
-// normalizeIdentifiers mutates all identifiers so that we have the table name and column name for all identifiers
-// We may return a non-nil error here, which should be returned back to the user
-func normalizeIdentifiers(backend Backend, stmt *ast.SelectStatement) error {
-	columns := make(map[string]map[string]bool)
-	tableToAlias := make(map[string]string)
-	tableReferences := make(map[string]int)
+// fromColumns returns the columns of each table in the FROM clause, by the name the table is referenced by in the statement:
+// its alias, or the table name if it has no alias
+func fromColumns(backend Backend, stmt *ast.SelectStatement) (map[string][]object.Column, error) {
+	columns := make(map[string][]object.Column)
 	for _, from := range stmt.From {
-		table := from.Table
-		if from.TableAlias != "" {
-			table = from.TableAlias
-			tableToAlias[from.Table] = from.TableAlias
-		}
-		tableReferences[table]++
-		backendColumns, err := backend.Columns(from.Table)
-		if err != nil {
-			return fmt.Errorf("columns: %w", err)
-		}
-		for _, c := range backendColumns {
-			if _, ok := columns[c.Name]; !ok {
-				columns[c.Name] = make(map[string]bool)
+		for {
+			name := from.Table
+			if from.TableAlias != "" {
+				name = from.TableAlias
 			}
-			columns[c.Name][from.Table] = true
-		}
-		for from.Join != nil {
-			table := from.Join.With.Table
-			if from.Join.With.TableAlias != "" {
-				table = from.Join.With.TableAlias
-				tableToAlias[from.Join.With.Table] = from.Join.With.TableAlias
+			if _, ok := columns[name]; ok {
+				return nil, object.Errorf(object.CodeDuplicateAlias, `table name "%s" specified more than once`, name)
 			}
-			tableReferences[table]++
-			backendColumns, err := backend.Columns(from.Join.With.Table)
+			backendColumns, err := backend.Columns(from.Table)
 			if err != nil {
-				return fmt.Errorf("columns: %w", err)
+				return nil, err
 			}
-			for _, c := range backendColumns {
-				if _, ok := columns[c.Name]; !ok {
-					columns[c.Name] = make(map[string]bool)
-				}
-				columns[c.Name][from.Join.With.Table] = true
+			columns[name] = backendColumns
+			if from.Join == nil {
+				break
 			}
 			from = from.Join.With
 		}
 	}
+	return columns, nil
+}
 
-	for table, count := range tableReferences {
-		if count > 1 {
-			return fmt.Errorf(`table name "%s" specified more than once`, table)
+// normalizeIdentifiers mutates all identifiers so that we have the table name and column name for all identifiers,
+// and returns the columns of the tables, as fromColumns.
+// The table name is the name the table is referenced by in the statement, which is its alias if it has one,
+// so that the two sides of a self-join can be told apart.
+// We may return a non-nil error here, which should be returned back to the user
+func normalizeIdentifiers(backend Backend, stmt *ast.SelectStatement) (map[string][]object.Column, error) {
+	tableColumns, err := fromColumns(backend, stmt)
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]map[string]bool)
+	for table, backendColumns := range tableColumns {
+		for _, c := range backendColumns {
+			if _, ok := columns[c.Name]; !ok {
+				columns[c.Name] = make(map[string]bool)
+			}
+			columns[c.Name][table] = true
+		}
+	}
+	tableToAlias := make(map[string]string)
+	for _, from := range stmt.From {
+		for {
+			if from.TableAlias != "" {
+				tableToAlias[from.Table] = from.TableAlias
+			}
+			if from.Join == nil {
+				break
+			}
+			from = from.Join.With
 		}
 	}
 
@@ -199,7 +229,7 @@ func normalizeIdentifiers(backend Backend, stmt *ast.SelectStatement) error {
 	for _, expr := range stmt.Expressions {
 		ids, err := identifiersInExpression(expr)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		allIdentifiers = append(allIdentifiers, ids...)
 	}
@@ -207,7 +237,7 @@ func normalizeIdentifiers(backend Backend, stmt *ast.SelectStatement) error {
 		if from.Join != nil {
 			ids, err := identifiersInExpression(from.Join.Predicate)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			allIdentifiers = append(allIdentifiers, ids...)
 		}
@@ -215,14 +245,21 @@ func normalizeIdentifiers(backend Backend, stmt *ast.SelectStatement) error {
 	if stmt.Where != nil {
 		ids, err := identifiersInExpression(stmt.Where)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		allIdentifiers = append(allIdentifiers, ids...)
 	}
 	for _, orderBy := range stmt.OrderBy {
 		ids, err := identifiersInExpression(orderBy.Expression)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		allIdentifiers = append(allIdentifiers, ids...)
+	}
+	for _, groupBy := range stmt.GroupBy {
+		ids, err := identifiersInExpression(groupBy)
+		if err != nil {
+			return nil, err
 		}
 		allIdentifiers = append(allIdentifiers, ids...)
 	}
@@ -231,39 +268,11 @@ func normalizeIdentifiers(backend Backend, stmt *ast.SelectStatement) error {
 	for _, id := range allIdentifiers {
 		// identifier is on the form `table_name.column_name`,
 		// but not selecting from `table_name`
-		if id.Table != "" {
-			missingFrom := true
-			for _, from := range stmt.From {
-				// alias not provided
-				if from.TableAlias == "" && id.Table == from.Table {
-					missingFrom = false
-				}
-
-				// alias is provided
-				if from.TableAlias != "" && id.Table == from.TableAlias {
-					missingFrom = false
-					id.Table = from.Table
-				}
-				for from.Join != nil {
-					// alias not provided
-					if from.Join.With.TableAlias == "" && id.Table == from.Join.With.Table {
-						missingFrom = false
-					}
-
-					// alias is provided
-					if from.Join.With.TableAlias != "" && id.Table == from.Join.With.TableAlias {
-						missingFrom = false
-						id.Table = from.Join.With.Table
-					}
-					from = from.Join.With
-				}
-			}
-			if alias, ok := tableToAlias[id.Table]; missingFrom && ok {
-				return fmt.Errorf(`invalid reference to FROM-clause entry for table "%s". Perhaps you meant to reference the table alias "%s"`, id.Table, alias)
-			}
-			if missingFrom {
-				return fmt.Errorf(`missing FROM-clause entry for table "%s"`, id.Table)
+		if _, ok := tableColumns[id.Table]; id.Table != "" && !ok {
+			if alias, ok := tableToAlias[id.Table]; ok {
+				return nil, object.Errorf(object.CodeUndefinedTable, `invalid reference to FROM-clause entry for table "%s". Perhaps you meant to reference the table alias "%s"`, id.Table, alias)
 			}
+			return nil, object.Errorf(object.CodeUndefinedTable, `missing FROM-clause entry for table "%s"`, id.Table)
 		}
 	}
 
@@ -271,13 +280,13 @@ func normalizeIdentifiers(backend Backend, stmt *ast.SelectStatement) error {
 	for _, identifier := range allIdentifiers {
 		tables, ok := columns[identifier.Value]
 		if !ok || len(tables) == 0 {
-			return fmt.Errorf(`column "%s" does not exist`, identifier.Value)
+			return nil, object.Errorf(object.CodeUndefinedColumn, `column "%s" does not exist`, identifier.Value)
 		}
 		if identifier.Table != "" {
 			continue
 		}
 		if len(tables) > 1 {
-			return fmt.Errorf(`column reference "%s" is ambiguous`, identifier.Value)
+			return nil, object.Errorf(object.CodeAmbiguousColumn, `column reference "%s" is ambiguous`, identifier.Value)
 		}
 		if identifier.Table != "" {
 			continue
@@ -291,154 +300,763 @@ func normalizeIdentifiers(backend Backend, stmt *ast.SelectStatement) error {
 			identifier.Table = t
 		}
 	}
-	return nil
+	return tableColumns, nil
 }
 
-func join(backend Backend, rows []object.Row, table string, predicate ast.Expression) ([]object.Row, error) {
-	r, err := backend.Rows(table)
+// planSelectStatement normalizes the statement and returns the operators that execute it.
+// If analyze is set, every operator is instrumented.
+func planSelectStatement(backend Backend, stmt *ast.SelectStatement, analyze bool, settings settings) (executor.Operator, error) {
+	logicalPlan, err := planLogical(backend, stmt)
 	if err != nil {
 		return nil, err
 	}
-	var newRows []object.Row
-	for _, row1 := range rows {
-		for _, row2 := range r {
-			newRow := concatenateRows(row1, row2)
-			if predicate != nil {
-				v := evalExpression(newRow, predicate)
-				if isError(v) {
-					return nil, fmt.Errorf(v.Inspect())
-				}
-				include, ok := v.(*object.Boolean)
-				if !ok {
-					return nil, fmt.Errorf("join condition must be of type boolean, not %s: %s", v.Type(), v.Inspect())
-				}
-				if !include.Value {
-					continue
-				}
-			}
-			newRows = append(newRows, newRow)
+	return planPhysical(backend, logicalPlan, analyze, settings, nil), nil
+}
+
+// planLogical normalizes the statement and returns its logical plan
+func planLogical(backend Backend, stmt *ast.SelectStatement) (planner.Node, error) {
+	// Traverse AST to get all column identifiers and normalize them
+	if _, err := normalizeIdentifiers(backend, stmt); err != nil {
+		return nil, err
+	}
+
+	// Populate aliases
+	for i, alias := range stmt.Aliases {
+		if alias == "" {
+			stmt.Aliases[i] = stmt.Expressions[i].String()
 		}
 	}
-	return newRows, nil
+
+	return planner.Plan(stmt, backendEstimator{backend})
 }
 
-func evalSelectStatement(backend Backend, stmt *ast.SelectStatement) object.Object {
-	// Traverse AST to get all column identifiers and normalize them
-	if err := normalizeIdentifiers(backend, stmt); err != nil {
-		return newError(err.Error())
+// planPhysical returns the operators executing the logical plan. If b is set, the parameters and now()
+// in the expressions of the plan are bound by it when they are compiled, without changing the plan,
+// so that the plan of a prepared statement is reused by each of its executions.
+func planPhysical(backend Backend, logicalPlan planner.Node, analyze bool, settings settings, b *binder) executor.Operator {
+	memory := executor.NewMemory(settings.workMem, "")
+	pp := physicalPlanner{
+		backend:    backend,
+		analyze:    analyze,
+		memory:     memory,
+		vectorize:  settings.vectorize,
+		workers:    settings.parallelWorkers,
+		referenced: referencedColumns(logicalPlan),
+		bind:       b,
+	}
+	if tables := planner.Tables(logicalPlan); len(tables) > 1 {
+		pp.qualify = tables
 	}
+	op, _ := pp.plan(logicalPlan)
+	return op
+}
 
-	// fetch rows
-	rows := []object.Row{{}}
-	var err error
-	for _, from := range stmt.From {
-		// cartesian join with existing rows
-		// if first FROM, this just returns the rows from that table
-		rows, err = join(backend, rows, from.Table, nil)
-		if err != nil {
-			return newError(err.Error())
+func evalSelectStatement(ctx context.Context, backend Backend, stmt *ast.SelectStatement, settings settings) object.Object {
+	plan, result, err := planSelectResult(backend, stmt, settings)
+	if err != nil {
+		return errorObject(err)
+	}
+	rows, err := executor.Run(ctx, plan)
+	if err != nil {
+		return errorObject(err)
+	}
+	result.Rows = rows
+	return result
+}
+
+// planSelectResult plans the statement, and returns the result with the names and types of its columns, but without rows
+func planSelectResult(backend Backend, stmt *ast.SelectStatement, settings settings) (executor.Operator, *object.Result, error) {
+	plan, err := planSelectStatement(backend, stmt, false, settings)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the statement was checked when it was planned
+	tables, _ := fromColumns(backend, stmt)
+	types := make([]object.DataType, len(stmt.Expressions))
+	for i, e := range stmt.Expressions {
+		types[i] = expressionType(tables, e)
+	}
+	return plan, &object.Result{Aliases: stmt.Aliases, Types: types}, nil
+}
+
+// evalExplainStatement returns the plan of the statement, one operator per row.
+// For EXPLAIN ANALYZE, the statement is run, and the plan shows the rows returned by and the time spent in each operator.
+func evalExplainStatement(ctx context.Context, backend Backend, es *ast.ExplainStatement, settings settings) object.Object {
+	stmt, ok := es.Statement.(*ast.SelectStatement)
+	if !ok {
+		return object.Errorf(object.CodeSyntaxError, "cannot explain %s statement", es.Statement.TokenLiteral())
+	}
+	plan, err := planSelectStatement(backend, stmt, es.Analyze, settings)
+	if err != nil {
+		return errorObject(err)
+	}
+	var elapsed time.Duration
+	if es.Analyze {
+		start := time.Now()
+		if _, err := executor.Run(ctx, plan); err != nil {
+			return errorObject(err)
 		}
+		elapsed = time.Since(start)
+	}
+	lines := executor.Explain(plan)
+	if es.Analyze {
+		lines = append(lines, fmt.Sprintf("Execution time: %s", elapsed))
+	}
+	result := &object.Result{Aliases: []string{"QUERY PLAN"}, Types: []object.DataType{object.STRING}}
+	for _, line := range lines {
+		result.Rows = append(result.Rows, &object.Row{
+			Aliases:   []string{"QUERY PLAN"},
+			Values:    []object.Object{&object.String{Value: line}},
+			TableName: []string{""},
+		})
+	}
+	return result
+}
 
-		// do all joins, left to right
-		for from.Join != nil {
-			rows, err = join(backend, rows, from.Join.With.Table, from.Join.Predicate)
-			if err != nil {
-				return newError(err.Error())
-			}
-			from = from.Join.With
+// BatchWriter is implemented by backends that can create tables and insert rows atomically,
+// so that other sessions see all of the changes or none of them. The tables are created
+// before the rows, which are given by table name, are inserted.
+type BatchWriter interface {
+	WriteBatch([]object.Table, map[string][]object.Row) error
+}
+
+// RowCounter is implemented by backends that can count the rows in a table without reading them.
+type RowCounter interface {
+	RowCount(string) (int, error)
+}
+
+// defaultRowEstimate is used by the planner for tables in backends that can't count rows
+const defaultRowEstimate = 1000
+
+type backendEstimator struct {
+	backend Backend
+}
+
+func (e backendEstimator) EstimateRows(table string) int {
+	if counter, ok := e.backend.(RowCounter); ok {
+		if n, err := counter.RowCount(table); err == nil {
+			return n
 		}
 	}
+	if stats := e.Statistics(table); stats != nil {
+		return stats.RowCount
+	}
+	return defaultRowEstimate
+}
 
-	// iterate over rows and evaluate expressions for each row
-	rowsToReturn := make([]*object.Row, 0)
-	aliases := stmt.Aliases
-	for _, backendRow := range rows {
-		row := &object.Row{
-			Aliases:      stmt.Aliases,
-			Values:       make([]object.Object, len(stmt.Expressions)),
-			SortByValues: make([]object.SortBy, len(stmt.OrderBy)),
+func (e backendEstimator) Statistics(table string) *object.TableStatistics {
+	store, ok := e.backend.(StatisticsStore)
+	if !ok {
+		return nil
+	}
+	stats, err := store.Statistics(table)
+	if err != nil {
+		return nil
+	}
+	return stats
+}
+
+// StatisticsStore is implemented by backends that can store the statistics computed by ANALYZE.
+// Statistics returns nil if the table hasn't been analyzed.
+type StatisticsStore interface {
+	SetStatistics(string, *object.TableStatistics) error
+	Statistics(string) (*object.TableStatistics, error)
+}
+
+// ColumnScanner is implemented by backends that can read some of the columns of a table without
+// reading the others, such as backends that store the values of each column together.
+// Scans of such backends only read the columns the query refers to.
+type ColumnScanner interface {
+	ScanColumns(string, []int) (object.RowIterator, error)
+}
+
+// BatchScanner is implemented by backends that can read some of the columns of a table a batch of rows
+// at a time, as vectors of values, which vectorized execution reads without making a row for each value.
+type BatchScanner interface {
+	ScanBatches(string, []int) (object.BatchIterator, error)
+}
+
+// PartitionScanner is implemented by backends that can read a table in partitions at the same time,
+// for parallel execution. See executor.PartitionScanner.
+type PartitionScanner interface {
+	ScanPartitions(string, []int, int) ([]object.RowIterator, error)
+}
+
+// TableLister is implemented by backends that can list their tables.
+type TableLister interface {
+	TableNames() ([]string, error)
+}
+
+// analyzeAliases and analyzeTypes are the columns returned by ANALYZE.
+// min and max have the type of the column, which is different for each row.
+var (
+	analyzeAliases = []string{"table", "column", "rows", "distinct", "null_fraction", "min", "max"}
+	analyzeTypes   = []object.DataType{object.STRING, object.STRING, object.INTEGER, object.INTEGER, object.FLOAT, "", ""}
+)
+
+// evalAnalyzeStatement computes and stores statistics for the table, or for all tables.
+// The statistics are returned, one row per column.
+func evalAnalyzeStatement(ctx context.Context, backend Backend, as *ast.AnalyzeStatement) object.Object {
+	store, ok := backend.(StatisticsStore)
+	if !ok {
+		return object.Errorf(object.CodeFeatureNotSupported, "ANALYZE is not supported by this backend")
+	}
+	tables := []string{as.TableName}
+	if as.TableName == "" {
+		lister, ok := backend.(TableLister)
+		if !ok {
+			return object.Errorf(object.CodeFeatureNotSupported, "ANALYZE without a table name is not supported by this backend")
 		}
-		for i, e := range stmt.Expressions {
-			row.Values[i] = evalExpression(backendRow, e)
-			if isError(row.Values[i]) {
-				return row.Values[i]
-			}
+		var err error
+		if tables, err = lister.TableNames(); err != nil {
+			return errorObject(err)
+		}
+	}
+	aliases := analyzeAliases
+	result := &object.Result{Aliases: aliases, Types: analyzeTypes}
+	for _, table := range tables {
+		columns, err := backend.Columns(table)
+		if err != nil {
+			return errorObject(err)
+		}
+		it, err := backend.Scan(table)
+		if err != nil {
+			return errorObject(err)
+		}
+		stats, err := executor.ComputeStatistics(ctx, columns, it)
+		if err != nil {
+			return errorObject(err)
 		}
-		if stmt.Where != nil {
-			v := evalExpression(backendRow, stmt.Where)
-			if isError(v) {
-				return v
+		if err := store.SetStatistics(table, stats); err != nil {
+			return errorObject(err)
+		}
+		for _, c := range stats.Columns {
+			min, max := c.Min, c.Max
+			if min == nil {
+				min, max = object.NULL, object.NULL
 			}
-			include, ok := v.(*object.Boolean)
+			result.Rows = append(result.Rows, &object.Row{
+				Aliases:   aliases,
+				TableName: make([]string, len(aliases)),
+				Values: []object.Object{
+					&object.String{Value: table},
+					&object.String{Value: c.Name},
+					&object.Integer{Value: int64(stats.RowCount)},
+					&object.Integer{Value: int64(c.DistinctCount)},
+					&object.Float{Value: c.NullFraction},
+					min,
+					max,
+				},
+			})
+		}
+	}
+	return result
+}
+
+// physicalPlanner turns a logical plan into the tree of operators that executes it.
+// If analyze is set, every operator is instrumented.
+type physicalPlanner struct {
+	backend Backend
+	analyze bool
+	memory  *executor.Memory // shared by all operators of the query
+	// vectorize is whether scans, and the filters, projections and aggregates of them, are executed with batch operators
+	vectorize bool
+	// workers is the number of goroutines scans of a PartitionScanner are divided between, or 0 to not divide them
+	workers int
+	// referenced is the columns of each table the plan refers to, which are read by scans of a ColumnScanner
+	referenced map[string]map[string]bool
+	// qualify is the tables of the plan if it reads several, whose columns EXPLAIN names by their table
+	qualify map[string]bool
+	// bind binds the parameters and now() in the expressions of the plan of a prepared statement, if set
+	bind *binder
+	// partition is set when planning the operators of a worker of a parallel operator, which scan a partition
+	partition *partitionScan
+}
+
+// partitionScan is the partitions of a table scanned by the workers of a parallel operator, the schema of
+// their rows, and the worker whose operators are being planned
+type partitionScan struct {
+	partitions *executor.Partitions
+	schema     schema
+	worker     int
+}
+
+func (pp physicalPlanner) operator(op executor.Operator) executor.Operator {
+	if pp.analyze {
+		return executor.NewInstrumented(op)
+	}
+	return op
+}
+
+// compile compiles the expression for rows of the schema, and names its columns by their table if the plan reads several
+func (pp physicalPlanner) compile(e ast.Expression, s schema) compiled {
+	e = pp.bound(e)
+	c := compile(e, s)
+	if pp.qualify != nil {
+		c.Expression = (&binder{qualify: pp.qualify}).expression(e)
+	}
+	return c
+}
+
+// compileVector is compile for batches
+func (pp physicalPlanner) compileVector(e ast.Expression, s schema) vectorized {
+	evalBatch, _ := compileVectorExpression(pp.bound(e), s)
+	return vectorized{compiled: pp.compile(e, s), evalBatch: evalBatch}
+}
+
+// bound returns the expression with the parameters and now() bound, if the plan is of a prepared statement
+func (pp physicalPlanner) bound(e ast.Expression) ast.Expression {
+	if pp.bind == nil {
+		return e
+	}
+	return pp.bind.expression(e)
+}
+
+func (pp physicalPlanner) batchOperator(op executor.BatchOperator) executor.BatchOperator {
+	if pp.analyze {
+		return executor.NewInstrumentedBatch(op)
+	}
+	return op
+}
+
+// plan returns the operators executing the node, and the schema of the rows they return,
+// which the expressions of the operators above are compiled for.
+func (pp physicalPlanner) plan(node planner.Node) (executor.Operator, schema) {
+	if op, s, ok := pp.planParallel(node); ok {
+		return op, s
+	}
+	if op, s, ok := pp.planBatches(node); ok {
+		return op, s
+	}
+	switch node := node.(type) {
+	case *planner.Values:
+		return pp.operator(executor.NewValues([]object.Row{{}})), schema{}
+	case *planner.Scan:
+		scan, s := pp.scan(node)
+		op := pp.operator(scan)
+		for _, p := range node.Predicates {
+			op = pp.operator(executor.NewFilter(op, pp.compile(p, s)))
+		}
+		return op, s
+	case *planner.Join:
+		return pp.join(node)
+	case *planner.Filter:
+		op, s := pp.plan(node.Child)
+		for _, p := range node.Predicates {
+			op = pp.operator(executor.NewFilter(op, pp.compile(p, s)))
+		}
+		return op, s
+	case *planner.Aggregate:
+		op, s := pp.aggregate(node)
+		return pp.operator(op), s
+	case *planner.Sort:
+		child, s := pp.plan(node.Child)
+		return pp.operator(executor.NewSort(child, pp.sortKeys(node.Keys, s), pp.memory)), s
+	case *planner.Limit:
+		if sort, ok := node.Child.(*planner.Sort); ok && node.Limit != nil && *node.Limit <= maxTopNRows-node.Offset {
+			child, s := pp.plan(sort.Child)
+			return pp.operator(executor.NewTopN(child, pp.sortKeys(sort.Keys, s), *node.Limit, node.Offset)), s
+		}
+		child, s := pp.plan(node.Child)
+		return pp.operator(executor.NewLimit(child, node.Limit, node.Offset)), s
+	case *planner.Project:
+		child, s := pp.plan(node.Child)
+		projections := make([]executor.Expression, len(node.Expressions))
+		for i, e := range node.Expressions {
+			projections[i] = pp.compile(e, s)
+		}
+		return pp.operator(executor.NewProject(child, projections, node.Aliases)), schema{aliases: node.Aliases}
+	}
+	panic(fmt.Sprintf("unknown plan node %T", node))
+}
+
+// aggregate returns the aggregate executing the node, which is a BatchAggregate if the child can be
+// executed with batch operators. It isn't instrumented, so that it can be a worker of a parallel aggregate.
+func (pp physicalPlanner) aggregate(node *planner.Aggregate) (executor.PartialAggregate, schema) {
+	batches, s, vectorized := pp.planBatches(node.Child)
+	var child executor.Operator = batches
+	if !vectorized {
+		child, s = pp.plan(node.Child)
+	}
+	var aggregated schema
+	groupBy := make([]executor.Expression, len(node.GroupBy))
+	vectorGroupBy := make([]executor.VectorExpression, len(node.GroupBy))
+	for i, e := range node.GroupBy {
+		if vectorized {
+			vectorGroupBy[i] = pp.compileVector(e, s)
+			groupBy[i] = vectorGroupBy[i]
+		} else {
+			groupBy[i] = pp.compile(e, s)
+		}
+		aggregated.aliases = append(aggregated.aliases, e.String())
+		aggregated.tables = append(aggregated.tables, executor.GroupColumnTable(i))
+	}
+	aggregates := make([]executor.AggregateFunction, len(node.Aggregates))
+	for i, call := range node.Aggregates {
+		aggregates[i] = executor.AggregateFunction{Name: call.Function}
+		if !call.Star && vectorized {
+			aggregates[i].Argument = pp.compileVector(call.Arguments[0], s)
+		} else if !call.Star {
+			aggregates[i].Argument = pp.compile(call.Arguments[0], s)
+		}
+		aggregated.aliases = append(aggregated.aliases, call.String())
+		aggregated.tables = append(aggregated.tables, executor.AggregateColumnTable(i))
+	}
+	if vectorized {
+		return executor.NewBatchAggregate(batches, vectorGroupBy, aggregates), aggregated
+	}
+	return executor.NewAggregate(child, groupBy, aggregates), aggregated
+}
+
+// scan returns a scan of the table. For a ColumnScanner, only the columns the plan refers to are read.
+// For a worker of a parallel operator, it is a scan of the partition of the worker.
+func (pp physicalPlanner) scan(node *planner.Scan) (*executor.Scan, schema) {
+	if pp.partition != nil {
+		return executor.NewPartitionScan(pp.partition.partitions, pp.partition.worker), pp.partition.schema
+	}
+	// a missing table is reported when the scan is opened
+	columns, _ := pp.backend.Columns(node.Table)
+	if scanner, ok := pp.backend.(ColumnScanner); ok && columns != nil {
+		positions, read := pp.referencedPositions(node, columns)
+		s := tableSchema(node.Name(), read)
+		scan := executor.NewColumnScan(scanner, node.Table, positions, s.aliases)
+		scan.Alias = node.Alias
+		return scan, s
+	}
+	scan := executor.NewScan(pp.backend, node.Table)
+	scan.Alias = node.Alias
+	return scan, tableSchema(node.Name(), columns)
+}
+
+// referencedPositions returns the positions of the columns of the scanned table the plan refers to, and the columns
+func (pp physicalPlanner) referencedPositions(node *planner.Scan, columns []object.Column) ([]int, []object.Column) {
+	var positions []int
+	var read []object.Column
+	for i, c := range columns {
+		if pp.referenced[node.Name()][c.Name] {
+			positions = append(positions, i)
+			read = append(read, c)
+		}
+	}
+	return positions, read
+}
+
+// planBatches returns the batch operators executing the node, if vectorized execution is on,
+// and the node is a scan, or a filter or projection of a node that can be executed with batch operators.
+func (pp physicalPlanner) planBatches(node planner.Node) (executor.BatchOperator, schema, bool) {
+	if !pp.vectorize {
+		return nil, schema{}, false
+	}
+	switch node := node.(type) {
+	case *planner.Scan:
+		var op executor.BatchOperator
+		var s schema
+		columns, _ := pp.backend.Columns(node.Table)
+		if scanner, ok := pp.backend.(BatchScanner); ok && columns != nil && pp.partition == nil {
+			positions, read := pp.referencedPositions(node, columns)
+			s = tableSchema(node.Name(), read)
+			scan := executor.NewBatchScan(scanner, node.Table, positions, s.aliases)
+			scan.Alias = node.Alias
+			op = pp.batchOperator(scan)
+		} else {
+			var scan *executor.Scan
+			scan, s = pp.scan(node)
+			op = pp.batchOperator(executor.NewRowBatchScan(scan))
+		}
+		for _, p := range node.Predicates {
+			op = pp.batchOperator(executor.NewBatchFilter(op, pp.compileVector(p, s)))
+		}
+		return op, s, true
+	case *planner.Filter:
+		op, s, ok := pp.planBatches(node.Child)
+		if !ok {
+			return nil, schema{}, false
+		}
+		for _, p := range node.Predicates {
+			op = pp.batchOperator(executor.NewBatchFilter(op, pp.compileVector(p, s)))
+		}
+		return op, s, true
+	case *planner.Project:
+		child, s, ok := pp.planBatches(node.Child)
+		if !ok {
+			return nil, schema{}, false
+		}
+		projections := make([]executor.VectorExpression, len(node.Expressions))
+		for i, e := range node.Expressions {
+			projections[i] = pp.compileVector(e, s)
+		}
+		return pp.batchOperator(executor.NewBatchProject(child, projections, node.Aliases)), schema{aliases: node.Aliases}, true
+	}
+	return nil, schema{}, false
+}
+
+// minParallelRows is the fewest rows a table must have to be scanned in parallel.
+// Starting the workers for a smaller table takes longer than scanning it.
+const minParallelRows = 10000
+
+// planParallel returns a parallel operator executing the node, if parallel execution is on, and the node
+// reads a table that can be scanned in partitions. The node is a scan, or a filter or projection of a node
+// that reads the table, whose rows are gathered, or an aggregate, sort or top-N of it, whose workers each
+// aggregate or sort a partition, and whose results are merged.
+func (pp physicalPlanner) planParallel(node planner.Node) (executor.Operator, schema, bool) {
+	switch node := node.(type) {
+	case *planner.Scan, *planner.Filter, *planner.Project:
+		p, ok := pp.partitions(node)
+		if !ok {
+			return nil, schema{}, false
+		}
+		workers, s := pp.workerOperators(p, func(wp physicalPlanner) (executor.Operator, schema) {
+			return wp.plan(node)
+		})
+		return pp.operator(executor.NewGather(p.partitions, workers)), s, true
+	case *planner.Aggregate:
+		p, ok := pp.partitions(node.Child)
+		if !ok {
+			return nil, schema{}, false
+		}
+		aggregates := make([]executor.PartialAggregate, p.partitions.Workers())
+		var s schema
+		for i := range aggregates {
+			aggregates[i], s = pp.worker(p, i).aggregate(node)
+		}
+		return pp.operator(executor.NewParallelAggregate(p.partitions, aggregates)), s, true
+	case *planner.Sort:
+		p, ok := pp.partitions(node.Child)
+		if !ok {
+			return nil, schema{}, false
+		}
+		workers, s := pp.workerOperators(p, func(wp physicalPlanner) (executor.Operator, schema) {
+			child, s := wp.plan(node.Child)
+			return wp.operator(executor.NewSort(child, wp.sortKeys(node.Keys, s), wp.memory)), s
+		})
+		return pp.operator(executor.NewGatherMerge(p.partitions, workers)), s, true
+	case *planner.Limit:
+		sort, ok := node.Child.(*planner.Sort)
+		if !ok || node.Limit == nil || *node.Limit > maxTopNRows-node.Offset {
+			return nil, schema{}, false
+		}
+		p, ok := pp.partitions(sort.Child)
+		if !ok {
+			return nil, schema{}, false
+		}
+		// each worker keeps the first rows of its partition, of which the first rows of all partitions are merged
+		workers, s := pp.workerOperators(p, func(wp physicalPlanner) (executor.Operator, schema) {
+			child, s := wp.plan(sort.Child)
+			return wp.operator(executor.NewTopN(child, wp.sortKeys(sort.Keys, s), *node.Limit+node.Offset, 0)), s
+		})
+		merge := pp.operator(executor.NewGatherMerge(p.partitions, workers))
+		return pp.operator(executor.NewLimit(merge, node.Limit, node.Offset)), s, true
+	}
+	return nil, schema{}, false
+}
+
+// partitions returns the partitions of the table read by the node, if the node can be executed by workers
+// each reading a partition: it is a scan of a table with enough rows in a PartitionScanner, or a filter or
+// projection of such a node. It is false when planning the operators of a worker.
+func (pp physicalPlanner) partitions(node planner.Node) (*partitionScan, bool) {
+	if pp.workers == 0 || pp.partition != nil {
+		return nil, false
+	}
+	for {
+		switch n := node.(type) {
+		case *planner.Filter:
+			node = n.Child
+			continue
+		case *planner.Project:
+			node = n.Child
+			continue
+		case *planner.Scan:
+			scanner, ok := pp.backend.(PartitionScanner)
 			if !ok {
-				return newError("argument of WHERE must be type boolean, not type integer: %s", v.Inspect())
+				return nil, false
 			}
-			if !include.Value {
-				continue
+			columns, err := pp.backend.Columns(n.Table)
+			if err != nil || (backendEstimator{pp.backend}).EstimateRows(n.Table) < minParallelRows {
+				return nil, false
 			}
-		}
-		for i, e := range stmt.OrderBy {
-			v := evalExpression(backendRow, e.Expression)
-			if isError(v) {
-				return v
+			if _, ok := pp.backend.(ColumnScanner); ok {
+				positions, read := pp.referencedPositions(n, columns)
+				s := tableSchema(n.Name(), read)
+				// the names of no columns are shown, as for a scan of a ColumnScanner
+				names := append([]string{}, s.aliases...)
+				partitions := executor.NewPartitions(scanner, n.Table, positions, names, pp.workers)
+				partitions.Alias = n.Alias
+				return &partitionScan{partitions: partitions, schema: s}, true
+			}
+			positions := make([]int, len(columns))
+			for i := range positions {
+				positions[i] = i
 			}
-			row.SortByValues[i].Value = v
-			row.SortByValues[i].Descending = e.Descending
+			partitions := executor.NewPartitions(scanner, n.Table, positions, nil, pp.workers)
+			partitions.Alias = n.Alias
+			return &partitionScan{partitions: partitions, schema: tableSchema(n.Name(), columns)}, true
 		}
-		rowsToReturn = append(rowsToReturn, row)
+		return nil, false
 	}
-	// Sort
-	if len(stmt.OrderBy) != 0 {
-		sortRows(rowsToReturn)
+}
+
+// worker returns the planner of the operators of the worker at the position, which scan its partition
+func (pp physicalPlanner) worker(p *partitionScan, i int) physicalPlanner {
+	pp.partition = &partitionScan{partitions: p.partitions, schema: p.schema, worker: i}
+	return pp
+}
+
+// workerOperators plans the operators of each worker, and returns them and the schema of their rows
+func (pp physicalPlanner) workerOperators(p *partitionScan, plan func(physicalPlanner) (executor.Operator, schema)) ([]executor.Operator, schema) {
+	workers := make([]executor.Operator, p.partitions.Workers())
+	var s schema
+	for i := range workers {
+		workers[i], s = plan(pp.worker(p, i))
 	}
-	// Limit
-	if stmt.Limit != nil {
-		offset := 0
-		if stmt.Offset != nil {
-			offset = *stmt.Offset
-		}
-		end := len(rowsToReturn)
-		if len(rowsToReturn) > offset+*stmt.Limit {
-			end = offset + *stmt.Limit
+	return workers, s
+}
+
+// referencedColumns returns the columns of each table that the expressions of the plan refer to
+func referencedColumns(node planner.Node) map[string]map[string]bool {
+	referenced := make(map[string]map[string]bool)
+	var walk func(ast.Expression)
+	walk = func(e ast.Expression) {
+		switch e := e.(type) {
+		case *ast.Identifier:
+			if referenced[e.Table] == nil {
+				referenced[e.Table] = make(map[string]bool)
+			}
+			referenced[e.Table][e.Value] = true
+		case *ast.PrefixExpression:
+			walk(e.Right)
+		case *ast.InfixExpression:
+			walk(e.Left)
+			walk(e.Right)
+		case *ast.PostfixExpression:
+			walk(e.Left)
+		case *ast.CallExpression:
+			for _, a := range e.Arguments {
+				walk(a)
+			}
 		}
-		if end < offset {
-			offset = 0
-			end = 0
+	}
+	walkAll := func(expressions []ast.Expression) {
+		for _, e := range expressions {
+			walk(e)
 		}
-		rowsToReturn = rowsToReturn[offset:end]
 	}
-	// Populate aliases
-	for i, alias := range stmt.Aliases {
-		if alias == "" {
-			aliases[i] = stmt.Expressions[i].String()
+	var visit func(planner.Node)
+	visit = func(node planner.Node) {
+		switch node := node.(type) {
+		case *planner.Scan:
+			walkAll(node.Predicates)
+		case *planner.Join:
+			walkAll(node.Predicates)
+			walkAll(node.Filters)
+		case *planner.Filter:
+			walkAll(node.Predicates)
+		case *planner.Aggregate:
+			walkAll(node.GroupBy)
+			for _, call := range node.Aggregates {
+				walk(call)
+			}
+		case *planner.Sort:
+			for _, k := range node.Keys {
+				walk(k.Expression)
+			}
+		case *planner.Project:
+			walkAll(node.Expressions)
+		}
+		for _, child := range node.Children() {
+			visit(child)
 		}
 	}
-	result := &object.Result{
-		Aliases: aliases,
-		Rows:    rowsToReturn,
+	visit(node)
+	return referenced
+}
+
+// maxTopNRows is the most rows a sort followed by a limit keeps in memory as a top-N sort.
+// Beyond it, all rows are sorted, so that the sort can write them to temporary files.
+const maxTopNRows = 10000
+
+func (pp physicalPlanner) sortKeys(orderBy []*ast.OrderByExpression, s schema) []executor.SortKey {
+	keys := make([]executor.SortKey, len(orderBy))
+	for i, k := range orderBy {
+		keys[i] = executor.SortKey{Expression: pp.compile(k.Expression, s), Descending: k.Descending}
 	}
-	return result
+	return keys
 }
 
-func sortRows(rows []*object.Row) {
-	if len(rows) == 0 {
-		return
+// join uses a hash join if any of the join conditions is an equality between the two sides of the join,
+// and a nested loop join otherwise. Conditions from WHERE that are not used as hash keys are evaluated
+// in a filter after the join.
+func (pp physicalPlanner) join(join *planner.Join) (executor.Operator, schema) {
+	left, leftSchema := pp.plan(join.Left)
+	right, rightSchema := pp.plan(join.Right)
+	joined := leftSchema.concat(rightSchema)
+	leftTables := planner.Tables(join.Left)
+	rightTables := planner.Tables(join.Right)
+
+	var leftKeys, rightKeys []executor.Expression
+	equalityKey := func(e ast.Expression) bool {
+		infix, ok := e.(*ast.InfixExpression)
+		if !ok || infix.Operator != "=" {
+			return false
+		}
+		l := planner.TablesInExpression(infix.Left)
+		r := planner.TablesInExpression(infix.Right)
+		switch {
+		case len(l) > 0 && len(r) > 0 && subset(l, leftTables) && subset(r, rightTables):
+			leftKeys = append(leftKeys, pp.compile(infix.Left, leftSchema))
+			rightKeys = append(rightKeys, pp.compile(infix.Right, rightSchema))
+		case len(l) > 0 && len(r) > 0 && subset(l, rightTables) && subset(r, leftTables):
+			leftKeys = append(leftKeys, pp.compile(infix.Right, leftSchema))
+			rightKeys = append(rightKeys, pp.compile(infix.Left, rightSchema))
+		default:
+			return false
+		}
+		return true
 	}
-	n := len(rows[0].SortByValues) // we know this is same length for all rows (check and panic if not?)
-	sort.Slice(rows, func(i, j int) bool {
-		for k := 0; k < n; k++ {
-			sign := float64(1)
-			if rows[j].SortByValues[k].Descending {
-				sign = -1
-			}
-			iValue := sign * rows[i].SortByValues[k].Value.SortValue()
-			jValue := sign * rows[j].SortByValues[k].Value.SortValue()
-			if iValue != jValue {
-				return iValue < jValue
+
+	var predicates, filters []ast.Expression
+	for _, p := range join.Predicates {
+		if !equalityKey(p) {
+			predicates = append(predicates, p)
+		}
+	}
+	for _, p := range join.Filters {
+		if !equalityKey(p) {
+			filters = append(filters, p)
+		}
+	}
+
+	var predicate executor.Expression
+	if len(predicates) != 0 {
+		conjunction := predicates[0]
+		for _, p := range predicates[1:] {
+			conjunction = &ast.InfixExpression{
+				Token:    token.Token{Type: token.AND, Literal: "AND"},
+				Left:     conjunction,
+				Operator: "AND",
+				Right:    p,
 			}
 		}
-		return false
-	})
+		predicate = pp.compile(conjunction, joined)
+	}
+	var op executor.Operator
+	if len(leftKeys) != 0 {
+		op = pp.operator(executor.NewHashJoin(left, right, leftKeys, rightKeys, predicate, pp.memory))
+	} else {
+		op = pp.operator(executor.NewNestedLoopJoin(left, right, predicate))
+	}
+	for _, f := range filters {
+		op = pp.operator(executor.NewFilter(op, pp.compile(f, joined)))
+	}
+	return op, joined
+}
+
+func subset(a map[string]bool, b map[string]bool) bool {
+	for k := range a {
+		if !b[k] {
+			return false
+		}
+	}
+	return true
 }
 
 func evalCreateTableStatement(backend Backend, cst *ast.CreateTableStatement) object.Object {
@@ -452,9 +1070,16 @@ func evalCreateTableStatement(backend Backend, cst *ast.CreateTableStatement) ob
 			Name: cst.ColumnNames[i],
 			Type: columnType,
 		}
+		if columnType == object.NUMERIC && i < len(cst.ColumnTypeModifiers) {
+			column, err := numericColumn(cst.ColumnNames[i], cst.ColumnTypeModifiers[i])
+			if err != nil {
+				return errorObject(err)
+			}
+			columns[i] = column
+		}
 	}
 	if err := backend.CreateTable(cst.Name, columns); err != nil {
-		return newError(err.Error())
+		return errorObject(err)
 	}
 	return &object.OK{}
 }
@@ -462,7 +1087,7 @@ func evalCreateTableStatement(backend Backend, cst *ast.CreateTableStatement) ob
 func evalInsertStatement(backend Backend, is *ast.InsertStatement) object.Object {
 	columns, err := backend.Columns(is.TableName)
 	if err != nil {
-		return newError(err.Error())
+		return errorObject(err)
 	}
 	rowsToInsert := make([]object.Row, len(is.Rows))
 	for i, rowToInsert := range is.Rows {
@@ -474,7 +1099,7 @@ func evalInsertStatement(backend Backend, is *ast.InsertStatement) object.Object
 			if len(rowToInsert) > 1 {
 				valuesPlural = "s"
 			}
-			return newError(`table "%s" has %d column%s but %d value%s were supplied`, is.TableName, len(columns), columnsPlural, len(rowToInsert), valuesPlural)
+			return object.Errorf(object.CodeSyntaxError, `table "%s" has %d column%s but %d value%s were supplied`, is.TableName, len(columns), columnsPlural, len(rowToInsert), valuesPlural)
 		}
 		columnNames := make([]string, len(columns))
 		columnTypes := make([]object.DataType, len(columns))
@@ -488,7 +1113,7 @@ func evalInsertStatement(backend Backend, is *ast.InsertStatement) object.Object
 			Aliases:   columnNames,
 		}
 		for i, es := range rowToInsert {
-			obj := Eval(backend, es)
+			obj := evalExpression(object.Row{}, es)
 			if errorObj, ok := obj.(*object.Error); ok {
 				return errorObj
 			}
@@ -496,26 +1121,109 @@ func evalInsertStatement(backend Backend, is *ast.InsertStatement) object.Object
 			row.TableName[i] = is.TableName
 		}
 		if err != nil {
-			return newError(err.Error())
+			return errorObject(err)
 		}
 		for i, value := range row.Values {
+			if object.IsTemporal(object.ObjectType(columnTypes[i])) && value.Type() != object.NULL_OBJ {
+				// a string or a date or timestamp of another type is converted to the type of the column
+				converted, err := convertTemporal(value, columnTypes[i])
+				if err != nil {
+					return errorObject(err)
+				}
+				row.Values[i] = converted
+				value = converted
+			}
+			if columnTypes[i] == object.NUMERIC && value.Type() != object.NULL_OBJ {
+				// an integer, float or string is converted to a number with the precision and scale of the column
+				converted, err := convertNumeric(value, columns[i])
+				if err != nil {
+					return errorObject(err)
+				}
+				row.Values[i] = converted
+				value = converted
+			}
 			t := value.Type()
+			if t == object.NULL_OBJ {
+				return object.Errorf(object.CodeDatatypeMismatch, `cannot insert NULL in %s column in table "%s"`, columnTypes[i], is.TableName)
+			}
 			columnType := object.DataTypeFromString(string(t))
 			if columnType == "" {
 				panic(fmt.Sprintf("invalid column type '%s'", t))
 			}
 			if columnType != columnTypes[i] {
-				return newError(`cannot insert %s with value %s in %s column in table "%s"`, t, value.Inspect(), columnTypes[i], is.TableName)
+				return object.Errorf(object.CodeDatatypeMismatch, `cannot insert %s with value %s in %s column in table "%s"`, t, value.Inspect(), columnTypes[i], is.TableName)
 			}
 		}
 		rowsToInsert[i] = row
 	}
+	// insert all rows at once if possible, so other sessions don't see some of the rows without the others
+	if bw, ok := backend.(BatchWriter); ok {
+		if err := bw.WriteBatch(nil, map[string][]object.Row{is.TableName: rowsToInsert}); err != nil {
+			return errorObject(err)
+		}
+		return &object.OK{RowsAffected: int64(len(rowsToInsert))}
+	}
 	for _, row := range rowsToInsert {
 		if err := backend.Insert(is.TableName, row); err != nil {
-			return newError(err.Error())
+			return errorObject(err)
 		}
 	}
-	return &object.OK{}
+	return &object.OK{RowsAffected: int64(len(rowsToInsert))}
+}
+
+// Deleter is implemented by backends that can delete rows. The rows in the table for which the function
+// returns true are deleted, and the number of rows deleted is returned.
+type Deleter interface {
+	Delete(string, func(object.Row) (bool, error)) (int, error)
+}
+
+// deleteAsSelect returns a statement selecting from the table with the same WHERE clause,
+// so that the identifiers in the WHERE clause are normalized like in a SELECT
+func deleteAsSelect(ds *ast.DeleteStatement) *ast.SelectStatement {
+	return &ast.SelectStatement{From: []*ast.From{{Table: ds.TableName}}, Where: ds.Where}
+}
+
+func evalDeleteStatement(ctx context.Context, backend Backend, ds *ast.DeleteStatement) object.Object {
+	deleter, ok := backend.(Deleter)
+	if !ok {
+		return object.Errorf(object.CodeFeatureNotSupported, "backend can't delete rows")
+	}
+	columns, err := backend.Columns(ds.TableName)
+	if err != nil {
+		return errorObject(err)
+	}
+	if columns == nil {
+		return object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, ds.TableName)
+	}
+	stmt := deleteAsSelect(ds)
+	if _, err := normalizeIdentifiers(backend, stmt); err != nil {
+		return errorObject(err)
+	}
+	var where compiled
+	if stmt.Where != nil {
+		where = compile(stmt.Where, tableSchema(ds.TableName, columns))
+	}
+	n, err := deleter.Delete(ds.TableName, func(row object.Row) (bool, error) {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		if stmt.Where == nil {
+			return true, nil
+		}
+		v := where.Eval(row)
+		if errorObj, ok := v.(*object.Error); ok {
+			return false, errorObj
+		}
+		include, ok := v.(*object.Boolean)
+		if !ok {
+			return false, object.Errorf(object.CodeDatatypeMismatch, "argument of WHERE must be type boolean, not type %s: %s", strings.ToLower(string(v.Type())), v.Inspect())
+		}
+		return include.Value, nil
+	})
+	if err != nil {
+		return errorObject(err)
+	}
+	return &object.OK{RowsAffected: int64(n)}
 }
 
 func evalPrefixExpression(operator string, right object.Object) object.Object {
@@ -525,7 +1233,7 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 	case "NOT":
 		return evalBangPrefixOperatorExpression(right)
 	default:
-		return newError("unknown operator: %s%s", operator, right.Type())
+		return object.Errorf(object.CodeUndefinedFunction, "unknown operator: %s%s", operator, right.Type())
 	}
 }
 
@@ -546,7 +1254,7 @@ func evalPostfixExpression(left object.Object, operator string) object.Object {
 			return &object.True
 		}
 	default:
-		return newError("unknown operator: %s %s", left.Type(), operator)
+		return object.Errorf(object.CodeUndefinedFunction, "unknown operator: %s %s", left.Type(), operator)
 	}
 }
 
@@ -559,7 +1267,13 @@ func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
 		value := right.(*object.Float).Value
 		return &object.Float{Value: -value}
 	}
-	return newError("unknown operator: -%s", right.Type())
+	if interval, ok := right.(*object.Interval); ok {
+		return negateInterval(interval)
+	}
+	if n, ok := right.(*object.Numeric); ok {
+		return n.Neg()
+	}
+	return object.Errorf(object.CodeUndefinedFunction, "unknown operator: -%s", right.Type())
 }
 
 func evalBangPrefixOperatorExpression(right object.Object) object.Object {
@@ -567,7 +1281,7 @@ func evalBangPrefixOperatorExpression(right object.Object) object.Object {
 		value := right.(*object.Boolean).Value
 		return &object.Boolean{Value: !value}
 	}
-	return newError("unknown operator: !%s", right.Type())
+	return object.Errorf(object.CodeUndefinedFunction, "unknown operator: !%s", right.Type())
 }
 
 func evalInfixExpression(operator string, left object.Object, right object.Object) object.Object {
@@ -594,8 +1308,14 @@ func evalInfixExpression(operator string, left object.Object, right object.Objec
 	// string
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
+	// numeric with numeric, int or float
+	case left.Type() == object.NUMERIC_OBJ || right.Type() == object.NUMERIC_OBJ:
+		return evalNumericInfixExpression(operator, left, right)
+	// date, time, timestamp or interval
+	case object.IsTemporal(left.Type()) || object.IsTemporal(right.Type()):
+		return evalTemporalInfixExpression(operator, left, right)
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return object.Errorf(object.CodeUndefinedFunction, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
@@ -611,6 +1331,9 @@ func evalIntegerInfixExpression(operator string, left object.Object, right objec
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
 	case "/":
+		if rightVal == 0 {
+			return object.Errorf(object.CodeDivisionByZero, "division by zero")
+		}
 		return &object.Integer{Value: leftVal / rightVal}
 	case "=":
 		return &object.Boolean{Value: leftVal == rightVal}
@@ -627,9 +1350,12 @@ func evalIntegerInfixExpression(operator string, left object.Object, right objec
 	case "^":
 		return &object.Integer{Value: int64(math.Pow(float64(leftVal), float64(rightVal)))}
 	case "%":
+		if rightVal == 0 {
+			return object.Errorf(object.CodeDivisionByZero, "division by zero")
+		}
 		return &object.Integer{Value: leftVal % rightVal}
 	default:
-		return newError("unknown integer operator: %s %s %s", left.Type(), operator, right.Type())
+		return object.Errorf(object.CodeUndefinedFunction, "unknown integer operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
@@ -663,7 +1389,7 @@ func evalFloatInfixExpression(operator string, left object.Object, right object.
 	case "%":
 		return &object.Float{Value: math.Mod(leftVal, rightVal)}
 	default:
-		return newError("unknown float operator: %s %s %s", left.Type(), operator, right.Type())
+		return object.Errorf(object.CodeUndefinedFunction, "unknown float operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
@@ -681,7 +1407,7 @@ func evalBooleanInfixExpression(operator string, left object.Object, right objec
 	case "OR":
 		return &object.Boolean{Value: leftVal || rightVal}
 	default:
-		return newError("unknown boolean operator: %s %s %s", left.Type(), operator, right.Type())
+		return object.Errorf(object.CodeUndefinedFunction, "unknown boolean operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
@@ -705,7 +1431,7 @@ func evalStringInfixExpression(operator string, left object.Object, right object
 	case "||":
 		return &object.String{Value: leftVal + rightVal}
 	default:
-		return newError("unknown string operator: %s %s %s", left.Type(), operator, right.Type())
+		return object.Errorf(object.CodeUndefinedFunction, "unknown string operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
@@ -713,6 +1439,15 @@ func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
+// errorObject returns the error as an error object. An error object is returned as it is, so that errors.Is
+// matches it with errors such as ErrSerializationFailure, and other errors get the code of the error object they wrap.
+func errorObject(err error) *object.Error {
+	if errorObj, ok := err.(*object.Error); ok {
+		return errorObj
+	}
+	return &object.Error{Message: err.Error(), Code: object.ErrorCode(err)}
+}
+
 func isError(obj object.Object) bool {
 	if obj != nil {
 		return obj.Type() == object.ERROR_OBJ
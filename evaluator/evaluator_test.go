@@ -1,11 +1,18 @@
 package evaluator_test
 
 import (
+	"context"
+	"fmt"
 	"math"
+	"reflect"
+	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/vegarsti/sql/backendtest"
 	"github.com/vegarsti/sql/evaluator"
 	"github.com/vegarsti/sql/inmemory"
 	"github.com/vegarsti/sql/lexer"
@@ -87,6 +94,8 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"select false and true", false},
 		{"select false or true", true},
 		{"select false or not true", false},
+		{"select false and (1 / 0) = 1", false},
+		{"select true or 1", true},
 		{"select 1 < 1", false},
 		{"select 1 <= 1", true},
 		{"select 1 >= 1", true},
@@ -266,6 +275,9 @@ func TestErrors(t *testing.T) {
 		{"select a from foo where 1", `argument of WHERE must be type boolean, not type integer: 1`},
 		{"select foo.a from foo f where 1", `invalid reference to FROM-clause entry for table "foo". Perhaps you meant to reference the table alias "f"`},
 		{"select 1 from foo, foo", `table name "foo" specified more than once`},
+		{"select a from foo f, foo g", `column reference "a" is ambiguous`},
+		{"select c / (c - 1) from foo", `division by zero`},
+		{"select a from foo where (c % 0) = 1", `division by zero`},
 		{"insert into foo values (1)", `table "foo" has 2 columns but 1 value were supplied`},
 		{"insert into foo values (1)", `table "foo" has 2 columns but 1 value were supplied`},
 		{"insert into foo values ('hello', 'world')", `cannot insert STRING with value 'world' in INTEGER column in table "foo"`},
@@ -275,11 +287,11 @@ func TestErrors(t *testing.T) {
 		backend := inmemory.NewBackend()
 
 		// table `foo`
-		backend.Tables["foo"] = []object.Column{
+		backendtest.MustCreateTable(t, backend, "foo", []object.Column{
 			{Name: "a", Type: object.STRING},
 			{Name: "c", Type: object.INTEGER},
-		}
-		backend.Tuples["foo"] = []object.Row{
+		})
+		backendtest.MustInsert(t, backend, "foo", []object.Row{
 			{
 				Values: []object.Object{
 					&object.String{Value: "abc"},
@@ -296,10 +308,10 @@ func TestErrors(t *testing.T) {
 				Aliases:   []string{"a", "c"},
 				TableName: []string{"foo", "foo"},
 			},
-		}
+		}...)
 
 		// table `bar`
-		backend.Tables["bar"] = []object.Column{{Name: "a", Type: object.STRING}}
+		backendtest.MustCreateTable(t, backend, "bar", []object.Column{{Name: "a", Type: object.STRING}})
 
 		evaluated := testEval(backend, tt.input)
 		testError(t, evaluated, tt.expectedErrorMessage)
@@ -392,7 +404,10 @@ func TestEvalCreateTable(t *testing.T) {
 		if _, ok := evaluated.(*object.OK); !ok {
 			t.Fatalf("object is not OK. got=%T", evaluated)
 		}
-		columns := backend.Tables[tt.tableName]
+		columns, err := backend.Columns(tt.tableName)
+		if err != nil {
+			t.Fatal(err)
+		}
 		expectedColumns := tt.expectedTable.Columns
 		if len(columns) != len(expectedColumns) {
 			t.Fatalf("expected %d columns. got=%d", len(expectedColumns), len(columns))
@@ -447,11 +462,11 @@ func TestEvalInsert(t *testing.T) {
 	}
 	for _, tt := range tests {
 		backend := inmemory.NewBackend()
-		backend.Tables["foo"] = []object.Column{
+		backendtest.MustCreateTable(t, backend, "foo", []object.Column{
 			{Name: "a", Type: object.DataType("STRING")},
 			{Name: "b", Type: object.DataType("INTEGER")},
 			{Name: "c", Type: object.DataType("FLOAT")},
-		}
+		})
 
 		evaluated := testEval(backend, tt.input)
 		if _, ok := evaluated.(*object.OK); !ok {
@@ -460,10 +475,7 @@ func TestEvalInsert(t *testing.T) {
 			}
 			t.Fatalf("object is not OK. got=%T", evaluated)
 		}
-		rows, ok := backend.Tuples["foo"]
-		if !ok {
-			t.Fatalf("row doesn't exist")
-		}
+		rows := backendtest.MustScan(t, backend, "foo")
 		if len(rows) != len(tt.expectedRows) {
 			t.Fatalf("expected table to have %d rows. got=%d", len(tt.expectedRows), len(rows))
 		}
@@ -888,12 +900,12 @@ func TestEvalSelectFrom(t *testing.T) {
 		backend := inmemory.NewBackend()
 
 		// table `foo`
-		backend.Tables["foo"] = []object.Column{
+		backendtest.MustCreateTable(t, backend, "foo", []object.Column{
 			{Name: "a", Type: object.STRING},
 			{Name: "b", Type: object.STRING},
 			{Name: "c", Type: object.STRING},
-		}
-		backend.Tuples["foo"] = []object.Row{
+		})
+		backendtest.MustInsert(t, backend, "foo", []object.Row{
 			{
 				Values: []object.Object{
 					&object.String{Value: "abc"},
@@ -912,13 +924,13 @@ func TestEvalSelectFrom(t *testing.T) {
 				Aliases:   []string{"a", "b", "c"},
 				TableName: []string{"foo", "foo", "foo"},
 			},
-		}
+		}...)
 
 		// table `bar`
-		backend.Tables["bar"] = []object.Column{
+		backendtest.MustCreateTable(t, backend, "bar", []object.Column{
 			{Name: "a", Type: object.STRING},
-		}
-		backend.Tuples["bar"] = []object.Row{
+		})
+		backendtest.MustInsert(t, backend, "bar", []object.Row{
 			{
 				Values: []object.Object{
 					&object.String{Value: "m"},
@@ -933,13 +945,13 @@ func TestEvalSelectFrom(t *testing.T) {
 				Aliases:   []string{"a"},
 				TableName: []string{"bar"},
 			},
-		}
+		}...)
 
 		// table `baz`
-		backend.Tables["baz"] = []object.Column{
+		backendtest.MustCreateTable(t, backend, "baz", []object.Column{
 			{Name: "x", Type: object.STRING},
-		}
-		backend.Tuples["baz"] = []object.Row{
+		})
+		backendtest.MustInsert(t, backend, "baz", []object.Row{
 			{
 				Values: []object.Object{
 					&object.String{Value: "x"},
@@ -947,7 +959,7 @@ func TestEvalSelectFrom(t *testing.T) {
 				Aliases:   []string{"x"},
 				TableName: []string{"baz"},
 			},
-		}
+		}...)
 
 		evaluated := testEval(backend, tt.input)
 		result, ok := evaluated.(*object.Result)
@@ -974,3 +986,942 @@ func TestEvalSelectFrom(t *testing.T) {
 		}
 	}
 }
+
+// countingBackend counts how many rows have been read from its scans.
+// It embeds the interface, so that the transactions of the in-memory backend are hidden and scans go through it.
+type countingBackend struct {
+	evaluator.Backend
+	rowsRead int
+}
+
+func (b *countingBackend) Scan(name string) (object.RowIterator, error) {
+	it, err := b.Backend.Scan(name)
+	if err != nil {
+		return nil, err
+	}
+	return &countingIterator{RowIterator: it, backend: b}, nil
+}
+
+type countingIterator struct {
+	object.RowIterator
+	backend *countingBackend
+}
+
+func (it *countingIterator) Next() (*object.Row, error) {
+	row, err := it.RowIterator.Next()
+	if row != nil {
+		it.backend.rowsRead++
+	}
+	return row, err
+}
+
+func TestEvalSelectStopsEarly(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedRows     int
+		expectedRowsRead int
+	}{
+		{"select n from numbers", 100, 100},
+		{"select n from numbers limit 1", 1, 1},
+		{"select n from numbers limit 5 offset 10", 5, 15},
+		{"select n from numbers where (n % 2) = 0 limit 3", 3, 6},
+		{"select n from numbers order by n desc limit 1", 1, 100},
+	}
+	for _, tt := range tests {
+		backend := &countingBackend{Backend: inmemory.NewBackend()}
+		backendtest.MustCreateTable(t, backend, "numbers", []object.Column{{Name: "n", Type: object.INTEGER}})
+		for i := 1; i <= 100; i++ {
+			backendtest.MustInsert(t, backend, "numbers", object.Row{
+				Values:    []object.Object{&object.Integer{Value: int64(i)}},
+				Aliases:   []string{"n"},
+				TableName: []string{"numbers"},
+			})
+		}
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := evaluator.Eval(backend, program)
+		result, ok := evaluated.(*object.Result)
+		if !ok {
+			t.Fatalf("%s: object is not Result. got=%T (%+v)", tt.input, evaluated, evaluated)
+		}
+		if len(result.Rows) != tt.expectedRows {
+			t.Fatalf("%s: expected %d rows. got=%d", tt.input, tt.expectedRows, len(result.Rows))
+		}
+		if backend.rowsRead != tt.expectedRowsRead {
+			t.Fatalf("%s: expected %d rows to be read. got=%d", tt.input, tt.expectedRowsRead, backend.rowsRead)
+		}
+	}
+}
+
+func TestEvalAggregate(t *testing.T) {
+	tests := []struct {
+		input           string
+		expected        []string
+		expectedAliases string
+	}{
+		{"select count(*) from numbers", []string{"6"}, "count(*)"},
+		{"select count(*) from numbers where n > 10", []string{"0"}, "count(*)"},
+		{"select sum(n), min(n), max(n), avg(n) from numbers", []string{"21\t1\t6\t3.500000"}, "sum(n), min(n), max(n), avg(n)"},
+		{"select sum(n) from numbers where n > 10", []string{"null"}, "sum(n)"},
+		{"select parity, count(*) from numbers group by parity", []string{"'odd'\t3", "'even'\t3"}, "parity, count(*)"},
+		{"select parity, sum(n) * 2 as s from numbers group by parity order by sum(n)", []string{"'odd'\t18", "'even'\t24"}, "parity, s"},
+		{"select parity, max(n) from numbers group by parity order by max(n) desc", []string{"'even'\t6", "'odd'\t5"}, "parity, max(n)"},
+		{"select n % 3 as m, count(*) from numbers group by n % 3 order by n % 3 limit 2", []string{"0\t2", "1\t2"}, "m, count(*)"},
+		{"select count(*) from numbers n, letters", []string{"12"}, "count(*)"},
+		{"select count(*)", []string{"1"}, "count(*)"},
+	}
+	for _, tt := range tests {
+		backend := inmemory.NewBackend()
+		backendtest.MustCreateTable(t, backend, "numbers", []object.Column{
+			{Name: "n", Type: object.INTEGER},
+			{Name: "parity", Type: object.STRING},
+		})
+		for i := 1; i <= 6; i++ {
+			parity := "odd"
+			if i%2 == 0 {
+				parity = "even"
+			}
+			backendtest.MustInsert(t, backend, "numbers", object.Row{
+				Values:    []object.Object{&object.Integer{Value: int64(i)}, &object.String{Value: parity}},
+				Aliases:   []string{"n", "parity"},
+				TableName: []string{"numbers", "numbers"},
+			})
+		}
+		backendtest.MustCreateTable(t, backend, "letters", []object.Column{{Name: "letter", Type: object.STRING}})
+		for _, letter := range []string{"a", "b"} {
+			backendtest.MustInsert(t, backend, "letters", object.Row{
+				Values:    []object.Object{&object.String{Value: letter}},
+				Aliases:   []string{"letter"},
+				TableName: []string{"letters"},
+			})
+		}
+
+		evaluated := testEval(backend, tt.input)
+		result, ok := evaluated.(*object.Result)
+		if !ok {
+			if errorEvaluated, errorOK := evaluated.(*object.Error); errorOK {
+				t.Fatalf("%s: %s", tt.input, errorEvaluated.Inspect())
+			}
+			t.Fatalf("object is not Result. got=%T", evaluated)
+		}
+		if len(result.Rows) != len(tt.expected) {
+			t.Fatalf("%s: expected result to contain %d rows. got=%d", tt.input, len(tt.expected), len(result.Rows))
+		}
+		for i, gotRow := range result.Rows {
+			if gotRow.Inspect() != tt.expected[i] {
+				t.Fatalf("%s: expected row %d to be %s. got=%s", tt.input, i, tt.expected[i], gotRow.Inspect())
+			}
+		}
+		gotAliases := strings.Join(result.Aliases, ", ")
+		if gotAliases != tt.expectedAliases {
+			t.Fatalf("%s: expected aliases '%s'. got='%s'", tt.input, tt.expectedAliases, gotAliases)
+		}
+	}
+}
+
+// TestEvalAggregateLargeIntegers checks that integers that are the same as floats are in different groups
+func TestEvalAggregateLargeIntegers(t *testing.T) {
+	s := evaluator.NewSession(inmemory.NewBackend())
+	evalSession(t, s, "create table big (n INTEGER)")
+	evalSession(t, s, "insert into big values (9007199254740992), (9007199254740993), (9007199254740993)")
+	expected := "n\tcount(*)\n9007199254740992\t1\n9007199254740993\t2"
+	if got := evalSession(t, s, "select n, count(*) from big group by n order by count(*)").Inspect(); got != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, got)
+	}
+}
+
+func TestEvalAggregateErrors(t *testing.T) {
+	tests := []struct {
+		input                string
+		expectedErrorMessage string
+	}{
+		{"select a, count(*) from foo", `column "foo.a" must appear in the GROUP BY clause or be used in an aggregate function`},
+		{"select c from foo group by a", `column "foo.c" must appear in the GROUP BY clause or be used in an aggregate function`},
+		{"select a from foo where count(*) > 1", `aggregate functions are not allowed in WHERE`},
+		{"select sum(count(*)) from foo", `aggregate function calls cannot be nested`},
+		{"select sum(*) from foo", `sum(*) is not allowed`},
+		{"select sum(a) from foo", `function sum(STRING) does not exist`},
+		{"select foo(c) from foo", `function foo does not exist`},
+		{"select count(d) from foo", `column "d" does not exist`},
+	}
+	for _, tt := range tests {
+		backend := inmemory.NewBackend()
+		backendtest.MustCreateTable(t, backend, "foo", []object.Column{
+			{Name: "a", Type: object.STRING},
+			{Name: "c", Type: object.INTEGER},
+		})
+		backendtest.MustInsert(t, backend, "foo", []object.Row{
+			{
+				Values:    []object.Object{&object.String{Value: "abc"}, &object.Integer{Value: 1}},
+				Aliases:   []string{"a", "c"},
+				TableName: []string{"foo", "foo"},
+			},
+		}...)
+		evaluated := testEval(backend, tt.input)
+		testError(t, evaluated, tt.expectedErrorMessage)
+	}
+}
+
+func TestEvalJoinPlans(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"select name, pet from people, pets where id = owner", []string{"'a'\t'cat'", "'a'\t'dog'", "'b'\t'fish'"}},
+		{"select name, pet from pets, people where owner = id", []string{"'a'\t'cat'", "'a'\t'dog'", "'b'\t'fish'"}},
+		{"select name, pet from people join pets on id = owner where pet != 'cat'", []string{"'a'\t'dog'", "'b'\t'fish'"}},
+		{"select name, pet from people p join pets on p.id = owner and name = 'b'", []string{"'b'\t'fish'"}},
+		{"select name, pet from people, pets where id = owner and (id > 1)", []string{"'b'\t'fish'"}},
+		{"select name, pet from people, pets where (id = owner) or (pet = 'bird')", []string{"'a'\t'cat'", "'a'\t'dog'", "'a'\t'bird'", "'b'\t'bird'", "'b'\t'fish'", "'c'\t'bird'"}},
+		{"select name, count(*) from people join pets on id = owner group by name", []string{"'a'\t2", "'b'\t1"}},
+	}
+	for _, tt := range tests {
+		backend := inmemory.NewBackend()
+		backendtest.MustCreateTable(t, backend, "people", []object.Column{
+			{Name: "id", Type: object.INTEGER},
+			{Name: "name", Type: object.STRING},
+		})
+		for i, name := range []string{"a", "b", "c"} {
+			backendtest.MustInsert(t, backend, "people", object.Row{
+				Values:    []object.Object{&object.Integer{Value: int64(i + 1)}, &object.String{Value: name}},
+				Aliases:   []string{"id", "name"},
+				TableName: []string{"people", "people"},
+			})
+		}
+		backendtest.MustCreateTable(t, backend, "pets", []object.Column{
+			{Name: "owner", Type: object.INTEGER},
+			{Name: "pet", Type: object.STRING},
+		})
+		for _, pet := range []struct {
+			owner int64
+			pet   string
+		}{{1, "cat"}, {1, "dog"}, {4, "bird"}, {2, "fish"}} {
+			backendtest.MustInsert(t, backend, "pets", object.Row{
+				Values:    []object.Object{&object.Integer{Value: pet.owner}, &object.String{Value: pet.pet}},
+				Aliases:   []string{"owner", "pet"},
+				TableName: []string{"pets", "pets"},
+			})
+		}
+
+		evaluated := testEval(backend, tt.input)
+		result, ok := evaluated.(*object.Result)
+		if !ok {
+			if errorEvaluated, errorOK := evaluated.(*object.Error); errorOK {
+				t.Fatalf("%s: %s", tt.input, errorEvaluated.Inspect())
+			}
+			t.Fatalf("object is not Result. got=%T", evaluated)
+		}
+		got := make([]string, len(result.Rows))
+		for i, row := range result.Rows {
+			got[i] = row.Inspect()
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.expected, "\n") {
+			t.Fatalf("%s: expected rows\n%s\ngot\n%s", tt.input, strings.Join(tt.expected, "\n"), strings.Join(got, "\n"))
+		}
+	}
+}
+
+// TestEvalSelfJoin checks that the columns of a table joined with itself are resolved by the aliases of the table
+func TestEvalSelfJoin(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"select e.name, m.name from emp e join emp m on e.boss = m.id",
+			"name\tname\n'bob'\t'ann'\n'cid'\t'ann'",
+		},
+		{
+			"select e.name, m.name from emp e, emp m where (m.id = e.boss) and (e.name != 'bob')",
+			"name\tname\n'cid'\t'ann'",
+		},
+		{
+			"select emp.name, m.name from emp join emp m on m.boss = emp.id where emp.id = 1",
+			"name\tname\n'ann'\t'bob'\n'ann'\t'cid'",
+		},
+	}
+	setup := statements(
+		"create table emp (id INTEGER, boss INTEGER, name TEXT)",
+		"insert into emp values (1, 0, 'ann'), (2, 1, 'bob'), (3, 1, 'cid')",
+	)
+	forEachBackend(t, setup, func(t *testing.T, s *evaluator.Session) {
+		for _, tt := range tests {
+			if got := evalSession(t, s, tt.input).Inspect(); got != tt.expected {
+				t.Fatalf("%s: expected\n%s\ngot\n%s", tt.input, tt.expected, got)
+			}
+		}
+	})
+}
+
+func TestEvalExplain(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{
+			"explain select 1",
+			[]string{"Project 1", "  Values (1 rows)"},
+		},
+		{
+			"explain select a from foo where (a > 1) order by a desc limit 1",
+			[]string{"Project a", "  TopN 1 by a DESC", "    Filter (a > 1)", "      Scan foo"},
+		},
+		{
+			"explain select a from foo order by a limit 2 offset 1",
+			[]string{"Project a", "  TopN 2 offset 1 by a", "    Scan foo"},
+		},
+		{
+			"explain select a from foo order by a limit 20000",
+			[]string{"Project a", "  Limit 20000", "    Sort a", "      Scan foo"},
+		},
+		{
+			"explain select a from foo order by a offset 1",
+			[]string{"Project a", "  Limit offset 1", "    Sort a", "      Scan foo"},
+		},
+		{
+			"explain analyze select a from foo where (a > 1) order by a desc limit 1",
+			[]string{
+				"Project a (actual rows=1 time=?)",
+				"  TopN 1 by a DESC (actual rows=1 time=?)",
+				"    Filter (a > 1) (actual rows=2 time=?)",
+				"      Scan foo (actual rows=3 time=?)",
+				"Execution time: ?",
+			},
+		},
+		{
+			// the columns of a plan of several tables are named by their table, and scans by their alias
+			"explain select x.a, count(*) from foo x, foo y where (x.a = y.a) and (y.a > 1) group by x.a",
+			[]string{"Project a, count(*)", "  Aggregate count(*) group by x.a", "    HashJoin on y.a = x.a", "      Filter (y.a > 1)", "        Scan foo y", "      Scan foo x"},
+		},
+		{
+			"explain select a from foo x where (x.a > 1)",
+			[]string{"Project a", "  Filter (a > 1)", "    Scan foo x"},
+		},
+	}
+	timing := regexp.MustCompile(`[0-9.]+(ns|µs|ms|s)`)
+	for _, tt := range tests {
+		backend := inmemory.NewBackend()
+		backendtest.MustCreateTable(t, backend, "foo", []object.Column{{Name: "a", Type: object.INTEGER}})
+		for i := 1; i <= 3; i++ {
+			backendtest.MustInsert(t, backend, "foo", object.Row{
+				Values:    []object.Object{&object.Integer{Value: int64(i)}},
+				Aliases:   []string{"a"},
+				TableName: []string{"foo"},
+			})
+		}
+		evaluated := testEval(backend, tt.input)
+		result, ok := evaluated.(*object.Result)
+		if !ok {
+			t.Fatalf("%s: object is not Result. got=%T (%+v)", tt.input, evaluated, evaluated)
+		}
+		if strings.Join(result.Aliases, ", ") != "QUERY PLAN" {
+			t.Fatalf("%s: expected aliases 'QUERY PLAN'. got=%v", tt.input, result.Aliases)
+		}
+		got := make([]string, len(result.Rows))
+		for i, row := range result.Rows {
+			got[i] = timing.ReplaceAllString(row.Values[0].(*object.String).Value, "?")
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.expected, "\n") {
+			t.Fatalf("%s: expected plan\n%s\ngot\n%s", tt.input, strings.Join(tt.expected, "\n"), strings.Join(got, "\n"))
+		}
+	}
+}
+
+func TestEvalExplainErrors(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"explain select b from foo", "column \"b\" does not exist"},
+		{"explain select a from foo where count(*) > 1", "aggregate functions are not allowed in WHERE"},
+		{"explain analyze select a from foo where a", "argument of WHERE must be type boolean, not type integer: 1"},
+	}
+	for _, tt := range tests {
+		backend := inmemory.NewBackend()
+		backendtest.MustCreateTable(t, backend, "foo", []object.Column{{Name: "a", Type: object.INTEGER}})
+		backendtest.MustInsert(t, backend, "foo", []object.Row{{
+			Values:    []object.Object{&object.Integer{Value: 1}},
+			Aliases:   []string{"a"},
+			TableName: []string{"foo"},
+		}}...)
+		evaluated := testEval(backend, tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("%s: no error object returned. got=%T (%+v)", tt.input, evaluated, evaluated)
+		}
+		if errObj.Message != tt.expectedError {
+			t.Fatalf("%s: wrong error message. expected=%q, got=%q", tt.input, tt.expectedError, errObj.Message)
+		}
+	}
+}
+
+func TestEvalAnalyze(t *testing.T) {
+	backend := inmemory.NewBackend()
+	backendtest.MustCreateTable(t, backend, "foo", []object.Column{{Name: "a", Type: object.INTEGER}, {Name: "b", Type: object.STRING}})
+	for _, values := range [][]object.Object{
+		{&object.Integer{Value: 1}, &object.String{Value: "x"}},
+		{&object.Integer{Value: 2}, &object.String{Value: "y"}},
+		{&object.Integer{Value: 2}, object.NULL},
+		{&object.Integer{Value: 4}, &object.String{Value: "x"}},
+	} {
+		backendtest.MustInsert(t, backend, "foo", object.Row{
+			Values:    values,
+			Aliases:   []string{"a", "b"},
+			TableName: []string{"foo", "foo"},
+		})
+	}
+	backendtest.MustCreateTable(t, backend, "bar", []object.Column{{Name: "c", Type: object.FLOAT}})
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"analyze foo", []string{"'foo'\t'a'\t4\t3\t0.000000\t1\t4", "'foo'\t'b'\t4\t2\t0.250000\t'x'\t'y'"}},
+		{"analyze", []string{"'bar'\t'c'\t0\t0\t0.000000\tnull\tnull", "'foo'\t'a'\t4\t3\t0.000000\t1\t4", "'foo'\t'b'\t4\t2\t0.250000\t'x'\t'y'"}},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(backend, tt.input)
+		result, ok := evaluated.(*object.Result)
+		if !ok {
+			t.Fatalf("%s: object is not Result. got=%T (%+v)", tt.input, evaluated, evaluated)
+		}
+		got := make([]string, len(result.Rows))
+		for i, row := range result.Rows {
+			got[i] = row.Inspect()
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.expected, "\n") {
+			t.Fatalf("%s: expected rows\n%s\ngot\n%s", tt.input, strings.Join(tt.expected, "\n"), strings.Join(got, "\n"))
+		}
+	}
+	stats, err := backend.Statistics("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats == nil || stats.RowCount != 4 || stats.Column("b").DistinctCount != 2 {
+		t.Fatalf("expected statistics to be stored. got=%+v", stats)
+	}
+	evaluated := testEval(backend, "analyze baz")
+	if errObj, ok := evaluated.(*object.Error); !ok || errObj.Message != `relation "baz" does not exist` {
+		t.Fatalf("expected error for missing table. got=%+v", evaluated)
+	}
+}
+
+func TestEvalPreparedStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"prepare q as select $1 + 1; execute q (41)", "($1 + 1)\n42"},
+		{"prepare q as select ? || ?, $1 as x; execute q ('a', 'b')", "($1 || $2)\tx\n'ab'\t'a'"},
+		{"prepare q as insert into foo values ($1, $2); execute q (3, 'c'); execute q (4, 'd'); select a, b from foo", "a\tb\n1\t'a'\n2\t'b'\n3\t'c'\n4\t'd'"},
+		{"prepare q as select b from foo where a = $1; execute q (2)", "b\n'b'"},
+		{"prepare q as select b from foo where a = $1; execute q (1); execute q (2)", "b\n'b'"},
+		{"prepare q as select b from foo where (a > $1) and (b != $2); execute q (0, 'a')", "b\n'b'"},
+		{"prepare q as select b from foo order by a * $1 limit 1; execute q (-1)", "b\n'b'"},
+		{"prepare q as select 1; deallocate q; prepare q as select 2; execute q", "2\n2"},
+		{"prepare q as explain select a from foo where a = $1; execute q (1)", "QUERY PLAN\n'Project a'\n'  Filter (a = 1)'\n'    Scan foo'"},
+		// a string argument is read as a value of the type of its parameter
+		{"prepare q as select b from foo where a = $1; execute q ('2')", "b\n'b'"},
+		{"prepare q as select a from foo where (a + $1) > $2; execute q (' 1 ', 2.5)", "a\n2"},
+		{"prepare q as insert into foo values ($1, $2); execute q ('3', 'c'); select a + 1 from foo where b = 'c'", "(a + 1)\n4"},
+		{"prepare q as select x.b from foo x join foo y on x.a = y.a where y.a = $1; execute q ('1'); execute q (2)", "b\n'b'"},
+	}
+	for _, tt := range tests {
+		backend := inmemory.NewBackend()
+		testEval(backend, "create table foo (a int, b text); insert into foo values (1, 'a'), (2, 'b')")
+		evaluated := testEval(backend, tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Fatalf("%s: expected\n%s\ngot\n%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestEvalPreparedStatementErrors(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"select $1", "there is no parameter $1"},
+		{"execute q", `prepared statement "q" does not exist`},
+		{"deallocate q", `prepared statement "q" does not exist`},
+		{"prepare q as select 1; prepare q as select 2", `prepared statement "q" already exists`},
+		{"prepare q as select $2; execute q (1)", `wrong number of parameters for prepared statement "q": expected 2, got 1`},
+		{"prepare q as select 1; deallocate all; execute q", `prepared statement "q" does not exist`},
+		// a string argument is read as a value of the type of its parameter
+		{"prepare q as insert into foo values ($1, $2); execute q ('a', 1)", `invalid input syntax for type integer: "a"`},
+		{"prepare q as insert into foo values ($1, $2); execute q (true, 1)", `cannot insert BOOLEAN with value true in INTEGER column in table "foo"`},
+		{"prepare q as select a from foo where a = $1; execute q (a)", "no such column: a"},
+	}
+	for _, tt := range tests {
+		backend := inmemory.NewBackend()
+		testEval(backend, "create table foo (a int, b text)")
+		evaluated := testEval(backend, tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("%s: no error object returned. got=%T (%+v)", tt.input, evaluated, evaluated)
+		}
+		if errObj.Message != tt.expectedError {
+			t.Fatalf("%s: wrong error message. expected=%q, got=%q", tt.input, tt.expectedError, errObj.Message)
+		}
+	}
+}
+
+func TestSessionPrepare(t *testing.T) {
+	session := evaluator.NewSession(inmemory.NewBackend())
+	session.Eval(parser.New(lexer.New("create table foo (a int, b text, c float, d boolean)")).ParseProgram())
+
+	insert, err := session.Prepare("insert into foo values (?, ?, ?, ?)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if insert.NumParameters() != 4 {
+		t.Fatalf("expected 4 parameters. got=%d", insert.NumParameters())
+	}
+	for i, name := range []string{"x", "it's"} {
+		if result := insert.Execute(i, name, float32(0.5), i == 0); result.Type() == object.ERROR_OBJ {
+			t.Fatal(result.Inspect())
+		}
+	}
+
+	query, err := session.Prepare("select b, c, d from foo where a >= $1 order by a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		argument int64
+		expected string
+	}{
+		{0, "b\tc\td\n'x'\t0.500000\ttrue\n'it's'\t0.500000\tfalse"},
+		{1, "b\tc\td\n'it's'\t0.500000\tfalse"},
+		{2, "b\tc\td"},
+	} {
+		if got := query.Execute(tt.argument).Inspect(); got != tt.expected {
+			t.Fatalf("%d: expected\n%s\ngot\n%s", tt.argument, tt.expected, got)
+		}
+	}
+
+	errorTests := []struct {
+		arguments     []interface{}
+		expectedError string
+	}{
+		{nil, "expected 1 arguments, got 0"},
+		{[]interface{}{1, 2}, "expected 1 arguments, got 2"},
+		{[]interface{}{[]byte("x")}, "argument $1: unsupported type []uint8"},
+	}
+	for _, tt := range errorTests {
+		errObj, ok := query.Execute(tt.arguments...).(*object.Error)
+		if !ok || errObj.Message != tt.expectedError {
+			t.Fatalf("%v: expected error %q. got=%v", tt.arguments, tt.expectedError, errObj)
+		}
+	}
+
+	if _, err := session.Prepare("select 1; select 2"); err == nil || err.Error() != "cannot prepare 2 statements, only one" {
+		t.Fatalf("expected error for two statements. got=%v", err)
+	}
+	if _, err := session.Prepare("execute q"); err == nil || err.Error() != "cannot prepare EXECUTE statement" {
+		t.Fatalf("expected error for preparing EXECUTE. got=%v", err)
+	}
+	if _, err := session.Prepare("select from"); err == nil {
+		t.Fatalf("expected parser error")
+	}
+}
+
+// TestPreparedStatementPlan checks that the plan of a prepared statement is reused with other arguments,
+// and made again when the tables it reads have changed
+func TestPreparedStatementPlan(t *testing.T) {
+	forEachBackend(t, statements(), func(t *testing.T, s *evaluator.Session) {
+		query, err := s.Prepare("select b from foo where a > $1 order by a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		testError(t, query.Execute(0), `relation "foo" does not exist`)
+		evalSession(t, s, "create table foo (a int, b text)")
+		evalSession(t, s, "insert into foo values (1, 'a'), (2, 'b'), (3, 'c')")
+		for _, vectorize := range []string{"off", "on"} {
+			evalSession(t, s, "set vectorize = '"+vectorize+"'")
+			for _, tt := range []struct {
+				argument interface{}
+				expected string
+			}{
+				{0, "b\n'a'\n'b'\n'c'"},
+				{"2", "b\n'c'"},
+				{int64(1), "b\n'b'\n'c'"},
+			} {
+				if got := query.Execute(tt.argument).Inspect(); got != tt.expected {
+					t.Fatalf("vectorize=%s, %v: expected\n%s\ngot\n%s", vectorize, tt.argument, tt.expected, got)
+				}
+			}
+		}
+		rows, err := query.QueryContext(context.Background(), "1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		row, err := rows.Next()
+		if err != nil || row == nil || row.Values[0].Inspect() != "'b'" {
+			t.Fatalf("expected the row 'b'. got=%v, %v", row, err)
+		}
+		testError(t, query.Execute("x"), `invalid input syntax for type integer: "x"`)
+	})
+}
+
+func TestSessionTransactions(t *testing.T) {
+	backend := inmemory.NewBackend()
+	a := evaluator.NewSession(backend)
+	b := evaluator.NewSession(backend)
+	eval := func(session *evaluator.Session, input string) string {
+		return session.Eval(parser.New(lexer.New(input)).ParseProgram()).Inspect()
+	}
+	tests := []struct {
+		session  *evaluator.Session
+		input    string
+		expected string
+	}{
+		{a, "create table foo (a int)", "OK"},
+		{a, "begin; insert into foo values (1), (2); create table bar (b text); insert into bar values ('x')", "OK"},
+		// changes in a transaction are seen by the session, but not by other sessions
+		{a, "select a from foo", "a\n1\n2"},
+		{a, "select b from bar", "b\n'x'"},
+		{b, "select a from foo", "a"},
+		{b, "create table bar (c int)", "OK"},
+		{a, "commit", `ERROR: relation "bar" already exists`},
+		{b, "select a from foo", "a"},
+		{a, "begin; insert into foo values (1), (2); commit", "OK"},
+		{b, "select a from foo", "a\n1\n2"},
+		{a, "begin; insert into foo values (3); rollback; select a from foo", "a\n1\n2"},
+		{a, "begin; begin", "ERROR: there is already a transaction in progress"},
+		{a, "rollback; rollback", "ERROR: there is no transaction in progress"},
+		{a, "commit", "ERROR: there is no transaction in progress"},
+		{a, "begin; create table baz (a int); insert into baz values (4); select foo.a, baz.a from foo, baz", "a\ta\n1\t4\n2\t4"},
+		{b, "select a from baz", `ERROR: relation "baz" does not exist`},
+		{a, "commit; select a from baz", "a\n4"},
+	}
+	for _, tt := range tests {
+		if got := eval(tt.session, tt.input); got != tt.expected {
+			t.Fatalf("%s: expected\n%s\ngot\n%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestSessionSnapshotIsolation(t *testing.T) {
+	backend := inmemory.NewBackend()
+	a := evaluator.NewSession(backend)
+	b := evaluator.NewSession(backend)
+	eval := func(session *evaluator.Session, input string) string {
+		return session.Eval(parser.New(lexer.New(input)).ParseProgram()).Inspect()
+	}
+	tests := []struct {
+		session  *evaluator.Session
+		input    string
+		expected string
+	}{
+		{a, "create table foo (a int); insert into foo values (1), (2), (3)", "OK"},
+		// a transaction reads the snapshot from when it began, and its own changes
+		{a, "begin; select count(*) from foo", "count(*)\n3"},
+		{b, "insert into foo values (4); delete from foo where a = 1", "OK"},
+		{b, "create table bar (b int)", "OK"},
+		{a, "select a from foo order by a", "a\n1\n2\n3"},
+		{a, "select b from bar", `ERROR: relation "bar" does not exist`},
+		{a, "delete from foo where a > 2", "OK"},
+		{a, "insert into foo values (5); select a from foo order by a", "a\n1\n2\n5"},
+		{a, "commit; select a from foo order by a", "a\n2\n4\n5"},
+		// the first transaction to commit a delete of a row wins
+		{a, "begin; delete from foo where a = 2", "OK"},
+		{b, "begin; delete from foo where (a = 2) or (a = 4)", "OK"},
+		{a, "commit", "OK"},
+		{b, "commit", "ERROR: could not serialize access due to concurrent update"},
+		{b, "select a from foo order by a", "a\n4\n5"},
+		// transactions that delete different rows don't conflict
+		{a, "begin; delete from foo where a = 4", "OK"},
+		{b, "begin; delete from foo where a = 5", "OK"},
+		{a, "commit", "OK"},
+		{b, "commit; select count(*) from foo", "count(*)\n0"},
+	}
+	for _, tt := range tests {
+		if got := eval(tt.session, tt.input); got != tt.expected {
+			t.Fatalf("%s: expected\n%s\ngot\n%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+// TestSessionQuery checks that a query returns its rows one at a time, and the error of a row once it is reached
+func TestSessionQuery(t *testing.T) {
+	a := evaluator.NewSession(inmemory.NewBackend())
+	a.Eval(parser.New(lexer.New("create table foo (a int); insert into foo values (1), (2), (0)")).ParseProgram())
+	rows, err := a.QueryContext(context.Background(), parser.New(lexer.New("select 4 / a as q from foo")).ParseProgram().Statements[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rows.Aliases, []string{"q"}) || !reflect.DeepEqual(rows.Types, []object.DataType{object.INTEGER}) {
+		t.Fatalf("expected column q of type INTEGER, got %v %v", rows.Aliases, rows.Types)
+	}
+	for _, expected := range []string{"4", "2"} {
+		row, err := rows.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := row.Values[0].Inspect(); got != expected {
+			t.Fatalf("expected %s, got %s", expected, got)
+		}
+	}
+	if _, err := rows.Next(); err == nil || err.Error() != "division by zero" || object.ErrorCode(err) != object.CodeDivisionByZero {
+		t.Fatalf("expected division by zero, got %v", err)
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.QueryContext(context.Background(), parser.New(lexer.New("delete from foo")).ParseProgram().Statements[0]); err == nil || err.Error() != "cannot query DELETE statement, only SELECT" {
+		t.Fatalf("expected error for DELETE, got %v", err)
+	}
+}
+
+func TestEvalDelete(t *testing.T) {
+	tests := []struct {
+		input        string
+		expected     string
+		rowsAffected int64
+	}{
+		{"delete from foo where a = 1", "a\n2\n3\n4", 1},
+		{"delete from foo where a > 2", "a\n1\n2", 2},
+		{"delete from foo where a > 4", "a\n1\n2\n3\n4", 0},
+		{"delete from foo where (foo.a % 2) = 0", "a\n1\n3", 2},
+		{"delete from foo", "a", 4},
+		{"begin; delete from foo where a < 3; insert into foo values (5); delete from foo where a = 5; commit", "a\n3\n4", 0},
+	}
+	for _, tt := range tests {
+		backend := inmemory.NewBackend()
+		testEval(backend, "create table foo (a int); insert into foo values (1), (2), (3), (4)")
+		evaluated := testEval(backend, tt.input)
+		ok, isOK := evaluated.(*object.OK)
+		if !isOK {
+			t.Fatalf("%s: object is not OK. got=%T (%+v)", tt.input, evaluated, evaluated)
+		}
+		if ok.RowsAffected != tt.rowsAffected {
+			t.Fatalf("%s: expected %d rows affected. got=%d", tt.input, tt.rowsAffected, ok.RowsAffected)
+		}
+		if got := testEval(backend, "select a from foo order by a").Inspect(); got != tt.expected {
+			t.Fatalf("%s: expected\n%s\ngot\n%s", tt.input, tt.expected, got)
+		}
+	}
+
+	errorTests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"delete from bar", `relation "bar" does not exist`},
+		{"delete from foo where b = 1", `column "b" does not exist`},
+		{"delete from foo where a", "argument of WHERE must be type boolean, not type integer: 1"},
+	}
+	for _, tt := range errorTests {
+		backend := inmemory.NewBackend()
+		testEval(backend, "create table foo (a int); insert into foo values (1)")
+		testError(t, testEval(backend, tt.input), tt.expectedError)
+	}
+}
+
+func TestEvalCancel(t *testing.T) {
+	backend := inmemory.NewBackend()
+	values := make([]string, 300)
+	for i := range values {
+		values[i] = fmt.Sprintf("(%d)", i)
+	}
+	testEval(backend, "create table foo (a int); insert into foo values "+strings.Join(values, ", "))
+	// a cross join of 27 million rows, which is stopped long before it is done
+	crossJoin := parser.New(lexer.New("select count(*) from foo x, foo y, foo z")).ParseProgram()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	testError(t, evaluator.EvalContext(ctx, backend, crossJoin), "canceling statement due to user request")
+
+	session := evaluator.NewSession(backend)
+	eval := func(input string) string {
+		return session.Eval(parser.New(lexer.New(input)).ParseProgram()).Inspect()
+	}
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"set statement_timeout = 10", "OK"},
+		{"select count(*) from foo x, foo y, foo z", "ERROR: canceling statement due to statement timeout"},
+		{"select count(*) from foo", "count(*)\n300"},
+		{"begin; insert into foo values (300); select count(*) from foo x, foo y, foo z", "ERROR: canceling statement due to statement timeout"},
+		{"rollback; set statement_timeout to '1min'; select count(*) from foo x, foo y", "count(*)\n90000"},
+		{"set statement_timeout to '2 h'", "OK"},
+		{"set statement_timeout = 0", "OK"},
+		{"set statement_timeout = 'soon'", `ERROR: invalid value for parameter "statement_timeout": "soon"`},
+		{"set statement_timeout = '1 week'", `ERROR: invalid value for parameter "statement_timeout": "1 week"`},
+		{"set statement_timeout = -1", `ERROR: invalid value for parameter "statement_timeout": "-1"`},
+		{"set search_path = 1", `ERROR: unrecognized configuration parameter "search_path"`},
+	}
+	for _, tt := range tests {
+		if got := eval(tt.input); got != tt.expected {
+			t.Fatalf("%s: expected\n%s\ngot\n%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestEvalSpill(t *testing.T) {
+	backend := inmemory.NewBackend()
+	values := make([]string, 300)
+	for i := range values {
+		values[i] = fmt.Sprintf("(%d, %d.5, 'row %d', %t)", i%10, i%7, i, i%3 == 0)
+	}
+	testEval(backend, "create table foo (a int, b float, c text, d boolean); insert into foo values "+strings.Join(values, ", "))
+	queries := []string{
+		"select a, b, c, d from foo order by a desc, b",
+		"select x.c, y.c, y.d from foo x join foo y on x.a = y.a where x.b < 1 order by x.c, y.c",
+	}
+	inMemory := evaluator.NewSession(backend)
+	spilling := evaluator.NewSession(backend)
+	// with 1 kB, both the sort and the join write rows to temporary files
+	if got := spilling.Eval(parser.New(lexer.New("set work_mem = 1")).ParseProgram()).Inspect(); got != "OK" {
+		t.Fatalf("expected OK, got %s", got)
+	}
+	for _, query := range queries {
+		program := parser.New(lexer.New(query)).ParseProgram()
+		expected := inMemory.Eval(program).Inspect()
+		program = parser.New(lexer.New(query)).ParseProgram()
+		if got := spilling.Eval(program).Inspect(); got != expected {
+			t.Fatalf("%s: expected the same result as in memory, got\n%s", query, got)
+		}
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"set work_mem = '64MB'", "OK"},
+		{"set work_mem to '512 kB'", "OK"},
+		{"set work_mem = 0", "OK"},
+		{"set work_mem = '1 PB'", `ERROR: invalid value for parameter "work_mem": "1 PB"`},
+		{"set work_mem = '9999999999 TB'", `ERROR: invalid value for parameter "work_mem": "9999999999 TB"`},
+	}
+	for _, tt := range tests {
+		if got := spilling.Eval(parser.New(lexer.New(tt.input)).ParseProgram()).Inspect(); got != tt.expected {
+			t.Fatalf("%s: expected\n%s\ngot\n%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestEvalTopN(t *testing.T) {
+	backend := inmemory.NewBackend()
+	values := make([]string, 300)
+	for i := range values {
+		values[i] = fmt.Sprintf("(%d, %d.5, 'row %d')", i%10, i%7, i)
+	}
+	testEval(backend, "create table foo (a int, b float, c text); insert into foo values "+strings.Join(values, ", "))
+	tests := []struct {
+		orderBy       string
+		limit, offset int
+	}{
+		// many rows have the same a, and are returned in the order they were read
+		{"a desc", 10, 0},
+		{"a", 5, 28},
+		{"a desc, b", 12, 40},
+		{"b, a desc, c", 7, 3},
+		{"a", 10, 295},
+		{"a", 0, 0},
+	}
+	for _, tt := range tests {
+		sorted := testEval(backend, "select a, b, c from foo order by "+tt.orderBy).(*object.Result).Rows
+		query := fmt.Sprintf("select a, b, c from foo order by %s limit %d offset %d", tt.orderBy, tt.limit, tt.offset)
+		evaluated := testEval(backend, query)
+		result, ok := evaluated.(*object.Result)
+		if !ok {
+			t.Fatalf("%s: object is not Result. got=%T (%+v)", query, evaluated, evaluated)
+		}
+		var expected []string
+		for i := tt.offset; i < tt.offset+tt.limit && i < len(sorted); i++ {
+			expected = append(expected, sorted[i].Inspect())
+		}
+		var got []string
+		for _, row := range result.Rows {
+			got = append(got, row.Inspect())
+		}
+		if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+			t.Fatalf("%s: expected rows\n%s\ngot\n%s", query, strings.Join(expected, "\n"), strings.Join(got, "\n"))
+		}
+	}
+}
+
+func TestPreparedStatementDescribe(t *testing.T) {
+	session := evaluator.NewSession(inmemory.NewBackend())
+	session.Eval(parser.New(lexer.New("create table foo (a int, b text, c float, d boolean)")).ParseProgram())
+	tests := []struct {
+		input      string
+		columns    []string
+		types      []object.DataType
+		parameters []object.DataType
+	}{
+		{"select a, b || $1 as s, $2 from foo where c > $3 and d = $4", []string{"a", "s", "$2"}, []object.DataType{object.INTEGER, object.STRING, ""}, []object.DataType{object.STRING, "", object.FLOAT, object.BOOLEAN}},
+		{"select count(*) from foo f join foo g on f.a = $1", []string{"count(*)"}, []object.DataType{object.INTEGER}, []object.DataType{object.INTEGER}},
+		{"insert into foo values ($1, $2, 1.5, $3 or true)", nil, nil, []object.DataType{object.INTEGER, object.STRING, object.BOOLEAN}},
+		{"explain select a from foo where b = $1", []string{"QUERY PLAN"}, []object.DataType{object.STRING}, []object.DataType{object.STRING}},
+		{"delete from foo where (b = $1) and (c < $2)", nil, nil, []object.DataType{object.STRING, object.FLOAT}},
+		{"create table bar (a int)", nil, nil, []object.DataType{}},
+	}
+	for _, tt := range tests {
+		prepared, err := session.Prepare(tt.input)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.input, err)
+		}
+		d, err := prepared.Describe()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.input, err)
+		}
+		if !reflect.DeepEqual(d.Columns, tt.columns) || !reflect.DeepEqual(d.Types, tt.types) || !reflect.DeepEqual(d.Parameters, tt.parameters) {
+			t.Fatalf("%s: expected %v %v %v, got %v %v %v", tt.input, tt.columns, tt.types, tt.parameters, d.Columns, d.Types, d.Parameters)
+		}
+	}
+	prepared, err := session.Prepare("select x from foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := prepared.Describe(); err == nil || err.Error() != `column "x" does not exist` {
+		t.Fatalf("expected error for unknown column. got=%v", err)
+	}
+}
+
+func TestConcurrentSessions(t *testing.T) {
+	backend := inmemory.NewBackend()
+	eval := func(session *evaluator.Session, input string) object.Object {
+		return session.Eval(parser.New(lexer.New(input)).ParseProgram())
+	}
+	eval(evaluator.NewSession(backend), "create table foo (a int)")
+	const sessions, transactions = 8, 20
+	var wg sync.WaitGroup
+	for i := 0; i < sessions; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			session := evaluator.NewSession(backend)
+			for j := 0; j < transactions; j++ {
+				if result := eval(session, "begin; insert into foo values (1), (2); insert into foo values (3); commit"); result.Type() == object.ERROR_OBJ {
+					t.Error(result.Inspect())
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			session := evaluator.NewSession(backend)
+			for j := 0; j < transactions; j++ {
+				result, ok := eval(session, "select count(*), sum(a) from foo").(*object.Result)
+				if !ok {
+					t.Errorf("expected a result")
+					return
+				}
+				// a committed transaction is seen all at once
+				count := result.Rows[0].Values[0].(*object.Integer).Value
+				if count%3 != 0 {
+					t.Errorf("saw %d rows, which is not a whole number of transactions", count)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	expected := fmt.Sprintf("count(*)\tsum(a)\n%d\t%d", sessions*transactions*3, sessions*transactions*6)
+	if got := eval(evaluator.NewSession(backend), "select count(*), sum(a) from foo").Inspect(); got != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, got)
+	}
+}
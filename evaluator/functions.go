@@ -0,0 +1,493 @@
+package evaluator
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vegarsti/sql/ast"
+	"github.com/vegarsti/sql/object"
+)
+
+// scalarFunction is a function evaluated for the values of its arguments in each row,
+// unlike the aggregate functions, which are evaluated for the values of all rows in a group
+type scalarFunction struct {
+	// arguments is the number of arguments
+	arguments int
+	// eval is called with values that are not NULL, since the result is NULL if any argument is NULL
+	eval func(name string, arguments []object.Object) object.Object
+	// resultType is the data type of the result for the arguments and their data types, or empty if it can't be known
+	resultType func(arguments []ast.Expression, types []object.DataType) object.DataType
+}
+
+var scalarFunctions = map[string]scalarFunction{
+	// now is replaced by the time the statement or transaction began when a session binds a statement,
+	// so this is only called for expressions evaluated on their own
+	"now": {
+		arguments: 0,
+		eval: func(string, []object.Object) object.Object {
+			return object.TimestampTZOf(time.Now())
+		},
+		resultType: func([]ast.Expression, []object.DataType) object.DataType { return object.TIMESTAMPTZ },
+	},
+	"date_trunc": {
+		arguments:  2,
+		eval:       evalDateTrunc,
+		resultType: dateTruncType,
+	},
+	"extract": {
+		arguments:  2,
+		eval:       evalExtract,
+		resultType: extractType,
+	},
+	"date_part": {
+		arguments:  2,
+		eval:       evalExtract,
+		resultType: extractType,
+	},
+	"to_char": {
+		arguments:  2,
+		eval:       evalToChar,
+		resultType: func([]ast.Expression, []object.DataType) object.DataType { return object.STRING },
+	},
+}
+
+// evalFunction evaluates the scalar function for the values of its arguments
+func evalFunction(node *ast.CallExpression, arguments []object.Object) object.Object {
+	f, ok := scalarFunctions[node.Function]
+	if !ok {
+		return object.Errorf(object.CodeUndefinedFunction, "function %s does not exist", node.Function)
+	}
+	if node.Star || len(arguments) != f.arguments {
+		return functionDoesNotExist(node.Function, arguments)
+	}
+	for _, a := range arguments {
+		if a.Type() == object.NULL_OBJ {
+			return object.NULL
+		}
+	}
+	return f.eval(node.Function, arguments)
+}
+
+// functionDoesNotExist is the error for a function that can't be called with the types of the arguments
+func functionDoesNotExist(name string, arguments []object.Object) *object.Error {
+	types := make([]string, len(arguments))
+	for i, a := range arguments {
+		types[i] = string(a.Type())
+	}
+	return object.Errorf(object.CodeUndefinedFunction, "function %s(%s) does not exist", name, strings.Join(types, ", "))
+}
+
+// unitNotRecognized is the error for a unit of date_trunc or extract that doesn't apply to the type of the value
+func unitNotRecognized(unit string, v object.Object) *object.Error {
+	return object.Errorf(object.CodeInvalidParameterValue, `unit "%s" not recognized for type %s`, unit, strings.ToLower(string(v.Type())))
+}
+
+// evalDateTrunc truncates a timestamp or interval to a unit, such as the first day of the month for month.
+// A date is truncated as a timestamp at the start of the day.
+func evalDateTrunc(name string, arguments []object.Object) object.Object {
+	unit, ok := arguments[0].(*object.String)
+	if !ok {
+		return functionDoesNotExist(name, arguments)
+	}
+	field := strings.ToLower(unit.Value)
+	var t time.Time
+	switch v := arguments[1].(type) {
+	case *object.Date:
+		t = v.GoTime()
+	case *object.Timestamp:
+		t = v.GoTime()
+	case *object.TimestampTZ:
+		t = v.GoTime()
+	case *object.Interval:
+		truncated, ok := truncateInterval(v, field)
+		if !ok {
+			return unitNotRecognized(unit.Value, v)
+		}
+		return truncated
+	default:
+		return functionDoesNotExist(name, arguments)
+	}
+	truncated, ok := truncateTime(t, field)
+	if !ok {
+		return unitNotRecognized(unit.Value, arguments[1])
+	}
+	if _, ok := arguments[1].(*object.TimestampTZ); ok {
+		return object.TimestampTZOf(truncated)
+	}
+	return object.TimestampOf(truncated)
+}
+
+func dateTruncType(_ []ast.Expression, types []object.DataType) object.DataType {
+	switch types[1] {
+	case object.DATE, object.TIMESTAMP:
+		return object.TIMESTAMP
+	case object.TIMESTAMPTZ, object.INTERVAL:
+		return types[1]
+	}
+	return ""
+}
+
+// truncateTime truncates a time in UTC to the start of the unit. Weeks start on Monday, and centuries and millennia
+// start in years such as 2001, like in PostgreSQL.
+func truncateTime(t time.Time, unit string) (time.Time, bool) {
+	year, month, day := t.Date()
+	switch unit {
+	case "microseconds":
+		return t, true
+	case "milliseconds":
+		return t.Truncate(time.Millisecond), true
+	case "second":
+		return t.Truncate(time.Second), true
+	case "minute":
+		return t.Truncate(time.Minute), true
+	case "hour":
+		return t.Truncate(time.Hour), true
+	case "day":
+	case "week":
+		day -= (int(t.Weekday()) + 6) % 7
+	case "month":
+		day = 1
+	case "quarter":
+		month, day = (month-1)/3*3+1, 1
+	case "year":
+		month, day = 1, 1
+	case "decade":
+		year, month, day = int(floorDiv(int64(year), 10)*10), 1, 1
+	case "century":
+		year, month, day = int(floorDiv(int64(year)-1, 100)*100+1), 1, 1
+	case "millennium":
+		year, month, day = int(floorDiv(int64(year)-1, 1000)*1000+1), 1, 1
+	default:
+		return time.Time{}, false
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC), true
+}
+
+func truncateInterval(interval *object.Interval, unit string) (*object.Interval, bool) {
+	truncated := *interval
+	microseconds := map[string]int64{
+		"microseconds": 1,
+		"milliseconds": 1000,
+		"second":       object.MicrosecondsPerSecond,
+		"minute":       60 * object.MicrosecondsPerSecond,
+		"hour":         60 * 60 * object.MicrosecondsPerSecond,
+	}
+	months := map[string]int64{"quarter": 3, "year": 12, "decade": 120, "century": 1200, "millennium": 12000}
+	switch {
+	case microseconds[unit] != 0:
+		truncated.Microseconds -= truncated.Microseconds % microseconds[unit]
+	case unit == "day":
+		truncated.Microseconds = 0
+	case unit == "month":
+		truncated.Days, truncated.Microseconds = 0, 0
+	case months[unit] != 0:
+		truncated.Months -= truncated.Months % months[unit]
+		truncated.Days, truncated.Microseconds = 0, 0
+	default:
+		return nil, false
+	}
+	return &truncated, true
+}
+
+// evalExtract returns a field of a date, time, timestamp or interval, such as the year. The fields second,
+// milliseconds and epoch are floats, with the fraction of a second, and the other fields are integers.
+// Timestamps with a time zone are in UTC.
+func evalExtract(name string, arguments []object.Object) object.Object {
+	unit, ok := arguments[0].(*object.String)
+	if !ok {
+		return functionDoesNotExist(name, arguments)
+	}
+	field := strings.ToLower(unit.Value)
+	var v object.Object
+	switch source := arguments[1].(type) {
+	case *object.Date, *object.Timestamp, *object.TimestampTZ:
+		microseconds, _ := object.PointInTime(source)
+		v = extractTimestamp(field, microseconds)
+	case *object.Time:
+		v = extractTime(field, source.Value)
+	case *object.Interval:
+		v = extractInterval(field, source)
+	default:
+		return functionDoesNotExist(name, arguments)
+	}
+	if v == nil {
+		return unitNotRecognized(unit.Value, arguments[1])
+	}
+	return v
+}
+
+func extractType(arguments []ast.Expression, _ []object.DataType) object.DataType {
+	unit, ok := arguments[0].(*ast.StringLiteral)
+	if !ok {
+		return ""
+	}
+	switch strings.ToLower(unit.Value) {
+	case "second", "milliseconds", "epoch":
+		return object.FLOAT
+	}
+	return object.INTEGER
+}
+
+// extractClock returns the fields of a number of microseconds that are the same for times, timestamps and intervals,
+// or nil if the field isn't one of them
+func extractClock(field string, microseconds int64) object.Object {
+	seconds := floorMod(microseconds, 60*object.MicrosecondsPerSecond)
+	switch field {
+	case "microseconds":
+		return &object.Integer{Value: seconds}
+	case "milliseconds":
+		return &object.Float{Value: float64(seconds) / 1000}
+	case "second":
+		return &object.Float{Value: float64(seconds) / object.MicrosecondsPerSecond}
+	case "minute":
+		return &object.Integer{Value: floorMod(floorDiv(microseconds, 60*object.MicrosecondsPerSecond), 60)}
+	}
+	return nil
+}
+
+func extractTimestamp(field string, microseconds int64) object.Object {
+	if v := extractClock(field, microseconds); v != nil {
+		return v
+	}
+	t := object.TimeOf(microseconds)
+	year := t.Year()
+	if year <= 0 {
+		// there is no year 0, so the year before 1 AD is -1
+		year--
+	}
+	isoYear, isoWeek := t.ISOWeek()
+	var n int
+	switch field {
+	case "hour":
+		n = t.Hour()
+	case "day":
+		n = t.Day()
+	case "month":
+		n = int(t.Month())
+	case "quarter":
+		n = (int(t.Month())-1)/3 + 1
+	case "year":
+		n = year
+	case "isoyear":
+		n = isoYear
+	case "week":
+		n = isoWeek
+	case "dow":
+		n = int(t.Weekday())
+	case "isodow":
+		n = (int(t.Weekday())+6)%7 + 1
+	case "doy":
+		n = t.YearDay()
+	case "decade":
+		n = int(floorDiv(int64(t.Year()), 10))
+	case "century":
+		n = int(floorDiv(int64(year)-1, 100)) + 1
+		if year < 0 {
+			n = -int(floorDiv(int64(-year)-1, 100)) - 1
+		}
+	case "millennium":
+		n = int(floorDiv(int64(year)-1, 1000)) + 1
+		if year < 0 {
+			n = -int(floorDiv(int64(-year)-1, 1000)) - 1
+		}
+	case "epoch":
+		return &object.Float{Value: float64(microseconds) / object.MicrosecondsPerSecond}
+	default:
+		return nil
+	}
+	return &object.Integer{Value: int64(n)}
+}
+
+func extractTime(field string, microseconds int64) object.Object {
+	switch field {
+	case "hour":
+		return &object.Integer{Value: microseconds / (60 * 60 * object.MicrosecondsPerSecond)}
+	case "epoch":
+		return &object.Float{Value: float64(microseconds) / object.MicrosecondsPerSecond}
+	}
+	return extractClock(field, microseconds)
+}
+
+// extractInterval returns a field of an interval, where the epoch is the number of seconds with 365.25 days in a year
+// and 30 days in a month, like in PostgreSQL
+func extractInterval(field string, interval *object.Interval) object.Object {
+	years, months := interval.Months/12, interval.Months%12
+	switch field {
+	case "microseconds", "milliseconds", "second", "minute":
+		// the fields of a negative time are negative
+		if interval.Microseconds < 0 {
+			v := extractClock(field, -interval.Microseconds)
+			if f, ok := v.(*object.Float); ok {
+				return &object.Float{Value: -f.Value}
+			}
+			return &object.Integer{Value: -v.(*object.Integer).Value}
+		}
+		return extractClock(field, interval.Microseconds)
+	case "hour":
+		return &object.Integer{Value: interval.Microseconds / (60 * 60 * object.MicrosecondsPerSecond)}
+	case "day":
+		return &object.Integer{Value: interval.Days}
+	case "month":
+		return &object.Integer{Value: months}
+	case "quarter":
+		return &object.Integer{Value: months/3 + 1}
+	case "year":
+		return &object.Integer{Value: years}
+	case "decade":
+		return &object.Integer{Value: years / 10}
+	case "century":
+		return &object.Integer{Value: years / 100}
+	case "millennium":
+		return &object.Integer{Value: years / 1000}
+	case "epoch":
+		days := float64(years)*365.25 + float64(months*object.DaysPerMonth+interval.Days)
+		return &object.Float{Value: days*24*60*60 + float64(interval.Microseconds)/object.MicrosecondsPerSecond}
+	}
+	return nil
+}
+
+// evalToChar formats a date or timestamp with a format such as 'YYYY-MM-DD HH24:MI:SS', where the patterns are
+// replaced by the fields of the timestamp, and the other characters are kept. Text in double quotes is kept as it is.
+// Names are padded to 9 characters and numbers with zeros, unless the pattern is prefixed with FM.
+func evalToChar(name string, arguments []object.Object) object.Object {
+	format, ok := arguments[1].(*object.String)
+	if !ok {
+		return functionDoesNotExist(name, arguments)
+	}
+	var t time.Time
+	zone := ""
+	switch v := arguments[0].(type) {
+	case *object.Date:
+		t = v.GoTime()
+	case *object.Timestamp:
+		t = v.GoTime()
+	case *object.TimestampTZ:
+		t, zone = v.GoTime(), "UTC"
+	default:
+		return functionDoesNotExist(name, arguments)
+	}
+	var out strings.Builder
+	s := format.Value
+	for len(s) > 0 {
+		if s[0] == '"' {
+			end := strings.IndexByte(s[1:], '"')
+			if end == -1 {
+				out.WriteString(s[1:])
+				break
+			}
+			out.WriteString(s[1 : end+1])
+			s = s[end+2:]
+			continue
+		}
+		fillMode := strings.HasPrefix(s, "FM")
+		if fillMode {
+			s = s[2:]
+		}
+		matched := false
+		for _, p := range charPatterns {
+			if strings.HasPrefix(s, p.pattern) {
+				out.WriteString(p.format(t, zone, fillMode))
+				s = s[len(p.pattern):]
+				matched = true
+				break
+			}
+		}
+		if !matched && len(s) > 0 {
+			out.WriteByte(s[0])
+			s = s[1:]
+		}
+	}
+	return &object.String{Value: out.String()}
+}
+
+// charPattern is a pattern of to_char, and the text of the time for it
+type charPattern struct {
+	pattern string
+	format  func(t time.Time, zone string, fillMode bool) string
+}
+
+// charPatterns are the patterns of to_char, where longer patterns come before the patterns they start with
+var charPatterns = []charPattern{
+	{"HH24", numberPattern(2, func(t time.Time) int { return t.Hour() })},
+	{"HH12", numberPattern(2, hour12)},
+	{"HH", numberPattern(2, hour12)},
+	{"MI", numberPattern(2, func(t time.Time) int { return t.Minute() })},
+	{"SSSS", numberPattern(0, func(t time.Time) int { return t.Hour()*3600 + t.Minute()*60 + t.Second() })},
+	{"SS", numberPattern(2, func(t time.Time) int { return t.Second() })},
+	{"MS", numberPattern(3, func(t time.Time) int { return t.Nanosecond() / 1e6 })},
+	{"US", numberPattern(6, func(t time.Time) int { return t.Nanosecond() / 1e3 })},
+	{"AM", meridiem("AM", "PM")},
+	{"PM", meridiem("AM", "PM")},
+	{"am", meridiem("am", "pm")},
+	{"pm", meridiem("am", "pm")},
+	{"IYYY", numberPattern(4, func(t time.Time) int { year, _ := t.ISOWeek(); return year })},
+	{"YYYY", numberPattern(4, yearOf)},
+	{"YYY", numberPattern(3, func(t time.Time) int { return yearOf(t) % 1000 })},
+	{"YY", numberPattern(2, func(t time.Time) int { return yearOf(t) % 100 })},
+	{"MONTH", namePattern(9, func(t time.Time) string { return strings.ToUpper(t.Month().String()) })},
+	{"Month", namePattern(9, func(t time.Time) string { return t.Month().String() })},
+	{"month", namePattern(9, func(t time.Time) string { return strings.ToLower(t.Month().String()) })},
+	{"MON", namePattern(0, func(t time.Time) string { return strings.ToUpper(t.Month().String()[:3]) })},
+	{"Mon", namePattern(0, func(t time.Time) string { return t.Month().String()[:3] })},
+	{"mon", namePattern(0, func(t time.Time) string { return strings.ToLower(t.Month().String()[:3]) })},
+	{"MM", numberPattern(2, func(t time.Time) int { return int(t.Month()) })},
+	{"DAY", namePattern(9, func(t time.Time) string { return strings.ToUpper(t.Weekday().String()) })},
+	{"Day", namePattern(9, func(t time.Time) string { return t.Weekday().String() })},
+	{"day", namePattern(9, func(t time.Time) string { return strings.ToLower(t.Weekday().String()) })},
+	{"DY", namePattern(0, func(t time.Time) string { return strings.ToUpper(t.Weekday().String()[:3]) })},
+	{"Dy", namePattern(0, func(t time.Time) string { return t.Weekday().String()[:3] })},
+	{"dy", namePattern(0, func(t time.Time) string { return strings.ToLower(t.Weekday().String()[:3]) })},
+	{"DDD", numberPattern(3, func(t time.Time) int { return t.YearDay() })},
+	{"DD", numberPattern(2, func(t time.Time) int { return t.Day() })},
+	{"D", numberPattern(0, func(t time.Time) int { return int(t.Weekday()) + 1 })},
+	{"ID", numberPattern(0, func(t time.Time) int { return (int(t.Weekday())+6)%7 + 1 })},
+	{"IW", numberPattern(2, func(t time.Time) int { _, week := t.ISOWeek(); return week })},
+	{"Q", numberPattern(0, func(t time.Time) int { return (int(t.Month())-1)/3 + 1 })},
+	{"TZ", func(_ time.Time, zone string, _ bool) string { return zone }},
+	{"tz", func(_ time.Time, zone string, _ bool) string { return strings.ToLower(zone) }},
+}
+
+// numberPattern formats a number padded with zeros to the width
+func numberPattern(width int, n func(time.Time) int) func(time.Time, string, bool) string {
+	return func(t time.Time, _ string, fillMode bool) string {
+		if fillMode {
+			return fmt.Sprint(n(t))
+		}
+		return fmt.Sprintf("%0*d", width, n(t))
+	}
+}
+
+// namePattern formats a name padded with spaces to the width
+func namePattern(width int, name func(time.Time) string) func(time.Time, string, bool) string {
+	return func(t time.Time, _ string, fillMode bool) string {
+		if fillMode {
+			return name(t)
+		}
+		return fmt.Sprintf("%-*s", width, name(t))
+	}
+}
+
+func meridiem(am string, pm string) func(time.Time, string, bool) string {
+	return func(t time.Time, _ string, _ bool) string {
+		if t.Hour() < 12 {
+			return am
+		}
+		return pm
+	}
+}
+
+func hour12(t time.Time) int {
+	if hour := t.Hour() % 12; hour != 0 {
+		return hour
+	}
+	return 12
+}
+
+// yearOf is the year of the time, counted from 1 BC for years before 1 AD
+func yearOf(t time.Time) int {
+	if year := t.Year(); year > 0 {
+		return year
+	}
+	return 1 - t.Year()
+}
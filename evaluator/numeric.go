@@ -0,0 +1,119 @@
+package evaluator
+
+import "github.com/vegarsti/sql/object"
+
+// numericOf returns an integer, a float or a numeric as a numeric, and false for other values
+func numericOf(v object.Object) (*object.Numeric, bool, error) {
+	switch v := v.(type) {
+	case *object.Numeric:
+		return v, true, nil
+	case *object.Integer:
+		return object.NumericOf(v.Value), true, nil
+	case *object.Float:
+		n, err := object.NumericOfFloat(v.Value)
+		return n, err == nil, err
+	}
+	return nil, false, nil
+}
+
+// evalNumericInfixExpression evaluates an operator where one of the sides is a numeric, and the other is
+// a numeric, an integer or a float, which is converted to a numeric so that the result is exact
+func evalNumericInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+	leftVal, leftOK, err := numericOf(left)
+	if err != nil {
+		return errorObject(err)
+	}
+	rightVal, rightOK, err := numericOf(right)
+	if err != nil {
+		return errorObject(err)
+	}
+	if !leftOK || !rightOK {
+		return object.Errorf(object.CodeUndefinedFunction, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+	switch operator {
+	case "+":
+		return leftVal.Add(rightVal)
+	case "-":
+		return leftVal.Sub(rightVal)
+	case "*":
+		return leftVal.Mul(rightVal)
+	case "/":
+		if rightVal.Coefficient.Sign() == 0 {
+			return object.Errorf(object.CodeDivisionByZero, "division by zero")
+		}
+		return leftVal.Quo(rightVal)
+	case "%":
+		if rightVal.Coefficient.Sign() == 0 {
+			return object.Errorf(object.CodeDivisionByZero, "division by zero")
+		}
+		return leftVal.Rem(rightVal)
+	case "=":
+		return &object.Boolean{Value: leftVal.Cmp(rightVal) == 0}
+	case "!=":
+		return &object.Boolean{Value: leftVal.Cmp(rightVal) != 0}
+	case ">":
+		return &object.Boolean{Value: leftVal.Cmp(rightVal) > 0}
+	case ">=":
+		return &object.Boolean{Value: leftVal.Cmp(rightVal) >= 0}
+	case "<":
+		return &object.Boolean{Value: leftVal.Cmp(rightVal) < 0}
+	case "<=":
+		return &object.Boolean{Value: leftVal.Cmp(rightVal) <= 0}
+	default:
+		return object.Errorf(object.CodeUndefinedFunction, "unknown numeric operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+// convertNumeric converts a value to be stored in the NUMERIC column: an integer, a float or a string
+// is read as a numeric, which is rounded to the scale of the column, and an error is returned if it has more
+// digits before the decimal point than the precision of the column allows. Other values are returned as they are.
+func convertNumeric(v object.Object, column object.Column) (object.Object, error) {
+	var n *object.Numeric
+	if s, ok := v.(*object.String); ok {
+		parsed, err := object.ParseNumeric(s.Value)
+		if err != nil {
+			return nil, err
+		}
+		n = parsed
+	} else {
+		converted, ok, err := numericOf(v)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return v, nil
+		}
+		n = converted
+	}
+	if column.Precision == 0 {
+		return n, nil
+	}
+	n = n.Round(int32(column.Scale))
+	if n.IntegerDigits() > column.Precision-column.Scale {
+		return nil, object.Errorf(object.CodeNumericValueOutOfRange, "numeric field overflow: a field with precision %d, scale %d must round to an absolute value less than 10^%d", column.Precision, column.Scale, column.Precision-column.Scale)
+	}
+	return n, nil
+}
+
+// numericColumn returns the column of the NUMERIC type with the precision and scale in parentheses after it,
+// such as NUMERIC(10, 2), where NUMERIC(10) has scale 0 and NUMERIC has neither
+func numericColumn(name string, modifiers []int64) (object.Column, error) {
+	column := object.Column{Name: name, Type: object.NUMERIC}
+	if len(modifiers) == 0 {
+		return column, nil
+	}
+	precision := modifiers[0]
+	if precision < 1 || precision > object.MaxNumericPrecision {
+		return object.Column{}, object.Errorf(object.CodeInvalidParameterValue, "NUMERIC precision %d must be between 1 and %d", precision, object.MaxNumericPrecision)
+	}
+	var scale int64
+	if len(modifiers) > 1 {
+		scale = modifiers[1]
+	}
+	if scale < 0 || scale > precision {
+		return object.Column{}, object.Errorf(object.CodeInvalidParameterValue, "NUMERIC scale %d must be between 0 and precision %d", scale, precision)
+	}
+	column.Precision = int(precision)
+	column.Scale = int(scale)
+	return column, nil
+}
@@ -0,0 +1,73 @@
+package evaluator_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/vegarsti/sql/columnar"
+	"github.com/vegarsti/sql/evaluator"
+	"github.com/vegarsti/sql/inmemory"
+)
+
+func TestEvalNumeric(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// literals
+		{"select numeric '12.50', decimal '-0.005', numeric '1.5e3'", "NUMERIC '12.50'\tNUMERIC '-0.005'\tNUMERIC '1.5e3'\n12.50\t-0.005\t1500"},
+		{"select numeric '123456789012345678901234567890.123456789' + 1", "(NUMERIC '123456789012345678901234567890.123456789' + 1)\n123456789012345678901234567891.123456789"},
+		// arithmetic keeps the scale, and integers and floats are converted to numerics
+		{"select numeric '12.50' + 1, numeric '0.1' + 0.2, numeric '1.10' * numeric '2.5'", "(NUMERIC '12.50' + 1)\t(NUMERIC '0.1' + 0.2)\t(NUMERIC '1.10' * NUMERIC '2.5')\n13.50\t0.3\t2.750"},
+		{"select numeric '10.05' - numeric '0.05', -numeric '1.50', numeric '7.5' % 2", "(NUMERIC '10.05' - NUMERIC '0.05')\t(-NUMERIC '1.50')\t(NUMERIC '7.5' % 2)\n10.00\t-1.50\t1.5"},
+		// division is rounded half away from zero to at least 20 significant digits
+		{"select numeric '1' / 3, numeric '2' / 3, numeric '-2' / 3", "(NUMERIC '1' / 3)\t(NUMERIC '2' / 3)\t(NUMERIC '-2' / 3)\n0.33333333333333333333\t0.66666666666666666667\t-0.66666666666666666667"},
+		{"select numeric '10.00' / 4, numeric '100' / numeric '0.5'", "(NUMERIC '10.00' / 4)\t(NUMERIC '100' / NUMERIC '0.5')\n2.5000000000000000000\t200.00000000000000000"},
+		// comparisons are exact
+		{"select numeric '1.0' = 1, numeric '0.1' = 0.1, numeric '0.30' = (numeric '0.1' + numeric '0.2')", "(NUMERIC '1.0' = 1)\t(NUMERIC '0.1' = 0.1)\t(NUMERIC '0.30' = (NUMERIC '0.1' + NUMERIC '0.2'))\ntrue\ttrue\ttrue"},
+		{"select numeric '100000000000000000000.1' > numeric '100000000000000000000'", "(NUMERIC '100000000000000000000.1' > NUMERIC '100000000000000000000')\ntrue"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(inmemory.NewBackend(), tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestEvalNumericErrors(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"select numeric '1.2.3'", `invalid input syntax for type numeric: "1.2.3"`},
+		{"select numeric '1' / 0", "division by zero"},
+		{"select numeric '1' % numeric '0.0'", "division by zero"},
+		{"select numeric '1' + 'a'", "unknown operator: NUMERIC + STRING"},
+		{"select numeric '1' ^ 2", "unknown numeric operator: NUMERIC ^ INTEGER"},
+		{"create table t (a NUMERIC(1001))", "NUMERIC precision 1001 must be between 1 and 1000"},
+		{"create table t (a NUMERIC(2, 3))", "NUMERIC scale 3 must be between 0 and precision 2"},
+	}
+	for _, tt := range tests {
+		testError(t, testEval(inmemory.NewBackend(), tt.input), tt.expectedError)
+	}
+}
+
+// TestEvalNumericParallel checks that numerics are summed exactly by parallel workers
+func TestEvalNumericParallel(t *testing.T) {
+	s := evaluator.NewSession(columnar.NewBackend())
+	evalSession(t, s, "create table payments (cents NUMERIC(12, 2))")
+	values := make([]string, 20000)
+	for i := range values {
+		values[i] = fmt.Sprintf("('%d.%02d')", (i+1)/100, (i+1)%100)
+	}
+	evalSession(t, s, "insert into payments values "+strings.Join(values, ", "))
+	expected := "sum(cents)\tavg(cents)\n2000100.00\t100.005000000000000000"
+	for _, workers := range []string{"0", "3"} {
+		evalSession(t, s, "set max_parallel_workers_per_gather = "+workers)
+		if got := evalSession(t, s, "select sum(cents), avg(cents) from payments").Inspect(); got != expected {
+			t.Errorf("workers=%s: expected\n%s\ngot\n%s", workers, expected, got)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package evaluator_test
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/vegarsti/sql/columnar"
+	"github.com/vegarsti/sql/evaluator"
+	"github.com/vegarsti/sql/inmemory"
+	"github.com/vegarsti/sql/lexer"
+	"github.com/vegarsti/sql/object"
+	"github.com/vegarsti/sql/parser"
+)
+
+// TestParallel checks that queries give the same results and errors with parallel workers as without them
+func TestParallel(t *testing.T) {
+	tests := []struct {
+		query string
+		// ordered is whether the rows are returned in the same order, rather than in any order
+		ordered bool
+	}{
+		{"select a, b, c, d from foo", false},
+		{"select a * 2, b from foo where (a > 20000) or d", false},
+		{"select a from foo where b is null", false},
+		{"select a, b from foo order by b, d", true},
+		{"select a, c from foo where (a > 100) order by c desc", true},
+		{"select a, b from foo order by b desc limit 20 offset 5", true},
+		{"select a from foo where d order by a limit 3", true},
+		{"select count(*), count(b), sum(a), sum(c), avg(c), min(b), max(c) from foo", true},
+		{"select b, count(*), sum(a), max(c) from foo group by b order by b", true},
+		{"select count(*), sum(a) from foo where a > 100000", true},
+		{"select b, count(*) from foo where a > 100000 group by b", true},
+		// errors
+		{"select a / (a - 25000) from foo", true},
+		{"select a from foo where (a > 12000) and ((1 / (a - a)) = 1)", true},
+		{"select a + c from foo order by a", true},
+		{"select sum(b) from foo", true},
+	}
+	setup := func(t *testing.T, backend evaluator.Backend) { vectorTable(t, backend, 30000) }
+	forEachBackend(t, setup, func(t *testing.T, s *evaluator.Session) {
+		for _, vectorize := range []string{"off", "on"} {
+			evalSession(t, s, "set vectorize = '"+vectorize+"'")
+			for _, tt := range tests {
+				evalSession(t, s, "set max_parallel_workers_per_gather = 0")
+				expected := evalSession(t, s, tt.query).Inspect()
+				evalSession(t, s, "set max_parallel_workers_per_gather = 3")
+				got := evalSession(t, s, tt.query).Inspect()
+				if !tt.ordered {
+					expected, got = sortLines(expected), sortLines(got)
+				}
+				if got != expected {
+					t.Errorf("vectorize=%s: %s: expected\n%.500s\ngot\n%.500s", vectorize, tt.query, expected, got)
+				}
+			}
+		}
+	})
+}
+
+func sortLines(s string) string {
+	lines := strings.Split(s, "\n")
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+func TestParallelExplain(t *testing.T) {
+	tests := []struct {
+		rows     int
+		input    string
+		expected string
+	}{
+		{
+			20000,
+			"explain select a from foo where (a > 1)",
+			"Gather (2 workers)\n  Project a\n    Filter (a > 1)\n      Scan foo (a) partition 1 of 2\n  Project a\n    Filter (a > 1)\n      Scan foo (a) partition 2 of 2",
+		},
+		{
+			20000,
+			"explain select a from foo order by a limit 5",
+			"Project a\n  Limit 5\n    Gather Merge (2 workers)\n      TopN 5 by a\n        Scan foo (a) partition 1 of 2\n      TopN 5 by a\n        Scan foo (a) partition 2 of 2",
+		},
+		{
+			20000,
+			"explain select b, count(*) from foo group by b",
+			"Project b, count(*)\n  Parallel Aggregate count(*) group by b (2 workers)\n    Aggregate count(*) group by b\n      Scan foo (b) partition 1 of 2\n    Aggregate count(*) group by b\n      Scan foo (b) partition 2 of 2",
+		},
+		{
+			// a small table is scanned without workers
+			100,
+			"explain select a from foo where (a > 1)",
+			"Project a\n  Filter (a > 1)\n    Scan foo (a)",
+		},
+	}
+	for _, tt := range tests {
+		backend := columnar.NewBackend()
+		vectorTable(t, backend, tt.rows)
+		session := evaluator.NewSession(backend)
+		evalSession(t, session, "set max_parallel_workers_per_gather = 2")
+		result, ok := evalSession(t, session, tt.input).(*object.Result)
+		if !ok {
+			t.Fatalf("%s: expected a result, got %s", tt.input, evalSession(t, session, tt.input).Inspect())
+		}
+		var got string
+		for i, row := range result.Rows {
+			if i > 0 {
+				got += "\n"
+			}
+			got += row.Values[0].(*object.String).Value
+		}
+		if got != tt.expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestSetParallelWorkers(t *testing.T) {
+	session := evaluator.NewSession(inmemory.NewBackend())
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"set max_parallel_workers_per_gather = 4", "OK"},
+		{"set max_parallel_workers_per_gather to '0'", "OK"},
+		{"set max_parallel_workers_per_gather = 1024", "OK"},
+		{"set max_parallel_workers_per_gather = 1025", `ERROR: 1025 is outside the valid range for parameter "max_parallel_workers_per_gather" (0 .. 1024)`},
+		{"set max_parallel_workers_per_gather = '4MB'", `ERROR: invalid value for parameter "max_parallel_workers_per_gather": "4MB"`},
+		{"set max_parallel_workers_per_gather = 'many'", `ERROR: invalid value for parameter "max_parallel_workers_per_gather": "many"`},
+	}
+	for _, tt := range tests {
+		if got := evalSession(t, session, tt.input).Inspect(); got != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+// BenchmarkParallel compares executing queries with parallel workers to executing them without
+func BenchmarkParallel(b *testing.B) {
+	queries := []string{
+		"select count(*) from foo where (a > 1000) and (a < 90000)",
+		"select b, count(*), sum(a) from foo group by b",
+		"select a from foo order by c desc limit 10",
+	}
+	backend := columnar.NewBackend()
+	vectorTable(b, backend, 400000)
+	for _, workers := range []int{0, 2, 4} {
+		session := evaluator.NewSession(backend)
+		evalSession(b, session, fmt.Sprintf("set max_parallel_workers_per_gather = %d", workers))
+		for _, query := range queries {
+			program := parser.New(lexer.New(query)).ParseProgram()
+			b.Run(fmt.Sprintf("workers=%d/%s", workers, query), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if result, ok := session.Eval(program).(*object.Error); ok {
+						b.Fatal(result.Message)
+					}
+				}
+			})
+		}
+	}
+}
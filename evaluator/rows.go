@@ -0,0 +1,112 @@
+package evaluator
+
+import (
+	"context"
+
+	"github.com/vegarsti/sql/ast"
+	"github.com/vegarsti/sql/executor"
+	"github.com/vegarsti/sql/object"
+)
+
+// Rows are the rows of a query, which are produced one at a time by Next, so they can be used before
+// the query has finished. Rows must be closed, which ends the transaction a query outside of a transaction
+// is evaluated in. The errors returned are *object.Error, as for EvalContext.
+type Rows struct {
+	// Aliases and Types are the names and types of the columns, as in object.Result
+	Aliases []string
+	Types   []object.DataType
+	ctx     context.Context
+	op      executor.Operator
+	// end ends the statement, and rolls back its transaction if it failed
+	end    func(failed bool) error
+	failed bool
+	closed bool
+}
+
+// QueryContext evaluates a SELECT statement like EvalContext, but returns its rows as they are produced
+// instead of a result with all of them. Outside of a transaction, the query reads a snapshot of its own,
+// but unlike EvalContext, it is not evaluated again if its transaction fails to commit, since its rows
+// have been read by then.
+func (s *Session) QueryContext(ctx context.Context, statement ast.Statement) (*Rows, error) {
+	if _, ok := statement.(*ast.SelectStatement); !ok {
+		return nil, object.Errorf(object.CodeSyntaxError, "cannot query %s statement, only SELECT", statement.TokenLiteral())
+	}
+	stmt := (&binder{now: s.now()}).statement(statement).(*ast.SelectStatement)
+	return s.query(ctx, func(backend Backend) (executor.Operator, *object.Result, error) {
+		return planSelectResult(backend, stmt, s.settings)
+	})
+}
+
+// query opens the operators returned by plan, which plans a query against the backend given to it
+func (s *Session) query(ctx context.Context, plan func(backend Backend) (executor.Operator, *object.Result, error)) (*Rows, error) {
+	ctx, cancel := s.statementContext(ctx)
+	backend := s.currentBackend()
+	end := func(bool) error {
+		cancel()
+		return nil
+	}
+	if transactor, ok := s.backend.(Transactor); ok && s.transaction == nil {
+		t, err := transactor.Begin()
+		if err != nil {
+			cancel()
+			return nil, errorObject(err)
+		}
+		backend = t
+		end = func(failed bool) error {
+			defer cancel()
+			if failed {
+				return t.Rollback()
+			}
+			return t.Commit()
+		}
+	}
+	r := &Rows{ctx: ctx, end: end}
+	op, result, err := plan(backend)
+	if err != nil {
+		r.end(true)
+		return nil, errorObject(err)
+	}
+	r.Aliases, r.Types, r.op = result.Aliases, result.Types, op
+	if err := op.Open(ctx); err != nil {
+		err = r.error(err)
+		r.failed = true
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Next returns the next row, or nil once all rows have been returned.
+func (r *Rows) Next() (*object.Row, error) {
+	row, err := r.op.Next()
+	if err != nil {
+		r.failed = true
+		return nil, r.error(err)
+	}
+	return row, nil
+}
+
+// Close stops the query and ends its transaction, and returns an error if the transaction fails to commit.
+func (r *Rows) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	err := r.op.Close()
+	if err != nil {
+		err = r.error(err)
+		r.failed = true
+	}
+	if endErr := r.end(r.failed); err == nil && endErr != nil {
+		err = errorObject(endErr)
+	}
+	return err
+}
+
+// error returns the error of the query, which is that it was canceled if its context is done
+func (r *Rows) error(err error) error {
+	if r.ctx.Err() != nil {
+		return object.Errorf(object.CodeQueryCanceled, "%s", canceledMessage(r.ctx.Err()))
+	}
+	return errorObject(err)
+}
@@ -0,0 +1,790 @@
+package evaluator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vegarsti/sql/ast"
+	"github.com/vegarsti/sql/executor"
+	"github.com/vegarsti/sql/lexer"
+	"github.com/vegarsti/sql/object"
+	"github.com/vegarsti/sql/parser"
+	"github.com/vegarsti/sql/planner"
+	"github.com/vegarsti/sql/token"
+)
+
+// Session evaluates statements against a backend, and keeps the state that lives
+// from one statement to the next, such as prepared statements and the current transaction.
+// A session must not be used by several goroutines at the same time.
+type Session struct {
+	backend     Backend
+	prepared    map[string]*PreparedStatement
+	transaction Transaction // nil outside of a transaction
+	// transactionStart is when the current transaction began, which now() returns in the transaction
+	transactionStart time.Time
+	settings         settings
+}
+
+// settings are the configuration parameters of a session, which are changed with SET
+type settings struct {
+	// statementTimeout is how long a statement may run before it is cancelled, or 0 for no limit
+	statementTimeout time.Duration
+	// workMem is the memory in bytes a query may use to sort and join rows before it writes rows
+	// to temporary files, or 0 for no limit
+	workMem int64
+	// vectorize is whether scans, filters, projections and aggregates are executed a batch of rows at a time
+	vectorize bool
+	// parallelWorkers is the number of goroutines a scan of a large table is divided between, or 0 to not divide it
+	parallelWorkers int
+}
+
+// defaultSettings are the settings of a new session
+var defaultSettings = settings{workMem: 64 << 20}
+
+func NewSession(backend Backend) *Session {
+	return &Session{
+		backend:  backend,
+		prepared: make(map[string]*PreparedStatement),
+		settings: defaultSettings,
+	}
+}
+
+// Eval evaluates a program, a statement or an expression.
+// For a program, the result of the last statement is returned, unless a statement fails.
+func (s *Session) Eval(node ast.Node) object.Object {
+	return s.EvalContext(context.Background(), node)
+}
+
+// EvalContext is like Eval, but stops evaluating a statement with an error when the context is done,
+// or when the statement has run for longer than the statement timeout set by SET statement_timeout.
+func (s *Session) EvalContext(ctx context.Context, node ast.Node) object.Object {
+	switch node := node.(type) {
+	case *ast.Program:
+		return s.evalStatements(ctx, node.Statements)
+	case *ast.TransactionStatement:
+		return s.evalTransactionStatement(node)
+	case *ast.SetStatement:
+		return s.evalSetStatement(node)
+	case *ast.PrepareStatement:
+		return s.evalPrepareStatement(node)
+	case *ast.ExecuteStatement:
+		return s.evalExecuteStatement(ctx, node)
+	case *ast.DeallocateStatement:
+		return s.evalDeallocateStatement(node)
+	case ast.Statement:
+		now := s.now()
+		return s.run(ctx, func(ctx context.Context, backend Backend) object.Object {
+			// evaluating a statement changes it, so a copy is evaluated, where now() is the same for each attempt
+			return evalStatement(ctx, backend, (&binder{now: now}).statement(node), s.settings)
+		})
+	default:
+		if expression, ok := node.(ast.Expression); ok {
+			return evalExpression(object.Row{}, expression)
+		}
+		return newError("unknown node type %T", node)
+	}
+}
+
+// run evaluates a statement by calling eval with the backend to evaluate it against, which is the transaction
+// of the session, or outside of a transaction, a transaction of its own. It stops the statement with an error
+// when the context is done, or when the statement has run for longer than the statement timeout.
+func (s *Session) run(ctx context.Context, eval func(ctx context.Context, backend Backend) object.Object) object.Object {
+	ctx, cancel := s.statementContext(ctx)
+	defer cancel()
+	var result object.Object
+	if t, ok := s.backend.(Transactor); ok && s.transaction == nil {
+		result = evalInTransaction(ctx, t, eval)
+	} else {
+		result = eval(ctx, s.currentBackend())
+	}
+	if isError(result) && ctx.Err() != nil {
+		return object.Errorf(object.CodeQueryCanceled, "%s", canceledMessage(ctx.Err()))
+	}
+	return result
+}
+
+// statementContext returns the context of a statement, which is done after the statement timeout
+func (s *Session) statementContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.settings.statementTimeout > 0 {
+		return context.WithTimeout(ctx, s.settings.statementTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// now returns the value of now() in a statement, which is the time the transaction began,
+// or the time the statement began outside of a transaction, as in PostgreSQL
+func (s *Session) now() *object.TimestampTZ {
+	if s.transaction == nil {
+		return object.TimestampTZOf(time.Now())
+	}
+	return object.TimestampTZOf(s.transactionStart)
+}
+
+// canceledMessage returns the error message of a statement that was stopped because its context was done
+func canceledMessage(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "canceling statement due to statement timeout"
+	}
+	return "canceling statement due to user request"
+}
+
+// evalStatement evaluates a statement that reads or changes the tables
+func evalStatement(ctx context.Context, backend Backend, node ast.Statement, settings settings) object.Object {
+	switch node := node.(type) {
+	case *ast.SelectStatement:
+		return evalSelectStatement(ctx, backend, node, settings)
+	case *ast.CreateTableStatement:
+		return evalCreateTableStatement(backend, node)
+	case *ast.InsertStatement:
+		return evalInsertStatement(backend, node)
+	case *ast.DeleteStatement:
+		return evalDeleteStatement(ctx, backend, node)
+	case *ast.ExplainStatement:
+		return evalExplainStatement(ctx, backend, node, settings)
+	case *ast.AnalyzeStatement:
+		return evalAnalyzeStatement(ctx, backend, node)
+	default:
+		return newError("unknown node type %T", node)
+	}
+}
+
+// maxStatementAttempts is how many times a statement outside of a transaction is evaluated
+// before giving up, when it fails to commit because of concurrent changes
+const maxStatementAttempts = 10
+
+// evalInTransaction evaluates a statement outside of a transaction in a transaction of its own,
+// so that it reads a single snapshot of the tables, even if it reads several tables.
+// If it conflicts with a concurrent change, it is evaluated again against a newer snapshot.
+func evalInTransaction(ctx context.Context, backend Transactor, eval func(ctx context.Context, backend Backend) object.Object) object.Object {
+	for attempt := 1; ; attempt++ {
+		t, err := backend.Begin()
+		if err != nil {
+			return errorObject(err)
+		}
+		result := eval(ctx, t)
+		if isError(result) {
+			t.Rollback()
+			return result
+		}
+		err = t.Commit()
+		if err == nil {
+			return result
+		}
+		if !errors.Is(err, ErrSerializationFailure) || attempt == maxStatementAttempts {
+			return errorObject(err)
+		}
+	}
+}
+
+// currentBackend is the backend statements are evaluated against, which keeps the changes in memory in a transaction
+func (s *Session) currentBackend() Backend {
+	if s.transaction != nil {
+		return s.transaction
+	}
+	return s.backend
+}
+
+// Begin starts a transaction. Changes made in the transaction are not seen by other sessions until Commit,
+// and are thrown away by Rollback. If the backend is a Transactor, the transaction reads a snapshot of the
+// tables as they were when it began. Otherwise, the changes are kept in the session until Commit.
+func (s *Session) Begin() error {
+	if s.transaction != nil {
+		return object.Errorf(object.CodeActiveTransaction, "there is already a transaction in progress")
+	}
+	if t, ok := s.backend.(Transactor); ok {
+		transaction, err := t.Begin()
+		if err != nil {
+			return err
+		}
+		s.transaction = transaction
+		s.transactionStart = time.Now()
+		return nil
+	}
+	s.transaction = newTransaction(s.backend)
+	s.transactionStart = time.Now()
+	return nil
+}
+
+// Commit applies the changes made in the transaction to the backend, and ends the transaction.
+// If the transaction deleted a row that another session has deleted since it began, nothing is applied,
+// and ErrSerializationFailure is returned.
+func (s *Session) Commit() error {
+	if s.transaction == nil {
+		return object.Errorf(object.CodeNoActiveTransaction, "there is no transaction in progress")
+	}
+	t := s.transaction
+	s.transaction = nil
+	return t.Commit()
+}
+
+// Rollback throws away the changes made in the transaction, and ends the transaction.
+func (s *Session) Rollback() error {
+	if s.transaction == nil {
+		return object.Errorf(object.CodeNoActiveTransaction, "there is no transaction in progress")
+	}
+	t := s.transaction
+	s.transaction = nil
+	return t.Rollback()
+}
+
+// InTransaction reports whether a transaction is in progress.
+func (s *Session) InTransaction() bool {
+	return s.transaction != nil
+}
+
+func (s *Session) evalTransactionStatement(ts *ast.TransactionStatement) object.Object {
+	var err error
+	switch ts.Token.Type {
+	case token.BEGIN:
+		err = s.Begin()
+	case token.COMMIT:
+		err = s.Commit()
+	case token.ROLLBACK:
+		err = s.Rollback()
+	default:
+		err = fmt.Errorf("unknown transaction statement %s", ts.Token.Literal)
+	}
+	if err != nil {
+		return errorObject(err)
+	}
+	return &object.OK{}
+}
+
+// evalSetStatement sets a configuration parameter. The value is a whole number, or a string with
+// a whole number and a unit, as in PostgreSQL:
+//
+//   - statement_timeout is how long a statement may run, such as '1s', with milliseconds as the default unit.
+//   - work_mem is the memory a query may use to sort and join rows, such as '64MB', with kB as the default unit.
+//
+// A value of 0 turns the limit off. vectorize is a boolean, true or 'on' to execute queries a batch of rows at a time.
+// max_parallel_workers_per_gather is the number of workers a query scans a large table with, at most maxParallelWorkers,
+// where 0 scans it without workers.
+func (s *Session) evalSetStatement(ss *ast.SetStatement) object.Object {
+	name := strings.ToLower(ss.Name)
+	var units map[string]int64
+	switch name {
+	case "statement_timeout":
+		units = timeoutUnits
+	case "work_mem":
+		units = memoryUnits
+	case "max_parallel_workers_per_gather":
+		units = countUnits
+	case "vectorize":
+	default:
+		return object.Errorf(object.CodeUndefinedObject, `unrecognized configuration parameter "%s"`, ss.Name)
+	}
+	value := evalExpression(object.Row{}, ss.Value)
+	if isError(value) {
+		return value
+	}
+	text := value.Inspect()
+	if s, ok := value.(*object.String); ok {
+		text = s.Value
+	}
+	if name == "vectorize" {
+		switch strings.ToLower(text) {
+		case "true", "on":
+			s.settings.vectorize = true
+		case "false", "off":
+			s.settings.vectorize = false
+		default:
+			return object.Errorf(object.CodeInvalidParameterValue, `parameter "%s" requires a Boolean value`, name)
+		}
+		return &object.OK{}
+	}
+	n, err := parseQuantity(text, units)
+	if err != nil {
+		return object.Errorf(object.CodeInvalidParameterValue, `invalid value for parameter "%s": "%s"`, name, text)
+	}
+	switch name {
+	case "statement_timeout":
+		s.settings.statementTimeout = time.Duration(n)
+	case "work_mem":
+		s.settings.workMem = n
+	case "max_parallel_workers_per_gather":
+		if n > maxParallelWorkers {
+			return object.Errorf(object.CodeInvalidParameterValue, `%d is outside the valid range for parameter "%s" (0 .. %d)`, n, name, maxParallelWorkers)
+		}
+		s.settings.parallelWorkers = int(n)
+	}
+	return &object.OK{}
+}
+
+// maxParallelWorkers is the most workers a query can scan a table with
+const maxParallelWorkers = 1024
+
+// timeoutUnits and memoryUnits are the units of the parameters, in nanoseconds and bytes, and countUnits
+// is for parameters that are a number of something. The empty unit is the unit of a number without a unit.
+var (
+	countUnits   = map[string]int64{"": 1}
+	timeoutUnits = map[string]int64{
+		"":    int64(time.Millisecond),
+		"us":  int64(time.Microsecond),
+		"ms":  int64(time.Millisecond),
+		"s":   int64(time.Second),
+		"min": int64(time.Minute),
+		"h":   int64(time.Hour),
+		"d":   int64(24 * time.Hour),
+	}
+	memoryUnits = map[string]int64{
+		"":   1 << 10,
+		"B":  1,
+		"kB": 1 << 10,
+		"MB": 1 << 20,
+		"GB": 1 << 30,
+		"TB": 1 << 40,
+	}
+)
+
+// parseQuantity parses a whole number followed by an optional unit, and returns the number in the smallest unit
+func parseQuantity(s string, units map[string]int64) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if i == -1 {
+		i = len(s)
+	}
+	n, err := strconv.ParseInt(s[:i], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	unit, ok := units[strings.TrimSpace(s[i:])]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", s[i:])
+	}
+	if n > math.MaxInt64/unit {
+		return 0, fmt.Errorf("%s is out of range", s)
+	}
+	return n * unit, nil
+}
+
+func (s *Session) evalStatements(ctx context.Context, stmts []ast.Statement) object.Object {
+	var result object.Object
+	for _, statement := range stmts {
+		result = s.EvalContext(ctx, statement)
+		if isError(result) {
+			return result
+		}
+	}
+	return result
+}
+
+// PreparedStatement is a parsed statement with parameters, which can be executed many times with different arguments.
+type PreparedStatement struct {
+	session    *Session
+	name       string // empty if prepared through the Go API
+	statement  ast.Statement
+	parameters int
+	// plan is reused by the executions of the statement, until the columns of its tables change
+	plan *preparedPlan
+}
+
+// preparedPlan is what the executions of a prepared statement reuse: the types of its parameters, which
+// the arguments are converted to, and for a SELECT statement, a normalized copy of it and its logical plan.
+// The operators executing the plan are made for each execution, with the arguments bound in their expressions.
+type preparedPlan struct {
+	parameters []object.DataType
+	// columns is the columns of the tables of the statement when it was planned, by the name the statement gives them
+	columns map[string][]object.Column
+	// selectStatement and logical are nil if the statement isn't a SELECT statement, or can't be planned
+	selectStatement *ast.SelectStatement
+	logical         planner.Node
+}
+
+// Prepare parses a single statement, so it can be executed many times without being parsed again.
+// Parameters are written as $1, $2, ... or as ?, and are given values when the statement is executed.
+func (s *Session) Prepare(input string) (*PreparedStatement, error) {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, object.Errorf(object.CodeSyntaxError, "%s", strings.Join(p.Errors(), "; "))
+	}
+	if len(program.Statements) != 1 {
+		return nil, object.Errorf(object.CodeSyntaxError, "cannot prepare %d statements, only one", len(program.Statements))
+	}
+	return s.prepare("", program.Statements[0])
+}
+
+func (s *Session) prepare(name string, statement ast.Statement) (*PreparedStatement, error) {
+	switch statement.(type) {
+	case *ast.PrepareStatement, *ast.ExecuteStatement, *ast.DeallocateStatement:
+		return nil, object.Errorf(object.CodeSyntaxError, "cannot prepare %s statement", statement.TokenLiteral())
+	}
+	b := &binder{}
+	b.statement(statement)
+	return &PreparedStatement{session: s, name: name, statement: statement, parameters: b.parameters}, nil
+}
+
+// NumParameters returns the number of parameters, which is the highest parameter number in the statement.
+func (ps *PreparedStatement) NumParameters() int {
+	return ps.parameters
+}
+
+// Statement returns the statement that was prepared. It must not be changed.
+func (ps *PreparedStatement) Statement() ast.Statement {
+	return ps.statement
+}
+
+// Execute evaluates the statement with the arguments as the values of the parameters, so that
+// the first argument is the value of $1. The arguments can be nil, booleans, integers, floats,
+// strings, times, which are timestamps with a time zone, or object.Object values.
+func (ps *PreparedStatement) Execute(arguments ...interface{}) object.Object {
+	return ps.ExecuteContext(context.Background(), arguments...)
+}
+
+// ExecuteContext is like Execute, but stops the statement with an error when the context is done.
+func (ps *PreparedStatement) ExecuteContext(ctx context.Context, arguments ...interface{}) object.Object {
+	values, errorObj := argumentValues(arguments)
+	if errorObj != nil {
+		return errorObj
+	}
+	return ps.execute(ctx, values)
+}
+
+// QueryContext is like ExecuteContext for a SELECT statement, but returns its rows as they are produced, like Session.QueryContext.
+func (ps *PreparedStatement) QueryContext(ctx context.Context, arguments ...interface{}) (*Rows, error) {
+	values, errorObj := argumentValues(arguments)
+	if errorObj != nil {
+		return nil, errorObj
+	}
+	p, b, errorObj := ps.bind(values)
+	if errorObj != nil {
+		return nil, errorObj
+	}
+	if p.logical == nil {
+		return ps.session.QueryContext(ctx, b.statement(ps.statement))
+	}
+	b.now = ps.session.now()
+	return ps.session.query(ctx, func(backend Backend) (executor.Operator, *object.Result, error) {
+		op, result := p.planSelect(backend, b, ps.session.settings)
+		return op, result, nil
+	})
+}
+
+// argumentValues converts the arguments given through the Go API to objects
+func argumentValues(arguments []interface{}) ([]object.Object, *object.Error) {
+	values := make([]object.Object, len(arguments))
+	for i, a := range arguments {
+		v, err := objectFromValue(a)
+		if err != nil {
+			return nil, object.Errorf(object.CodeInvalidParameterValue, "argument $%d: %s", i+1, err)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// Description describes the result of a prepared statement, and the types of its parameters.
+type Description struct {
+	// Columns and Types are the names and types of the columns returned, and are nil for statements
+	// that don't return rows. A type is empty if it can't be known before the statement is executed.
+	Columns []string
+	Types   []object.DataType
+	// Parameters is the type of each parameter, or empty if it can't be known,
+	// for example for $1 in select $1.
+	Parameters []object.DataType
+}
+
+// Describe returns the columns and parameter types of the statement, without executing it.
+// The types of parameters are inferred from the columns and values they are compared to or inserted into.
+func (ps *PreparedStatement) Describe() (*Description, error) {
+	backend := ps.session.currentBackend()
+	d := &Description{Parameters: make([]object.DataType, ps.parameters)}
+	// describe a copy, since normalizing a statement changes its identifiers
+	statement := (&binder{}).statement(ps.statement)
+	if es, ok := statement.(*ast.ExplainStatement); ok {
+		d.Columns = []string{"QUERY PLAN"}
+		d.Types = []object.DataType{object.STRING}
+		statement = es.Statement
+	}
+	switch stmt := statement.(type) {
+	case *ast.SelectStatement:
+		tables, err := normalizeIdentifiers(backend, stmt)
+		if err != nil {
+			return nil, err
+		}
+		expressions := append(append([]ast.Expression{stmt.Where}, stmt.Expressions...), stmt.GroupBy...)
+		for _, o := range stmt.OrderBy {
+			expressions = append(expressions, o.Expression)
+		}
+		for _, from := range stmt.From {
+			for join := from.Join; join != nil; join = join.With.Join {
+				expressions = append(expressions, join.Predicate)
+			}
+		}
+		for _, e := range expressions {
+			inferParameterTypes(tables, d.Parameters, e)
+		}
+		if d.Columns != nil {
+			break
+		}
+		d.Columns = stmt.Aliases
+		d.Types = make([]object.DataType, len(stmt.Expressions))
+		for i, e := range stmt.Expressions {
+			d.Types[i] = expressionType(tables, e)
+		}
+	case *ast.InsertStatement:
+		columns, err := backend.Columns(stmt.TableName)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range stmt.Rows {
+			for i, e := range row {
+				if i < len(columns) {
+					setParameterType(d.Parameters, e, columns[i].Type)
+				}
+				inferParameterTypes(nil, d.Parameters, e)
+			}
+		}
+	case *ast.DeleteStatement:
+		selectStatement := deleteAsSelect(stmt)
+		tables, err := normalizeIdentifiers(backend, selectStatement)
+		if err != nil {
+			return nil, err
+		}
+		inferParameterTypes(tables, d.Parameters, selectStatement.Where)
+	case *ast.AnalyzeStatement:
+		d.Columns = analyzeAliases
+		d.Types = analyzeTypes
+	}
+	return d, nil
+}
+
+func (ps *PreparedStatement) execute(ctx context.Context, arguments []object.Object) object.Object {
+	p, b, errorObj := ps.bind(arguments)
+	if errorObj != nil {
+		return errorObj
+	}
+	if p.logical == nil {
+		return ps.session.EvalContext(ctx, b.statement(ps.statement))
+	}
+	s := ps.session
+	b.now = s.now()
+	return s.run(ctx, func(ctx context.Context, backend Backend) object.Object {
+		op, result := p.planSelect(backend, b, s.settings)
+		rows, err := executor.Run(ctx, op)
+		if err != nil {
+			return errorObject(err)
+		}
+		result.Rows = rows
+		return result
+	})
+}
+
+// bind returns the plan of the statement, and the binder of the arguments, converted to the types of the parameters
+func (ps *PreparedStatement) bind(arguments []object.Object) (*preparedPlan, *binder, *object.Error) {
+	if len(arguments) != ps.parameters {
+		if ps.name == "" {
+			return nil, nil, object.Errorf(object.CodeSyntaxError, "expected %d arguments, got %d", ps.parameters, len(arguments))
+		}
+		return nil, nil, object.Errorf(object.CodeSyntaxError, `wrong number of parameters for prepared statement "%s": expected %d, got %d`, ps.name, ps.parameters, len(arguments))
+	}
+	p := ps.planned()
+	converted := make([]object.Object, len(arguments))
+	for i, a := range arguments {
+		v, err := convertArgument(a, p.parameters[i])
+		if err != nil {
+			return nil, nil, errorObject(err)
+		}
+		converted[i] = v
+	}
+	return p, &binder{arguments: converted}, nil
+}
+
+// planned returns the plan of the statement, which is made again if the columns of its tables have changed
+// since it was made, such as when a table it reads has been created since, or was created in a transaction
+// that was rolled back
+func (ps *PreparedStatement) planned() *preparedPlan {
+	backend := ps.session.currentBackend()
+	columns, err := statementColumns(backend, ps.statement)
+	if ps.plan != nil && err == nil && sameColumns(ps.plan.columns, columns) {
+		return ps.plan
+	}
+	p := &preparedPlan{parameters: make([]object.DataType, ps.parameters), columns: columns}
+	if d, err := ps.Describe(); err == nil {
+		p.parameters = d.Parameters
+	}
+	if stmt, ok := ps.statement.(*ast.SelectStatement); ok {
+		// plan a copy, since normalizing a statement changes its identifiers
+		normalized := (&binder{}).statement(stmt).(*ast.SelectStatement)
+		if logical, err := planLogical(backend, normalized); err == nil {
+			p.selectStatement, p.logical = normalized, logical
+		}
+	}
+	// a statement of a table that doesn't exist is planned again, and fails, when it is executed
+	if err == nil {
+		ps.plan = p
+	}
+	return p
+}
+
+// planSelect returns the operators executing the plan of a SELECT statement with the parameters and now() bound by b,
+// and the result with the names and types of its columns, but without rows
+func (p *preparedPlan) planSelect(backend Backend, b *binder, settings settings) (executor.Operator, *object.Result) {
+	op := planPhysical(backend, p.logical, false, settings, b)
+	types := make([]object.DataType, len(p.selectStatement.Expressions))
+	for i, e := range p.selectStatement.Expressions {
+		types[i] = expressionType(p.columns, b.expression(e))
+	}
+	return op, &object.Result{Aliases: p.selectStatement.Aliases, Types: types}
+}
+
+// statementColumns returns the columns of the tables the statement reads or changes, by the name the statement gives them
+func statementColumns(backend Backend, statement ast.Statement) (map[string][]object.Column, error) {
+	var table string
+	switch stmt := statement.(type) {
+	case *ast.SelectStatement:
+		return fromColumns(backend, stmt)
+	case *ast.ExplainStatement:
+		return statementColumns(backend, stmt.Statement)
+	case *ast.InsertStatement:
+		table = stmt.TableName
+	case *ast.DeleteStatement:
+		table = stmt.TableName
+	default:
+		return nil, nil
+	}
+	columns, err := backend.Columns(table)
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]object.Column{table: columns}, nil
+}
+
+// sameColumns reports whether the tables have the same columns
+func sameColumns(a map[string][]object.Column, b map[string][]object.Column) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, columns := range a {
+		other, ok := b[name]
+		if !ok || len(columns) != len(other) {
+			return false
+		}
+		for i := range columns {
+			if columns[i] != other[i] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// convertArgument converts an argument given as a string to the type of its parameter, as PostgreSQL
+// reads arguments given as text, so that '3' is the integer 3 for a parameter compared with an integer
+// column. Other values, and arguments of parameters whose type isn't known, are returned as they are.
+func convertArgument(v object.Object, t object.DataType) (object.Object, error) {
+	s, ok := v.(*object.String)
+	if !ok {
+		return v, nil
+	}
+	switch t {
+	case object.INTEGER:
+		i, err := strconv.ParseInt(strings.TrimSpace(s.Value), 10, 64)
+		if err != nil {
+			return nil, object.Errorf(object.CodeInvalidTextRepresentation, `invalid input syntax for type integer: "%s"`, s.Value)
+		}
+		return &object.Integer{Value: i}, nil
+	case object.FLOAT:
+		f, err := strconv.ParseFloat(strings.TrimSpace(s.Value), 64)
+		if err != nil {
+			return nil, object.Errorf(object.CodeInvalidTextRepresentation, `invalid input syntax for type double precision: "%s"`, s.Value)
+		}
+		return &object.Float{Value: f}, nil
+	case object.BOOLEAN:
+		switch strings.ToLower(strings.TrimSpace(s.Value)) {
+		case "t", "true", "y", "yes", "on", "1":
+			return &object.Boolean{Value: true}, nil
+		case "f", "false", "n", "no", "off", "0":
+			return &object.Boolean{Value: false}, nil
+		}
+		return nil, object.Errorf(object.CodeInvalidTextRepresentation, `invalid input syntax for type boolean: "%s"`, s.Value)
+	case object.NUMERIC:
+		return object.ParseNumeric(s.Value)
+	case object.DATE, object.TIME, object.TIMESTAMP, object.TIMESTAMPTZ, object.INTERVAL:
+		return parseTemporal(t, s.Value)
+	}
+	return v, nil
+}
+
+func (s *Session) evalPrepareStatement(ps *ast.PrepareStatement) object.Object {
+	if _, ok := s.prepared[ps.Name]; ok {
+		return object.Errorf(object.CodeDuplicatePreparedStatement, `prepared statement "%s" already exists`, ps.Name)
+	}
+	prepared, err := s.prepare(ps.Name, ps.Statement)
+	if err != nil {
+		return errorObject(err)
+	}
+	s.prepared[ps.Name] = prepared
+	return &object.OK{}
+}
+
+func (s *Session) evalExecuteStatement(ctx context.Context, es *ast.ExecuteStatement) object.Object {
+	prepared, ok := s.prepared[es.Name]
+	if !ok {
+		return object.Errorf(object.CodeInvalidStatementName, `prepared statement "%s" does not exist`, es.Name)
+	}
+	arguments := make([]object.Object, len(es.Arguments))
+	for i, e := range es.Arguments {
+		v := evalExpression(object.Row{}, e)
+		if isError(v) {
+			return v
+		}
+		arguments[i] = v
+	}
+	return prepared.execute(ctx, arguments)
+}
+
+func (s *Session) evalDeallocateStatement(ds *ast.DeallocateStatement) object.Object {
+	if ds.All {
+		s.prepared = make(map[string]*PreparedStatement)
+		return &object.OK{}
+	}
+	if _, ok := s.prepared[ds.Name]; !ok {
+		return object.Errorf(object.CodeInvalidStatementName, `prepared statement "%s" does not exist`, ds.Name)
+	}
+	delete(s.prepared, ds.Name)
+	return &object.OK{}
+}
+
+// objectFromValue converts a Go value to the object with the same value
+func objectFromValue(v interface{}) (object.Object, error) {
+	switch v := v.(type) {
+	case nil:
+		return object.NULL, nil
+	case *object.Integer, *object.Float, *object.String, *object.Boolean, *object.Null,
+		*object.Date, *object.Time, *object.Timestamp, *object.TimestampTZ, *object.Interval, *object.Numeric:
+		return v.(object.Object), nil
+	case time.Time:
+		return object.TimestampTZOf(v), nil
+	case bool:
+		return &object.Boolean{Value: v}, nil
+	case int:
+		return &object.Integer{Value: int64(v)}, nil
+	case int8:
+		return &object.Integer{Value: int64(v)}, nil
+	case int16:
+		return &object.Integer{Value: int64(v)}, nil
+	case int32:
+		return &object.Integer{Value: int64(v)}, nil
+	case int64:
+		return &object.Integer{Value: v}, nil
+	case uint8:
+		return &object.Integer{Value: int64(v)}, nil
+	case uint16:
+		return &object.Integer{Value: int64(v)}, nil
+	case uint32:
+		return &object.Integer{Value: int64(v)}, nil
+	case float32:
+		return &object.Float{Value: float64(v)}, nil
+	case float64:
+		return &object.Float{Value: v}, nil
+	case string:
+		return &object.String{Value: v}, nil
+	}
+	return nil, fmt.Errorf("unsupported type %T", v)
+}
@@ -0,0 +1,302 @@
+package evaluator
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/vegarsti/sql/ast"
+	"github.com/vegarsti/sql/object"
+)
+
+// evalTypedLiteral reads the string of a literal such as DATE '2024-01-01' or NUMERIC '12.50' as a value of its type
+func evalTypedLiteral(node *ast.TypedLiteral) object.Object {
+	if object.DataType(node.Token.Type) == object.NUMERIC {
+		n, err := object.ParseNumeric(node.Value)
+		if err != nil {
+			return errorObject(err)
+		}
+		return n
+	}
+	v, err := parseTemporal(object.DataType(node.Token.Type), node.Value)
+	if err != nil {
+		return errorObject(err)
+	}
+	return v
+}
+
+// parseTemporal reads the string as a value of the temporal data type
+func parseTemporal(t object.DataType, s string) (object.Object, error) {
+	switch t {
+	case object.DATE:
+		return object.ParseDate(s)
+	case object.TIME:
+		return object.ParseTime(s)
+	case object.TIMESTAMP:
+		return object.ParseTimestamp(s)
+	case object.TIMESTAMPTZ:
+		return object.ParseTimestampTZ(s)
+	case object.INTERVAL:
+		return object.ParseInterval(s)
+	}
+	return nil, fmt.Errorf("%s is not a date, time, timestamp or interval type", t)
+}
+
+// convertTemporal converts a value to be stored in a column of the temporal data type: a string is read
+// as a value of the type, and dates and timestamps are converted to each other, where a timestamp without
+// a time zone is in UTC. Other values are returned as they are.
+func convertTemporal(v object.Object, t object.DataType) (object.Object, error) {
+	if s, ok := v.(*object.String); ok {
+		return parseTemporal(t, s.Value)
+	}
+	microseconds, ok := object.PointInTime(v)
+	if !ok || object.DataType(v.Type()) == t {
+		return v, nil
+	}
+	switch t {
+	case object.DATE:
+		return &object.Date{Value: floorDiv(microseconds, object.MicrosecondsPerDay)}, nil
+	case object.TIMESTAMP:
+		return &object.Timestamp{Value: microseconds}, nil
+	case object.TIMESTAMPTZ:
+		return &object.TimestampTZ{Value: microseconds}, nil
+	}
+	return v, nil
+}
+
+// evalTemporalInfixExpression evaluates an operator where one of the sides is a date, time, timestamp or interval
+func evalTemporalInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+	if isComparison(operator) {
+		// a string compared with a temporal value is read as a value of its type, as in d > '2024-01-31'
+		var err error
+		if s, ok := left.(*object.String); ok {
+			left, err = parseTemporal(object.DataType(right.Type()), s.Value)
+		} else if s, ok := right.(*object.String); ok {
+			right, err = parseTemporal(object.DataType(left.Type()), s.Value)
+		}
+		if err != nil {
+			return errorObject(err)
+		}
+		if c, ok := compareTemporal(left, right); ok {
+			return &object.Boolean{Value: compares(operator, c)}
+		}
+	}
+	var result object.Object
+	switch operator {
+	case "+":
+		result = addTemporal(left, right)
+		if result == nil {
+			result = addTemporal(right, left)
+		}
+	case "-":
+		result = subtractTemporal(left, right)
+	case "*":
+		result = multiplyInterval(left, right)
+		if result == nil {
+			result = multiplyInterval(right, left)
+		}
+	case "/":
+		if interval, ok := left.(*object.Interval); ok {
+			if f, ok := number(right); ok {
+				if f == 0 {
+					return object.Errorf(object.CodeDivisionByZero, "division by zero")
+				}
+				result = scaleInterval(interval, 1/f)
+			}
+		}
+	}
+	if result == nil {
+		return object.Errorf(object.CodeUndefinedFunction, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+	return result
+}
+
+// compareTemporal returns -1, 0 or 1 if a is before, at or after b. Dates and timestamps can be compared with
+// each other, as points in time, and other values only with values of the same type.
+func compareTemporal(a object.Object, b object.Object) (int, bool) {
+	if a, ok := object.PointInTime(a); ok {
+		if b, ok := object.PointInTime(b); ok {
+			return compareInt64(a, b), true
+		}
+		return 0, false
+	}
+	switch a := a.(type) {
+	case *object.Time:
+		if b, ok := b.(*object.Time); ok {
+			return compareInt64(a.Value, b.Value), true
+		}
+	case *object.Interval:
+		if b, ok := b.(*object.Interval); ok {
+			return compareInt64(a.Span(), b.Span()), true
+		}
+	}
+	return 0, false
+}
+
+func compareInt64(a int64, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compares reports whether the comparison operator is true for values that compare as c
+func compares(operator string, c int) bool {
+	switch operator {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	}
+	return c <= 0
+}
+
+// addTemporal adds b to a, or returns nil if b can't be added to a. Adding a number of days to a date is a date,
+// adding an interval to a date is a timestamp, and adding an interval to another value is a value of its type.
+func addTemporal(a object.Object, b object.Object) object.Object {
+	switch a := a.(type) {
+	case *object.Date:
+		switch b := b.(type) {
+		case *object.Integer:
+			return &object.Date{Value: a.Value + b.Value}
+		case *object.Interval:
+			return &object.Timestamp{Value: addInterval(a.Value*object.MicrosecondsPerDay, b)}
+		case *object.Time:
+			return &object.Timestamp{Value: a.Value*object.MicrosecondsPerDay + b.Value}
+		}
+	case *object.Timestamp:
+		if b, ok := b.(*object.Interval); ok {
+			return &object.Timestamp{Value: addInterval(a.Value, b)}
+		}
+	case *object.TimestampTZ:
+		if b, ok := b.(*object.Interval); ok {
+			return &object.TimestampTZ{Value: addInterval(a.Value, b)}
+		}
+	case *object.Time:
+		// the time of day wraps around midnight, and the months and days of the interval are left out
+		if b, ok := b.(*object.Interval); ok {
+			return &object.Time{Value: floorMod(a.Value+b.Microseconds, object.MicrosecondsPerDay)}
+		}
+	case *object.Interval:
+		if b, ok := b.(*object.Interval); ok {
+			return &object.Interval{Months: a.Months + b.Months, Days: a.Days + b.Days, Microseconds: a.Microseconds + b.Microseconds}
+		}
+	}
+	return nil
+}
+
+// subtractTemporal subtracts b from a, or returns nil if b can't be subtracted from a. The difference between two dates
+// is a number of days, and the difference between two timestamps or times is an interval of days and time.
+func subtractTemporal(a object.Object, b object.Object) object.Object {
+	if interval, ok := b.(*object.Interval); ok {
+		return addTemporal(a, negateInterval(interval))
+	}
+	if integer, ok := b.(*object.Integer); ok {
+		if date, ok := a.(*object.Date); ok {
+			return &object.Date{Value: date.Value - integer.Value}
+		}
+		return nil
+	}
+	if a, ok := a.(*object.Date); ok {
+		if b, ok := b.(*object.Date); ok {
+			return &object.Integer{Value: a.Value - b.Value}
+		}
+	}
+	if a, ok := a.(*object.Time); ok {
+		if b, ok := b.(*object.Time); ok {
+			return &object.Interval{Microseconds: a.Value - b.Value}
+		}
+		return nil
+	}
+	if a, ok := object.PointInTime(a); ok {
+		if b, ok := object.PointInTime(b); ok {
+			difference := a - b
+			return &object.Interval{Days: difference / object.MicrosecondsPerDay, Microseconds: difference % object.MicrosecondsPerDay}
+		}
+	}
+	return nil
+}
+
+// addInterval adds the interval to a number of microseconds since 1970-01-01 00:00:00. Months are added first,
+// keeping the day of the month unless the month is shorter, so that 2024-01-31 plus 1 month is 2024-02-29.
+func addInterval(microseconds int64, interval *object.Interval) int64 {
+	if interval.Months != 0 {
+		t := object.TimeOf(microseconds)
+		year, month, day := t.Date()
+		months := int64(month) - 1 + interval.Months
+		year += int(floorDiv(months, 12))
+		month = time.Month(floorMod(months, 12) + 1)
+		if days := daysInMonth(year, month); day > days {
+			day = days
+		}
+		t = time.Date(year, month, day, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+		microseconds = object.MicrosecondsOf(t)
+	}
+	return microseconds + interval.Days*object.MicrosecondsPerDay + interval.Microseconds
+}
+
+func daysInMonth(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func negateInterval(interval *object.Interval) *object.Interval {
+	return &object.Interval{Months: -interval.Months, Days: -interval.Days, Microseconds: -interval.Microseconds}
+}
+
+// multiplyInterval multiplies an interval by a number, or returns nil if a isn't an interval or b isn't a number
+func multiplyInterval(a object.Object, b object.Object) object.Object {
+	interval, ok := a.(*object.Interval)
+	if !ok {
+		return nil
+	}
+	if n, ok := b.(*object.Integer); ok {
+		return &object.Interval{Months: interval.Months * n.Value, Days: interval.Days * n.Value, Microseconds: interval.Microseconds * n.Value}
+	}
+	if f, ok := number(b); ok {
+		return scaleInterval(interval, f)
+	}
+	return nil
+}
+
+// scaleInterval multiplies the interval by f, where fractions of months are added as days of DaysPerMonth days,
+// and fractions of days as microseconds
+func scaleInterval(interval *object.Interval, f float64) *object.Interval {
+	months := float64(interval.Months) * f
+	wholeMonths := math.Trunc(months)
+	days := float64(interval.Days)*f + (months-wholeMonths)*object.DaysPerMonth
+	wholeDays := math.Trunc(days)
+	microseconds := float64(interval.Microseconds)*f + (days-wholeDays)*object.MicrosecondsPerDay
+	return &object.Interval{Months: int64(wholeMonths), Days: int64(wholeDays), Microseconds: int64(math.Round(microseconds))}
+}
+
+// number returns the value of an integer or float as a float
+func number(v object.Object) (float64, bool) {
+	switch v := v.(type) {
+	case *object.Integer:
+		return float64(v.Value), true
+	case *object.Float:
+		return v.Value, true
+	}
+	return 0, false
+}
+
+func floorDiv(a int64, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+func floorMod(a int64, b int64) int64 {
+	return a - floorDiv(a, b)*b
+}
@@ -0,0 +1,104 @@
+package evaluator_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vegarsti/sql/evaluator"
+	"github.com/vegarsti/sql/inmemory"
+)
+
+func TestEvalTemporal(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// literals
+		{"select date '2024-01-31'", "DATE '2024-01-31'\n'2024-01-31'"},
+		{"select time '10:30'", "TIME '10:30'\n'10:30:00'"},
+		{"select timestamp '2024-01-31 10:30:15.25'", "TIMESTAMP '2024-01-31 10:30:15.25'\n'2024-01-31 10:30:15.25'"},
+		{"select timestamp with time zone '2024-01-31 10:30:00+02'", "TIMESTAMPTZ '2024-01-31 10:30:00+02'\n'2024-01-31 08:30:00+00'"},
+		{"select interval '1 year 14 months 3 days 4 hours'", "INTERVAL '1 year 14 months 3 days 4 hours'\n'2 years 2 mons 3 days 04:00:00'"},
+		{"select interval '90 minutes ago'", "INTERVAL '90 minutes ago'\n'-01:30:00'"},
+		{"select date '0001-01-01' - 1", "(DATE '0001-01-01' - 1)\n'0001-12-31 BC'"},
+		// arithmetic
+		{"select date '2024-01-31' + 1", "(DATE '2024-01-31' + 1)\n'2024-02-01'"},
+		{"select date '2024-03-01' - date '2024-02-01'", "(DATE '2024-03-01' - DATE '2024-02-01')\n29"},
+		{"select date '2024-01-31' + interval '1 month'", "(DATE '2024-01-31' + INTERVAL '1 month')\n'2024-02-29 00:00:00'"},
+		{"select interval '1 day' + timestamp '2024-12-31 23:00:00'", "(INTERVAL '1 day' + TIMESTAMP '2024-12-31 23:00:00')\n'2025-01-01 23:00:00'"},
+		{"select timestamptz '2024-03-31 12:00:00Z' - interval '1 month 1 hour'", "(TIMESTAMPTZ '2024-03-31 12:00:00Z' - INTERVAL '1 month 1 hour')\n'2024-02-29 11:00:00+00'"},
+		{"select timestamp '2024-03-01 06:00:00' - timestamp '2024-02-28 12:00:00'", "(TIMESTAMP '2024-03-01 06:00:00' - TIMESTAMP '2024-02-28 12:00:00')\n'1 day 18:00:00'"},
+		{"select time '23:30' + interval '45 minutes'", "(TIME '23:30' + INTERVAL '45 minutes')\n'00:15:00'"},
+		{"select interval '1 month' * 1.5, interval '3 days' / 2, -interval '1 day'", "(INTERVAL '1 month' * 1.5)\t(INTERVAL '3 days' / 2)\t(-INTERVAL '1 day')\n'1 mon 15 days'\t'1 day 12:00:00'\t'-1 days'"},
+		// comparisons, where strings are read as values of the other side
+		{"select date '2024-01-31' < timestamp '2024-01-31 00:00:01'", "(DATE '2024-01-31' < TIMESTAMP '2024-01-31 00:00:01')\ntrue"},
+		{"select date '2024-01-31' = '2024-01-31'", "(DATE '2024-01-31' = '2024-01-31')\ntrue"},
+		{"select interval '1 month' > interval '29 days'", "(INTERVAL '1 month' > INTERVAL '29 days')\ntrue"},
+		{"select interval '24 hours' = interval '1 day'", "(INTERVAL '24 hours' = INTERVAL '1 day')\ntrue"},
+		// functions
+		{"select date_trunc('month', timestamp '2024-02-15 10:30:00')", "date_trunc('month', TIMESTAMP '2024-02-15 10:30:00')\n'2024-02-01 00:00:00'"},
+		{"select date_trunc('week', date '2024-02-15')", "date_trunc('week', DATE '2024-02-15')\n'2024-02-12 00:00:00'"},
+		{"select date_trunc('century', timestamptz '2024-02-15 10:30:00Z')", "date_trunc('century', TIMESTAMPTZ '2024-02-15 10:30:00Z')\n'2001-01-01 00:00:00+00'"},
+		{"select date_trunc('hour', interval '2 days 03:04:05')", "date_trunc('hour', INTERVAL '2 days 03:04:05')\n'2 days 03:00:00'"},
+		{"select extract(year from date '2024-02-15'), extract(month from date '2024-02-15'), extract(doy from date '2024-02-15')", "extract('year', DATE '2024-02-15')\textract('month', DATE '2024-02-15')\textract('doy', DATE '2024-02-15')\n2024\t2\t46"},
+		{"select extract(second from time '10:30:15.5'), date_part('dow', date '2024-02-18'), extract(isodow from date '2024-02-18')", "extract('second', TIME '10:30:15.5')\tdate_part('dow', DATE '2024-02-18')\textract('isodow', DATE '2024-02-18')\n15.500000\t0\t7"},
+		{"select extract(epoch from timestamptz '1970-01-02 00:00:00Z'), extract(hour from interval '30 hours')", "extract('epoch', TIMESTAMPTZ '1970-01-02 00:00:00Z')\textract('hour', INTERVAL '30 hours')\n86400.000000\t30"},
+		{"select to_char(timestamp '2024-02-05 15:04:05.123', 'YYYY-MM-DD HH24:MI:SS.MS')", "to_char(TIMESTAMP '2024-02-05 15:04:05.123', 'YYYY-MM-DD HH24:MI:SS.MS')\n'2024-02-05 15:04:05.123'"},
+		{"select to_char(date '2024-02-05', 'Day, FMMonth FMDD \"in\" Q')", "to_char(DATE '2024-02-05', 'Day, FMMonth FMDD \"in\" Q')\n'Monday   , February 5 in 1'"},
+		{"select to_char(timestamptz '2024-02-05 00:30:00Z', 'HH12:MI am TZ')", "to_char(TIMESTAMPTZ '2024-02-05 00:30:00Z', 'HH12:MI am TZ')\n'12:30 am UTC'"},
+		{"select now() > timestamptz '2024-01-01 00:00:00'", "(now() > TIMESTAMPTZ '2024-01-01 00:00:00')\ntrue"},
+		{"select extract(year from null)", "extract('year', null)\nnull"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(inmemory.NewBackend(), tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+// TestEvalNow checks that now() is the time the statement began, or the transaction it is in, as in PostgreSQL
+func TestEvalNow(t *testing.T) {
+	s := evaluator.NewSession(inmemory.NewBackend())
+	expected := "(now() - now())\n'00:00:00'"
+	if got := evalSession(t, s, "select now() - now()").Inspect(); got != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, got)
+	}
+	before := evalSession(t, s, "select now()").Inspect()
+	time.Sleep(time.Millisecond)
+	evalSession(t, s, "begin")
+	first := evalSession(t, s, "select now()").Inspect()
+	time.Sleep(time.Millisecond)
+	if second := evalSession(t, s, "select now()").Inspect(); second != first {
+		t.Fatalf("now() changed in a transaction from\n%s\nto\n%s", first, second)
+	}
+	if first == before {
+		t.Fatalf("now() in a transaction is the time of the statement before it: %s", first)
+	}
+	evalSession(t, s, "commit")
+	if after := evalSession(t, s, "select now()").Inspect(); after == first {
+		t.Fatalf("now() after a transaction is the time the transaction began: %s", after)
+	}
+}
+
+func TestEvalTemporalErrors(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"select date '2024-02-30'", `invalid input syntax for type date: "2024-02-30"`},
+		{"select interval '1 fortnight'", `invalid input syntax for type interval: "1 fortnight"`},
+		{"select date '2024-01-31' + date '2024-01-31'", "unknown operator: DATE + DATE"},
+		{"select time '10:00' < date '2024-01-31'", "unknown operator: TIME < DATE"},
+		{"select date '2024-01-31' = 'tomorrow'", `invalid input syntax for type date: "tomorrow"`},
+		{"select interval '1 day' / 0", "division by zero"},
+		{"select date_trunc('fortnight', date '2024-01-31')", `unit "fortnight" not recognized for type date`},
+		{"select extract(year from time '10:00')", `unit "year" not recognized for type time`},
+		{"select to_char(1, 'YYYY')", "function to_char(INTEGER, STRING) does not exist"},
+		{"select date_trunc('day')", "function date_trunc(STRING) does not exist"},
+		{"select today()", "function today does not exist"},
+	}
+	for _, tt := range tests {
+		testError(t, testEval(inmemory.NewBackend(), tt.input), tt.expectedError)
+	}
+}
@@ -0,0 +1,188 @@
+package evaluator
+
+import (
+	"fmt"
+
+	"github.com/vegarsti/sql/object"
+)
+
+// Transactor is implemented by backends with transactions of their own, such as backends that keep several
+// versions of each row. A session begins a transaction in the backend for each transaction, and for each
+// statement outside of a transaction, so that a statement reads a single snapshot of the tables.
+type Transactor interface {
+	Begin() (Transaction, error)
+}
+
+// Transaction is a backend that the statements in a transaction are evaluated against.
+// Its changes are seen by other sessions once it is committed, and are thrown away if it is rolled back.
+type Transaction interface {
+	Backend
+	Commit() error
+	Rollback() error
+}
+
+// ErrSerializationFailure is returned when committing a transaction that changed a row that was changed
+// by another transaction, which committed after the first transaction began.
+var ErrSerializationFailure error = object.Errorf(object.CodeSerializationFailure, "could not serialize access due to concurrent update")
+
+// transaction is a backend that keeps the changes made in a transaction in memory,
+// and applies them to the underlying backend on commit.
+// Statements in the transaction see their own changes, while other sessions
+// only see them once the transaction is committed.
+// The changes are applied atomically if the underlying backend is a BatchWriter. Otherwise, if it fails
+// while the changes are applied, the changes applied so far are kept.
+type transaction struct {
+	Backend
+	tables  map[string][]object.Column // tables created in the transaction
+	rows    map[string][]object.Row    // rows inserted in the transaction
+	changes []change
+}
+
+// change is a table created, or a row inserted, in a transaction
+type change struct {
+	table   string
+	columns []object.Column // for a created table
+	row     *object.Row     // for an inserted row
+}
+
+func newTransaction(backend Backend) *transaction {
+	return &transaction{
+		Backend: backend,
+		tables:  make(map[string][]object.Column),
+		rows:    make(map[string][]object.Row),
+	}
+}
+
+// Commit applies the changes to the underlying backend, in the order they were made.
+// If another session has created a table with the same name as a table created in the transaction,
+// nothing is applied.
+func (t *transaction) Commit() error {
+	for name := range t.tables {
+		if columns, err := t.Backend.Columns(name); err == nil && columns != nil {
+			return object.Errorf(object.CodeDuplicateTable, `relation "%s" already exists`, name)
+		}
+	}
+	if bw, ok := t.Backend.(BatchWriter); ok {
+		var tables []object.Table
+		rows := make(map[string][]object.Row)
+		for _, c := range t.changes {
+			if c.row == nil {
+				tables = append(tables, object.Table{Name: c.table, Columns: c.columns})
+				continue
+			}
+			rows[c.table] = append(rows[c.table], *c.row)
+		}
+		return bw.WriteBatch(tables, rows)
+	}
+	for _, c := range t.changes {
+		if c.row == nil {
+			if err := t.Backend.CreateTable(c.table, c.columns); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := t.Backend.Insert(c.table, *c.row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rollback throws away the changes, which are only kept in the transaction
+func (t *transaction) Rollback() error {
+	return nil
+}
+
+// exists reports whether the table exists, either in the transaction or in the underlying backend
+func (t *transaction) exists(name string) bool {
+	if _, ok := t.tables[name]; ok {
+		return true
+	}
+	columns, err := t.Backend.Columns(name)
+	return err == nil && columns != nil
+}
+
+func (t *transaction) CreateTable(name string, columns []object.Column) error {
+	if t.exists(name) {
+		return object.Errorf(object.CodeDuplicateTable, `relation "%s" already exists`, name)
+	}
+	t.tables[name] = columns
+	t.changes = append(t.changes, change{table: name, columns: columns})
+	return nil
+}
+
+func (t *transaction) Insert(name string, row object.Row) error {
+	if !t.exists(name) {
+		return object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
+	}
+	t.rows[name] = append(t.rows[name], row)
+	t.changes = append(t.changes, change{table: name, row: &row})
+	return nil
+}
+
+func (t *transaction) Columns(name string) ([]object.Column, error) {
+	if columns, ok := t.tables[name]; ok {
+		return columns, nil
+	}
+	return t.Backend.Columns(name)
+}
+
+// Scan returns the rows in the underlying backend, followed by the rows inserted in the transaction
+func (t *transaction) Scan(name string) (object.RowIterator, error) {
+	if _, ok := t.tables[name]; ok {
+		return object.NewSliceIterator(t.rows[name]), nil
+	}
+	it, err := t.Backend.Scan(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(t.rows[name]) == 0 {
+		return it, nil
+	}
+	return &concatIterator{iterators: []object.RowIterator{it, object.NewSliceIterator(t.rows[name])}}, nil
+}
+
+func (t *transaction) RowCount(name string) (int, error) {
+	if _, ok := t.tables[name]; ok {
+		return len(t.rows[name]), nil
+	}
+	counter, ok := t.Backend.(RowCounter)
+	if !ok {
+		return 0, fmt.Errorf("backend can't count rows")
+	}
+	n, err := counter.RowCount(name)
+	if err != nil {
+		return 0, err
+	}
+	return n + len(t.rows[name]), nil
+}
+
+// concatIterator returns the rows of each iterator in turn
+type concatIterator struct {
+	iterators []object.RowIterator
+}
+
+func (c *concatIterator) Next() (*object.Row, error) {
+	for len(c.iterators) > 0 {
+		row, err := c.iterators[0].Next()
+		if err != nil || row != nil {
+			return row, err
+		}
+		if err := c.iterators[0].Close(); err != nil {
+			return nil, err
+		}
+		c.iterators = c.iterators[1:]
+	}
+	return nil, nil
+}
+
+func (c *concatIterator) Close() error {
+	var err error
+	for _, it := range c.iterators {
+		if closeErr := it.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	c.iterators = nil
+	return err
+}
@@ -0,0 +1,165 @@
+package evaluator
+
+import (
+	"github.com/vegarsti/sql/ast"
+	"github.com/vegarsti/sql/object"
+)
+
+// expressionType returns the data type of the values of the expression, or an empty type if it can't be known
+// before the expression is evaluated. The identifiers in the expression must have been normalized,
+// and tables has the columns of the tables they refer to, as returned by fromColumns.
+func expressionType(tables map[string][]object.Column, e ast.Expression) object.DataType {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		return object.INTEGER
+	case *ast.FloatLiteral:
+		return object.FLOAT
+	case *ast.StringLiteral:
+		return object.STRING
+	case *ast.BooleanLiteral:
+		return object.BOOLEAN
+	case *ast.TypedLiteral:
+		return object.DataType(e.Token.Type)
+	case *ast.Identifier:
+		for _, c := range tables[e.Table] {
+			if c.Name == e.Value {
+				return c.Type
+			}
+		}
+	case *ast.PrefixExpression:
+		if e.Operator == "NOT" {
+			return object.BOOLEAN
+		}
+		return expressionType(tables, e.Right)
+	case *ast.PostfixExpression:
+		return object.BOOLEAN
+	case *ast.InfixExpression:
+		switch e.Operator {
+		case "=", "!=", "<", "<=", ">", ">=", "AND", "OR":
+			return object.BOOLEAN
+		case "||":
+			return object.STRING
+		}
+		left := expressionType(tables, e.Left)
+		right := expressionType(tables, e.Right)
+		if object.IsTemporal(object.ObjectType(left)) || object.IsTemporal(object.ObjectType(right)) {
+			return temporalResultType(e.Operator, left, right)
+		}
+		if left == object.NUMERIC || right == object.NUMERIC {
+			if isNumber(left) && isNumber(right) {
+				return object.NUMERIC
+			}
+			return ""
+		}
+		if left == object.FLOAT || right == object.FLOAT {
+			return object.FLOAT
+		}
+		if left == object.INTEGER && right == object.INTEGER {
+			return object.INTEGER
+		}
+	case *ast.CallExpression:
+		switch e.Function {
+		case "count":
+			return object.INTEGER
+		case "avg":
+			if len(e.Arguments) == 1 && expressionType(tables, e.Arguments[0]) == object.NUMERIC {
+				return object.NUMERIC
+			}
+			return object.FLOAT
+		case "sum", "min", "max":
+			if len(e.Arguments) == 1 {
+				return expressionType(tables, e.Arguments[0])
+			}
+		}
+		if f, ok := scalarFunctions[e.Function]; ok && len(e.Arguments) == f.arguments {
+			types := make([]object.DataType, len(e.Arguments))
+			for i, a := range e.Arguments {
+				types[i] = expressionType(tables, a)
+			}
+			return f.resultType(e.Arguments, types)
+		}
+	}
+	return ""
+}
+
+// isNumber returns true for the integer, float and numeric data types
+func isNumber(t object.DataType) bool {
+	return t == object.INTEGER || t == object.FLOAT || t == object.NUMERIC
+}
+
+// temporalResultType returns the data type of an arithmetic operator where one of the sides is a date, time,
+// timestamp or interval, like evalTemporalInfixExpression
+func temporalResultType(operator string, left object.DataType, right object.DataType) object.DataType {
+	switch operator {
+	case "+":
+		if left == object.INTERVAL {
+			left, right = right, left
+		}
+		switch {
+		case left == object.DATE && right == object.INTEGER:
+			return object.DATE
+		case left == object.DATE && (right == object.INTERVAL || right == object.TIME):
+			return object.TIMESTAMP
+		case right == object.INTERVAL:
+			return left
+		}
+	case "-":
+		switch {
+		case right == object.INTERVAL:
+			if left == object.DATE {
+				return object.TIMESTAMP
+			}
+			return left
+		case left == object.DATE && right == object.INTEGER:
+			return object.DATE
+		case left == object.DATE && right == object.DATE:
+			return object.INTEGER
+		case left != "" && right != "":
+			return object.INTERVAL
+		}
+	case "*", "/":
+		return object.INTERVAL
+	}
+	return ""
+}
+
+// inferParameterTypes sets the types of the parameters in the expression from the expressions they are
+// compared or combined with, such as INTEGER for $1 in a > $1 when a is an INTEGER column.
+// Types that are already known are kept, and types that can't be known are left empty.
+func inferParameterTypes(tables map[string][]object.Column, types []object.DataType, e ast.Expression) {
+	switch e := e.(type) {
+	case *ast.InfixExpression:
+		switch e.Operator {
+		case "AND", "OR":
+			setParameterType(types, e.Left, object.BOOLEAN)
+			setParameterType(types, e.Right, object.BOOLEAN)
+		case "||":
+			setParameterType(types, e.Left, object.STRING)
+			setParameterType(types, e.Right, object.STRING)
+		default:
+			setParameterType(types, e.Left, expressionType(tables, e.Right))
+			setParameterType(types, e.Right, expressionType(tables, e.Left))
+		}
+		inferParameterTypes(tables, types, e.Left)
+		inferParameterTypes(tables, types, e.Right)
+	case *ast.PrefixExpression:
+		if e.Operator == "NOT" {
+			setParameterType(types, e.Right, object.BOOLEAN)
+		}
+		inferParameterTypes(tables, types, e.Right)
+	case *ast.PostfixExpression:
+		inferParameterTypes(tables, types, e.Left)
+	case *ast.CallExpression:
+		for _, argument := range e.Arguments {
+			inferParameterTypes(tables, types, argument)
+		}
+	}
+}
+
+func setParameterType(types []object.DataType, e ast.Expression, dataType object.DataType) {
+	p, ok := e.(*ast.Parameter)
+	if !ok || dataType == "" || p.Index > len(types) || types[p.Index-1] != "" {
+		return
+	}
+	types[p.Index-1] = dataType
+}
@@ -0,0 +1,142 @@
+package evaluator_test
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/vegarsti/sql/evaluator"
+	"github.com/vegarsti/sql/object"
+)
+
+// TestEvalColumnTypes checks that values of each data type are stored and queried the same way in both in-memory
+// backends, a row at a time and a batch at a time, and that values are converted to the type of their column on insert
+func TestEvalColumnTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   []string
+		queries []struct{ input, expected string }
+	}{
+		{
+			"temporal",
+			[]string{
+				"create table events (d DATE, t TIMESTAMP, z TIMESTAMP WITH TIME ZONE, i INTERVAL, c TIME)",
+				"insert into events values (date '2024-01-31', timestamp '2024-01-31 10:30:00', timestamptz '2024-01-31 10:30:00+02', interval '1 month 2 days', time '23:30')",
+				"insert into events values ('2024-03-15', '2024-03-15 08:00:00.123456', '2024-03-15 08:00:00Z', '1 year 3 hours', '01:02:03')",
+				// dates and timestamps are converted to the type of the column
+				"insert into events values (timestamp '2024-03-20 18:00:00', date '2024-03-20', date '2024-03-20', '0', '00:00')",
+			},
+			[]struct{ input, expected string }{
+				{"select d, t, z, i, c from events order by d", "d\tt\tz\ti\tc\n'2024-01-31'\t'2024-01-31 10:30:00'\t'2024-01-31 08:30:00+00'\t'1 mon 2 days'\t'23:30:00'\n'2024-03-15'\t'2024-03-15 08:00:00.123456'\t'2024-03-15 08:00:00+00'\t'1 year 03:00:00'\t'01:02:03'\n'2024-03-20'\t'2024-03-20 00:00:00'\t'2024-03-20 00:00:00+00'\t'00:00:00'\t'00:00:00'"},
+				{"select d from events where (d > '2024-02-01') and (t < timestamp '2024-03-16 00:00:00')", "d\n'2024-03-15'"},
+				{"select t + i from events order by t + i desc", "(t + i)\n'2025-03-15 11:00:00.123456'\n'2024-03-20 00:00:00'\n'2024-03-02 10:30:00'"},
+				{"select date_trunc('month', d), count(*) from events group by date_trunc('month', d) order by date_trunc('month', d)", "date_trunc('month', d)\tcount(*)\n'2024-01-01 00:00:00'\t1\n'2024-03-01 00:00:00'\t2"},
+				{"select extract(month from d) as m, min(t), max(i) from events group by extract(month from d) order by extract(month from d)", "m\tmin(t)\tmax(i)\n1\t'2024-01-31 10:30:00'\t'1 mon 2 days'\n3\t'2024-03-15 08:00:00.123456'\t'1 year 03:00:00'"},
+				{"select to_char(z, 'DD Mon YYYY') from events order by z limit 1", "to_char(z, 'DD Mon YYYY')\n'31 Jan 2024'"},
+				// a date is equal to the timestamp at its midnight in a hash join, as when it is compared
+				{"select a.d, b.t from events a join events b on a.d = b.t", "d\tt\n'2024-03-20'\t'2024-03-20 00:00:00'"},
+				{"select a.d, b.z from events a, events b where a.d = b.z", "d\tz\n'2024-03-20'\t'2024-03-20 00:00:00+00'"},
+				{"insert into events values ('2024-13-01', '2024-03-15', '2024-03-15', '1 day', '00:00')", `ERROR: invalid input syntax for type date: "2024-13-01"`},
+				{"insert into events values (1, '2024-03-15', '2024-03-15', '1 day', '00:00')", `ERROR: cannot insert INTEGER with value 1 in DATE column in table "events"`},
+			},
+		},
+		{
+			"numeric",
+			[]string{
+				"create table prices (item TEXT, price NUMERIC(10, 2), qty DECIMAL)",
+				// integers, floats and strings are converted to numerics with the scale of the column
+				"insert into prices values ('a', 19.999, 3), ('b', '0.125', numeric '0.5'), ('c', numeric '12', 1.25)",
+				"create table amounts (item TEXT, amount NUMERIC)",
+				"insert into amounts values ('e', numeric '100000000000000000000.1'), ('f', numeric '100000000000000000000.2'), ('g', numeric '12.00'), ('h', numeric '12.000')",
+				"create table stock (item TEXT, n INTEGER, f FLOAT)",
+				"insert into stock values ('x', 20, 0.5), ('y', 12, 1.25), ('z', 3, 0.13)",
+			},
+			[]struct{ input, expected string }{
+				{"select item, price, qty, price * qty from prices order by price", "item\tprice\tqty\t(price * qty)\n'b'\t0.13\t0.5\t0.065\n'c'\t12.00\t1.25\t15.0000\n'a'\t20.00\t3\t60.00"},
+				{"select item from prices where price = 12", "item\n'c'"},
+				{"select sum(price), avg(price), min(price), max(qty), sum(qty) from prices", "sum(price)\tavg(price)\tmin(price)\tmax(qty)\tsum(qty)\n32.13\t10.7100000000000000000\t0.13\t3\t4.75"},
+				// equal numerics with different scales are in the same group
+				{"select count(*) from amounts group by amount order by count(*)", "count(*)\n1\n1\n2"},
+				// numerics that are the same as floats are sorted exactly
+				{"select item from amounts order by amount desc, item", "item\n'f'\n'e'\n'g'\n'h'"},
+				// numerics are equal to the integers and floats with the same value in a hash join, as when they are compared
+				{"select p.item, s.item from prices p join stock s on p.price = s.n order by p.item", "item\titem\n'a'\t'x'\n'c'\t'y'"},
+				{"select p.item, s.item from prices p join stock s on p.qty = s.f order by p.item", "item\titem\n'b'\t'x'\n'c'\t'y'"},
+				{"insert into prices values ('e', 99999999.995, 1)", "ERROR: numeric field overflow: a field with precision 10, scale 2 must round to an absolute value less than 10^8"},
+				{"insert into prices values ('e', 'cheap', 1)", `ERROR: invalid input syntax for type numeric: "cheap"`},
+				{"insert into prices values ('e', true, 1)", `ERROR: cannot insert BOOLEAN with value true in NUMERIC column in table "prices"`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forEachBackend(t, statements(tt.setup...), func(t *testing.T, s *evaluator.Session) {
+				for _, vectorize := range []string{"off", "on"} {
+					evalSession(t, s, "set vectorize = '"+vectorize+"'")
+					for _, q := range tt.queries {
+						if got := evalSession(t, s, q.input).Inspect(); got != q.expected {
+							t.Errorf("vectorize=%s: %s: expected\n%s\ngot\n%s", vectorize, q.input, q.expected, got)
+						}
+					}
+				}
+			})
+		})
+	}
+}
+
+// TestEvalParameterTypes checks that arguments of each data type are inserted and compared with values in both in-memory backends
+func TestEvalParameterTypes(t *testing.T) {
+	at := time.Date(2024, 1, 31, 10, 30, 0, 0, time.FixedZone("", 2*60*60))
+	hour := &object.Interval{Microseconds: object.MicrosecondsPerSecond * 60 * 60}
+	tests := []struct {
+		name   string
+		create string
+		insert string
+		// inserted are the arguments of each insert
+		inserted  [][]interface{}
+		query     string
+		arguments []interface{}
+		expected  string
+	}{
+		{
+			"temporal",
+			"create table events (d DATE, z TIMESTAMPTZ)",
+			"insert into events values ($1, $2)",
+			[][]interface{}{{at, at}},
+			"select d, z + $1 from events where z = $2",
+			[]interface{}{hour, at},
+			"d\t(z + $1)\n'2024-01-31'\t'2024-01-31 09:30:00+00'",
+		},
+		{
+			"numeric",
+			"create table prices (price NUMERIC(6, 2))",
+			"insert into prices values ($1)",
+			[][]interface{}{{"10.005"}, {2.5}, {&object.Numeric{Coefficient: big.NewInt(333), Scale: 3}}},
+			"select sum(price) + $1 from prices where price > $2",
+			[]interface{}{object.NumericOf(0), 0.33},
+			"(sum(price) + $1)\n12.51",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forEachBackend(t, statements(tt.create), func(t *testing.T, s *evaluator.Session) {
+				insert, err := s.Prepare(tt.insert)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, arguments := range tt.inserted {
+					if evaluated := insert.Execute(arguments...); evaluated.Inspect() != "OK" {
+						t.Fatalf("insert %v: %s", arguments, evaluated.Inspect())
+					}
+				}
+				query, err := s.Prepare(tt.query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if evaluated := query.Execute(tt.arguments...); evaluated.Inspect() != tt.expected {
+					t.Fatalf("expected\n%s\ngot\n%s", tt.expected, evaluated.Inspect())
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,530 @@
+package evaluator
+
+import (
+	"math"
+
+	"github.com/vegarsti/sql/ast"
+	"github.com/vegarsti/sql/object"
+)
+
+// vectorFunc evaluates an expression for the selected rows of a batch
+type vectorFunc func(b *object.Batch, selection []int) (*object.Vector, error)
+
+// vectorized is an expression compiled both for rows and for batches of the schema it was compiled for.
+// Evaluated for a batch, it gives the same values and errors as for each of the rows, with loops over
+// the values of the vectors for integers, floats, strings and booleans, and by evaluating the expression
+// for the values of each row otherwise, such as for NULL values, which are errors in operators.
+type vectorized struct {
+	compiled
+	evalBatch vectorFunc
+}
+
+func (v vectorized) EvalBatch(b *object.Batch, selection []int) (*object.Vector, error) {
+	return v.evalBatch(b, selection)
+}
+
+// compileVector compiles the expression for batches of the schema. The identifiers in the expression must have been normalized.
+func compileVector(node ast.Expression, s schema) vectorized {
+	evalBatch, _ := compileVectorExpression(node, s)
+	return vectorized{compiled: compile(node, s), evalBatch: evalBatch}
+}
+
+// compileVectorExpression is compileExpression for batches
+func compileVectorExpression(node ast.Expression, s schema) (vectorFunc, object.Object) {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		i := s.position(node)
+		if i == -1 {
+			return vectorConstant(evalExpression(object.Row{}, node))
+		}
+		return func(b *object.Batch, selection []int) (*object.Vector, error) { return b.Vectors[i], nil }, nil
+	case *ast.PrefixExpression:
+		right, rightValue := compileVectorExpression(node.Right, s)
+		if rightValue != nil {
+			if isError(rightValue) {
+				return vectorConstant(rightValue)
+			}
+			return vectorConstant(evalPrefixExpression(node.Operator, rightValue))
+		}
+		return func(b *object.Batch, selection []int) (*object.Vector, error) {
+			v, err := right(b, selection)
+			if err != nil {
+				return nil, err
+			}
+			return prefixVector(node.Operator, v, b.Length, selection)
+		}, nil
+	case *ast.PostfixExpression:
+		left, leftValue := compileVectorExpression(node.Left, s)
+		if leftValue != nil {
+			if isError(leftValue) {
+				return vectorConstant(leftValue)
+			}
+			return vectorConstant(evalPostfixExpression(leftValue, node.Operator))
+		}
+		return func(b *object.Batch, selection []int) (*object.Vector, error) {
+			v, err := left(b, selection)
+			if err != nil {
+				return nil, err
+			}
+			return postfixVector(v, node.Operator, b.Length, selection)
+		}, nil
+	case *ast.InfixExpression:
+		return compileVectorInfixExpression(node, s)
+	case *ast.CallExpression:
+		if _, ok := scalarFunctions[node.Function]; ok {
+			return compileVectorCallExpression(node, s)
+		}
+	}
+	return vectorConstant(evalExpression(object.Row{}, node))
+}
+
+// compileVectorCallExpression compiles a call of a scalar function, which is evaluated for the values of each row
+func compileVectorCallExpression(node *ast.CallExpression, s schema) (vectorFunc, object.Object) {
+	arguments := make([]vectorFunc, len(node.Arguments))
+	values := make([]object.Object, len(node.Arguments))
+	isConstant := true
+	for i, a := range node.Arguments {
+		arguments[i], values[i] = compileVectorExpression(a, s)
+		if values[i] == nil {
+			isConstant = false
+		} else if isError(values[i]) {
+			return vectorConstant(values[i])
+		}
+	}
+	if isConstant {
+		return vectorConstant(evalFunction(node, values))
+	}
+	return func(b *object.Batch, selection []int) (*object.Vector, error) {
+		vectors := make([]*object.Vector, len(arguments))
+		for k, argument := range arguments {
+			v, err := argument(b, selection)
+			if err != nil {
+				return nil, err
+			}
+			vectors[k] = v
+		}
+		return vectorOfEach(b.Length, selection, func(i int) object.Object {
+			values := make([]object.Object, len(vectors))
+			for k, v := range vectors {
+				values[k] = v.Value(i)
+			}
+			return evalFunction(node, values)
+		})
+	}, nil
+}
+
+// vectorConstant returns a function returning a vector with the value at every position,
+// or the error if the value is an error and any rows are selected
+func vectorConstant(v object.Object) (vectorFunc, object.Object) {
+	if errorObj, ok := v.(*object.Error); ok {
+		return func(b *object.Batch, selection []int) (*object.Vector, error) {
+			if len(selection) == 0 {
+				return object.NewVector(object.NULL_OBJ, b.Length), nil
+			}
+			return nil, errorObj
+		}, v
+	}
+	values := make([]object.Object, object.BatchSize)
+	for i := range values {
+		values[i] = v
+	}
+	vector := object.VectorOf(values, object.SelectAll(object.BatchSize), object.BatchSize)
+	return func(*object.Batch, []int) (*object.Vector, error) { return vector, nil }, v
+}
+
+func compileVectorInfixExpression(node *ast.InfixExpression, s schema) (vectorFunc, object.Object) {
+	left, leftValue := compileVectorExpression(node.Left, s)
+	right, rightValue := compileVectorExpression(node.Right, s)
+	if leftValue != nil && isError(leftValue) {
+		return vectorConstant(leftValue)
+	}
+	operator := node.Operator
+	if operator == "AND" || operator == "OR" {
+		decided := operator == "OR"
+		if decides(leftValue, decided) {
+			return vectorConstant(leftValue)
+		}
+		if leftValue == nil {
+			return func(b *object.Batch, selection []int) (*object.Vector, error) {
+				return shortCircuitVector(operator, left, right, b, selection)
+			}, nil
+		}
+	}
+	switch {
+	case leftValue != nil && rightValue != nil:
+		if isError(rightValue) {
+			return vectorConstant(rightValue)
+		}
+		return vectorConstant(evalInfixExpression(operator, leftValue, rightValue))
+	case rightValue != nil && isError(rightValue):
+		return func(b *object.Batch, selection []int) (*object.Vector, error) {
+			if _, err := left(b, selection); err != nil {
+				return nil, err
+			}
+			return right(b, selection)
+		}, nil
+	}
+	return func(b *object.Batch, selection []int) (*object.Vector, error) {
+		l, err := left(b, selection)
+		if err != nil {
+			return nil, err
+		}
+		r, err := right(b, selection)
+		if err != nil {
+			return nil, err
+		}
+		return infixVector(operator, l, r, b.Length, selection)
+	}, nil
+}
+
+// shortCircuitVector evaluates AND or OR, where the right side is only evaluated for the rows
+// where the left side doesn't decide the result, like for a single row
+func shortCircuitVector(operator string, left vectorFunc, right vectorFunc, b *object.Batch, selection []int) (*object.Vector, error) {
+	decided := operator == "OR"
+	l, err := left(b, selection)
+	if err != nil {
+		return nil, err
+	}
+	undecided := make([]int, 0, len(selection))
+	if l.Type == object.BOOLEAN_OBJ && l.Nulls == nil {
+		for _, i := range selection {
+			if l.Booleans[i] != decided {
+				undecided = append(undecided, i)
+			}
+		}
+	} else {
+		for _, i := range selection {
+			if !decides(l.Value(i), decided) {
+				undecided = append(undecided, i)
+			}
+		}
+	}
+	if len(undecided) == 0 {
+		return l, nil
+	}
+	r, err := right(b, undecided)
+	if err != nil {
+		return nil, err
+	}
+	if l.Type == object.BOOLEAN_OBJ && l.Nulls == nil && r.Type == object.BOOLEAN_OBJ && r.Nulls == nil {
+		out := object.NewVector(object.BOOLEAN_OBJ, b.Length)
+		for _, i := range selection {
+			out.Booleans[i] = decided
+		}
+		// for an undecided row, the left side is the opposite of decided, so the result is the right side
+		for _, i := range undecided {
+			out.Booleans[i] = r.Booleans[i]
+		}
+		return out, nil
+	}
+	next := 0
+	return vectorOfEach(b.Length, selection, func(i int) object.Object {
+		if next < len(undecided) && undecided[next] == i {
+			next++
+			return evalInfixExpression(operator, l.Value(i), r.Value(i))
+		}
+		return l.Value(i)
+	})
+}
+
+// vectorOfEach returns a vector of the value of f for each selected row, or the first error it returns
+func vectorOfEach(length int, selection []int, f func(i int) object.Object) (*object.Vector, error) {
+	values := make([]object.Object, len(selection))
+	for k, i := range selection {
+		v := f(i)
+		if errorObj, ok := v.(*object.Error); ok {
+			return nil, errorObj
+		}
+		values[k] = v
+	}
+	return object.VectorOf(values, selection, length), nil
+}
+
+func prefixVector(operator string, v *object.Vector, length int, selection []int) (*object.Vector, error) {
+	if v.Nulls == nil {
+		switch {
+		case operator == "-" && v.Type == object.INTEGER_OBJ:
+			out := object.NewVector(object.INTEGER_OBJ, length)
+			for _, i := range selection {
+				out.Integers[i] = -v.Integers[i]
+			}
+			return out, nil
+		case operator == "-" && v.Type == object.FLOAT_OBJ:
+			out := object.NewVector(object.FLOAT_OBJ, length)
+			for _, i := range selection {
+				out.Floats[i] = -v.Floats[i]
+			}
+			return out, nil
+		case operator == "NOT" && v.Type == object.BOOLEAN_OBJ:
+			out := object.NewVector(object.BOOLEAN_OBJ, length)
+			for _, i := range selection {
+				out.Booleans[i] = !v.Booleans[i]
+			}
+			return out, nil
+		}
+	}
+	return vectorOfEach(length, selection, func(i int) object.Object { return evalPrefixExpression(operator, v.Value(i)) })
+}
+
+func postfixVector(v *object.Vector, operator string, length int, selection []int) (*object.Vector, error) {
+	if operator == "IS NULL" || operator == "IS NOT NULL" {
+		out := object.NewVector(object.BOOLEAN_OBJ, length)
+		for _, i := range selection {
+			out.Booleans[i] = v.IsNull(i) == (operator == "IS NULL")
+		}
+		return out, nil
+	}
+	return vectorOfEach(length, selection, func(i int) object.Object { return evalPostfixExpression(v.Value(i), operator) })
+}
+
+// infixVector evaluates the operator for the values of the vectors at the selected positions
+func infixVector(operator string, l *object.Vector, r *object.Vector, length int, selection []int) (*object.Vector, error) {
+	if l.Nulls == nil && r.Nulls == nil {
+		var out *object.Vector
+		var err error
+		switch {
+		case l.Type == object.INTEGER_OBJ && r.Type == object.INTEGER_OBJ:
+			out, err = integerInfixVector(operator, l.Integers, r.Integers, length, selection)
+		case l.Type == object.FLOAT_OBJ && r.Type == object.FLOAT_OBJ:
+			out = floatInfixVector(operator, l.Floats, r.Floats, length, selection)
+		case l.Type == object.INTEGER_OBJ && r.Type == object.FLOAT_OBJ:
+			out = floatInfixVector(operator, toFloats(l.Integers, length, selection), r.Floats, length, selection)
+		case l.Type == object.FLOAT_OBJ && r.Type == object.INTEGER_OBJ:
+			out = floatInfixVector(operator, l.Floats, toFloats(r.Integers, length, selection), length, selection)
+		case l.Type == object.STRING_OBJ && r.Type == object.STRING_OBJ:
+			out = stringInfixVector(operator, l.Strings, r.Strings, length, selection)
+		case l.Type == object.BOOLEAN_OBJ && r.Type == object.BOOLEAN_OBJ:
+			out = booleanInfixVector(operator, l.Booleans, r.Booleans, length, selection)
+		}
+		if out != nil || err != nil {
+			return out, err
+		}
+	}
+	return vectorOfEach(length, selection, func(i int) object.Object { return evalInfixExpression(operator, l.Value(i), r.Value(i)) })
+}
+
+func toFloats(integers []int64, length int, selection []int) []float64 {
+	floats := make([]float64, length)
+	for _, i := range selection {
+		floats[i] = float64(integers[i])
+	}
+	return floats
+}
+
+// integerInfixVector returns nil for operators it doesn't evaluate, which are evaluated for each row
+func integerInfixVector(operator string, l []int64, r []int64, length int, selection []int) (*object.Vector, error) {
+	if isComparison(operator) {
+		comparison := object.NewVector(object.BOOLEAN_OBJ, length)
+		compareIntegers(operator, l, r, comparison.Booleans, selection)
+		return comparison, nil
+	}
+	out := object.NewVector(object.INTEGER_OBJ, length)
+	switch operator {
+	case "+":
+		for _, i := range selection {
+			out.Integers[i] = l[i] + r[i]
+		}
+	case "-":
+		for _, i := range selection {
+			out.Integers[i] = l[i] - r[i]
+		}
+	case "*":
+		for _, i := range selection {
+			out.Integers[i] = l[i] * r[i]
+		}
+	case "/":
+		for _, i := range selection {
+			if r[i] == 0 {
+				return nil, object.Errorf(object.CodeDivisionByZero, "division by zero")
+			}
+			out.Integers[i] = l[i] / r[i]
+		}
+	case "%":
+		for _, i := range selection {
+			if r[i] == 0 {
+				return nil, object.Errorf(object.CodeDivisionByZero, "division by zero")
+			}
+			out.Integers[i] = l[i] % r[i]
+		}
+	default:
+		return nil, nil
+	}
+	return out, nil
+}
+
+func floatInfixVector(operator string, l []float64, r []float64, length int, selection []int) *object.Vector {
+	if isComparison(operator) {
+		comparison := object.NewVector(object.BOOLEAN_OBJ, length)
+		compareFloats(operator, l, r, comparison.Booleans, selection)
+		return comparison
+	}
+	out := object.NewVector(object.FLOAT_OBJ, length)
+	switch operator {
+	case "+":
+		for _, i := range selection {
+			out.Floats[i] = l[i] + r[i]
+		}
+	case "-":
+		for _, i := range selection {
+			out.Floats[i] = l[i] - r[i]
+		}
+	case "*":
+		for _, i := range selection {
+			out.Floats[i] = l[i] * r[i]
+		}
+	case "/":
+		for _, i := range selection {
+			out.Floats[i] = l[i] / r[i]
+		}
+	case "^":
+		for _, i := range selection {
+			out.Floats[i] = math.Pow(l[i], r[i])
+		}
+	case "%":
+		for _, i := range selection {
+			out.Floats[i] = math.Mod(l[i], r[i])
+		}
+	default:
+		return nil
+	}
+	return out
+}
+
+func stringInfixVector(operator string, l []string, r []string, length int, selection []int) *object.Vector {
+	if isComparison(operator) {
+		comparison := object.NewVector(object.BOOLEAN_OBJ, length)
+		compareStrings(operator, l, r, comparison.Booleans, selection)
+		return comparison
+	}
+	if operator != "||" {
+		return nil
+	}
+	out := object.NewVector(object.STRING_OBJ, length)
+	for _, i := range selection {
+		out.Strings[i] = l[i] + r[i]
+	}
+	return out
+}
+
+func booleanInfixVector(operator string, l []bool, r []bool, length int, selection []int) *object.Vector {
+	out := object.NewVector(object.BOOLEAN_OBJ, length)
+	switch operator {
+	case "=":
+		for _, i := range selection {
+			out.Booleans[i] = l[i] == r[i]
+		}
+	case "!=":
+		for _, i := range selection {
+			out.Booleans[i] = l[i] != r[i]
+		}
+	case "AND":
+		for _, i := range selection {
+			out.Booleans[i] = l[i] && r[i]
+		}
+	case "OR":
+		for _, i := range selection {
+			out.Booleans[i] = l[i] || r[i]
+		}
+	default:
+		return nil
+	}
+	return out
+}
+
+// compareIntegers sets the results of the comparison operator in out
+func compareIntegers(operator string, l []int64, r []int64, out []bool, selection []int) {
+	switch operator {
+	case "=":
+		for _, i := range selection {
+			out[i] = l[i] == r[i]
+		}
+	case "!=":
+		for _, i := range selection {
+			out[i] = l[i] != r[i]
+		}
+	case ">":
+		for _, i := range selection {
+			out[i] = l[i] > r[i]
+		}
+	case ">=":
+		for _, i := range selection {
+			out[i] = l[i] >= r[i]
+		}
+	case "<":
+		for _, i := range selection {
+			out[i] = l[i] < r[i]
+		}
+	case "<=":
+		for _, i := range selection {
+			out[i] = l[i] <= r[i]
+		}
+	}
+}
+
+// compareFloats sets the results of the comparison operator in out
+func compareFloats(operator string, l []float64, r []float64, out []bool, selection []int) {
+	switch operator {
+	case "=":
+		for _, i := range selection {
+			out[i] = l[i] == r[i]
+		}
+	case "!=":
+		for _, i := range selection {
+			out[i] = l[i] != r[i]
+		}
+	case ">":
+		for _, i := range selection {
+			out[i] = l[i] > r[i]
+		}
+	case ">=":
+		for _, i := range selection {
+			out[i] = l[i] >= r[i]
+		}
+	case "<":
+		for _, i := range selection {
+			out[i] = l[i] < r[i]
+		}
+	case "<=":
+		for _, i := range selection {
+			out[i] = l[i] <= r[i]
+		}
+	}
+}
+
+// compareStrings sets the results of the comparison operator in out
+func compareStrings(operator string, l []string, r []string, out []bool, selection []int) {
+	switch operator {
+	case "=":
+		for _, i := range selection {
+			out[i] = l[i] == r[i]
+		}
+	case "!=":
+		for _, i := range selection {
+			out[i] = l[i] != r[i]
+		}
+	case ">":
+		for _, i := range selection {
+			out[i] = l[i] > r[i]
+		}
+	case ">=":
+		for _, i := range selection {
+			out[i] = l[i] >= r[i]
+		}
+	case "<":
+		for _, i := range selection {
+			out[i] = l[i] < r[i]
+		}
+	case "<=":
+		for _, i := range selection {
+			out[i] = l[i] <= r[i]
+		}
+	}
+}
+
+func isComparison(operator string) bool {
+	switch operator {
+	case "=", "!=", ">", ">=", "<", "<=":
+		return true
+	}
+	return false
+}
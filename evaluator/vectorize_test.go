@@ -0,0 +1,230 @@
+package evaluator_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/vegarsti/sql/columnar"
+	"github.com/vegarsti/sql/evaluator"
+	"github.com/vegarsti/sql/inmemory"
+	"github.com/vegarsti/sql/lexer"
+	"github.com/vegarsti/sql/object"
+	"github.com/vegarsti/sql/parser"
+)
+
+// vectorTable creates the table foo with n rows, where some of the values of b and c are NULL
+func vectorTable(t testing.TB, backend evaluator.Backend, n int) {
+	columns := []object.Column{
+		{Name: "a", Type: object.INTEGER},
+		{Name: "b", Type: object.STRING},
+		{Name: "c", Type: object.FLOAT},
+		{Name: "d", Type: object.BOOLEAN},
+	}
+	rows := make([]object.Row, n)
+	for i := range rows {
+		var b, c object.Object = &object.String{Value: fmt.Sprintf("s%d", i%5)}, &object.Float{Value: float64(i%7) / 2}
+		if i%11 == 0 {
+			b = object.NULL
+		}
+		if i%13 == 0 {
+			c = object.NULL
+		}
+		rows[i] = object.Row{Values: []object.Object{&object.Integer{Value: int64(i)}, b, c, &object.Boolean{Value: i%3 == 0}}}
+	}
+	if err := backend.(evaluator.BatchWriter).WriteBatch([]object.Table{{Name: "foo", Columns: columns}}, map[string][]object.Row{"foo": rows}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func evalSession(t testing.TB, s *evaluator.Session, input string) object.Object {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("%s: %v", input, p.Errors())
+	}
+	return s.Eval(program)
+}
+
+// forEachBackend runs the test in a subtest for each in-memory backend, with a session of a backend that has been set up
+func forEachBackend(t *testing.T, setup func(t *testing.T, backend evaluator.Backend), test func(t *testing.T, s *evaluator.Session)) {
+	backends := []struct {
+		name       string
+		newBackend func() evaluator.Backend
+	}{
+		{"inmemory", func() evaluator.Backend { return inmemory.NewBackend() }},
+		{"columnar", func() evaluator.Backend { return columnar.NewBackend() }},
+	}
+	for _, bb := range backends {
+		t.Run(bb.name, func(t *testing.T) {
+			backend := bb.newBackend()
+			setup(t, backend)
+			test(t, evaluator.NewSession(backend))
+		})
+	}
+}
+
+// statements returns a setup for forEachBackend that evaluates the statements, which must succeed
+func statements(inputs ...string) func(t *testing.T, backend evaluator.Backend) {
+	return func(t *testing.T, backend evaluator.Backend) {
+		s := evaluator.NewSession(backend)
+		for _, input := range inputs {
+			if evaluated := evalSession(t, s, input); evaluated.Inspect() != "OK" {
+				t.Fatalf("%s: %s", input, evaluated.Inspect())
+			}
+		}
+	}
+}
+
+// TestVectorize checks that queries give the same results and errors executed a batch at a time as a row at a time
+func TestVectorize(t *testing.T) {
+	queries := []string{
+		"select a from foo",
+		"select a, b, c, d from foo where a > 2000",
+		"select (a * 2) + 1, -a, a % 7, a / 3 from foo",
+		"select a - c from foo where (a % 13) != 0",
+		"select c * 2.5, c / 2, c % 2 from foo where ((a % 13) != 0) and (c > 1)",
+		"select c ^ 2, -c from foo where (a % 13) != 0",
+		"select b || 'x' from foo where ((a % 11) != 0) and (b >= 's3')",
+		"select a from foo where d and not (a < 100)",
+		"select a from foo where (a < 5) or (a > 3000)",
+		"select a from foo where ((a % 11) = 0) or (b = 's1')",
+		"select a from foo where ((a > 3000) and ((a % 11) != 0)) and (b = 's1')",
+		"select a from foo where b is null",
+		"select a from foo where c is not null",
+		"select d = true, d != false from foo where a < 10",
+		"select count(*), count(b), count(c), sum(a), sum(c), avg(c), min(a), max(a), min(b), max(b), min(c), max(c) from foo",
+		"select b, count(*), sum(a), min(c) from foo group by b order by b",
+		"select a % 4, d, count(*) from foo group by a % 4, d order by a % 4, d",
+		"select c, count(*) from foo group by c order by c",
+		"select count(*), sum(a) from foo where a > 100000",
+		"select b, count(*) from foo where a > 100000 group by b",
+		"select a from foo where a > 10 order by a desc limit 3",
+		"select 1, 'x', 2.5 from foo where a < 3",
+		// errors
+		"select a + c from foo",
+		"select a / (a - 100) from foo",
+		"select a % (a - 100) from foo",
+		"select a from foo where b > 's1'",
+		"select a from foo where a",
+		"select a from foo where (a + 'x') = 1",
+		"select sum(b) from foo",
+		"select -b from foo",
+		"select a from foo where (1 / 0) = 1",
+		"select a from foo where (a > 100000) and ((1 / (a - a)) = 1)",
+		"select a from foo where (a < 5) and ((1 / (a - a)) = 1)",
+	}
+	setup := func(t *testing.T, backend evaluator.Backend) { vectorTable(t, backend, 3500) }
+	forEachBackend(t, setup, func(t *testing.T, s *evaluator.Session) {
+		for _, query := range queries {
+			evalSession(t, s, "set vectorize = false")
+			expected := evalSession(t, s, query).Inspect()
+			evalSession(t, s, "set vectorize = true")
+			if got := evalSession(t, s, query).Inspect(); got != expected {
+				t.Errorf("%s: expected\n%s\ngot\n%s", query, expected, got)
+			}
+		}
+	})
+}
+
+func TestVectorizeExplain(t *testing.T) {
+	tests := []struct {
+		backend  evaluator.Backend
+		input    string
+		expected string
+	}{
+		{
+			inmemory.NewBackend(),
+			"explain select a + 1 from foo where (a > 1) order by a",
+			"Project (a + 1)\n  Sort a\n    Vectorized Filter (a > 1)\n      Vectorized Scan foo",
+		},
+		{
+			inmemory.NewBackend(),
+			"explain select a + 1 from foo where (a > 1)",
+			"Vectorized Project (a + 1)\n  Vectorized Filter (a > 1)\n    Vectorized Scan foo",
+		},
+		{
+			columnar.NewBackend(),
+			"explain select b, sum(a) from foo where (c > 1) group by b",
+			"Project b, sum(a)\n  Vectorized Aggregate sum(a) group by b\n    Vectorized Filter (c > 1)\n      Vectorized Scan foo (a, b, c)",
+		},
+		{
+			columnar.NewBackend(),
+			"explain select foo.a from foo join foo x on foo.a = x.a",
+			"Project foo.a\n  HashJoin on foo.a = x.a\n    Vectorized Scan foo (a)\n    Vectorized Scan foo x (a)",
+		},
+	}
+	for _, tt := range tests {
+		vectorTable(t, tt.backend, 10)
+		session := evaluator.NewSession(tt.backend)
+		evalSession(t, session, "set vectorize = 'on'")
+		result, ok := evalSession(t, session, tt.input).(*object.Result)
+		if !ok {
+			t.Fatalf("%s: expected a result, got %s", tt.input, evalSession(t, session, tt.input).Inspect())
+		}
+		var got string
+		for i, row := range result.Rows {
+			if i > 0 {
+				got += "\n"
+			}
+			got += row.Values[0].(*object.String).Value
+		}
+		if got != tt.expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestSetVectorize(t *testing.T) {
+	session := evaluator.NewSession(inmemory.NewBackend())
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"set vectorize = true", "OK"},
+		{"set vectorize to 'off'", "OK"},
+		{"set vectorize = 'ON'", "OK"},
+		{"set vectorize = false", "OK"},
+		{"set vectorize = 1", `ERROR: parameter "vectorize" requires a Boolean value`},
+	}
+	for _, tt := range tests {
+		if got := evalSession(t, session, tt.input).Inspect(); got != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+// BenchmarkVectorize compares executing queries a batch at a time with the interpreter, which evaluates
+// the expressions for one row at a time
+func BenchmarkVectorize(b *testing.B) {
+	queries := []string{
+		"select count(*) from foo where (a > 1000) and (a < 90000)",
+		"select sum(a * 2 + 1), avg(c) from foo where d",
+		"select b, count(*), sum(a) from foo group by b",
+		"select a + 1 from foo where (a % 10) = 0",
+	}
+	backends := []struct {
+		name    string
+		backend evaluator.Backend
+	}{
+		{"inmemory", inmemory.NewBackend()},
+		{"columnar", columnar.NewBackend()},
+	}
+	for _, bb := range backends {
+		vectorTable(b, bb.backend, 100000)
+		for _, mode := range []string{"off", "on"} {
+			session := evaluator.NewSession(bb.backend)
+			evalSession(b, session, "set vectorize = '"+mode+"'")
+			for _, query := range queries {
+				program := parser.New(lexer.New(query)).ParseProgram()
+				b.Run(fmt.Sprintf("%s/vectorize=%s/%s", bb.name, mode, query), func(b *testing.B) {
+					for i := 0; i < b.N; i++ {
+						if result, ok := session.Eval(program).(*object.Error); ok {
+							b.Fatal(result.Message)
+						}
+					}
+				})
+			}
+		}
+	}
+}
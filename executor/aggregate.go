@@ -0,0 +1,564 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/vegarsti/sql/object"
+)
+
+// Prefixes of the table names used for the columns of the rows returned by Aggregate.
+// They cannot clash with real tables, since '#' is not allowed in identifiers.
+const (
+	GroupTable     = "#group"
+	AggregateTable = "#aggregate"
+)
+
+// GroupColumnTable is the table name of the i'th group column in the rows returned by Aggregate.
+func GroupColumnTable(i int) string { return GroupTable + strconv.Itoa(i) }
+
+// AggregateColumnTable is the table name of the i'th aggregate column in the rows returned by Aggregate.
+func AggregateColumnTable(i int) string { return AggregateTable + strconv.Itoa(i) }
+
+// IsAggregateColumnTable is true for the table names of the columns returned by Aggregate.
+func IsAggregateColumnTable(table string) bool { return strings.HasPrefix(table, "#") }
+
+// AggregateFunction is a call to an aggregate function, such as sum(x).
+// The argument is nil for count(*).
+type AggregateFunction struct {
+	Name     string
+	Argument Expression
+}
+
+func (f AggregateFunction) String() string {
+	if f.Argument == nil {
+		return f.Name + "(*)"
+	}
+	return f.Name + "(" + f.Argument.String() + ")"
+}
+
+// IsAggregateFunction reports whether name is the name of a supported aggregate function.
+func IsAggregateFunction(name string) bool {
+	switch name {
+	case "count", "sum", "min", "max", "avg":
+		return true
+	}
+	return false
+}
+
+// Aggregate groups the rows of its child by the group expressions, and computes the aggregate functions for each group.
+// Each returned row has one column per group expression, followed by one column per aggregate function.
+// The columns are named by the expression or function, e.g. "count(*)",
+// and their table names, given by GroupColumnTable and AggregateColumnTable, tell them apart.
+// Groups are returned in the order they were first seen. Without group expressions,
+// exactly one row is returned, also if the child returns no rows.
+type Aggregate struct {
+	child      Operator
+	groupBy    []Expression
+	aggregates []AggregateFunction
+
+	rows     []*object.Row
+	position int
+	done     bool
+}
+
+func NewAggregate(child Operator, groupBy []Expression, aggregates []AggregateFunction) *Aggregate {
+	return &Aggregate{child: child, groupBy: groupBy, aggregates: aggregates}
+}
+
+func (a *Aggregate) Open(ctx context.Context) error {
+	a.rows = nil
+	a.position = 0
+	a.done = false
+	return a.child.Open(ctx)
+}
+
+func (a *Aggregate) Next() (*object.Row, error) {
+	if !a.done {
+		if err := a.aggregate(); err != nil {
+			return nil, err
+		}
+	}
+	if a.position >= len(a.rows) {
+		return nil, nil
+	}
+	row := a.rows[a.position]
+	a.position++
+	return row, nil
+}
+
+type group struct {
+	keys         []object.Object
+	accumulators []accumulator
+}
+
+func (a *Aggregate) aggregate() error {
+	groups, err := a.groups()
+	if err != nil {
+		return err
+	}
+	return a.setGroups(groups)
+}
+
+// groups reads the rows of the child, and returns the groups in the order they were first seen
+func (a *Aggregate) groups() ([]*group, error) {
+	var groups []*group
+	groupIndex := make(map[string]*group)
+	for {
+		row, err := a.child.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			break
+		}
+		keys := make([]object.Object, len(a.groupBy))
+		for i, e := range a.groupBy {
+			v := e.Eval(*row)
+			if errorObj, ok := v.(*object.Error); ok {
+				return nil, errorObj
+			}
+			keys[i] = v
+		}
+		key := GroupKey(keys)
+		g, ok := groupIndex[key]
+		if !ok {
+			g, err = a.newGroup(keys)
+			if err != nil {
+				return nil, err
+			}
+			groupIndex[key] = g
+			groups = append(groups, g)
+		}
+		for i, f := range a.aggregates {
+			v := object.Object(object.NULL)
+			if f.Argument != nil {
+				v = f.Argument.Eval(*row)
+				if errorObj, ok := v.(*object.Error); ok {
+					return nil, errorObj
+				}
+			}
+			if err := g.accumulators[i].add(v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return groups, nil
+}
+
+// setGroups sets the rows to return to the rows of the groups. Without group expressions,
+// the row of a group of no rows is returned if there are no groups.
+func (a *Aggregate) setGroups(groups []*group) error {
+	if len(groups) == 0 && len(a.groupBy) == 0 {
+		g, err := a.newGroup(nil)
+		if err != nil {
+			return err
+		}
+		groups = append(groups, g)
+	}
+	for _, g := range groups {
+		a.rows = append(a.rows, a.groupRow(g))
+	}
+	a.done = true
+	return nil
+}
+
+// definition returns the aggregate, which has the group expressions and aggregate functions
+func (a *Aggregate) definition() *Aggregate { return a }
+
+func (a *Aggregate) newGroup(keys []object.Object) (*group, error) {
+	g := &group{keys: keys, accumulators: make([]accumulator, len(a.aggregates))}
+	for i, f := range a.aggregates {
+		acc, err := newAccumulator(f)
+		if err != nil {
+			return nil, err
+		}
+		g.accumulators[i] = acc
+	}
+	return g, nil
+}
+
+func (a *Aggregate) groupRow(g *group) *object.Row {
+	n := len(a.groupBy) + len(a.aggregates)
+	row := &object.Row{
+		Aliases:   make([]string, 0, n),
+		TableName: make([]string, 0, n),
+		Values:    make([]object.Object, 0, n),
+	}
+	for i, v := range g.keys {
+		row.Aliases = append(row.Aliases, a.groupBy[i].String())
+		row.TableName = append(row.TableName, GroupColumnTable(i))
+		row.Values = append(row.Values, v)
+	}
+	for i, acc := range g.accumulators {
+		row.Aliases = append(row.Aliases, a.aggregates[i].String())
+		row.TableName = append(row.TableName, AggregateColumnTable(i))
+		row.Values = append(row.Values, acc.result())
+	}
+	return row
+}
+
+func (a *Aggregate) Close() error {
+	a.rows = nil
+	return a.child.Close()
+}
+
+func (a *Aggregate) Children() []Operator { return []Operator{a.child} }
+func (a *Aggregate) String() string {
+	aggregates := make([]string, len(a.aggregates))
+	for i, f := range a.aggregates {
+		aggregates[i] = f.String()
+	}
+	if len(a.groupBy) == 0 {
+		return "Aggregate " + strings.Join(aggregates, ", ")
+	}
+	groupBy := make([]string, len(a.groupBy))
+	for i, e := range a.groupBy {
+		groupBy[i] = e.String()
+	}
+	return "Aggregate " + strings.Join(aggregates, ", ") + " group by " + strings.Join(groupBy, ", ")
+}
+
+// GroupKey returns a string that is equal for two lists of values exactly when the values are equal.
+// Integers, floats and numerics with the same value are equal, and so are intervals of the same length,
+// such as 1 month and 30 days, and dates and timestamps at the same point in time.
+func GroupKey(values []object.Object) string {
+	keys := make([]string, len(values))
+	for i, v := range values {
+		switch v := v.(type) {
+		case *object.Integer:
+			keys[i] = "n" + strconv.FormatInt(v.Value, 10)
+		case *object.Float:
+			if integer, ok := floatInteger(v.Value); ok {
+				// a float with an integer value has the key of the integer, so that 1 and 1.0 are in the same group
+				keys[i] = "n" + strconv.FormatInt(integer, 10)
+			} else {
+				// the shortest decimal that is read as the float, which is the key of the numeric it is equal to
+				keys[i] = "n" + strconv.FormatFloat(v.Value, 'f', -1, 64)
+			}
+		case *object.Date, *object.Timestamp, *object.TimestampTZ:
+			microseconds, _ := object.PointInTime(v)
+			keys[i] = "t" + strconv.FormatInt(microseconds, 10)
+		case *object.Interval:
+			keys[i] = "i" + strconv.FormatInt(v.Span(), 10)
+		case *object.Numeric:
+			keys[i] = "n" + v.Normalized()
+		default:
+			keys[i] = string(v.Type()) + v.Inspect()
+		}
+	}
+	return strings.Join(keys, "\x00")
+}
+
+// compareNumericFloat compares the numeric with the float as the shortest decimal that is read as the float,
+// as the evaluator does. A float that is not a number or infinite is compared as a float.
+func compareNumericFloat(n *object.Numeric, f float64) int {
+	if m, err := object.NumericOfFloat(f); err == nil {
+		return n.Cmp(m)
+	}
+	return compareFloat64(n.Float64(), f)
+}
+
+// floatInteger returns the float as an integer if it is a whole number that an int64 can hold
+func floatInteger(f float64) (int64, bool) {
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// accumulator computes an aggregate function of the values added to it. merge adds the values
+// added to another accumulator of the same function, for the partial aggregates of a parallel aggregate.
+type accumulator interface {
+	add(object.Object) error
+	merge(accumulator) error
+	result() object.Object
+}
+
+func newAccumulator(f AggregateFunction) (accumulator, error) {
+	switch f.Name {
+	case "count":
+		return &countAccumulator{star: f.Argument == nil}, nil
+	case "sum":
+		return &sumAccumulator{}, nil
+	case "avg":
+		return &avgAccumulator{}, nil
+	case "min":
+		return &extremeAccumulator{name: "min", keep: func(c int) bool { return c < 0 }}, nil
+	case "max":
+		return &extremeAccumulator{name: "max", keep: func(c int) bool { return c > 0 }}, nil
+	}
+	return nil, object.Errorf(object.CodeUndefinedFunction, "function %s does not exist", f.Name)
+}
+
+// countAccumulator counts the non-null values, or all rows for count(*)
+type countAccumulator struct {
+	star  bool
+	count int64
+}
+
+func (c *countAccumulator) add(v object.Object) error {
+	if c.star || v.Type() != object.NULL_OBJ {
+		c.count++
+	}
+	return nil
+}
+
+func (c *countAccumulator) merge(other accumulator) error {
+	c.count += other.(*countAccumulator).count
+	return nil
+}
+
+func (c *countAccumulator) result() object.Object { return &object.Integer{Value: c.count} }
+
+// sumAccumulator sums integers as integers and numerics exactly, and switches to floats as soon as a float is seen.
+// The sum of no values is null.
+type sumAccumulator struct {
+	seen       bool
+	isFloat    bool
+	integerSum int64
+	floatSum   float64
+	// numericSum is the sum of the numerics, or nil if there are none
+	numericSum *object.Numeric
+}
+
+func (s *sumAccumulator) add(v object.Object) error {
+	switch v := v.(type) {
+	case *object.Null:
+		return nil
+	case *object.Integer:
+		s.integerSum += v.Value
+		s.floatSum += float64(v.Value)
+	case *object.Float:
+		s.isFloat = true
+		s.floatSum += v.Value
+	case *object.Numeric:
+		s.numericSum = addNumeric(s.numericSum, v)
+		s.floatSum += v.Float64()
+	default:
+		return object.Errorf(object.CodeUndefinedFunction, "function sum(%s) does not exist", v.Type())
+	}
+	s.seen = true
+	return nil
+}
+
+// merge adds the sum of the other accumulator. Floats may be summed in another order than by add, so the sum may differ in the last digits.
+func (s *sumAccumulator) merge(other accumulator) error {
+	o := other.(*sumAccumulator)
+	if !o.seen {
+		return nil
+	}
+	s.seen = true
+	s.isFloat = s.isFloat || o.isFloat
+	s.integerSum += o.integerSum
+	s.floatSum += o.floatSum
+	if o.numericSum != nil {
+		s.numericSum = addNumeric(s.numericSum, o.numericSum)
+	}
+	return nil
+}
+
+func (s *sumAccumulator) result() object.Object {
+	if !s.seen {
+		return object.NULL
+	}
+	if s.isFloat {
+		return &object.Float{Value: s.floatSum}
+	}
+	if s.numericSum != nil {
+		return s.numericSum.Add(object.NumericOf(s.integerSum))
+	}
+	return &object.Integer{Value: s.integerSum}
+}
+
+// addNumeric returns sum + n, where a nil sum is zero
+func addNumeric(sum *object.Numeric, n *object.Numeric) *object.Numeric {
+	if sum == nil {
+		return n
+	}
+	return sum.Add(n)
+}
+
+// avgAccumulator returns the average of the values as a float, or as a numeric rounded like
+// numeric division if the values are numerics and no float is seen. The average of no values is null.
+type avgAccumulator struct {
+	count      int64
+	sum        float64
+	isFloat    bool
+	integerSum int64
+	// numericSum is the sum of the numerics, or nil if there are none
+	numericSum *object.Numeric
+}
+
+func (a *avgAccumulator) add(v object.Object) error {
+	switch v := v.(type) {
+	case *object.Null:
+		return nil
+	case *object.Integer:
+		a.integerSum += v.Value
+		a.sum += float64(v.Value)
+	case *object.Float:
+		a.isFloat = true
+		a.sum += v.Value
+	case *object.Numeric:
+		a.numericSum = addNumeric(a.numericSum, v)
+		a.sum += v.Float64()
+	default:
+		return object.Errorf(object.CodeUndefinedFunction, "function avg(%s) does not exist", v.Type())
+	}
+	a.count++
+	return nil
+}
+
+func (a *avgAccumulator) merge(other accumulator) error {
+	o := other.(*avgAccumulator)
+	a.count += o.count
+	a.sum += o.sum
+	a.isFloat = a.isFloat || o.isFloat
+	a.integerSum += o.integerSum
+	if o.numericSum != nil {
+		a.numericSum = addNumeric(a.numericSum, o.numericSum)
+	}
+	return nil
+}
+
+func (a *avgAccumulator) result() object.Object {
+	if a.count == 0 {
+		return object.NULL
+	}
+	if a.numericSum != nil && !a.isFloat {
+		return a.numericSum.Add(object.NumericOf(a.integerSum)).Quo(object.NumericOf(a.count))
+	}
+	return &object.Float{Value: a.sum / float64(a.count)}
+}
+
+// extremeAccumulator keeps the smallest or largest value, depending on keep.
+type extremeAccumulator struct {
+	name  string
+	keep  func(int) bool
+	value object.Object
+}
+
+func (e *extremeAccumulator) add(v object.Object) error {
+	if v.Type() == object.NULL_OBJ {
+		return nil
+	}
+	if e.value == nil {
+		e.value = v
+		return nil
+	}
+	c, err := Compare(v, e.value)
+	if err != nil {
+		return fmt.Errorf("function %s: %w", e.name, err)
+	}
+	if e.keep(c) {
+		e.value = v
+	}
+	return nil
+}
+
+func (e *extremeAccumulator) merge(other accumulator) error {
+	if o := other.(*extremeAccumulator); o.value != nil {
+		return e.add(o.value)
+	}
+	return nil
+}
+
+func (e *extremeAccumulator) result() object.Object {
+	if e.value == nil {
+		return object.NULL
+	}
+	return e.value
+}
+
+// Compare returns -1, 0 or 1 if a is less than, equal to or greater than b.
+// Integers, floats and numerics can be compared with each other, other values only with values of the same type.
+func Compare(a object.Object, b object.Object) (int, error) {
+	switch a := a.(type) {
+	case *object.Integer:
+		switch b := b.(type) {
+		case *object.Integer:
+			return compareInt64(a.Value, b.Value), nil
+		case *object.Float:
+			return -compareFloatInteger(b.Value, a.Value), nil
+		case *object.Numeric:
+			return object.NumericOf(a.Value).Cmp(b), nil
+		}
+	case *object.Float:
+		switch b := b.(type) {
+		case *object.Integer:
+			return compareFloatInteger(a.Value, b.Value), nil
+		case *object.Float:
+			return compareFloat64(a.Value, b.Value), nil
+		case *object.Numeric:
+			return -compareNumericFloat(b, a.Value), nil
+		}
+	case *object.String:
+		if b, ok := b.(*object.String); ok {
+			return strings.Compare(a.Value, b.Value), nil
+		}
+	case *object.Boolean:
+		if b, ok := b.(*object.Boolean); ok {
+			return compareFloat64(a.SortValue(), b.SortValue()), nil
+		}
+	case *object.Date, *object.Timestamp, *object.TimestampTZ:
+		// dates and timestamps are compared as points in time, as by the evaluator
+		if b, ok := object.PointInTime(b); ok {
+			a, _ := object.PointInTime(a)
+			return compareInt64(a, b), nil
+		}
+	case *object.Time:
+		if b, ok := b.(*object.Time); ok {
+			return compareInt64(a.Value, b.Value), nil
+		}
+	case *object.Interval:
+		if b, ok := b.(*object.Interval); ok {
+			return compareInt64(a.Span(), b.Span()), nil
+		}
+	case *object.Numeric:
+		switch b := b.(type) {
+		case *object.Numeric:
+			return a.Cmp(b), nil
+		case *object.Integer:
+			return a.Cmp(object.NumericOf(b.Value)), nil
+		case *object.Float:
+			return compareNumericFloat(a, b.Value), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %s and %s", a.Type(), b.Type())
+}
+
+func compareInt64(a int64, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareFloatInteger compares a float with an integer exactly, also when the integer has more digits than a float can hold
+func compareFloatInteger(f float64, i int64) int {
+	if integer, ok := floatInteger(f); ok {
+		return compareInt64(integer, i)
+	}
+	if f >= math.MaxInt64 {
+		// float64(i) may be rounded up to 2^63, which is larger than any integer
+		return 1
+	}
+	return compareFloat64(f, float64(i))
+}
+
+func compareFloat64(a float64, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
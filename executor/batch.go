@@ -0,0 +1,563 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vegarsti/sql/object"
+)
+
+// Batch operators execute a query a batch of rows at a time, where each column is a vector of values
+// and filters select rows by their position in the batch, rather than evaluating one object at a time.
+// Each batch operator is also an Operator returning the selected rows of its batches one at a time,
+// so that it can be the child of the other operators.
+//
+// The expressions of a batch are evaluated for all of its selected rows before the operator
+// above sees any of them, so a query that fails for some row can fail before a limit above is reached.
+
+// BatchOperator is a node in a query plan that returns batches of rows.
+type BatchOperator interface {
+	Operator
+	// NextBatch returns the next batch with at least one selected row, or nil when there are no more rows.
+	NextBatch() (*object.Batch, error)
+}
+
+// VectorExpression is an expression that can also be evaluated for the selected rows of a batch at a time.
+// The vector it returns has the value of the expression at the position of each of the selected rows,
+// and is valid until it is evaluated again.
+type VectorExpression interface {
+	Expression
+	EvalBatch(b *object.Batch, selection []int) (*object.Vector, error)
+}
+
+// BatchScanner is the part of a storage backend needed to read some of the columns of tables a batch at a time.
+type BatchScanner interface {
+	ScanBatches(string, []int) (object.BatchIterator, error)
+}
+
+// batchRows returns the rows of the batches of a batch operator one at a time
+type batchRows struct {
+	rows []object.Row
+}
+
+func (r *batchRows) next(op BatchOperator) (*object.Row, error) {
+	for len(r.rows) == 0 {
+		b, err := op.NextBatch()
+		if err != nil || b == nil {
+			return nil, err
+		}
+		r.rows = b.Rows()
+	}
+	row := &r.rows[0]
+	r.rows = r.rows[1:]
+	return row, nil
+}
+
+// BatchScan reads the rows of a table a batch at a time, from a backend that returns batches,
+// or by making batches of the rows of a scan.
+type BatchScan struct {
+	// Alias is the name the query gives the table, if any, which is shown after the table name
+	Alias string
+	// scan is the scan whose rows are made into batches, or nil if the backend returns batches
+	scan    *Scan
+	backend BatchScanner
+	table   string
+	columns []int
+	names   []string
+
+	ctx     context.Context
+	batches object.BatchIterator
+	rows    batchRows
+}
+
+// NewBatchScan returns a scan of the columns of the table at the positions, with the names, from a backend that returns batches.
+func NewBatchScan(backend BatchScanner, table string, columns []int, names []string) *BatchScan {
+	return &BatchScan{backend: backend, table: table, columns: columns, names: names}
+}
+
+// NewRowBatchScan returns a scan that makes batches of the rows read by the scan.
+func NewRowBatchScan(scan *Scan) *BatchScan {
+	return &BatchScan{scan: scan}
+}
+
+func (s *BatchScan) Open(ctx context.Context) error {
+	s.ctx = ctx
+	s.rows = batchRows{}
+	if s.scan != nil {
+		if err := s.scan.Open(ctx); err != nil {
+			return err
+		}
+		// the rows of a backend that reads them a batch at a time are read as batches
+		if batches, ok := s.scan.rows.(object.BatchIterator); ok {
+			s.batches = batches
+		} else {
+			s.batches = object.Batches(s.scan)
+		}
+		return nil
+	}
+	batches, err := s.backend.ScanBatches(s.table, s.columns)
+	if err != nil {
+		return err
+	}
+	s.batches = batches
+	return nil
+}
+
+func (s *BatchScan) NextBatch() (*object.Batch, error) {
+	if err := checkContext(s.ctx); err != nil {
+		return nil, err
+	}
+	return s.batches.NextBatch()
+}
+
+func (s *BatchScan) Next() (*object.Row, error) { return s.rows.next(s) }
+
+func (s *BatchScan) Close() error {
+	if s.scan != nil {
+		s.batches = nil
+		return s.scan.Close()
+	}
+	if s.batches == nil {
+		return nil
+	}
+	err := s.batches.Close()
+	s.batches = nil
+	return err
+}
+
+func (s *BatchScan) Children() []Operator { return nil }
+func (s *BatchScan) String() string {
+	if s.scan != nil {
+		return "Vectorized " + s.scan.String()
+	}
+	return fmt.Sprintf("Vectorized Scan %s (%s)", scanName(s.table, s.Alias), strings.Join(s.names, ", "))
+}
+
+// BatchFilter selects the rows of each batch for which the predicate is true.
+type BatchFilter struct {
+	child     BatchOperator
+	predicate VectorExpression
+	rows      batchRows
+}
+
+func NewBatchFilter(child BatchOperator, predicate VectorExpression) *BatchFilter {
+	return &BatchFilter{child: child, predicate: predicate}
+}
+
+func (f *BatchFilter) Open(ctx context.Context) error {
+	f.rows = batchRows{}
+	return f.child.Open(ctx)
+}
+
+func (f *BatchFilter) NextBatch() (*object.Batch, error) {
+	for {
+		b, err := f.child.NextBatch()
+		if err != nil || b == nil {
+			return nil, err
+		}
+		v, err := f.predicate.EvalBatch(b, b.Selection)
+		if err != nil {
+			return nil, err
+		}
+		selection := make([]int, 0, len(b.Selection))
+		if v.Type == object.BOOLEAN_OBJ && v.Nulls == nil {
+			for _, i := range b.Selection {
+				if v.Booleans[i] {
+					selection = append(selection, i)
+				}
+			}
+		} else {
+			for _, i := range b.Selection {
+				value := v.Value(i)
+				include, ok := value.(*object.Boolean)
+				if !ok {
+					return nil, object.Errorf(object.CodeDatatypeMismatch, "argument of WHERE must be type boolean, not type %s: %s", strings.ToLower(string(value.Type())), value.Inspect())
+				}
+				if include.Value {
+					selection = append(selection, i)
+				}
+			}
+		}
+		if len(selection) == 0 {
+			continue
+		}
+		filtered := *b
+		filtered.Selection = selection
+		return &filtered, nil
+	}
+}
+
+func (f *BatchFilter) Next() (*object.Row, error) { return f.rows.next(f) }
+func (f *BatchFilter) Close() error               { return f.child.Close() }
+func (f *BatchFilter) Children() []Operator       { return []Operator{f.child} }
+func (f *BatchFilter) String() string             { return "Vectorized Filter " + f.predicate.String() }
+
+// BatchProject evaluates a list of expressions for each batch of its child.
+type BatchProject struct {
+	child       BatchOperator
+	expressions []VectorExpression
+	aliases     []string
+	rows        batchRows
+}
+
+func NewBatchProject(child BatchOperator, expressions []VectorExpression, aliases []string) *BatchProject {
+	return &BatchProject{child: child, expressions: expressions, aliases: aliases}
+}
+
+func (p *BatchProject) Open(ctx context.Context) error {
+	p.rows = batchRows{}
+	return p.child.Open(ctx)
+}
+
+func (p *BatchProject) NextBatch() (*object.Batch, error) {
+	b, err := p.child.NextBatch()
+	if err != nil || b == nil {
+		return nil, err
+	}
+	projected := &object.Batch{
+		Length:    b.Length,
+		Vectors:   make([]*object.Vector, len(p.expressions)),
+		Selection: b.Selection,
+		Aliases:   p.aliases,
+	}
+	for i, e := range p.expressions {
+		if projected.Vectors[i], err = e.EvalBatch(b, b.Selection); err != nil {
+			return nil, err
+		}
+	}
+	return projected, nil
+}
+
+func (p *BatchProject) Next() (*object.Row, error) { return p.rows.next(p) }
+func (p *BatchProject) Close() error               { return p.child.Close() }
+func (p *BatchProject) Children() []Operator       { return []Operator{p.child} }
+func (p *BatchProject) String() string {
+	expressions := make([]string, len(p.expressions))
+	for i, e := range p.expressions {
+		expressions[i] = e.String()
+	}
+	return "Vectorized Project " + strings.Join(expressions, ", ")
+}
+
+// BatchAggregate is Aggregate for a child returning batches. The group expressions and the arguments
+// of the aggregate functions must be VectorExpressions. It returns the same rows as Aggregate.
+type BatchAggregate struct {
+	Aggregate
+	child BatchOperator
+}
+
+func NewBatchAggregate(child BatchOperator, groupBy []VectorExpression, aggregates []AggregateFunction) *BatchAggregate {
+	expressions := make([]Expression, len(groupBy))
+	for i, e := range groupBy {
+		expressions[i] = e
+	}
+	return &BatchAggregate{Aggregate: Aggregate{child: child, groupBy: expressions, aggregates: aggregates}, child: child}
+}
+
+func (a *BatchAggregate) Next() (*object.Row, error) {
+	if !a.done {
+		if err := a.aggregate(); err != nil {
+			return nil, err
+		}
+	}
+	if a.position >= len(a.rows) {
+		return nil, nil
+	}
+	row := a.rows[a.position]
+	a.position++
+	return row, nil
+}
+
+func (a *BatchAggregate) aggregate() error {
+	groups, err := a.groups()
+	if err != nil {
+		return err
+	}
+	return a.setGroups(groups)
+}
+
+// groups reads the batches, and for each batch finds the positions of the rows of each group,
+// and adds the values of the arguments at those positions to the accumulators of the group
+func (a *BatchAggregate) groups() ([]*group, error) {
+	var groups []*group
+	groupIndex := make(map[string]*group)
+	// the groups of a single INTEGER or STRING group expression are also found by the value,
+	// without making its group key
+	integerGroups := make(map[int64]*group)
+	stringGroups := make(map[string]*group)
+	var nullGroup *group
+	positions := make(map[*group][]int)
+	var order []*group
+	keys := make([]*object.Vector, len(a.groupBy))
+	arguments := make([]*object.Vector, len(a.aggregates))
+	for {
+		b, err := a.child.NextBatch()
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			break
+		}
+		for i, e := range a.groupBy {
+			if keys[i], err = e.(VectorExpression).EvalBatch(b, b.Selection); err != nil {
+				return nil, err
+			}
+		}
+		for i, f := range a.aggregates {
+			if f.Argument == nil {
+				arguments[i] = nil
+				continue
+			}
+			if arguments[i], err = f.Argument.(VectorExpression).EvalBatch(b, b.Selection); err != nil {
+				return nil, err
+			}
+		}
+		order = order[:0]
+		if len(a.groupBy) == 0 {
+			if len(groups) == 0 {
+				g, err := a.newGroup(nil)
+				if err != nil {
+					return nil, err
+				}
+				groups = append(groups, g)
+			}
+			positions[groups[0]] = b.Selection
+			order = append(order, groups[0])
+		} else {
+			values := make([]object.Object, len(keys))
+			find := func(i int) (*group, error) {
+				for j, v := range keys {
+					values[j] = v.Value(i)
+				}
+				key := GroupKey(values)
+				g, ok := groupIndex[key]
+				if !ok {
+					g, err = a.newGroup(append([]object.Object(nil), values...))
+					if err != nil {
+						return nil, err
+					}
+					groupIndex[key] = g
+					groups = append(groups, g)
+				}
+				return g, nil
+			}
+			single := keys[0]
+			if len(keys) > 1 {
+				single = &object.Vector{}
+			}
+			for _, i := range b.Selection {
+				var g *group
+				var ok bool
+				switch {
+				case single.Nulls != nil && single.Nulls[i]:
+					if g = nullGroup; g == nil {
+						g, err = find(i)
+						nullGroup = g
+					}
+				case single.Type == object.INTEGER_OBJ:
+					if g, ok = integerGroups[single.Integers[i]]; !ok {
+						g, err = find(i)
+						integerGroups[single.Integers[i]] = g
+					}
+				case single.Type == object.STRING_OBJ:
+					if g, ok = stringGroups[single.Strings[i]]; !ok {
+						g, err = find(i)
+						stringGroups[single.Strings[i]] = g
+					}
+				default:
+					g, err = find(i)
+				}
+				if err != nil {
+					return nil, err
+				}
+				if len(positions[g]) == 0 {
+					order = append(order, g)
+				}
+				positions[g] = append(positions[g], i)
+			}
+		}
+		for _, g := range order {
+			for i, acc := range g.accumulators {
+				if err := addVector(acc, arguments[i], positions[g]); err != nil {
+					return nil, err
+				}
+			}
+			if len(a.groupBy) > 0 {
+				positions[g] = positions[g][:0]
+			}
+		}
+	}
+	return groups, nil
+}
+
+func (a *BatchAggregate) Children() []Operator { return []Operator{a.child} }
+func (a *BatchAggregate) String() string       { return "Vectorized " + a.Aggregate.String() }
+
+// vectorAccumulator is implemented by accumulators that can add the values of a vector at once.
+// The vector is nil for count(*).
+type vectorAccumulator interface {
+	addVector(v *object.Vector, selection []int) error
+}
+
+// addVector adds the values of the vector at the positions of the selection to the accumulator
+func addVector(acc accumulator, v *object.Vector, selection []int) error {
+	if va, ok := acc.(vectorAccumulator); ok {
+		return va.addVector(v, selection)
+	}
+	for _, i := range selection {
+		value := object.Object(object.NULL)
+		if v != nil {
+			value = v.Value(i)
+		}
+		if err := acc.add(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *countAccumulator) addVector(v *object.Vector, selection []int) error {
+	if c.star || v.Type != object.NULL_OBJ && v.Objects == nil && v.Nulls == nil {
+		c.count += int64(len(selection))
+		return nil
+	}
+	for _, i := range selection {
+		if !v.IsNull(i) {
+			c.count++
+		}
+	}
+	return nil
+}
+
+func (s *sumAccumulator) addVector(v *object.Vector, selection []int) error {
+	switch {
+	case v.Type == object.INTEGER_OBJ:
+		for _, i := range selection {
+			if v.Nulls == nil || !v.Nulls[i] {
+				s.integerSum += v.Integers[i]
+				s.floatSum += float64(v.Integers[i])
+				s.seen = true
+			}
+		}
+	case v.Type == object.FLOAT_OBJ:
+		for _, i := range selection {
+			if v.Nulls == nil || !v.Nulls[i] {
+				s.floatSum += v.Floats[i]
+				s.isFloat = true
+				s.seen = true
+			}
+		}
+	default:
+		for _, i := range selection {
+			if err := s.add(v.Value(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (a *avgAccumulator) addVector(v *object.Vector, selection []int) error {
+	switch {
+	case v.Type == object.INTEGER_OBJ:
+		for _, i := range selection {
+			if v.Nulls == nil || !v.Nulls[i] {
+				a.integerSum += v.Integers[i]
+				a.sum += float64(v.Integers[i])
+				a.count++
+			}
+		}
+	case v.Type == object.FLOAT_OBJ:
+		for _, i := range selection {
+			if v.Nulls == nil || !v.Nulls[i] {
+				a.sum += v.Floats[i]
+				a.isFloat = true
+				a.count++
+			}
+		}
+	default:
+		for _, i := range selection {
+			if err := a.add(v.Value(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// addVector finds the smallest or largest value of the vector, and adds only that value
+func (e *extremeAccumulator) addVector(v *object.Vector, selection []int) error {
+	best := -1
+	switch v.Type {
+	case object.INTEGER_OBJ:
+		for _, i := range selection {
+			if (v.Nulls == nil || !v.Nulls[i]) && (best == -1 || e.keep(compareInt64(v.Integers[i], v.Integers[best]))) {
+				best = i
+			}
+		}
+	case object.FLOAT_OBJ:
+		for _, i := range selection {
+			if (v.Nulls == nil || !v.Nulls[i]) && (best == -1 || e.keep(compareFloat64(v.Floats[i], v.Floats[best]))) {
+				best = i
+			}
+		}
+	case object.STRING_OBJ:
+		for _, i := range selection {
+			if (v.Nulls == nil || !v.Nulls[i]) && (best == -1 || e.keep(strings.Compare(v.Strings[i], v.Strings[best]))) {
+				best = i
+			}
+		}
+	default:
+		for _, i := range selection {
+			if err := e.add(v.Value(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if best == -1 {
+		return nil
+	}
+	return e.add(v.Value(best))
+}
+
+// InstrumentedBatch is Instrumented for a batch operator, and counts the selected rows of the batches it returns.
+type InstrumentedBatch struct {
+	BatchOperator
+	Rows    int
+	Elapsed time.Duration
+	rows    batchRows
+}
+
+func NewInstrumentedBatch(op BatchOperator) *InstrumentedBatch {
+	return &InstrumentedBatch{BatchOperator: op}
+}
+
+func (i *InstrumentedBatch) Open(ctx context.Context) error {
+	start := time.Now()
+	i.rows = batchRows{}
+	err := i.BatchOperator.Open(ctx)
+	i.Elapsed += time.Since(start)
+	return err
+}
+
+func (i *InstrumentedBatch) NextBatch() (*object.Batch, error) {
+	start := time.Now()
+	b, err := i.BatchOperator.NextBatch()
+	i.Elapsed += time.Since(start)
+	if b != nil {
+		i.Rows += len(b.Selection)
+	}
+	return b, err
+}
+
+func (i *InstrumentedBatch) Next() (*object.Row, error) { return i.rows.next(i) }
+
+func (i *InstrumentedBatch) Close() error {
+	start := time.Now()
+	err := i.BatchOperator.Close()
+	i.Elapsed += time.Since(start)
+	return err
+}
@@ -0,0 +1,323 @@
+// Package executor contains the physical operators a query is executed with.
+//
+// Operators are composed into a tree, where each operator pulls rows from its children
+// by calling Next until it returns a nil row. A plan is run by calling Open on the root,
+// then Next until the rows are exhausted, and finally Close.
+//
+// The context given to Open is checked as rows are read, so that a query stops with the
+// error of the context once it is cancelled or its deadline is exceeded.
+package executor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/vegarsti/sql/object"
+)
+
+// Operator is a node in a query plan.
+type Operator interface {
+	Open(context.Context) error
+	// Next returns the next row, or nil when there are no more rows.
+	Next() (*object.Row, error)
+	Close() error
+	// Children returns the operators this operator reads rows from.
+	Children() []Operator
+	// String describes the operator, without its children.
+	String() string
+}
+
+// Expression is an expression that can be evaluated for a row.
+// Evaluation errors are returned as *object.Error.
+type Expression interface {
+	Eval(row object.Row) object.Object
+	String() string
+}
+
+// Scanner is the part of a storage backend needed to read tables.
+type Scanner interface {
+	Scan(string) (object.RowIterator, error)
+}
+
+// ColumnScanner is the part of a storage backend needed to read some of the columns of tables.
+// The rows have the values of the columns at the given positions, in that order.
+type ColumnScanner interface {
+	ScanColumns(string, []int) (object.RowIterator, error)
+}
+
+// Run opens the operator, reads all rows and closes it.
+func Run(ctx context.Context, op Operator) ([]*object.Row, error) {
+	if err := op.Open(ctx); err != nil {
+		op.Close()
+		return nil, err
+	}
+	rows := make([]*object.Row, 0)
+	for {
+		row, err := op.Next()
+		if err != nil {
+			op.Close()
+			return nil, err
+		}
+		if row == nil {
+			break
+		}
+		rows = append(rows, row)
+	}
+	if err := op.Close(); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// checkContext returns the error of the context if it is done, without waiting for it
+func checkContext(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return nil
+	}
+}
+
+// Scan reads all rows of a table. It stops with the error of the context once the context is done,
+// which ends the operators above it, since rows are read from scans.
+type Scan struct {
+	// Alias is the name the query gives the table, if any, which is shown after the table name
+	Alias   string
+	backend Scanner
+	table   string
+	ctx     context.Context
+	rows    object.RowIterator
+	// columnScanner is set for a scan of the columns at the positions in columns, whose names are in names
+	columnScanner ColumnScanner
+	columns       []int
+	names         []string
+	// partitions is set for a scan of one of the partitions of a table, the one at position partition
+	partitions *Partitions
+	partition  int
+}
+
+func NewScan(backend Scanner, table string) *Scan {
+	return &Scan{backend: backend, table: table}
+}
+
+// NewColumnScan returns a scan of some of the columns of the table, given by their positions and names.
+func NewColumnScan(backend ColumnScanner, table string, columns []int, names []string) *Scan {
+	return &Scan{columnScanner: backend, table: table, columns: columns, names: names}
+}
+
+// NewPartitionScan returns a scan of the partition at the position, for a worker of a parallel operator.
+func NewPartitionScan(partitions *Partitions, partition int) *Scan {
+	return &Scan{partitions: partitions, table: partitions.table, partition: partition}
+}
+
+func (s *Scan) Open(ctx context.Context) error {
+	var rows object.RowIterator
+	var err error
+	if s.partitions != nil {
+		rows, err = s.partitions.take(s.partition)
+	} else if s.columnScanner != nil {
+		rows, err = s.columnScanner.ScanColumns(s.table, s.columns)
+	} else {
+		rows, err = s.backend.Scan(s.table)
+	}
+	if err != nil {
+		return err
+	}
+	s.ctx = ctx
+	s.rows = rows
+	return nil
+}
+
+func (s *Scan) Next() (*object.Row, error) {
+	if err := checkContext(s.ctx); err != nil {
+		return nil, err
+	}
+	return s.rows.Next()
+}
+
+func (s *Scan) Close() error {
+	if s.rows == nil {
+		return nil
+	}
+	err := s.rows.Close()
+	s.rows = nil
+	return err
+}
+
+func (s *Scan) Children() []Operator { return nil }
+func (s *Scan) String() string {
+	if s.partitions != nil {
+		return fmt.Sprintf("%s partition %d of %d", s.partitions, s.partition+1, s.partitions.n)
+	}
+	if s.columnScanner != nil {
+		return fmt.Sprintf("Scan %s (%s)", scanName(s.table, s.Alias), strings.Join(s.names, ", "))
+	}
+	return "Scan " + scanName(s.table, s.Alias)
+}
+
+// scanName is the table of a scan, followed by its alias if it has one
+func scanName(table string, alias string) string {
+	if alias == "" {
+		return table
+	}
+	return table + " " + alias
+}
+
+// Values returns a fixed set of rows. A select without FROM reads a single empty row from it.
+type Values struct {
+	rows     []object.Row
+	position int
+}
+
+func NewValues(rows []object.Row) *Values {
+	return &Values{rows: rows}
+}
+
+func (v *Values) Open(ctx context.Context) error {
+	v.position = 0
+	return nil
+}
+
+func (v *Values) Next() (*object.Row, error) {
+	if v.position >= len(v.rows) {
+		return nil, nil
+	}
+	row := v.rows[v.position]
+	v.position++
+	return &row, nil
+}
+
+func (v *Values) Close() error         { return nil }
+func (v *Values) Children() []Operator { return nil }
+func (v *Values) String() string       { return fmt.Sprintf("Values (%d rows)", len(v.rows)) }
+
+// Filter returns the rows of its child for which the predicate is true.
+type Filter struct {
+	child     Operator
+	predicate Expression
+}
+
+func NewFilter(child Operator, predicate Expression) *Filter {
+	return &Filter{child: child, predicate: predicate}
+}
+
+func (f *Filter) Open(ctx context.Context) error { return f.child.Open(ctx) }
+
+func (f *Filter) Next() (*object.Row, error) {
+	for {
+		row, err := f.child.Next()
+		if err != nil || row == nil {
+			return nil, err
+		}
+		v := f.predicate.Eval(*row)
+		if errorObj, ok := v.(*object.Error); ok {
+			return nil, errorObj
+		}
+		include, ok := v.(*object.Boolean)
+		if !ok {
+			return nil, object.Errorf(object.CodeDatatypeMismatch, "argument of WHERE must be type boolean, not type %s: %s", strings.ToLower(string(v.Type())), v.Inspect())
+		}
+		if include.Value {
+			return row, nil
+		}
+	}
+}
+
+func (f *Filter) Close() error         { return f.child.Close() }
+func (f *Filter) Children() []Operator { return []Operator{f.child} }
+func (f *Filter) String() string       { return "Filter " + f.predicate.String() }
+
+// Project evaluates a list of expressions for each row of its child.
+type Project struct {
+	child       Operator
+	expressions []Expression
+	aliases     []string
+}
+
+func NewProject(child Operator, expressions []Expression, aliases []string) *Project {
+	return &Project{child: child, expressions: expressions, aliases: aliases}
+}
+
+func (p *Project) Open(ctx context.Context) error { return p.child.Open(ctx) }
+
+func (p *Project) Next() (*object.Row, error) {
+	row, err := p.child.Next()
+	if err != nil || row == nil {
+		return nil, err
+	}
+	projected := &object.Row{
+		Aliases: p.aliases,
+		Values:  make([]object.Object, len(p.expressions)),
+	}
+	for i, e := range p.expressions {
+		v := e.Eval(*row)
+		if errorObj, ok := v.(*object.Error); ok {
+			return nil, errorObj
+		}
+		projected.Values[i] = v
+	}
+	return projected, nil
+}
+
+func (p *Project) Close() error         { return p.child.Close() }
+func (p *Project) Children() []Operator { return []Operator{p.child} }
+func (p *Project) String() string {
+	expressions := make([]string, len(p.expressions))
+	for i, e := range p.expressions {
+		expressions[i] = e.String()
+	}
+	return "Project " + strings.Join(expressions, ", ")
+}
+
+// Limit skips the first offset rows of its child, and then returns at most limit rows.
+// A nil limit means no limit.
+type Limit struct {
+	child    Operator
+	limit    *int
+	offset   int
+	returned int
+	skipped  int
+}
+
+func NewLimit(child Operator, limit *int, offset int) *Limit {
+	return &Limit{child: child, limit: limit, offset: offset}
+}
+
+func (l *Limit) Open(ctx context.Context) error {
+	l.returned = 0
+	l.skipped = 0
+	return l.child.Open(ctx)
+}
+
+func (l *Limit) Next() (*object.Row, error) {
+	if l.limit != nil && l.returned >= *l.limit {
+		return nil, nil
+	}
+	for l.skipped < l.offset {
+		row, err := l.child.Next()
+		if err != nil || row == nil {
+			return nil, err
+		}
+		l.skipped++
+	}
+	row, err := l.child.Next()
+	if err != nil || row == nil {
+		return nil, err
+	}
+	l.returned++
+	return row, nil
+}
+
+func (l *Limit) Close() error         { return l.child.Close() }
+func (l *Limit) Children() []Operator { return []Operator{l.child} }
+func (l *Limit) String() string {
+	if l.limit == nil {
+		return fmt.Sprintf("Limit offset %d", l.offset)
+	}
+	if l.offset == 0 {
+		return fmt.Sprintf("Limit %d", *l.limit)
+	}
+	return fmt.Sprintf("Limit %d offset %d", *l.limit, l.offset)
+}
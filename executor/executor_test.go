@@ -0,0 +1,739 @@
+package executor_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/vegarsti/sql/backendtest"
+	"github.com/vegarsti/sql/executor"
+	"github.com/vegarsti/sql/inmemory"
+	"github.com/vegarsti/sql/object"
+)
+
+// column is an expression returning the value of the column with the given name
+type column string
+
+func (c column) Eval(row object.Row) object.Object {
+	for i, alias := range row.Aliases {
+		if alias == string(c) {
+			return row.Values[i]
+		}
+	}
+	return &object.Error{Message: "no such column: " + string(c)}
+}
+
+func (c column) String() string { return string(c) }
+
+// predicate is an expression evaluating a Go function
+type predicate struct {
+	description string
+	f           func(object.Row) object.Object
+}
+
+func (p predicate) Eval(row object.Row) object.Object { return p.f(row) }
+func (p predicate) String() string                    { return p.description }
+
+func greaterThan(c string, n int64) predicate {
+	return predicate{
+		description: c + " > " + strconv.FormatInt(n, 10),
+		f: func(row object.Row) object.Object {
+			v := column(c).Eval(row)
+			if v.Type() == object.ERROR_OBJ {
+				return v
+			}
+			return &object.Boolean{Value: v.(*object.Integer).Value > n}
+		},
+	}
+}
+
+func testBackend(t *testing.T) *inmemory.Backend {
+	backend := inmemory.NewBackend()
+	backendtest.MustCreateTable(t, backend, "numbers", []object.Column{
+		{Name: "n", Type: object.INTEGER},
+		{Name: "parity", Type: object.STRING},
+	})
+	for i := 1; i <= 5; i++ {
+		parity := "odd"
+		if i%2 == 0 {
+			parity = "even"
+		}
+		backendtest.MustInsert(t, backend, "numbers", object.Row{
+			Values:    []object.Object{&object.Integer{Value: int64(i)}, &object.String{Value: parity}},
+			Aliases:   []string{"n", "parity"},
+			TableName: []string{"numbers", "numbers"},
+		})
+	}
+	backendtest.MustCreateTable(t, backend, "letters", []object.Column{{Name: "letter", Type: object.STRING}})
+	for _, letter := range []string{"a", "b"} {
+		backendtest.MustInsert(t, backend, "letters", object.Row{
+			Values:    []object.Object{&object.String{Value: letter}},
+			Aliases:   []string{"letter"},
+			TableName: []string{"letters"},
+		})
+	}
+	return backend
+}
+
+func mustColumns(t *testing.T, backend *inmemory.Backend, name string) []object.Column {
+	columns, err := backend.Columns(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return columns
+}
+
+func intPointer(n int) *int { return &n }
+
+func TestOperators(t *testing.T) {
+	backend := testBackend(t)
+	tests := []struct {
+		name     string
+		plan     executor.Operator
+		expected []string
+	}{
+		{
+			"scan",
+			executor.NewScan(backend, "numbers"),
+			[]string{"1\t'odd'", "2\t'even'", "3\t'odd'", "4\t'even'", "5\t'odd'"},
+		},
+		{
+			"values",
+			executor.NewValues([]object.Row{{Values: []object.Object{&object.Integer{Value: 1}}}}),
+			[]string{"1"},
+		},
+		{
+			"filter",
+			executor.NewFilter(executor.NewScan(backend, "numbers"), greaterThan("n", 3)),
+			[]string{"4\t'even'", "5\t'odd'"},
+		},
+		{
+			"project",
+			executor.NewProject(executor.NewScan(backend, "numbers"), []executor.Expression{column("parity"), column("n")}, []string{"parity", "n"}),
+			[]string{"'odd'\t1", "'even'\t2", "'odd'\t3", "'even'\t4", "'odd'\t5"},
+		},
+		{
+			"limit",
+			executor.NewLimit(executor.NewScan(backend, "numbers"), intPointer(2), 0),
+			[]string{"1\t'odd'", "2\t'even'"},
+		},
+		{
+			"limit with offset",
+			executor.NewLimit(executor.NewScan(backend, "numbers"), intPointer(2), 2),
+			[]string{"3\t'odd'", "4\t'even'"},
+		},
+		{
+			"offset without limit",
+			executor.NewLimit(executor.NewScan(backend, "numbers"), nil, 4),
+			[]string{"5\t'odd'"},
+		},
+		{
+			"sort",
+			executor.NewSort(executor.NewScan(backend, "numbers"), []executor.SortKey{{Expression: column("n"), Descending: true}}, nil),
+			[]string{"5\t'odd'", "4\t'even'", "3\t'odd'", "2\t'even'", "1\t'odd'"},
+		},
+		{
+			"sort is stable",
+			executor.NewSort(executor.NewScan(backend, "numbers"), []executor.SortKey{{Expression: column("parity")}}, nil),
+			[]string{"2\t'even'", "4\t'even'", "1\t'odd'", "3\t'odd'", "5\t'odd'"},
+		},
+		{
+			"cross join",
+			executor.NewNestedLoopJoin(
+				executor.NewLimit(executor.NewScan(backend, "numbers"), intPointer(2), 0),
+				executor.NewScan(backend, "letters"),
+				nil,
+			),
+			[]string{"1\t'odd'\t'a'", "1\t'odd'\t'b'", "2\t'even'\t'a'", "2\t'even'\t'b'"},
+		},
+		{
+			"join with predicate",
+			executor.NewNestedLoopJoin(executor.NewScan(backend, "letters"), executor.NewScan(backend, "numbers"), greaterThan("n", 4)),
+			[]string{"'a'\t5\t'odd'", "'b'\t5\t'odd'"},
+		},
+		{
+			"hash join",
+			executor.NewHashJoin(
+				executor.NewLimit(executor.NewScan(backend, "numbers"), intPointer(2), 0),
+				executor.NewScan(backend, "numbers"),
+				[]executor.Expression{column("parity")},
+				[]executor.Expression{column("parity")},
+				nil,
+				nil,
+			),
+			[]string{"1\t'odd'\t1\t'odd'", "1\t'odd'\t3\t'odd'", "1\t'odd'\t5\t'odd'", "2\t'even'\t2\t'even'", "2\t'even'\t4\t'even'"},
+		},
+		{
+			"hash join on integers that are the same as floats",
+			executor.NewHashJoin(
+				executor.NewValues(largeIntegers()),
+				executor.NewValues(largeIntegers()),
+				[]executor.Expression{column("k")},
+				[]executor.Expression{column("k")},
+				nil,
+				nil,
+			),
+			[]string{"9007199254740992\t9007199254740992", "9007199254740993\t9007199254740993"},
+		},
+		{
+			"hash join with residual predicate",
+			executor.NewHashJoin(
+				executor.NewLimit(executor.NewScan(backend, "numbers"), intPointer(2), 0),
+				executor.NewScan(backend, "numbers"),
+				[]executor.Expression{column("parity")},
+				[]executor.Expression{column("parity")},
+				greaterThan("n", 1),
+				nil,
+			),
+			[]string{"2\t'even'\t2\t'even'", "2\t'even'\t4\t'even'"},
+		},
+		{
+			"aggregate without groups",
+			executor.NewAggregate(executor.NewScan(backend, "numbers"), nil, []executor.AggregateFunction{
+				{Name: "count"},
+				{Name: "sum", Argument: column("n")},
+				{Name: "min", Argument: column("parity")},
+				{Name: "max", Argument: column("n")},
+				{Name: "avg", Argument: column("n")},
+			}),
+			[]string{"5\t15\t'even'\t5\t3.000000"},
+		},
+		{
+			"aggregate of no rows",
+			executor.NewAggregate(executor.NewFilter(executor.NewScan(backend, "numbers"), greaterThan("n", 9)), nil, []executor.AggregateFunction{
+				{Name: "count"},
+				{Name: "sum", Argument: column("n")},
+			}),
+			[]string{"0\tnull"},
+		},
+		{
+			"aggregate with groups",
+			executor.NewAggregate(executor.NewScan(backend, "numbers"), []executor.Expression{column("parity")}, []executor.AggregateFunction{
+				{Name: "count", Argument: column("n")},
+				{Name: "sum", Argument: column("n")},
+			}),
+			[]string{"'odd'\t3\t9", "'even'\t2\t6"},
+		},
+		{
+			"aggregate of no rows with groups",
+			executor.NewAggregate(executor.NewFilter(executor.NewScan(backend, "numbers"), greaterThan("n", 9)), []executor.Expression{column("parity")}, []executor.AggregateFunction{
+				{Name: "count"},
+			}),
+			[]string{},
+		},
+	}
+	for _, tt := range tests {
+		rows, err := executor.Run(context.Background(), tt.plan)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got := make([]string, len(rows))
+		for i, row := range rows {
+			got[i] = row.Inspect()
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.expected, "\n") {
+			t.Fatalf("%s: expected rows\n%s\ngot\n%s", tt.name, strings.Join(tt.expected, "\n"), strings.Join(got, "\n"))
+		}
+	}
+}
+
+// largeIntegers returns rows with the integers 2^53 and 2^53 + 1, which are the same as floats
+func largeIntegers() []object.Row {
+	return []object.Row{
+		{Aliases: []string{"k"}, Values: []object.Object{&object.Integer{Value: 1 << 53}}, TableName: []string{"t"}},
+		{Aliases: []string{"k"}, Values: []object.Object{&object.Integer{Value: 1<<53 + 1}}, TableName: []string{"t"}},
+	}
+}
+
+func TestOperatorErrors(t *testing.T) {
+	backend := testBackend(t)
+	tests := []struct {
+		name          string
+		plan          executor.Operator
+		expectedError string
+	}{
+		{
+			"missing table",
+			executor.NewScan(backend, "foo"),
+			`relation "foo" does not exist`,
+		},
+		{
+			"filter on non-boolean",
+			executor.NewFilter(executor.NewScan(backend, "numbers"), column("n")),
+			"argument of WHERE must be type boolean, not type integer: 1",
+		},
+		{
+			"join on non-boolean",
+			executor.NewNestedLoopJoin(executor.NewScan(backend, "numbers"), executor.NewScan(backend, "letters"), column("letter")),
+			"join condition must be of type boolean, not STRING: 'a'",
+		},
+		{
+			"error in projection",
+			executor.NewProject(executor.NewScan(backend, "numbers"), []executor.Expression{column("m")}, []string{"m"}),
+			"no such column: m",
+		},
+		{
+			"error with a percent sign",
+			executor.NewProject(executor.NewScan(backend, "numbers"), []executor.Expression{column("m%d")}, []string{"m"}),
+			"no such column: m%d",
+		},
+		{
+			"sum of strings",
+			executor.NewAggregate(executor.NewScan(backend, "numbers"), nil, []executor.AggregateFunction{{Name: "sum", Argument: column("parity")}}),
+			"function sum(STRING) does not exist",
+		},
+	}
+	for _, tt := range tests {
+		_, err := executor.Run(context.Background(), tt.plan)
+		if err == nil {
+			t.Fatalf("%s: expected error", tt.name)
+		}
+		if err.Error() != tt.expectedError {
+			t.Fatalf("%s: expected error %q. got=%q", tt.name, tt.expectedError, err.Error())
+		}
+	}
+}
+
+func numeric(s string) *object.Numeric {
+	n, err := object.ParseNumeric(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+func TestGroupKey(t *testing.T) {
+	tests := []struct {
+		a     object.Object
+		b     object.Object
+		equal bool
+	}{
+		{&object.Integer{Value: 1}, &object.Float{Value: 1}, true},
+		{&object.Integer{Value: 1}, &object.Float{Value: 1.5}, false},
+		{&object.Integer{Value: 1 << 53}, &object.Integer{Value: 1<<53 + 1}, false},
+		{&object.Integer{Value: 1<<53 + 1}, &object.Float{Value: 1 << 53}, false},
+		{&object.Integer{Value: 1 << 53}, &object.Float{Value: 1 << 53}, true},
+		{&object.Integer{Value: 1}, &object.String{Value: "1"}, false},
+		{&object.Date{Value: 1}, &object.Timestamp{Value: object.MicrosecondsPerDay}, true},
+		{&object.Date{Value: 1}, &object.TimestampTZ{Value: object.MicrosecondsPerDay}, true},
+		{&object.Date{Value: 1}, &object.Timestamp{Value: object.MicrosecondsPerDay + 1}, false},
+		{&object.Timestamp{Value: 5}, &object.TimestampTZ{Value: 5}, true},
+		{&object.Time{Value: 5}, &object.Timestamp{Value: 5}, false},
+		{&object.Date{Value: 1}, &object.Integer{Value: 1}, false},
+		{&object.Integer{Value: 5}, numeric("5.00"), true},
+		{&object.Integer{Value: 0}, numeric("-0.00"), true},
+		{&object.Float{Value: 1.5}, numeric("1.50"), true},
+		{&object.Float{Value: 0.1}, numeric("0.1"), true},
+		{&object.Float{Value: 1e-7}, numeric("0.0000001"), true},
+		{&object.Float{Value: 0.30000000000000004}, numeric("0.3"), false},
+		{&object.Float{Value: 1e20}, numeric("100000000000000000000"), true},
+	}
+	for _, tt := range tests {
+		a := executor.GroupKey([]object.Object{tt.a})
+		b := executor.GroupKey([]object.Object{tt.b})
+		if (a == b) != tt.equal {
+			t.Errorf("%s and %s: expected equal keys to be %t. got keys %q and %q", tt.a.Inspect(), tt.b.Inspect(), tt.equal, a, b)
+		}
+		c, err := executor.Compare(tt.a, tt.b)
+		if err == nil && (c == 0) != tt.equal {
+			t.Errorf("%s and %s: expected comparison to be equal to be %t. got %d", tt.a.Inspect(), tt.b.Inspect(), tt.equal, c)
+		}
+	}
+}
+
+func TestPlanString(t *testing.T) {
+	plan := executor.NewProject(
+		executor.NewLimit(
+			executor.NewFilter(executor.NewScan(testBackend(t), "numbers"), greaterThan("n", 3)),
+			intPointer(1), 0,
+		),
+		[]executor.Expression{column("n")},
+		[]string{"n"},
+	)
+	var describe func(executor.Operator) []string
+	describe = func(op executor.Operator) []string {
+		lines := []string{op.String()}
+		for _, child := range op.Children() {
+			lines = append(lines, describe(child)...)
+		}
+		return lines
+	}
+	expected := "Project n, Limit 1, Filter n > 3, Scan numbers"
+	got := strings.Join(describe(plan), ", ")
+	if got != expected {
+		t.Fatalf("expected plan %q. got=%q", expected, got)
+	}
+}
+
+func TestComputeStatistics(t *testing.T) {
+	backend := testBackend(t)
+	backendtest.MustInsert(t, backend, "numbers", object.Row{
+		Values:    []object.Object{object.NULL, &object.String{Value: "even"}},
+		Aliases:   []string{"n", "parity"},
+		TableName: []string{"numbers", "numbers"},
+	})
+	it, err := backend.Scan("numbers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := executor.ComputeStatistics(context.Background(), mustColumns(t, backend, "numbers"), it)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.RowCount != 6 {
+		t.Fatalf("expected 6 rows. got=%d", stats.RowCount)
+	}
+	expected := []string{"n 5 0.17 1 5", "parity 2 0.00 'even' 'odd'"}
+	for i, c := range stats.Columns {
+		got := fmt.Sprintf("%s %d %.2f %s %s", c.Name, c.DistinctCount, c.NullFraction, c.Min.Inspect(), c.Max.Inspect())
+		if got != expected[i] {
+			t.Fatalf("expected statistics %q. got=%q", expected[i], got)
+		}
+	}
+}
+
+func TestComputeStatisticsEstimatesDistinct(t *testing.T) {
+	const n = 100000
+	rows := make([]object.Row, 2*n)
+	for i := range rows {
+		// every value appears twice
+		rows[i] = object.Row{Values: []object.Object{&object.Integer{Value: int64(i % n)}}}
+	}
+	stats, err := executor.ComputeStatistics(context.Background(), []object.Column{{Name: "n", Type: object.INTEGER}}, object.NewSliceIterator(rows))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := stats.Columns[0].DistinctCount
+	if got < n*9/10 || got > n*11/10 {
+		t.Fatalf("expected around %d distinct values. got=%d", n, got)
+	}
+}
+
+// pairs returns rows with the columns a and b, where a is i % m and b is i, so that many rows have the same a
+func pairs(n int, m int) []object.Row {
+	rows := make([]object.Row, n)
+	for i := range rows {
+		rows[i] = object.Row{
+			Values:    []object.Object{&object.Integer{Value: int64(i % m)}, &object.String{Value: strconv.Itoa(i)}},
+			Aliases:   []string{"a", "b"},
+			TableName: []string{"pairs", "pairs"},
+		}
+	}
+	return rows
+}
+
+// temporalRows returns n rows with a date in the column a, which repeats every m rows,
+// and values of the other temporal types in the other columns
+func temporalRows(n int, m int) []object.Row {
+	rows := make([]object.Row, n)
+	for i := range rows {
+		v := int64(i)
+		rows[i] = object.Row{
+			Values: []object.Object{
+				&object.Date{Value: v % int64(m)},
+				&object.Time{Value: v * object.MicrosecondsPerSecond},
+				&object.Timestamp{Value: -v * object.MicrosecondsPerDay},
+				&object.TimestampTZ{Value: v},
+				&object.Interval{Months: v % 12, Days: -v, Microseconds: v * 7},
+			},
+			Aliases:   []string{"a", "b", "c", "d", "e"},
+			TableName: []string{"temporal", "temporal", "temporal", "temporal", "temporal"},
+		}
+	}
+	return rows
+}
+
+// numericRows returns n rows with a numeric with two digits after the decimal point in the column a,
+// which repeats every m rows, and a numeric too large for an int64 in the column b
+func numericRows(n int, m int) []object.Row {
+	rows := make([]object.Row, n)
+	for i := range rows {
+		rows[i] = object.Row{
+			Values:    []object.Object{numeric(fmt.Sprintf("%d.00", i%m)), numeric(fmt.Sprintf("-1%025d.5", i))},
+			Aliases:   []string{"a", "b"},
+			TableName: []string{"numerics", "numerics"},
+		}
+	}
+	return rows
+}
+
+// midnights replaces the dates in the column a of the rows with the timestamps at their midnight
+func midnights(rows []object.Row) []object.Row {
+	for _, row := range rows {
+		microseconds, _ := object.PointInTime(row.Values[0])
+		row.Values[0] = &object.Timestamp{Value: microseconds}
+	}
+	return rows
+}
+
+func inspectRows(rows []*object.Row) []string {
+	inspected := make([]string, len(rows))
+	for i, row := range rows {
+		inspected[i] = row.Inspect()
+	}
+	return inspected
+}
+
+func countFiles(t *testing.T, dir string) int {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
+
+func TestSortSpills(t *testing.T) {
+	tests := []struct {
+		name string
+		rows func() []object.Row
+	}{
+		{"integers", func() []object.Row { return pairs(2000, 7) }},
+		{"temporal values", func() []object.Row { return temporalRows(2000, 7) }},
+		{"numerics", func() []object.Row { return numericRows(2000, 7) }},
+	}
+	for _, tt := range tests {
+		keys := []executor.SortKey{{Expression: column("a"), Descending: true}}
+		expected, err := executor.Run(context.Background(), executor.NewSort(executor.NewValues(tt.rows()), keys, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// a budget of a few rows gives more runs than are merged at once
+		dir := t.TempDir()
+		sort := executor.NewSort(executor.NewValues(tt.rows()), keys, executor.NewMemory(4000, dir))
+		if err := sort.Open(context.Background()); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var rows []*object.Row
+		for {
+			row, err := sort.Next()
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if row == nil {
+				break
+			}
+			rows = append(rows, row)
+		}
+		if countFiles(t, dir) == 0 {
+			t.Fatalf("%s: expected the sort to write rows to temporary files", tt.name)
+		}
+		if err := sort.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if n := countFiles(t, dir); n != 0 {
+			t.Fatalf("%s: expected the temporary files to be removed, got %d files", tt.name, n)
+		}
+		// equal rows are returned in the order they were read, as by the sort in memory
+		if got, want := inspectRows(rows), inspectRows(expected); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: expected the same rows as the sort in memory, got %d rows, first %v", tt.name, len(got), got[:5])
+		}
+	}
+}
+
+func TestHashJoinSpills(t *testing.T) {
+	tests := []struct {
+		name        string
+		left, right []object.Row
+	}{
+		{"many keys", pairs(600, 50), pairs(500, 40)},
+		// rows with the same key can't be spread over partitions, and are read into memory anyway
+		{"one key", pairs(30, 1), pairs(300, 1)},
+		{"dates", temporalRows(600, 50), temporalRows(500, 40)},
+		// dates are equal to the timestamps at their midnight
+		{"dates and timestamps", temporalRows(600, 50), midnights(temporalRows(500, 40))},
+		{"numerics", numericRows(600, 50), numericRows(500, 40)},
+		// numerics are equal to the integers with the same value
+		{"numerics and integers", numericRows(600, 50), pairs(500, 40)},
+	}
+	for _, tt := range tests {
+		join := func(memory *executor.Memory) []string {
+			plan := executor.NewHashJoin(
+				executor.NewValues(tt.left),
+				executor.NewValues(tt.right),
+				[]executor.Expression{column("a")},
+				[]executor.Expression{column("a")},
+				nil,
+				memory,
+			)
+			rows, err := executor.Run(context.Background(), plan)
+			if err != nil {
+				t.Fatal(err)
+			}
+			inspected := inspectRows(rows)
+			// the rows are not returned in the same order when the join spills
+			sort.Strings(inspected)
+			return inspected
+		}
+		dir := t.TempDir()
+		expected := join(nil)
+		if len(expected) == 0 {
+			t.Fatalf("%s: expected the join to return rows", tt.name)
+		}
+		if got := join(executor.NewMemory(2000, dir)); !reflect.DeepEqual(got, expected) {
+			t.Fatalf("%s: expected %d rows, as when joining in memory, got %d", tt.name, len(expected), len(got))
+		}
+		if n := countFiles(t, dir); n != 0 {
+			t.Fatalf("%s: expected the temporary files to be removed, got %d files", tt.name, n)
+		}
+	}
+}
+
+func TestTopN(t *testing.T) {
+	a := executor.SortKey{Expression: column("a")}
+	aDesc := executor.SortKey{Expression: column("a"), Descending: true}
+	bDesc := executor.SortKey{Expression: column("b"), Descending: true}
+	tests := []struct {
+		name          string
+		keys          []executor.SortKey
+		limit, offset int
+	}{
+		// many rows have the same a, and are returned in the order they were read
+		{"ties", []executor.SortKey{a}, 10, 0},
+		{"ties descending", []executor.SortKey{aDesc}, 25, 0},
+		{"offset", []executor.SortKey{a}, 10, 20},
+		{"offset into ties", []executor.SortKey{aDesc}, 3, 27},
+		{"multiple keys", []executor.SortKey{aDesc, bDesc}, 15, 5},
+		{"limit 0", []executor.SortKey{a}, 0, 0},
+		{"more than all rows", []executor.SortKey{a}, 500, 0},
+		{"offset past all rows", []executor.SortKey{a}, 10, 200},
+		{"offset to the last rows", []executor.SortKey{aDesc, bDesc}, 10, 195},
+	}
+	for _, tt := range tests {
+		sorted := executor.NewLimit(executor.NewSort(executor.NewValues(pairs(200, 7)), tt.keys, nil), &tt.limit, tt.offset)
+		expected, err := executor.Run(context.Background(), sorted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		topN := executor.NewTopN(executor.NewValues(pairs(200, 7)), tt.keys, tt.limit, tt.offset)
+		got, err := executor.Run(context.Background(), topN)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(inspectRows(got), inspectRows(expected)) {
+			t.Fatalf("%s: expected rows\n%s\ngot\n%s", tt.name, strings.Join(inspectRows(expected), "\n"), strings.Join(inspectRows(got), "\n"))
+		}
+	}
+}
+
+// TestParallel checks that the parallel operators return the rows of the serial operators,
+// where the rows of a table are scanned in partitions
+func TestParallel(t *testing.T) {
+	backend := inmemory.NewBackend()
+	backendtest.MustCreateTable(t, backend, "pairs", []object.Column{{Name: "a", Type: object.INTEGER}, {Name: "b", Type: object.STRING}})
+	if err := backend.WriteBatch(nil, map[string][]object.Row{"pairs": pairs(4000, 7)}); err != nil {
+		t.Fatal(err)
+	}
+	const workers = 4
+	partitions := executor.NewPartitions(backend, "pairs", []int{0, 1}, nil, workers)
+	scans := func(f func(op executor.Operator) executor.Operator) []executor.Operator {
+		ops := make([]executor.Operator, workers)
+		for i := range ops {
+			ops[i] = f(executor.NewPartitionScan(partitions, i))
+		}
+		return ops
+	}
+	keys := []executor.SortKey{{Expression: column("a"), Descending: true}}
+	aggregates := []executor.AggregateFunction{{Name: "count"}, {Name: "sum", Argument: column("a")}, {Name: "max", Argument: column("b")}}
+	partialAggregates := make([]executor.PartialAggregate, workers)
+	for i := range partialAggregates {
+		partialAggregates[i] = executor.NewAggregate(executor.NewPartitionScan(partitions, i), []executor.Expression{column("a")}, aggregates)
+	}
+	tests := []struct {
+		name     string
+		serial   executor.Operator
+		parallel executor.Operator
+		// ordered is whether the rows are returned in the same order
+		ordered bool
+	}{
+		{
+			"gather",
+			executor.NewFilter(executor.NewScan(backend, "pairs"), greaterThan("a", 3)),
+			executor.NewGather(partitions, scans(func(op executor.Operator) executor.Operator {
+				return executor.NewFilter(op, greaterThan("a", 3))
+			})),
+			false,
+		},
+		{
+			// many rows have the same a, and are returned in the order they were read
+			"gather merge",
+			executor.NewSort(executor.NewScan(backend, "pairs"), keys, nil),
+			executor.NewGatherMerge(partitions, scans(func(op executor.Operator) executor.Operator {
+				return executor.NewSort(op, keys, executor.NewMemory(20000, t.TempDir()))
+			})),
+			true,
+		},
+		{
+			"top n",
+			executor.NewTopN(executor.NewScan(backend, "pairs"), keys, 700, 0),
+			executor.NewLimit(executor.NewGatherMerge(partitions, scans(func(op executor.Operator) executor.Operator {
+				return executor.NewTopN(op, keys, 700, 0)
+			})), intPointer(700), 0),
+			true,
+		},
+		{
+			"aggregate",
+			executor.NewAggregate(executor.NewScan(backend, "pairs"), []executor.Expression{column("a")}, aggregates),
+			executor.NewParallelAggregate(partitions, partialAggregates),
+			true,
+		},
+	}
+	for _, tt := range tests {
+		expected, err := executor.Run(context.Background(), tt.serial)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := executor.Run(context.Background(), tt.parallel)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		want, have := inspectRows(expected), inspectRows(got)
+		if !tt.ordered {
+			sort.Strings(want)
+			sort.Strings(have)
+		}
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("%s: expected %d rows, as returned by %s, got %d rows", tt.name, len(want), tt.serial, len(have))
+		}
+	}
+}
+
+// TestParallelError checks that a parallel operator fails with the error of the first partition that fails,
+// which is the error the serial operator fails with
+func TestParallelError(t *testing.T) {
+	backend := inmemory.NewBackend()
+	backendtest.MustCreateTable(t, backend, "pairs", []object.Column{{Name: "a", Type: object.INTEGER}, {Name: "b", Type: object.STRING}})
+	if err := backend.WriteBatch(nil, map[string][]object.Row{"pairs": pairs(4000, 7)}); err != nil {
+		t.Fatal(err)
+	}
+	fail := predicate{
+		description: "fail",
+		f: func(row object.Row) object.Object {
+			b := column("b").Eval(row).(*object.String).Value
+			// the partitions after the first one that fails are also slow to fail
+			if b == "3999" || b == "2500" {
+				return &object.Error{Message: "failed at " + b}
+			}
+			return &object.Boolean{Value: true}
+		},
+	}
+	for _, workers := range []int{2, 4, 8} {
+		partitions := executor.NewPartitions(backend, "pairs", []int{0, 1}, nil, workers)
+		filters := make([]executor.Operator, workers)
+		sorts := make([]executor.Operator, workers)
+		for i := range filters {
+			filters[i] = executor.NewFilter(executor.NewPartitionScan(partitions, i), fail)
+			sorts[i] = executor.NewSort(executor.NewFilter(executor.NewPartitionScan(partitions, i), fail), []executor.SortKey{{Expression: column("a")}}, nil)
+		}
+		for _, op := range []executor.Operator{executor.NewGather(partitions, filters), executor.NewGatherMerge(partitions, sorts)} {
+			_, err := executor.Run(context.Background(), op)
+			if err == nil || err.Error() != "failed at 2500" {
+				t.Errorf("%s with %d workers: expected the error failed at 2500, got %v", op, workers, err)
+			}
+		}
+	}
+}
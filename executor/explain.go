@@ -0,0 +1,68 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vegarsti/sql/object"
+)
+
+// Instrumented wraps an operator, and counts the rows it returns and the time spent in it.
+// The time includes the time spent in the operator's children.
+type Instrumented struct {
+	Operator
+	Rows    int
+	Elapsed time.Duration
+}
+
+func NewInstrumented(op Operator) *Instrumented {
+	return &Instrumented{Operator: op}
+}
+
+func (i *Instrumented) Open(ctx context.Context) error {
+	start := time.Now()
+	err := i.Operator.Open(ctx)
+	i.Elapsed += time.Since(start)
+	return err
+}
+
+func (i *Instrumented) Next() (*object.Row, error) {
+	start := time.Now()
+	row, err := i.Operator.Next()
+	i.Elapsed += time.Since(start)
+	if row != nil {
+		i.Rows++
+	}
+	return row, err
+}
+
+func (i *Instrumented) Close() error {
+	start := time.Now()
+	err := i.Operator.Close()
+	i.Elapsed += time.Since(start)
+	return err
+}
+
+// Explain describes the tree of operators, one line per operator, with children indented below their parent.
+// Instrumented operators are described with the number of rows they returned and the time spent in them.
+func Explain(op Operator) []string {
+	var lines []string
+	var explain func(Operator, int)
+	explain = func(op Operator, depth int) {
+		line := strings.Repeat("  ", depth) + op.String()
+		switch instrumented := op.(type) {
+		case *Instrumented:
+			line += fmt.Sprintf(" (actual rows=%d time=%s)", instrumented.Rows, instrumented.Elapsed)
+		case *InstrumentedBatch:
+			line += fmt.Sprintf(" (actual rows=%d time=%s)", instrumented.Rows, instrumented.Elapsed)
+		}
+		lines = append(lines, line)
+		for _, child := range op.Children() {
+			explain(child, depth+1)
+		}
+	}
+	explain(op, 0)
+	return lines
+}
@@ -0,0 +1,580 @@
+package executor
+
+import (
+	"context"
+	"hash/fnv"
+	"strings"
+
+	"github.com/vegarsti/sql/object"
+)
+
+// NestedLoopJoin concatenates each row of the left child with each row of the right child,
+// and returns the combined rows for which the predicate is true. A nil predicate is a cross join.
+// The right child is read once, on the first call to Next, and kept in memory.
+type NestedLoopJoin struct {
+	left      Operator
+	right     Operator
+	predicate Expression
+	ctx       context.Context
+
+	inner      []*object.Row
+	innerRead  bool
+	current    *object.Row
+	innerIndex int
+}
+
+func NewNestedLoopJoin(left Operator, right Operator, predicate Expression) *NestedLoopJoin {
+	return &NestedLoopJoin{left: left, right: right, predicate: predicate}
+}
+
+func (j *NestedLoopJoin) Open(ctx context.Context) error {
+	j.ctx = ctx
+	j.inner = nil
+	j.innerRead = false
+	j.current = nil
+	if err := j.left.Open(ctx); err != nil {
+		return err
+	}
+	return j.right.Open(ctx)
+}
+
+func (j *NestedLoopJoin) Next() (*object.Row, error) {
+	if !j.innerRead {
+		for {
+			row, err := j.right.Next()
+			if err != nil {
+				return nil, err
+			}
+			if row == nil {
+				break
+			}
+			j.inner = append(j.inner, row)
+		}
+		j.innerRead = true
+	}
+	for {
+		if j.current == nil || j.innerIndex >= len(j.inner) {
+			row, err := j.left.Next()
+			if err != nil || row == nil {
+				return nil, err
+			}
+			j.current = row
+			j.innerIndex = 0
+			continue
+		}
+		// the inner rows are in memory, so the context is checked here, where most of the time is spent
+		if err := checkContext(j.ctx); err != nil {
+			return nil, err
+		}
+		newRow := ConcatenateRows(*j.current, *j.inner[j.innerIndex])
+		j.innerIndex++
+		if j.predicate != nil {
+			include, err := joinCondition(j.predicate, newRow)
+			if err != nil {
+				return nil, err
+			}
+			if !include {
+				continue
+			}
+		}
+		return &newRow, nil
+	}
+}
+
+func (j *NestedLoopJoin) Close() error {
+	j.inner = nil
+	leftErr := j.left.Close()
+	rightErr := j.right.Close()
+	if leftErr != nil {
+		return leftErr
+	}
+	return rightErr
+}
+
+func (j *NestedLoopJoin) Children() []Operator { return []Operator{j.left, j.right} }
+func (j *NestedLoopJoin) String() string {
+	if j.predicate == nil {
+		return "NestedLoopJoin"
+	}
+	return "NestedLoopJoin on " + j.predicate.String()
+}
+
+func joinCondition(predicate Expression, row object.Row) (bool, error) {
+	v := predicate.Eval(row)
+	if errorObj, ok := v.(*object.Error); ok {
+		return false, errorObj
+	}
+	include, ok := v.(*object.Boolean)
+	if !ok {
+		return false, object.Errorf(object.CodeDatatypeMismatch, "join condition must be of type boolean, not %s: %s", v.Type(), v.Inspect())
+	}
+	return include.Value, nil
+}
+
+// ConcatenateRows by simply splicing the tuples together.
+// Used when joining tables
+func ConcatenateRows(row1 object.Row, row2 object.Row) object.Row {
+	// copy into new slices, since row1 is concatenated with many rows when streaming joins
+	aliases := append(append(make([]string, 0, len(row1.Aliases)+len(row2.Aliases)), row1.Aliases...), row2.Aliases...)
+	values := append(append(make([]object.Object, 0, len(row1.Values)+len(row2.Values)), row1.Values...), row2.Values...)
+	tableNames := append(append(make([]string, 0, len(row1.TableName)+len(row2.TableName)), row1.TableName...), row2.TableName...)
+	return object.Row{
+		Aliases:   aliases,
+		Values:    values,
+		TableName: tableNames,
+	}
+}
+
+// HashJoin joins the rows of the left and right children where the left keys are equal to the right keys.
+// The right child is read into a hash table on the first call to Next, and the left child is streamed.
+// Rows are returned in the same order as NestedLoopJoin would return them.
+// Null keys never match. An optional residual predicate is evaluated for the combined rows.
+//
+// If the right child doesn't fit in the memory budget, the join is a grace hash join: the rows of
+// both children are written to temporary files, in partitions by the hash of their keys, and each
+// pair of partitions is joined in turn. A partition that doesn't fit either is partitioned again,
+// up to maxPartitionLevel times. The rows are then not returned in the order of NestedLoopJoin.
+type HashJoin struct {
+	left      Operator
+	right     Operator
+	leftKeys  []Expression
+	rightKeys []Expression
+	predicate Expression
+	memory    *Memory
+	ctx       context.Context
+
+	table       map[string][]hashEntry
+	reserved    int64 // the memory reserved for the hash table
+	keyTypes    []object.ObjectType
+	built       bool
+	current     *object.Row
+	currentKeys []object.Object
+	matches     []hashEntry
+	matchIndex  int
+
+	// partitioned is set when the right child didn't fit in memory, and the rows of both children were partitioned
+	partitioned bool
+	// partitions are the partitions that have not been joined yet
+	partitions []*partition
+	// probe is the partition whose left rows are read instead of the left child, or nil
+	probe *partition
+}
+
+// hashEntry is a row in the hash table, with its keys. Rows with different keys may have the same GroupKey,
+// such as an integer and a float that is almost the same, so the keys of the rows found by it are compared again.
+type hashEntry struct {
+	row  *object.Row
+	keys []object.Object
+}
+
+// partition is the rows of the left and right children with the same hash of their keys
+type partition struct {
+	left  *spillFile
+	right *spillFile
+	level int // the number of times the rows have been partitioned
+}
+
+func (p *partition) Close() error {
+	return closeSpillFiles([]*spillFile{p.left, p.right})
+}
+
+// The number of partitions the rows are written to at each level, and the most levels
+const (
+	numPartitions     = 16
+	maxPartitionLevel = 3
+)
+
+func NewHashJoin(left Operator, right Operator, leftKeys []Expression, rightKeys []Expression, predicate Expression, memory *Memory) *HashJoin {
+	return &HashJoin{left: left, right: right, leftKeys: leftKeys, rightKeys: rightKeys, predicate: predicate, memory: memory}
+}
+
+func (j *HashJoin) Open(ctx context.Context) error {
+	j.ctx = ctx
+	j.reset()
+	j.keyTypes = make([]object.ObjectType, len(j.rightKeys))
+	j.built = false
+	j.partitioned = false
+	j.current = nil
+	j.matches = nil
+	if err := j.left.Open(ctx); err != nil {
+		return err
+	}
+	return j.right.Open(ctx)
+}
+
+// build reads the right child into the hash table. If it doesn't fit, the rows of both children are partitioned.
+func (j *HashJoin) build() error {
+	j.built = true
+	full, err := j.buildTable(j.right.Next, true)
+	if err != nil || !full {
+		return err
+	}
+	partitions, err := j.partition(j.right.Next, j.left.Next, 0)
+	if err != nil {
+		return err
+	}
+	j.partitioned = true
+	j.partitions = partitions
+	return j.nextPartition()
+}
+
+// buildTable reads rows into the hash table until there are no more rows, or, if it can spill, until the table
+// doesn't fit in the memory budget, and reports whether it doesn't fit. The rows that are not read are left in next.
+func (j *HashJoin) buildTable(next func() (*object.Row, error), canSpill bool) (bool, error) {
+	j.table = make(map[string][]hashEntry)
+	for {
+		row, err := next()
+		if err != nil {
+			return false, err
+		}
+		if row == nil {
+			return false, nil
+		}
+		keys, ok, err := j.rightKeysOf(*row)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			continue
+		}
+		key := GroupKey(keys)
+		j.table[key] = append(j.table[key], hashEntry{row: row, keys: keys})
+		size := rowSize(row)
+		j.reserved += size
+		if !j.memory.grow(size) && canSpill {
+			return true, nil
+		}
+	}
+}
+
+// rightKeysOf evaluates the keys of a row of the right child, and keeps the types of the first keys,
+// which the keys of the left child must be comparable to
+func (j *HashJoin) rightKeysOf(row object.Row) ([]object.Object, bool, error) {
+	keys, ok, err := j.evalKeys(j.rightKeys, row)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	for i, k := range keys {
+		if j.keyTypes[i] == "" {
+			j.keyTypes[i] = k.Type()
+		}
+	}
+	return keys, true, nil
+}
+
+// partition writes the rows in the hash table, the rest of the right rows, and the left rows, to partitions
+// by the hash of their keys at the level, and empties the hash table. Rows with null keys are left out,
+// since they can't match.
+func (j *HashJoin) partition(nextRight func() (*object.Row, error), nextLeft func() (*object.Row, error), level int) ([]*partition, error) {
+	partitions := make([]*partition, numPartitions)
+	var err error
+	for i := range partitions {
+		partitions[i] = &partition{level: level + 1}
+		if partitions[i].left, err = j.memory.newSpillFile(); err == nil {
+			partitions[i].right, err = j.memory.newSpillFile()
+		}
+		if err != nil {
+			closePartitions(partitions)
+			return nil, err
+		}
+	}
+	write := func(row *object.Row, keys []object.Object, side func(*partition) *spillFile) error {
+		return side(partitions[partitionOf(keys, level)]).write(row)
+	}
+	left := func(p *partition) *spillFile { return p.left }
+	right := func(p *partition) *spillFile { return p.right }
+	err = func() error {
+		for _, entries := range j.table {
+			for _, entry := range entries {
+				if err := write(entry.row, entry.keys, right); err != nil {
+					return err
+				}
+			}
+		}
+		j.resetTable()
+		for {
+			row, err := nextRight()
+			if err != nil || row == nil {
+				return err
+			}
+			keys, ok, err := j.rightKeysOf(*row)
+			if err != nil {
+				return err
+			}
+			if ok {
+				if err := write(row, keys, right); err != nil {
+					return err
+				}
+			}
+		}
+	}()
+	if err == nil {
+		err = func() error {
+			for {
+				row, err := nextLeft()
+				if err != nil || row == nil {
+					return err
+				}
+				keys, ok, err := j.leftKeysOf(*row)
+				if err != nil {
+					return err
+				}
+				if ok {
+					if err := write(row, keys, left); err != nil {
+						return err
+					}
+				}
+			}
+		}()
+	}
+	if err != nil {
+		closePartitions(partitions)
+		return nil, err
+	}
+	return partitions, nil
+}
+
+// partitionOf returns the partition of the keys at the level. The hash depends on the level,
+// so that the rows of a partition are spread over all partitions when they are partitioned again.
+func partitionOf(keys []object.Object, level int) int {
+	h := fnv.New32a()
+	h.Write([]byte{byte(level)})
+	h.Write([]byte(GroupKey(keys)))
+	return int(h.Sum32() % numPartitions)
+}
+
+func closePartitions(partitions []*partition) error {
+	var err error
+	for _, p := range partitions {
+		if closeErr := p.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// nextPartition builds the hash table of the next partition, and probes it with the left rows of the partition.
+// A partition that doesn't fit in memory is partitioned again, unless it has been partitioned too many times.
+func (j *HashJoin) nextPartition() error {
+	for len(j.partitions) > 0 {
+		p := j.partitions[0]
+		j.partitions = j.partitions[1:]
+		j.closeProbe()
+		j.resetTable()
+		if err := p.right.rewind(); err != nil {
+			p.Close()
+			return err
+		}
+		if err := p.left.rewind(); err != nil {
+			p.Close()
+			return err
+		}
+		nextRight := func() (*object.Row, error) {
+			if err := checkContext(j.ctx); err != nil {
+				return nil, err
+			}
+			return p.right.read()
+		}
+		// a partition that has been partitioned too many times is read into memory even if it doesn't fit,
+		// since its rows may not be spread over more partitions, such as when many rows have the same keys
+		full, err := j.buildTable(nextRight, p.level < maxPartitionLevel)
+		if err != nil {
+			p.Close()
+			return err
+		}
+		if full {
+			partitions, err := j.partition(nextRight, p.left.read, p.level)
+			p.Close()
+			if err != nil {
+				return err
+			}
+			j.partitions = append(partitions, j.partitions...)
+			continue
+		}
+		j.probe = p
+		return nil
+	}
+	return nil
+}
+
+// nextLeft returns the next row of the left child, or of the partition being probed
+func (j *HashJoin) nextLeft() (*object.Row, error) {
+	if !j.partitioned {
+		return j.left.Next()
+	}
+	if j.probe == nil {
+		// all partitions have been joined
+		return nil, nil
+	}
+	for {
+		row, err := j.probe.left.read()
+		if err != nil || row != nil {
+			return row, err
+		}
+		if len(j.partitions) == 0 {
+			j.closeProbe()
+			j.resetTable()
+			return nil, nil
+		}
+		if err := j.nextPartition(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// evalKeys evaluates the key expressions. If any key is null, the row can't match, and ok is false.
+func (j *HashJoin) evalKeys(expressions []Expression, row object.Row) ([]object.Object, bool, error) {
+	keys := make([]object.Object, len(expressions))
+	for i, e := range expressions {
+		v := e.Eval(row)
+		if errorObj, ok := v.(*object.Error); ok {
+			return nil, false, errorObj
+		}
+		if v.Type() == object.NULL_OBJ {
+			return nil, false, nil
+		}
+		keys[i] = v
+	}
+	return keys, true, nil
+}
+
+// leftKeysOf evaluates the keys of a row of the left child, which must be comparable to the keys of the right child
+func (j *HashJoin) leftKeysOf(row object.Row) ([]object.Object, bool, error) {
+	keys, ok, err := j.evalKeys(j.leftKeys, row)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	for i, k := range keys {
+		if j.keyTypes[i] != "" && !equalityComparable(k.Type(), j.keyTypes[i]) {
+			return nil, false, object.Errorf(object.CodeUndefinedFunction, "unknown operator: %s = %s", k.Type(), j.keyTypes[i])
+		}
+	}
+	return keys, true, nil
+}
+
+func (j *HashJoin) Next() (*object.Row, error) {
+	if !j.built {
+		if err := j.build(); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		if j.current == nil || j.matchIndex >= len(j.matches) {
+			row, err := j.nextLeft()
+			if err != nil || row == nil {
+				return nil, err
+			}
+			keys, ok, err := j.leftKeysOf(*row)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			j.current = row
+			j.currentKeys = keys
+			j.matches = j.table[GroupKey(keys)]
+			j.matchIndex = 0
+			continue
+		}
+		if err := checkContext(j.ctx); err != nil {
+			return nil, err
+		}
+		match := j.matches[j.matchIndex]
+		j.matchIndex++
+		equal, err := keysEqual(j.currentKeys, match.keys)
+		if err != nil {
+			return nil, err
+		}
+		if !equal {
+			continue
+		}
+		newRow := ConcatenateRows(*j.current, *match.row)
+		if j.predicate != nil {
+			include, err := joinCondition(j.predicate, newRow)
+			if err != nil {
+				return nil, err
+			}
+			if !include {
+				continue
+			}
+		}
+		return &newRow, nil
+	}
+}
+
+// keysEqual is true if each key is equal to the key at the same position
+func keysEqual(a []object.Object, b []object.Object) (bool, error) {
+	for i := range a {
+		c, err := Compare(a[i], b[i])
+		if err != nil || c != 0 {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// equalityComparable is true if values of the two types can be compared for equality
+func equalityComparable(a object.ObjectType, b object.ObjectType) bool {
+	numeric := func(t object.ObjectType) bool {
+		return t == object.INTEGER_OBJ || t == object.FLOAT_OBJ || t == object.NUMERIC_OBJ
+	}
+	pointInTime := func(t object.ObjectType) bool {
+		return t == object.DATE_OBJ || t == object.TIMESTAMP_OBJ || t == object.TIMESTAMPTZ_OBJ
+	}
+	return a == b || (numeric(a) && numeric(b)) || (pointInTime(a) && pointInTime(b))
+}
+
+// resetTable empties the hash table, and gives back the memory used
+func (j *HashJoin) resetTable() {
+	j.table = nil
+	j.matches = nil
+	j.memory.release(j.reserved)
+	j.reserved = 0
+}
+
+func (j *HashJoin) closeProbe() error {
+	if j.probe == nil {
+		return nil
+	}
+	err := j.probe.Close()
+	j.probe = nil
+	return err
+}
+
+// reset throws away the hash table and the partitions
+func (j *HashJoin) reset() error {
+	j.resetTable()
+	err := j.closeProbe()
+	if closeErr := closePartitions(j.partitions); err == nil {
+		err = closeErr
+	}
+	j.partitions = nil
+	return err
+}
+
+func (j *HashJoin) Close() error {
+	resetErr := j.reset()
+	leftErr := j.left.Close()
+	rightErr := j.right.Close()
+	if leftErr != nil {
+		return leftErr
+	}
+	if rightErr != nil {
+		return rightErr
+	}
+	return resetErr
+}
+
+func (j *HashJoin) Children() []Operator { return []Operator{j.left, j.right} }
+func (j *HashJoin) String() string {
+	conditions := make([]string, len(j.leftKeys))
+	for i := range j.leftKeys {
+		conditions[i] = j.leftKeys[i].String() + " = " + j.rightKeys[i].String()
+	}
+	s := "HashJoin on " + strings.Join(conditions, " AND ")
+	if j.predicate != nil {
+		s += " AND " + j.predicate.String()
+	}
+	return s
+}
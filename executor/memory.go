@@ -0,0 +1,396 @@
+package executor
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"sync"
+
+	"github.com/vegarsti/sql/object"
+)
+
+// Memory is the memory budget of a query, which is shared by the operators that keep rows in memory,
+// Sort and HashJoin. An operator that would go over the budget writes rows to temporary files instead.
+// A nil *Memory has no limit. It can be used by the workers of a parallel operator at the same time.
+type Memory struct {
+	mu    sync.Mutex
+	limit int64
+	used  int64
+	dir   string
+}
+
+// NewMemory returns a budget of limit bytes, or no limit if limit is 0. Temporary files are created
+// in the directory, or in the default directory for temporary files if it is empty.
+func NewMemory(limit int64, dir string) *Memory {
+	return &Memory{limit: limit, dir: dir}
+}
+
+// grow adds n bytes to the memory used, and reports whether the memory used is still within the budget
+func (m *Memory) grow(n int64) bool {
+	if m == nil {
+		return true
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.used += n
+	return m.limit == 0 || m.used <= m.limit
+}
+
+// release gives back n bytes added by grow
+func (m *Memory) release(n int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.used -= n
+}
+
+func (m *Memory) tempDir() string {
+	if m == nil {
+		return ""
+	}
+	return m.dir
+}
+
+// The estimated sizes in bytes of the parts of a row, as laid out by Go on 64-bit platforms
+const (
+	rowOverhead     = 4*24 + 8 // the slice headers of a row, and the pointer to it
+	valueOverhead   = 16 + 16  // the interface value, and the value it points to
+	stringOverhead  = 16       // the string header
+	numericOverhead = 32       // the big.Int of the coefficient, whose words are counted apart
+	sortByOverhead  = 8        // the direction of a sort value, padded
+)
+
+// rowSize estimates the memory used by a row. The aliases and table names are usually
+// shared with other rows, so only their headers are counted.
+func rowSize(row *object.Row) int64 {
+	size := int64(rowOverhead)
+	size += int64(len(row.Aliases)+len(row.TableName)) * stringOverhead
+	for _, v := range row.Values {
+		size += valueSize(v)
+	}
+	for _, v := range row.SortByValues {
+		size += sortByOverhead + valueSize(v.Value)
+	}
+	return size
+}
+
+func valueSize(v object.Object) int64 {
+	switch v := v.(type) {
+	case *object.String:
+		return valueOverhead + stringOverhead + int64(len(v.Value))
+	case *object.Numeric:
+		return valueOverhead + numericOverhead + int64(len(v.Coefficient.Bits()))*8
+	}
+	return valueOverhead
+}
+
+// spillFile is a temporary file that rows are written to, and then read back in the order they were written.
+// The file is removed when it is closed.
+type spillFile struct {
+	file *os.File
+	w    *bufio.Writer
+	r    *bufio.Reader
+	buf  [binary.MaxVarintLen64]byte
+}
+
+func (m *Memory) newSpillFile() (*spillFile, error) {
+	file, err := os.CreateTemp(m.tempDir(), "sql-spill-")
+	if err != nil {
+		return nil, fmt.Errorf("create temporary file: %w", err)
+	}
+	return &spillFile{file: file, w: bufio.NewWriter(file)}, nil
+}
+
+// The tags that start each value in a spill file
+const (
+	tagNull byte = iota
+	tagInteger
+	tagFloat
+	tagString
+	tagTrue
+	tagFalse
+	tagDate
+	tagTime
+	tagTimestamp
+	tagTimestampTZ
+	tagInterval
+	tagNumeric
+)
+
+func (f *spillFile) write(row *object.Row) error {
+	f.uvarint(uint64(len(row.Values)))
+	for _, v := range row.Values {
+		if err := f.value(v); err != nil {
+			return err
+		}
+	}
+	f.strings(row.Aliases)
+	f.strings(row.TableName)
+	f.uvarint(uint64(len(row.SortByValues)))
+	for _, v := range row.SortByValues {
+		if v.Descending {
+			f.w.WriteByte(1)
+		} else {
+			f.w.WriteByte(0)
+		}
+		if err := f.value(v.Value); err != nil {
+			return err
+		}
+	}
+	// errors are kept by the writer, and returned by the next write
+	_, err := f.w.Write(nil)
+	return err
+}
+
+func (f *spillFile) uvarint(n uint64) {
+	f.w.Write(f.buf[:binary.PutUvarint(f.buf[:], n)])
+}
+
+func (f *spillFile) varint(n int64) {
+	f.w.Write(f.buf[:binary.PutVarint(f.buf[:], n)])
+}
+
+func (f *spillFile) strings(ss []string) {
+	f.uvarint(uint64(len(ss)))
+	for _, s := range ss {
+		f.uvarint(uint64(len(s)))
+		f.w.WriteString(s)
+	}
+}
+
+func (f *spillFile) value(v object.Object) error {
+	switch v := v.(type) {
+	case *object.Null:
+		f.w.WriteByte(tagNull)
+	case *object.Integer:
+		f.w.WriteByte(tagInteger)
+		f.varint(v.Value)
+	case *object.Float:
+		f.w.WriteByte(tagFloat)
+		binary.BigEndian.PutUint64(f.buf[:], math.Float64bits(v.Value))
+		f.w.Write(f.buf[:8])
+	case *object.String:
+		f.w.WriteByte(tagString)
+		f.uvarint(uint64(len(v.Value)))
+		f.w.WriteString(v.Value)
+	case *object.Boolean:
+		if v.Value {
+			f.w.WriteByte(tagTrue)
+		} else {
+			f.w.WriteByte(tagFalse)
+		}
+	case *object.Date:
+		f.w.WriteByte(tagDate)
+		f.varint(v.Value)
+	case *object.Time:
+		f.w.WriteByte(tagTime)
+		f.varint(v.Value)
+	case *object.Timestamp:
+		f.w.WriteByte(tagTimestamp)
+		f.varint(v.Value)
+	case *object.TimestampTZ:
+		f.w.WriteByte(tagTimestampTZ)
+		f.varint(v.Value)
+	case *object.Interval:
+		f.w.WriteByte(tagInterval)
+		f.varint(v.Months)
+		f.varint(v.Days)
+		f.varint(v.Microseconds)
+	case *object.Numeric:
+		f.w.WriteByte(tagNumeric)
+		f.varint(int64(v.Scale))
+		f.varint(int64(v.Coefficient.Sign()))
+		magnitude := v.Coefficient.Bytes()
+		f.uvarint(uint64(len(magnitude)))
+		f.w.Write(magnitude)
+	default:
+		return fmt.Errorf("cannot write %s value to temporary file", v.Type())
+	}
+	return nil
+}
+
+// rewind writes the buffered rows to the file, and starts reading the rows from the beginning
+func (f *spillFile) rewind() error {
+	if err := f.w.Flush(); err != nil {
+		return fmt.Errorf("write temporary file: %w", err)
+	}
+	if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("seek temporary file: %w", err)
+	}
+	f.r = bufio.NewReader(f.file)
+	return nil
+}
+
+// read returns the next row, or nil when all rows have been read
+func (f *spillFile) read() (*object.Row, error) {
+	n, err := binary.ReadUvarint(f.r)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, readError(err)
+	}
+	row := &object.Row{Values: make([]object.Object, n)}
+	for i := range row.Values {
+		if row.Values[i], err = f.readValue(); err != nil {
+			return nil, err
+		}
+	}
+	if row.Aliases, err = f.readStrings(); err != nil {
+		return nil, err
+	}
+	if row.TableName, err = f.readStrings(); err != nil {
+		return nil, err
+	}
+	if n, err = binary.ReadUvarint(f.r); err != nil {
+		return nil, readError(err)
+	}
+	if n > 0 {
+		row.SortByValues = make([]object.SortBy, n)
+	}
+	for i := range row.SortByValues {
+		descending, err := f.r.ReadByte()
+		if err != nil {
+			return nil, readError(err)
+		}
+		row.SortByValues[i].Descending = descending == 1
+		if row.SortByValues[i].Value, err = f.readValue(); err != nil {
+			return nil, err
+		}
+	}
+	return row, nil
+}
+
+func (f *spillFile) readString() (string, error) {
+	n, err := binary.ReadUvarint(f.r)
+	if err != nil {
+		return "", readError(err)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(f.r, b); err != nil {
+		return "", readError(err)
+	}
+	return string(b), nil
+}
+
+func (f *spillFile) readStrings() ([]string, error) {
+	n, err := binary.ReadUvarint(f.r)
+	if err != nil {
+		return nil, readError(err)
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	ss := make([]string, n)
+	for i := range ss {
+		if ss[i], err = f.readString(); err != nil {
+			return nil, err
+		}
+	}
+	return ss, nil
+}
+
+func (f *spillFile) readValue() (object.Object, error) {
+	tag, err := f.r.ReadByte()
+	if err != nil {
+		return nil, readError(err)
+	}
+	switch tag {
+	case tagNull:
+		return object.NULL, nil
+	case tagInteger, tagDate, tagTime, tagTimestamp, tagTimestampTZ:
+		n, err := binary.ReadVarint(f.r)
+		if err != nil {
+			return nil, readError(err)
+		}
+		switch tag {
+		case tagDate:
+			return &object.Date{Value: n}, nil
+		case tagTime:
+			return &object.Time{Value: n}, nil
+		case tagTimestamp:
+			return &object.Timestamp{Value: n}, nil
+		case tagTimestampTZ:
+			return &object.TimestampTZ{Value: n}, nil
+		}
+		return &object.Integer{Value: n}, nil
+	case tagFloat:
+		if _, err := io.ReadFull(f.r, f.buf[:8]); err != nil {
+			return nil, readError(err)
+		}
+		return &object.Float{Value: math.Float64frombits(binary.BigEndian.Uint64(f.buf[:8]))}, nil
+	case tagString:
+		s, err := f.readString()
+		if err != nil {
+			return nil, err
+		}
+		return &object.String{Value: s}, nil
+	case tagTrue:
+		return &object.Boolean{Value: true}, nil
+	case tagFalse:
+		return &object.Boolean{Value: false}, nil
+	case tagInterval:
+		var parts [3]int64
+		for i := range parts {
+			if parts[i], err = binary.ReadVarint(f.r); err != nil {
+				return nil, readError(err)
+			}
+		}
+		return &object.Interval{Months: parts[0], Days: parts[1], Microseconds: parts[2]}, nil
+	case tagNumeric:
+		scale, err := binary.ReadVarint(f.r)
+		if err != nil {
+			return nil, readError(err)
+		}
+		sign, err := binary.ReadVarint(f.r)
+		if err != nil {
+			return nil, readError(err)
+		}
+		magnitude, err := f.readString()
+		if err != nil {
+			return nil, err
+		}
+		coefficient := new(big.Int).SetBytes([]byte(magnitude))
+		if sign < 0 {
+			coefficient.Neg(coefficient)
+		}
+		return &object.Numeric{Coefficient: coefficient, Scale: int32(scale)}, nil
+	}
+	return nil, fmt.Errorf("read temporary file: unknown value tag %d", tag)
+}
+
+func readError(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("read temporary file: %w", err)
+}
+
+// Close closes and removes the file
+func (f *spillFile) Close() error {
+	err := f.file.Close()
+	if removeErr := os.Remove(f.file.Name()); err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// closeSpillFiles closes the files, and returns the first error
+func closeSpillFiles(files []*spillFile) error {
+	var err error
+	for _, f := range files {
+		if f == nil {
+			continue
+		}
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,476 @@
+package executor
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/vegarsti/sql/object"
+)
+
+// Parallel operators execute a part of a query in several goroutines. Each of their workers is a tree
+// of operators reading one partition of a table, where the partitions are consecutive parts of the rows
+// of the table, as of the same version of it. Gather returns the rows of the workers as they come,
+// GatherMerge merges the sorted rows of the workers, and ParallelAggregate merges the groups
+// aggregated by the workers.
+//
+// When a worker fails, the workers after it are cancelled, and the operator fails with the error of
+// the first worker that fails. That is the error the query fails with when it is executed by one goroutine,
+// which reads the partitions in order.
+
+// PartitionScanner is the part of a storage backend needed to read a table in partitions at the same time.
+// ScanPartitions returns n iterators over consecutive parts of the rows of the table, with the values of
+// the columns at the positions, in that order. Together they return the rows a scan of the table returns,
+// in the same order, and they can be read by different goroutines.
+type PartitionScanner interface {
+	ScanPartitions(table string, columns []int, n int) ([]object.RowIterator, error)
+}
+
+// Partitions is the partitions of a table read by the workers of a parallel operator, one each.
+// The partitions are scanned when the operator is opened, and then taken by the scans of the workers.
+type Partitions struct {
+	// Alias is the name the query gives the table, if any, which is shown after the table name
+	Alias   string
+	backend PartitionScanner
+	table   string
+	columns []int
+	names   []string
+	n       int
+	rows    []object.RowIterator // the partitions that haven't been taken by a scan
+}
+
+// NewPartitions returns n partitions of the columns of the table at the positions, with the names.
+// names is nil for a backend that reads every column, and then columns is the positions of all columns.
+func NewPartitions(backend PartitionScanner, table string, columns []int, names []string, n int) *Partitions {
+	return &Partitions{backend: backend, table: table, columns: columns, names: names, n: n}
+}
+
+// Workers returns the number of partitions, which is the number of workers reading them
+func (p *Partitions) Workers() int { return p.n }
+
+func (p *Partitions) open() error {
+	p.close()
+	rows, err := p.backend.ScanPartitions(p.table, p.columns, p.n)
+	if err != nil {
+		return err
+	}
+	p.rows = rows
+	return nil
+}
+
+// take returns the iterator of the partition at the position, which the caller closes
+func (p *Partitions) take(i int) (object.RowIterator, error) {
+	if i >= len(p.rows) || p.rows[i] == nil {
+		return nil, fmt.Errorf("partition %d of %s is not open", i+1, p.table)
+	}
+	rows := p.rows[i]
+	p.rows[i] = nil
+	return rows, nil
+}
+
+// close closes the partitions that haven't been taken
+func (p *Partitions) close() {
+	for _, rows := range p.rows {
+		if rows != nil {
+			rows.Close()
+		}
+	}
+	p.rows = nil
+}
+
+func (p *Partitions) String() string {
+	if p.names != nil {
+		return fmt.Sprintf("Scan %s (%s)", scanName(p.table, p.Alias), strings.Join(p.names, ", "))
+	}
+	return "Scan " + scanName(p.table, p.Alias)
+}
+
+// parallel runs the workers of a parallel operator, each in its own goroutine and with its own context
+type parallel struct {
+	partitions *Partitions
+	workers    []Operator
+
+	contexts []context.Context
+	cancels  []context.CancelFunc
+	errs     []error // the error of each worker, set before its goroutine is done
+	wg       *sync.WaitGroup
+}
+
+// open scans the partitions, and opens the workers
+func (p *parallel) open(ctx context.Context) error {
+	p.contexts = make([]context.Context, len(p.workers))
+	p.cancels = make([]context.CancelFunc, len(p.workers))
+	p.errs = make([]error, len(p.workers))
+	p.wg = &sync.WaitGroup{}
+	for i := range p.workers {
+		p.contexts[i], p.cancels[i] = context.WithCancel(ctx)
+	}
+	if err := p.partitions.open(); err != nil {
+		return err
+	}
+	for i, worker := range p.workers {
+		if err := worker.Open(p.contexts[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// start calls work for each worker in its own goroutine, with the context of the worker, and then finished,
+// unless it is nil. If work fails for a worker, the workers after it are cancelled.
+func (p *parallel) start(work func(i int, ctx context.Context) error, finished func(i int)) {
+	p.wg.Add(len(p.workers))
+	for i := range p.workers {
+		go func(i int) {
+			defer p.wg.Done()
+			if err := work(i, p.contexts[i]); err != nil {
+				p.errs[i] = err
+				for _, cancel := range p.cancels[i+1:] {
+					cancel()
+				}
+			}
+			if finished != nil {
+				finished(i)
+			}
+		}(i)
+	}
+}
+
+// err returns the error of the first worker that failed, after the workers are done
+func (p *parallel) err() error {
+	for _, err := range p.errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close cancels the workers, waits for their goroutines to be done, and closes them
+func (p *parallel) close() error {
+	for _, cancel := range p.cancels {
+		cancel()
+	}
+	if p.wg != nil {
+		p.wg.Wait()
+	}
+	var err error
+	for _, worker := range p.workers {
+		if closeErr := worker.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	p.partitions.close()
+	p.contexts = nil
+	p.cancels = nil
+	return err
+}
+
+// chunkSize is the number of rows a worker of Gather or GatherMerge sends at a time
+const chunkSize = 256
+
+// send reads the rows of the worker and sends them in chunks, until there are no more rows
+// or the context is done. The rows of a batch operator are sent a batch at a time.
+func send(ctx context.Context, worker Operator, out chan<- []*object.Row) error {
+	put := func(chunk []*object.Row) error {
+		select {
+		case out <- chunk:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if batches, ok := worker.(BatchOperator); ok {
+		for {
+			b, err := batches.NextBatch()
+			if err != nil || b == nil {
+				return err
+			}
+			rows := b.Rows()
+			chunk := make([]*object.Row, len(rows))
+			for i := range rows {
+				chunk[i] = &rows[i]
+			}
+			if err := put(chunk); err != nil {
+				return err
+			}
+		}
+	}
+	chunk := make([]*object.Row, 0, chunkSize)
+	for {
+		row, err := worker.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		chunk = append(chunk, row)
+		if len(chunk) == chunkSize {
+			if err := put(chunk); err != nil {
+				return err
+			}
+			chunk = make([]*object.Row, 0, chunkSize)
+		}
+	}
+	if len(chunk) > 0 {
+		return put(chunk)
+	}
+	return nil
+}
+
+// Gather returns the rows of its workers, which run in parallel, in the order the workers return them.
+// The order of the rows of different workers can differ from one execution to the next.
+type Gather struct {
+	parallel
+	chunks chan []*object.Row
+	chunk  []*object.Row // the rows received that haven't been returned
+}
+
+func NewGather(partitions *Partitions, workers []Operator) *Gather {
+	return &Gather{parallel: parallel{partitions: partitions, workers: workers}}
+}
+
+func (g *Gather) Open(ctx context.Context) error {
+	g.chunk = nil
+	if err := g.open(ctx); err != nil {
+		return err
+	}
+	chunks := make(chan []*object.Row, len(g.workers))
+	g.chunks = chunks
+	g.start(func(i int, ctx context.Context) error {
+		return send(ctx, g.workers[i], chunks)
+	}, nil)
+	wg := g.wg
+	go func() {
+		wg.Wait()
+		close(chunks)
+	}()
+	return nil
+}
+
+func (g *Gather) Next() (*object.Row, error) {
+	for len(g.chunk) == 0 {
+		chunk, ok := <-g.chunks
+		if !ok {
+			return nil, g.err()
+		}
+		g.chunk = chunk
+	}
+	row := g.chunk[0]
+	g.chunk = g.chunk[1:]
+	return row, nil
+}
+
+func (g *Gather) Close() error {
+	g.chunk = nil
+	return g.close()
+}
+
+func (g *Gather) Children() []Operator { return g.workers }
+func (g *Gather) String() string       { return fmt.Sprintf("Gather (%d workers)", len(g.workers)) }
+
+// GatherMerge merges the rows of its workers, which run in parallel and each return rows sorted by the
+// same sort keys, as Sort and TopN do. Rows that compare equal are returned in the order of the workers,
+// so the rows are returned in the order Sort returns the rows of all of the partitions.
+type GatherMerge struct {
+	parallel
+	streams []chan []*object.Row
+	heads   gatherHeap
+	started bool
+}
+
+func NewGatherMerge(partitions *Partitions, workers []Operator) *GatherMerge {
+	return &GatherMerge{parallel: parallel{partitions: partitions, workers: workers}}
+}
+
+func (g *GatherMerge) Open(ctx context.Context) error {
+	g.heads = nil
+	g.started = false
+	if err := g.open(ctx); err != nil {
+		return err
+	}
+	streams := make([]chan []*object.Row, len(g.workers))
+	for i := range streams {
+		streams[i] = make(chan []*object.Row, 1)
+	}
+	g.streams = streams
+	g.start(func(i int, ctx context.Context) error {
+		return send(ctx, g.workers[i], streams[i])
+	}, func(i int) {
+		close(streams[i])
+	})
+	return nil
+}
+
+func (g *GatherMerge) Next() (*object.Row, error) {
+	if !g.started {
+		// the first row can't be returned before the first rows of every worker are received
+		for i := range g.streams {
+			if err := g.receive(i); err != nil {
+				return nil, err
+			}
+		}
+		g.started = true
+	}
+	if len(g.heads) == 0 {
+		return nil, nil
+	}
+	head := &g.heads[0]
+	row := head.rows[0]
+	head.rows = head.rows[1:]
+	if len(head.rows) > 0 {
+		heap.Fix(&g.heads, 0)
+		return row, nil
+	}
+	worker := head.worker
+	heap.Pop(&g.heads)
+	if err := g.receive(worker); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+// receive waits for the next rows of the worker, and adds them to the heads.
+// It returns the error of the worker if it failed.
+func (g *GatherMerge) receive(worker int) error {
+	rows, ok := <-g.streams[worker]
+	if !ok {
+		return g.errs[worker]
+	}
+	heap.Push(&g.heads, gatherHead{rows: rows, worker: worker})
+	return nil
+}
+
+func (g *GatherMerge) Close() error {
+	g.heads = nil
+	return g.close()
+}
+
+func (g *GatherMerge) Children() []Operator { return g.workers }
+func (g *GatherMerge) String() string {
+	return fmt.Sprintf("Gather Merge (%d workers)", len(g.workers))
+}
+
+// gatherHead is the rows received from a worker that haven't been returned
+type gatherHead struct {
+	rows   []*object.Row
+	worker int
+}
+
+type gatherHeap []gatherHead
+
+func (h gatherHeap) Len() int { return len(h) }
+func (h gatherHeap) Less(i, j int) bool {
+	a, b := h[i].rows[0].SortByValues, h[j].rows[0].SortByValues
+	if lessSortValues(a, b) {
+		return true
+	}
+	if lessSortValues(b, a) {
+		return false
+	}
+	return h[i].worker < h[j].worker
+}
+func (h gatherHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *gatherHeap) Push(x interface{}) { *h = append(*h, x.(gatherHead)) }
+func (h *gatherHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// PartialAggregate is an aggregate whose groups can be merged with the groups of aggregates
+// with the same group expressions and aggregate functions: an Aggregate or a BatchAggregate.
+type PartialAggregate interface {
+	Operator
+	groups() ([]*group, error)
+	definition() *Aggregate
+}
+
+// ParallelAggregate aggregates the rows of each partition in a worker, and merges the groups of the workers
+// in the order of the workers. It returns the same rows as an aggregate of all of the rows, in the same order,
+// except that sums and averages of floats can differ in the last digits, since the values are added in another order.
+type ParallelAggregate struct {
+	Aggregate
+	parallel
+	partials []PartialAggregate
+}
+
+func NewParallelAggregate(partitions *Partitions, workers []PartialAggregate) *ParallelAggregate {
+	operators := make([]Operator, len(workers))
+	for i, w := range workers {
+		operators[i] = w
+	}
+	definition := workers[0].definition()
+	return &ParallelAggregate{
+		Aggregate: Aggregate{groupBy: definition.groupBy, aggregates: definition.aggregates},
+		parallel:  parallel{partitions: partitions, workers: operators},
+		partials:  workers,
+	}
+}
+
+func (a *ParallelAggregate) Open(ctx context.Context) error {
+	a.rows = nil
+	a.position = 0
+	a.done = false
+	return a.open(ctx)
+}
+
+func (a *ParallelAggregate) Next() (*object.Row, error) {
+	if !a.done {
+		if err := a.aggregate(); err != nil {
+			return nil, err
+		}
+	}
+	if a.position >= len(a.rows) {
+		return nil, nil
+	}
+	row := a.rows[a.position]
+	a.position++
+	return row, nil
+}
+
+func (a *ParallelAggregate) aggregate() error {
+	partial := make([][]*group, len(a.partials))
+	a.start(func(i int, ctx context.Context) error {
+		groups, err := a.partials[i].groups()
+		partial[i] = groups
+		return err
+	}, nil)
+	a.wg.Wait()
+	if err := a.err(); err != nil {
+		return err
+	}
+	var groups []*group
+	groupIndex := make(map[string]*group)
+	for _, worker := range partial {
+		for _, g := range worker {
+			key := GroupKey(g.keys)
+			merged, ok := groupIndex[key]
+			if !ok {
+				groupIndex[key] = g
+				groups = append(groups, g)
+				continue
+			}
+			for i, acc := range merged.accumulators {
+				if err := acc.merge(g.accumulators[i]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return a.setGroups(groups)
+}
+
+func (a *ParallelAggregate) Close() error {
+	a.rows = nil
+	return a.close()
+}
+
+func (a *ParallelAggregate) Children() []Operator { return a.workers }
+func (a *ParallelAggregate) String() string {
+	return fmt.Sprintf("Parallel %s (%d workers)", a.Aggregate.String(), len(a.workers))
+}
@@ -0,0 +1,337 @@
+package executor
+
+import (
+	"container/heap"
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/vegarsti/sql/object"
+)
+
+// SortKey is an expression to sort by, and the direction to sort in.
+type SortKey struct {
+	Expression Expression
+	Descending bool
+}
+
+func (k SortKey) String() string {
+	if k.Descending {
+		return k.Expression.String() + " DESC"
+	}
+	return k.Expression.String()
+}
+
+// Sort reads all rows of its child and returns them ordered by the sort keys.
+// Rows that compare equal are returned in the order they were read.
+//
+// If the rows don't fit in the memory budget, each batch of rows that fits is sorted and written
+// to a temporary file as a sorted run, and the runs are merged as rows are returned.
+type Sort struct {
+	child  Operator
+	keys   []SortKey
+	memory *Memory
+	ctx    context.Context
+
+	rows     []*object.Row
+	reserved int64 // the memory reserved for rows
+	position int
+	sorted   bool
+	runs     []*spillFile
+	merger   *merger // reads the runs in order, when the rows didn't fit in memory
+}
+
+func NewSort(child Operator, keys []SortKey, memory *Memory) *Sort {
+	return &Sort{child: child, keys: keys, memory: memory}
+}
+
+func (s *Sort) Open(ctx context.Context) error {
+	s.ctx = ctx
+	s.reset()
+	s.position = 0
+	s.sorted = false
+	return s.child.Open(ctx)
+}
+
+func (s *Sort) Next() (*object.Row, error) {
+	if !s.sorted {
+		if err := s.sort(); err != nil {
+			return nil, err
+		}
+	}
+	if s.merger != nil {
+		return s.merger.next()
+	}
+	if s.position >= len(s.rows) {
+		return nil, nil
+	}
+	row := s.rows[s.position]
+	s.position++
+	return row, nil
+}
+
+func (s *Sort) sort() error {
+	for {
+		row, err := s.child.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		sortValues, err := evalSortKeys(s.keys, *row)
+		if err != nil {
+			return err
+		}
+		row.SortByValues = sortValues
+		s.rows = append(s.rows, row)
+		size := rowSize(row)
+		s.reserved += size
+		if !s.memory.grow(size) {
+			if err := s.spill(); err != nil {
+				return err
+			}
+		}
+	}
+	if len(s.runs) > 0 {
+		if len(s.rows) > 0 {
+			if err := s.spill(); err != nil {
+				return err
+			}
+		}
+		if err := s.merge(); err != nil {
+			return err
+		}
+		s.sorted = true
+		return nil
+	}
+	// the rows are sorted in memory, which can't be stopped, so the context is checked when it is done
+	sortRows(s.rows)
+	if err := checkContext(s.ctx); err != nil {
+		return err
+	}
+	s.sorted = true
+	return nil
+}
+
+// spill sorts the rows in memory, and writes them to a temporary file as a sorted run
+func (s *Sort) spill() error {
+	sortRows(s.rows)
+	run, err := s.memory.newSpillFile()
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, run)
+	for _, row := range s.rows {
+		if err := run.write(row); err != nil {
+			return err
+		}
+	}
+	s.memory.release(s.reserved)
+	s.reserved = 0
+	s.rows = nil
+	return nil
+}
+
+// maxMergeRuns is the most runs merged at once, which is the most temporary files read at the same time
+const maxMergeRuns = 64
+
+// merge starts merging the sorted runs. If there are too many runs to merge at once,
+// groups of runs are first merged into longer runs.
+func (s *Sort) merge() error {
+	for len(s.runs) > maxMergeRuns {
+		var merged []*spillFile
+		for len(s.runs) > 0 {
+			group := s.runs
+			if len(group) > maxMergeRuns {
+				group = group[:maxMergeRuns]
+			}
+			s.runs = s.runs[len(group):]
+			run, err := s.mergeInto(group)
+			closeSpillFiles(group)
+			if err != nil {
+				closeSpillFiles(merged)
+				return err
+			}
+			merged = append(merged, run)
+		}
+		s.runs = merged
+	}
+	m, err := newMerger(s.ctx, s.runs)
+	if err != nil {
+		return err
+	}
+	s.merger = m
+	return nil
+}
+
+// mergeInto merges the runs into a new run
+func (s *Sort) mergeInto(runs []*spillFile) (*spillFile, error) {
+	m, err := newMerger(s.ctx, runs)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := s.memory.newSpillFile()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		row, err := m.next()
+		if err != nil {
+			merged.Close()
+			return nil, err
+		}
+		if row == nil {
+			return merged, nil
+		}
+		if err := merged.write(row); err != nil {
+			merged.Close()
+			return nil, err
+		}
+	}
+}
+
+// reset throws away the rows and runs, and gives back the memory used
+func (s *Sort) reset() error {
+	s.memory.release(s.reserved)
+	s.reserved = 0
+	s.rows = nil
+	s.merger = nil
+	err := closeSpillFiles(s.runs)
+	s.runs = nil
+	return err
+}
+
+func (s *Sort) Close() error {
+	resetErr := s.reset()
+	if err := s.child.Close(); err != nil {
+		return err
+	}
+	return resetErr
+}
+
+func (s *Sort) Children() []Operator { return []Operator{s.child} }
+func (s *Sort) String() string {
+	keys := make([]string, len(s.keys))
+	for i, k := range s.keys {
+		keys[i] = k.String()
+	}
+	return "Sort " + strings.Join(keys, ", ")
+}
+
+func evalSortKeys(keys []SortKey, row object.Row) ([]object.SortBy, error) {
+	sortValues := make([]object.SortBy, len(keys))
+	for i, k := range keys {
+		v := k.Expression.Eval(row)
+		if errorObj, ok := v.(*object.Error); ok {
+			return nil, errorObj
+		}
+		sortValues[i] = object.SortBy{Value: v, Descending: k.Descending}
+	}
+	return sortValues, nil
+}
+
+func sortRows(rows []*object.Row) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		return lessSortValues(rows[i].SortByValues, rows[j].SortByValues)
+	})
+}
+
+// merger merges sorted runs. Rows that compare equal are returned in the order of the runs they are in,
+// so that merging runs of consecutive rows is stable.
+type merger struct {
+	ctx   context.Context
+	heads mergeHeap
+}
+
+// mergeHead is the next row of a run
+type mergeHead struct {
+	row *object.Row
+	run int
+	f   *spillFile
+}
+
+type mergeHeap []mergeHead
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	if lessSortValues(h[i].row.SortByValues, h[j].row.SortByValues) {
+		return true
+	}
+	if lessSortValues(h[j].row.SortByValues, h[i].row.SortByValues) {
+		return false
+	}
+	return h[i].run < h[j].run
+}
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(mergeHead)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+func newMerger(ctx context.Context, runs []*spillFile) (*merger, error) {
+	m := &merger{ctx: ctx}
+	for i, run := range runs {
+		if err := run.rewind(); err != nil {
+			return nil, err
+		}
+		row, err := run.read()
+		if err != nil {
+			return nil, err
+		}
+		if row != nil {
+			m.heads = append(m.heads, mergeHead{row: row, run: i, f: run})
+		}
+	}
+	heap.Init(&m.heads)
+	return m, nil
+}
+
+// next returns the smallest row of the runs, or nil when all rows have been returned
+func (m *merger) next() (*object.Row, error) {
+	if len(m.heads) == 0 {
+		return nil, nil
+	}
+	if err := checkContext(m.ctx); err != nil {
+		return nil, err
+	}
+	head := &m.heads[0]
+	row := head.row
+	next, err := head.f.read()
+	if err != nil {
+		return nil, err
+	}
+	if next == nil {
+		heap.Pop(&m.heads)
+	} else {
+		head.row = next
+		heap.Fix(&m.heads, 0)
+	}
+	return row, nil
+}
+
+func lessSortValues(a []object.SortBy, b []object.SortBy) bool {
+	for k := range a {
+		sign := float64(1)
+		if b[k].Descending {
+			sign = -1
+		}
+		aValue := sign * a[k].Value.SortValue()
+		bValue := sign * b[k].Value.SortValue()
+		if aValue != bValue {
+			return aValue < bValue
+		}
+		// numerics that are too close to be told apart as floats are compared exactly
+		if aNumeric, ok := a[k].Value.(*object.Numeric); ok {
+			if bNumeric, ok := b[k].Value.(*object.Numeric); ok {
+				if c := aNumeric.Cmp(bNumeric); c != 0 {
+					return float64(c)*sign < 0
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package executor
+
+import (
+	"container/heap"
+	"context"
+	"hash/fnv"
+	"math"
+
+	"github.com/vegarsti/sql/object"
+)
+
+// distinctSketchSize is the number of hashes kept by the distinct value estimator.
+// The relative error of the estimate is roughly 1/sqrt(distinctSketchSize).
+const distinctSketchSize = 1024
+
+// ComputeStatistics reads all rows from the iterator, and returns statistics for the given columns.
+// The number of distinct values is estimated with a k minimum values sketch, so it uses constant memory.
+// It is exact for columns with fewer than distinctSketchSize distinct values.
+// Reading stops with the error of the context once it is done.
+func ComputeStatistics(ctx context.Context, columns []object.Column, it object.RowIterator) (*object.TableStatistics, error) {
+	stats := &object.TableStatistics{Columns: make([]object.ColumnStatistics, len(columns))}
+	nulls := make([]int, len(columns))
+	sketches := make([]*distinctSketch, len(columns))
+	for i, c := range columns {
+		stats.Columns[i].Name = c.Name
+		sketches[i] = newDistinctSketch(distinctSketchSize)
+	}
+	for {
+		if err := checkContext(ctx); err != nil {
+			it.Close()
+			return nil, err
+		}
+		row, err := it.Next()
+		if err != nil {
+			it.Close()
+			return nil, err
+		}
+		if row == nil {
+			break
+		}
+		stats.RowCount++
+		for i := range columns {
+			v := row.Values[i]
+			if v.Type() == object.NULL_OBJ {
+				nulls[i]++
+				continue
+			}
+			sketches[i].add(GroupKey([]object.Object{v}))
+			c := &stats.Columns[i]
+			if c.Min == nil {
+				c.Min, c.Max = v, v
+				continue
+			}
+			if cmp, err := Compare(v, c.Min); err == nil && cmp < 0 {
+				c.Min = v
+			}
+			if cmp, err := Compare(v, c.Max); err == nil && cmp > 0 {
+				c.Max = v
+			}
+		}
+	}
+	if err := it.Close(); err != nil {
+		return nil, err
+	}
+	for i := range columns {
+		stats.Columns[i].DistinctCount = sketches[i].estimate()
+		if stats.RowCount > 0 {
+			stats.Columns[i].NullFraction = float64(nulls[i]) / float64(stats.RowCount)
+		}
+	}
+	return stats, nil
+}
+
+// distinctSketch keeps the k smallest distinct hashes of the values it has seen.
+// If the hashes are uniformly distributed, the k'th smallest hash tells how densely
+// the hash space is populated, and thus how many distinct values there are.
+type distinctSketch struct {
+	k      int
+	hashes maxHeap
+	seen   map[uint64]bool
+}
+
+func newDistinctSketch(k int) *distinctSketch {
+	return &distinctSketch{k: k, seen: make(map[uint64]bool)}
+}
+
+func (s *distinctSketch) add(key string) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	hash := mix(h.Sum64())
+	if s.seen[hash] {
+		return
+	}
+	if len(s.hashes) < s.k {
+		heap.Push(&s.hashes, hash)
+		s.seen[hash] = true
+		return
+	}
+	if hash >= s.hashes[0] {
+		return
+	}
+	delete(s.seen, s.hashes[0])
+	s.hashes[0] = hash
+	heap.Fix(&s.hashes, 0)
+	s.seen[hash] = true
+}
+
+// mix spreads the bits of an FNV hash, which are not uniform enough for similar keys, using the MurmurHash3 finalizer
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+func (s *distinctSketch) estimate() int {
+	if len(s.hashes) < s.k {
+		return len(s.hashes)
+	}
+	fraction := float64(s.hashes[0]) / math.MaxUint64
+	return int(float64(s.k-1) / fraction)
+}
+
+type maxHeap []uint64
+
+func (h maxHeap) Len() int            { return len(h) }
+func (h maxHeap) Less(i, j int) bool  { return h[i] > h[j] }
+func (h maxHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *maxHeap) Push(x interface{}) { *h = append(*h, x.(uint64)) }
+func (h *maxHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,148 @@
+package executor
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vegarsti/sql/object"
+)
+
+// TopN returns the rows of its child ordered by the sort keys, skipping the first offset rows
+// and then returning at most limit rows. It returns the same rows as a Sort followed by a Limit,
+// but keeps only the first offset+limit rows in a heap as it reads, instead of sorting all of them.
+// Rows that compare equal are returned in the order they were read.
+type TopN struct {
+	child  Operator
+	keys   []SortKey
+	limit  int
+	offset int
+	ctx    context.Context
+
+	heap     topNHeap
+	read     int // the rows read, which orders the rows that compare equal
+	rows     []*object.Row
+	position int
+	sorted   bool
+}
+
+func NewTopN(child Operator, keys []SortKey, limit int, offset int) *TopN {
+	return &TopN{child: child, keys: keys, limit: limit, offset: offset}
+}
+
+func (t *TopN) Open(ctx context.Context) error {
+	t.ctx = ctx
+	t.heap = nil
+	t.read = 0
+	t.rows = nil
+	t.position = 0
+	t.sorted = false
+	return t.child.Open(ctx)
+}
+
+func (t *TopN) Next() (*object.Row, error) {
+	if t.limit == 0 {
+		return nil, nil
+	}
+	if !t.sorted {
+		if err := t.sort(); err != nil {
+			return nil, err
+		}
+	}
+	if t.position >= len(t.rows) {
+		return nil, nil
+	}
+	row := t.rows[t.position]
+	t.position++
+	return row, nil
+}
+
+func (t *TopN) sort() error {
+	n := t.offset + t.limit
+	for {
+		row, err := t.child.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		sortValues, err := evalSortKeys(t.keys, *row)
+		if err != nil {
+			return err
+		}
+		row.SortByValues = sortValues
+		entry := topNEntry{row: row, read: t.read}
+		t.read++
+		if len(t.heap) < n {
+			heap.Push(&t.heap, entry)
+			continue
+		}
+		// the row was read after the rows in the heap, so it only replaces the last of them if it sorts before it
+		if lessSortValues(sortValues, t.heap[0].row.SortByValues) {
+			t.heap[0] = entry
+			heap.Fix(&t.heap, 0)
+		}
+	}
+	sort.Slice(t.heap, func(i, j int) bool { return t.heap.Less(j, i) })
+	if t.offset < len(t.heap) {
+		t.rows = make([]*object.Row, 0, len(t.heap)-t.offset)
+		for _, entry := range t.heap[t.offset:] {
+			t.rows = append(t.rows, entry.row)
+		}
+	}
+	t.heap = nil
+	if err := checkContext(t.ctx); err != nil {
+		return err
+	}
+	t.sorted = true
+	return nil
+}
+
+func (t *TopN) Close() error {
+	t.heap = nil
+	t.rows = nil
+	return t.child.Close()
+}
+
+func (t *TopN) Children() []Operator { return []Operator{t.child} }
+func (t *TopN) String() string {
+	keys := make([]string, len(t.keys))
+	for i, k := range t.keys {
+		keys[i] = k.String()
+	}
+	if t.offset == 0 {
+		return fmt.Sprintf("TopN %d by %s", t.limit, strings.Join(keys, ", "))
+	}
+	return fmt.Sprintf("TopN %d offset %d by %s", t.limit, t.offset, strings.Join(keys, ", "))
+}
+
+// topNEntry is a row kept by TopN, and when it was read
+type topNEntry struct {
+	row  *object.Row
+	read int
+}
+
+// topNHeap has the row that sorts last at the top, which is the row to drop when a row that sorts before it is read
+type topNHeap []topNEntry
+
+func (h topNHeap) Len() int { return len(h) }
+func (h topNHeap) Less(i, j int) bool {
+	if lessSortValues(h[j].row.SortByValues, h[i].row.SortByValues) {
+		return true
+	}
+	if lessSortValues(h[i].row.SortByValues, h[j].row.SortByValues) {
+		return false
+	}
+	return h[i].read > h[j].read
+}
+func (h topNHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *topNHeap) Push(x interface{}) { *h = append(*h, x.(topNEntry)) }
+func (h *topNHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,364 @@
+// Package httpapi serves a database over HTTP. A query is sent in the body of a POST request,
+// and the result is returned as JSON.
+//
+//	$ curl -d "select title, year from films where year > 2000" localhost:8080/query
+//	{"columns":["title","year"],"types":["STRING","INTEGER"],"rows":[["Arrival",2016]]}
+//
+// The body is either the query itself, or, with the content type application/json, an object
+// with the query and the values of its parameters, such as {"query": "select $1", "parameters": [1]}.
+// Each request is evaluated in a session of its own, so a transaction must begin and end in the same request.
+//
+// The status of an error response is chosen by the SQLSTATE class of the error: 409 Conflict for a
+// serialization failure, which can be retried, 408 Request Timeout for a canceled query, 400 Bad Request
+// for errors in the query, its values or the transaction it is in, and 500 Internal Server Error for errors
+// of the server or backend.
+//
+// With the header "Accept: application/x-ndjson", the result is written as newline-delimited JSON:
+// first an object with the columns and types, and then each row as an array on a line of its own.
+// The rows of a SELECT are written as they are produced. If the query fails after its first row was written,
+// the error is written as a last line with an object such as {"error": "division by zero"}.
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/vegarsti/sql/ast"
+	"github.com/vegarsti/sql/evaluator"
+	"github.com/vegarsti/sql/lexer"
+	"github.com/vegarsti/sql/object"
+	"github.com/vegarsti/sql/parser"
+)
+
+// maxQueryLength is the largest request body that is read
+const maxQueryLength = 1 << 20
+
+// Handler serves queries against a backend on /query.
+type Handler struct {
+	backend evaluator.Backend
+}
+
+// NewHandler returns a handler for the backend, which must be open.
+func NewHandler(backend evaluator.Backend) *Handler {
+	return &Handler{backend: backend}
+}
+
+// request is the body of a request with the content type application/json
+type request struct {
+	Query      string        `json:"query"`
+	Parameters []interface{} `json:"parameters"`
+}
+
+// header describes the columns of a result. A type is empty if it isn't known.
+type header struct {
+	Columns []string          `json:"columns"`
+	Types   []object.DataType `json:"types"`
+}
+
+// resultResponse is the response for statements that return rows
+type resultResponse struct {
+	header
+	Rows [][]interface{} `json:"rows"`
+}
+
+// okResponse is the response for statements that don't return rows
+type okResponse struct {
+	RowsAffected int64 `json:"rows_affected"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/query" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("method %s is not allowed, use POST", r.Method))
+		return
+	}
+	req, status, err := readRequest(w, r)
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+	ndjson := acceptsNDJSON(r)
+	result, query := h.eval(r.Context(), req, ndjson)
+	if query != nil {
+		writeRows(w, query)
+		return
+	}
+	if errorObj, ok := result.(*object.Error); ok {
+		writeError(w, errorStatus(errorObj), errorObj.Message)
+		return
+	}
+	if ndjson {
+		writeNDJSON(w, result)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	rows, ok := result.(*object.Result)
+	if !ok {
+		json.NewEncoder(w).Encode(newOKResponse(result))
+		return
+	}
+	resp := resultResponse{header: newHeader(rows), Rows: make([][]interface{}, len(rows.Rows))}
+	for i, row := range rows.Rows {
+		resp.Rows[i] = jsonValues(row)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// readRequest reads the query and its parameters from the body, and returns the status to respond with if it fails
+func readRequest(w http.ResponseWriter, r *http.Request) (*request, int, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxQueryLength))
+	if err != nil {
+		return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("the query is longer than %d bytes", maxQueryLength)
+	}
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "" {
+		if contentType, _, err = mime.ParseMediaType(contentType); err != nil {
+			return nil, http.StatusUnsupportedMediaType, fmt.Errorf("invalid content type: %s", err)
+		}
+	}
+	switch contentType {
+	case "application/json":
+		d := json.NewDecoder(bytes.NewReader(body))
+		// numbers are kept as written, so integers are not turned into floats
+		d.UseNumber()
+		req := &request{}
+		if err := d.Decode(req); err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid JSON: %s", err)
+		}
+		for i, p := range req.Parameters {
+			v, err := parameterValue(p)
+			if err != nil {
+				return nil, http.StatusBadRequest, fmt.Errorf("parameter $%d: %s", i+1, err)
+			}
+			req.Parameters[i] = v
+		}
+		return req, 0, nil
+	case "", "text/plain", "application/sql", "application/x-www-form-urlencoded":
+		// curl -d sends the query as a form
+		return &request{Query: string(body)}, 0, nil
+	}
+	return nil, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content type %s", contentType)
+}
+
+// parameterValue converts a value decoded from JSON to a value that can be given to a prepared statement
+func parameterValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case nil, bool, string:
+		return v, nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		return v.Float64()
+	}
+	return nil, fmt.Errorf("unsupported value %v", v)
+}
+
+// eval evaluates the query in a session of its own, which is cancelled when the context is done,
+// such as when the client disconnects. A query with parameters must be a single statement.
+// If stream is set and the last statement is a SELECT, its rows are returned to be read as they
+// are produced, instead of a result.
+func (h *Handler) eval(ctx context.Context, req *request, stream bool) (object.Object, *evaluator.Rows) {
+	session := evaluator.NewSession(h.backend)
+	if len(req.Parameters) == 0 {
+		p := parser.New(lexer.New(req.Query))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			return object.Errorf(object.CodeSyntaxError, "%s", strings.Join(p.Errors(), "; ")), nil
+		}
+		if len(program.Statements) == 0 {
+			return object.Errorf(object.CodeSyntaxError, "empty query"), nil
+		}
+		last := program.Statements[len(program.Statements)-1]
+		if _, ok := last.(*ast.SelectStatement); !stream || !ok {
+			return session.EvalContext(ctx, program), nil
+		}
+		for _, statement := range program.Statements[:len(program.Statements)-1] {
+			if result, ok := session.EvalContext(ctx, statement).(*object.Error); ok {
+				return result, nil
+			}
+		}
+		return queryResult(session.QueryContext(ctx, last))
+	}
+	prepared, err := session.Prepare(req.Query)
+	if err != nil {
+		return errorResult(err), nil
+	}
+	if _, ok := prepared.Statement().(*ast.SelectStatement); stream && ok {
+		return queryResult(prepared.QueryContext(ctx, req.Parameters...))
+	}
+	return prepared.ExecuteContext(ctx, req.Parameters...), nil
+}
+
+// queryResult returns the rows of a query, or the error if it failed
+func queryResult(rows *evaluator.Rows, err error) (object.Object, *evaluator.Rows) {
+	if err != nil {
+		return errorResult(err), nil
+	}
+	return nil, rows
+}
+
+// errorResult returns the error as an error object
+func errorResult(err error) *object.Error {
+	var errorObj *object.Error
+	if errors.As(err, &errorObj) {
+		return errorObj
+	}
+	return &object.Error{Message: err.Error()}
+}
+
+func acceptsNDJSON(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(accept); err == nil && mediaType == "application/x-ndjson" {
+			return true
+		}
+	}
+	return false
+}
+
+func newHeader(r *object.Result) header {
+	h := header{Columns: r.Aliases, Types: make([]object.DataType, len(r.Aliases))}
+	if h.Columns == nil {
+		h.Columns = []string{}
+	}
+	copy(h.Types, r.Types)
+	return h
+}
+
+func newOKResponse(result object.Object) okResponse {
+	if ok, isOK := result.(*object.OK); isOK {
+		return okResponse{RowsAffected: ok.RowsAffected}
+	}
+	return okResponse{}
+}
+
+// writeNDJSON writes the columns and types on the first line, and then a line for each row of the result
+func writeNDJSON(w http.ResponseWriter, result object.Object) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	e := json.NewEncoder(w)
+	r, ok := result.(*object.Result)
+	if !ok {
+		e.Encode(newOKResponse(result))
+		return
+	}
+	if err := e.Encode(newHeader(r)); err != nil {
+		return
+	}
+	for _, row := range r.Rows {
+		if err := e.Encode(jsonValues(row)); err != nil {
+			// the client has gone away
+			return
+		}
+	}
+}
+
+// writeRows writes the columns and types on the first line, and then each row on a line of its own,
+// which is flushed as soon as the row is produced. The first row is read before anything is written,
+// so that a query that fails before its first row gets an error response.
+func writeRows(w http.ResponseWriter, rows *evaluator.Rows) {
+	defer rows.Close()
+	row, err := rows.Next()
+	if err != nil {
+		errorObj := errorResult(err)
+		writeError(w, errorStatus(errorObj), errorObj.Message)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	e := json.NewEncoder(w)
+	if err := e.Encode(newHeader(&object.Result{Aliases: rows.Aliases, Types: rows.Types})); err != nil {
+		return
+	}
+	flusher, _ := w.(http.Flusher)
+	for ; row != nil; row, err = rows.Next() {
+		if err := e.Encode(jsonValues(row)); err != nil {
+			// the client has gone away
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	if err == nil {
+		err = rows.Close()
+	}
+	if err != nil {
+		e.Encode(errorResponse{Error: errorResult(err).Message})
+	}
+}
+
+// jsonValues returns the values of the row as values that can be encoded as JSON.
+// Floats that are not numbers or infinite are encoded as the strings "NaN", "Infinity" and "-Infinity".
+func jsonValues(row *object.Row) []interface{} {
+	values := make([]interface{}, len(row.Values))
+	for i, v := range row.Values {
+		switch v := v.(type) {
+		case *object.Integer:
+			values[i] = v.Value
+		case *object.Float:
+			switch {
+			case math.IsNaN(v.Value):
+				values[i] = "NaN"
+			case math.IsInf(v.Value, 1):
+				values[i] = "Infinity"
+			case math.IsInf(v.Value, -1):
+				values[i] = "-Infinity"
+			default:
+				values[i] = v.Value
+			}
+		case *object.String:
+			values[i] = v.Value
+		case *object.Boolean:
+			values[i] = v.Value
+		case *object.Null:
+			values[i] = nil
+		case *object.Date, *object.Time, *object.Timestamp, *object.TimestampTZ, *object.Interval:
+			values[i] = v.(fmt.Stringer).String()
+		case *object.Numeric:
+			// a JSON number with all the digits of the numeric
+			values[i] = json.Number(v.String())
+		default:
+			values[i] = v.Inspect()
+		}
+	}
+	return values
+}
+
+// errorStatus returns the HTTP status of the error, by the class of its SQLSTATE code.
+// Errors without a code are internal errors, or errors of the backend.
+func errorStatus(errorObj *object.Error) int {
+	switch code := errorObj.Code; {
+	case code == object.CodeSerializationFailure:
+		return http.StatusConflict
+	case code == object.CodeQueryCanceled:
+		return http.StatusRequestTimeout
+	case strings.HasPrefix(code, "22"), strings.HasPrefix(code, "42"):
+		// data exceptions, and syntax errors or access rule violations
+		return http.StatusBadRequest
+	case strings.HasPrefix(code, "25"), strings.HasPrefix(code, "26"):
+		// a transaction or prepared statement that is in the wrong state or doesn't exist
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: message})
+}
@@ -0,0 +1,231 @@
+package httpapi_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/vegarsti/sql/evaluator"
+	"github.com/vegarsti/sql/httpapi"
+	"github.com/vegarsti/sql/inmemory"
+)
+
+func newServer(t *testing.T) *httptest.Server {
+	backend := inmemory.NewBackend()
+	if err := backend.Open(); err != nil {
+		t.Fatal(err)
+	}
+	return newBackendServer(t, backend)
+}
+
+func newBackendServer(t *testing.T, backend evaluator.Backend) *httptest.Server {
+	server := httptest.NewServer(httpapi.NewHandler(backend))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func post(t *testing.T, server *httptest.Server, contentType string, accept string, body string) (int, string, string) {
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/query", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, resp.Header.Get("Content-Type"), string(b)
+}
+
+func TestQuery(t *testing.T) {
+	server := newServer(t)
+	tests := []struct {
+		contentType         string
+		accept              string
+		body                string
+		expectedStatus      int
+		expectedContentType string
+		expected            string
+	}{
+		{"text/plain", "", "create table films (title text, year integer, rating float)", 200, "application/json", `{"rows_affected":0}`},
+		{"", "", "insert into films values ('Alien', 1979, 8.5), ('Heat', 1995, 8.3)", 200, "application/json", `{"rows_affected":2}`},
+		{"application/json", "", `{"query": "insert into films values ($1, $2, $3)", "parameters": ["Arrival", 2016, 7.9]}`, 200, "application/json", `{"rows_affected":1}`},
+		{
+			"text/plain", "", "select title, year, rating, null as n from films where year > 1980 order by year",
+			200, "application/json",
+			`{"columns":["title","year","rating","n"],"types":["STRING","INTEGER","FLOAT",""],"rows":[["Heat",1995,8.3,null],["Arrival",2016,7.9,null]]}`,
+		},
+		{"text/plain", "", "select title from films where year > 2020", 200, "application/json", `{"columns":["title"],"types":["STRING"],"rows":[]}`},
+		{"application/json", "", `{"query": "select title from films where year < $1 and (rating > $2)", "parameters": [2000, 8.4]}`, 200, "application/json", `{"columns":["title"],"types":["STRING"],"rows":[["Alien"]]}`},
+		{
+			"text/plain", "application/x-ndjson", "select title, year from films order by year",
+			200, "application/x-ndjson",
+			"{\"columns\":[\"title\",\"year\"],\"types\":[\"STRING\",\"INTEGER\"]}\n[\"Alien\",1979]\n[\"Heat\",1995]\n[\"Arrival\",2016]",
+		},
+		{"text/plain", "application/x-ndjson", "insert into films values ('Up', 2009, 8.2)", 200, "application/x-ndjson", `{"rows_affected":1}`},
+		{"application/json", "application/x-ndjson", `{"query": "select title from films where year = $1", "parameters": [1995]}`, 200, "application/x-ndjson", "{\"columns\":[\"title\"],\"types\":[\"STRING\"]}\n[\"Heat\"]"},
+		{"text/plain", "application/x-ndjson", "create table empty (a integer); select a from empty", 200, "application/x-ndjson", `{"columns":["a"],"types":["INTEGER"]}`},
+		// a query that fails after its first row has been written ends with the error
+		{
+			"text/plain", "application/x-ndjson", "select title, 1 / (year - 1995) from films",
+			200, "application/x-ndjson",
+			"{\"columns\":[\"title\",\"(1 / (year - 1995))\"],\"types\":[\"STRING\",\"INTEGER\"]}\n[\"Alien\",0]\n{\"error\":\"division by zero\"}",
+		},
+		{"text/plain", "application/x-ndjson", "select 1 / (year - 1979) from films", 400, "application/json", `{"error":"division by zero"}`},
+		{"text/plain", "", "select numeric '12.50' * 2 as total", 200, "application/json", `{"columns":["total"],"types":["NUMERIC"],"rows":[[25.00]]}`},
+		{"text/plain", "", "select x from films", 400, "application/json", `{"error":"column \"x\" does not exist"}`},
+		{"text/plain", "", "select from", 400, "application/json", `{"error":"no prefix parse function for FROM token with literal 'FROM' found"}`},
+		{"text/plain", "", "", 400, "application/json", `{"error":"empty query"}`},
+		{"text/plain", "", "select date '2024-02-30'", 400, "application/json", `{"error":"invalid input syntax for type date: \"2024-02-30\""}`},
+		{"text/plain", "", "begin; begin", 400, "application/json", `{"error":"there is already a transaction in progress"}`},
+		{"application/json", "", `{"query": "select $1", "parameters": [[1]]}`, 400, "application/json", `{"error":"parameter $1: unsupported value [1]"}`},
+		{"application/json", "", `{"query": "select 1; select 2", "parameters": [1]}`, 400, "application/json", `{"error":"cannot prepare 2 statements, only one"}`},
+		{"application/json", "", `{"query":`, 400, "application/json", `{"error":"invalid JSON: unexpected EOF"}`},
+		{"image/png", "", "select 1", 415, "application/json", `{"error":"unsupported content type image/png"}`},
+	}
+	for _, tt := range tests {
+		status, contentType, body := post(t, server, tt.contentType, tt.accept, tt.body)
+		body = strings.TrimSuffix(body, "\n")
+		if status != tt.expectedStatus || contentType != tt.expectedContentType || body != tt.expected {
+			t.Fatalf("%s: expected %d %s\n%s\ngot %d %s\n%s", tt.body, tt.expectedStatus, tt.expectedContentType, tt.expected, status, contentType, body)
+		}
+	}
+}
+
+// flushRecorder counts the flushes of a response
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushes int
+}
+
+func (r *flushRecorder) Flush() {
+	r.flushes++
+	r.ResponseRecorder.Flush()
+}
+
+// TestNDJSONFlushesEachRow checks that each row of an NDJSON response is flushed as soon as it is written
+func TestNDJSONFlushesEachRow(t *testing.T) {
+	backend := inmemory.NewBackend()
+	if err := backend.Open(); err != nil {
+		t.Fatal(err)
+	}
+	handler := httpapi.NewHandler(backend)
+	query := func(accept string, body string) *flushRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(body))
+		req.Header.Set("Accept", accept)
+		w := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	query("", "create table foo (a integer); insert into foo values (1), (2), (3)")
+	w := query("application/x-ndjson", "select a from foo")
+	expected := "{\"columns\":[\"a\"],\"types\":[\"INTEGER\"]}\n[1]\n[2]\n[3]\n"
+	if w.Body.String() != expected || w.flushes != 3 {
+		t.Fatalf("expected\n%s\nwith 3 flushes, got\n%s\nwith %d", expected, w.Body.String(), w.flushes)
+	}
+}
+
+// failingBackend is a backend whose transactions fail to commit with an error
+type failingBackend struct {
+	*inmemory.Backend
+	err error
+}
+
+func (b failingBackend) Begin() (evaluator.Transaction, error) {
+	t, err := b.Backend.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return failingTransaction{Transaction: t, err: b.err}, nil
+}
+
+type failingTransaction struct {
+	evaluator.Transaction
+	err error
+}
+
+func (t failingTransaction) Commit() error {
+	t.Transaction.Rollback()
+	return t.err
+}
+
+// TestErrorStatus checks that the status of an error is chosen by its SQLSTATE class
+func TestErrorStatus(t *testing.T) {
+	conflict := failingBackend{Backend: inmemory.NewBackend(), err: evaluator.ErrSerializationFailure}
+	status, _, body := post(t, newBackendServer(t, conflict), "text/plain", "", "create table foo (a integer)")
+	if expected := `{"error":"could not serialize access due to concurrent update"}` + "\n"; status != http.StatusConflict || body != expected {
+		t.Fatalf("expected 409 %s, got %d %s", expected, status, body)
+	}
+	broken := failingBackend{Backend: inmemory.NewBackend(), err: errors.New("disk is full")}
+	status, _, body = post(t, newBackendServer(t, broken), "text/plain", "", "create table foo (a integer)")
+	if expected := `{"error":"disk is full"}` + "\n"; status != http.StatusInternalServerError || body != expected {
+		t.Fatalf("expected 500 %s, got %d %s", expected, status, body)
+	}
+	server := newServer(t)
+	values := make([]string, 200)
+	for i := range values {
+		values[i] = fmt.Sprintf("(%d)", i)
+	}
+	post(t, server, "text/plain", "", "create table big (a integer); insert into big values "+strings.Join(values, ", "))
+	for _, accept := range []string{"", "application/x-ndjson"} {
+		status, _, body = post(t, server, "text/plain", accept, "set statement_timeout = 1; select count(*) from big x, big y, big z")
+		if expected := `{"error":"canceling statement due to statement timeout"}` + "\n"; status != http.StatusRequestTimeout || body != expected {
+			t.Fatalf("expected 408 %s, got %d %s", expected, status, body)
+		}
+	}
+}
+
+func TestMethodAndPath(t *testing.T) {
+	server := newServer(t)
+	resp, err := http.Get(server.URL + "/query")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "POST" {
+		t.Fatalf("expected 405 with Allow: POST, got %d %q", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+	resp, err = http.Post(server.URL+"/other", "text/plain", strings.NewReader("select 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	server := newServer(t)
+	post(t, server, "text/plain", "", "create table counter (n integer)")
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if status, _, body := post(t, server, "text/plain", "", "insert into counter values (1); select count(*) from counter"); status != 200 {
+				t.Errorf("expected 200, got %d: %s", status, body)
+			}
+		}()
+	}
+	wg.Wait()
+	expected := `{"columns":["count(*)"],"types":["INTEGER"],"rows":[[20]]}` + "\n"
+	if _, _, body := post(t, server, "text/plain", "", "select count(*) from counter"); body != expected {
+		t.Fatalf("expected %s, got %s", expected, body)
+	}
+}
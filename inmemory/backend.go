@@ -2,62 +2,511 @@ package inmemory
 
 import (
 	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
 
+	"github.com/vegarsti/sql/evaluator"
 	"github.com/vegarsti/sql/object"
 )
 
+// Backend keeps the tables in memory. It is safe for concurrent use.
+//
+// Rows are stored as versions, which record the ID of the transaction that inserted the row, and of the
+// transaction that deleted it. Transactions are given IDs in the order they commit, and a snapshot is the ID
+// of the last transaction it sees. A scan reads a snapshot of the table without holding the lock, so readers
+// never block writers, and a transaction reads the same snapshot in all of its statements.
+// Deleted versions are removed by Vacuum once no transaction can see them.
+//
+// A backend made with NewDurableBackend also appends each change to a log, so the tables are kept across restarts.
 type Backend struct {
-	Tables map[string][]object.Column
-	Tuples map[string][]object.Row
+	// mu guards the maps, the transaction IDs and the versions of each table,
+	// except for the ID of the transaction that deleted a version, which is accessed atomically
+	mu         sync.RWMutex
+	tables     map[string]*table
+	statistics map[string]*object.TableStatistics
+	// committed is the ID of the last committed transaction
+	committed uint64
+	// active is the transactions that have begun and not ended, whose snapshots Vacuum must keep
+	active map[*Transaction]bool
+	// log is where changes are written before they are applied, or nil if the backend isn't durable
+	log *wal
 }
 
+type table struct {
+	columns []object.Column
+	created uint64 // the ID of the transaction that created the table
+	// versions are appended to, and never changed, so that a snapshot can read them without holding the lock.
+	// Vacuum makes a new slice without the versions it removes.
+	versions []*version
+	deleted  int // the number of deleted versions, which are left for Vacuum
+	// lastRowID is the ID of the last row inserted, so versions are in the order of their row IDs
+	lastRowID uint64
+}
+
+// version is a version of a row
+type version struct {
+	xmax uint64 // the ID of the transaction that deleted the row, or 0, first for 64-bit alignment
+	xmin uint64 // the ID of the transaction that inserted the row
+	// rowID identifies the row in the log, where deletes refer to the rows they delete
+	rowID uint64
+	row   object.Row
+}
+
+// visible reports whether the version was inserted, and not deleted, as of the snapshot
+func (v *version) visible(snapshot uint64) bool {
+	xmax := atomic.LoadUint64(&v.xmax)
+	return v.xmin <= snapshot && (xmax == 0 || xmax > snapshot)
+}
+
+func (v *version) isDeleted() bool {
+	return atomic.LoadUint64(&v.xmax) != 0
+}
+
+// Open reads the snapshot and replays the log of a durable backend.
 func (b *Backend) Open() error {
-	return nil
+	if b.log == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.recover()
 }
 
+// Close checkpoints a durable backend, so the log is empty, and closes the log.
 func (b *Backend) Close() error {
+	if b.log == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	err := b.checkpoint()
+	if closeErr := b.log.close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// change is the changes made by a statement or transaction, which are applied at once
+type change struct {
+	tables     []object.Table
+	inserted   map[string][]object.Row
+	deleted    map[string][]*version
+	statistics map[string]*object.TableStatistics
+}
+
+// commit applies the change, after appending it to the log of a durable backend
+func (b *Backend) commit(c *change) error {
+	if b.log != nil {
+		r, err := b.encodeChange(c)
+		if err != nil {
+			return err
+		}
+		if err := b.log.append(r); err != nil {
+			return err
+		}
+	}
+	b.apply(c)
+	if b.log != nil && b.log.size > checkpointSize {
+		// the change is in the log, so a failed checkpoint is only tried again after the next change,
+		// and reported by Close
+		b.checkpoint()
+	}
 	return nil
 }
 
+// apply applies the change as a new transaction, unless it only changes statistics, which aren't versioned
+func (b *Backend) apply(c *change) {
+	if len(c.tables) > 0 || len(c.inserted) > 0 || len(c.deleted) > 0 {
+		b.committed++
+	}
+	for _, table := range c.tables {
+		b.createTable(table.Name, table.Columns, b.committed)
+	}
+	for name, stats := range c.statistics {
+		b.statistics[name] = stats
+	}
+	for name, rows := range c.inserted {
+		for _, row := range rows {
+			b.insert(name, row, b.committed)
+		}
+	}
+	for name, versions := range c.deleted {
+		b.delete(name, versions, b.committed)
+	}
+}
+
 func (b *Backend) CreateTable(name string, columns []object.Column) error {
-	if _, ok := b.Tables[name]; ok {
-		return fmt.Errorf(`relation "%s" already exists`, name)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.tables[name]; ok {
+		return object.Errorf(object.CodeDuplicateTable, `relation "%s" already exists`, name)
 	}
-	b.Tables[name] = columns
-	b.Tuples[name] = make([]object.Row, 0)
-	return nil
+	return b.commit(&change{tables: []object.Table{{Name: name, Columns: columns}}})
+}
+
+func (b *Backend) createTable(name string, columns []object.Column, id uint64) {
+	b.tables[name] = &table{columns: columns, created: id}
 }
 
 func (b *Backend) Insert(name string, row object.Row) error {
-	if _, ok := b.Tables[name]; !ok {
-		return fmt.Errorf(`relation "%s" does not exist`, name)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, ok := b.tables[name]
+	if !ok {
+		return object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
 	}
-	b.Tuples[name] = append(b.Tuples[name], row)
-	// Populate aliases
-	for i := range b.Tuples[name] {
-		b.Tuples[name][i].Aliases = make([]string, len(b.Tables[name]))
-		for j, column := range b.Tables[name] {
-			b.Tuples[name][i].Aliases[j] = column.Name
+	if err := (object.Table{Name: name, Columns: t.columns}).CheckRow(row); err != nil {
+		return err
+	}
+	return b.commit(&change{inserted: map[string][]object.Row{name: {row}}})
+}
+
+// insert appends a version of the row, with the column names as aliases, inserted by the transaction with the ID
+func (b *Backend) insert(name string, row object.Row, id uint64) {
+	t := b.tables[name]
+	t.lastRowID++
+	t.versions = append(t.versions, &version{xmin: id, rowID: t.lastRowID, row: withAliases(t.columns, row)})
+}
+
+// withAliases returns the row with the column names as aliases
+func withAliases(columns []object.Column, row object.Row) object.Row {
+	row.Aliases = make([]string, len(columns))
+	for j, column := range columns {
+		row.Aliases[j] = column.Name
+	}
+	return row
+}
+
+// WriteBatch creates the tables and inserts the rows at once, so that other sessions see all of the changes or none of them.
+// Nothing is changed if a table already exists, or if a row can't be inserted.
+func (b *Backend) WriteBatch(tables []object.Table, rows map[string][]object.Row) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	created := make(map[string][]object.Column)
+	for _, table := range tables {
+		_, duplicate := created[table.Name]
+		if _, ok := b.tables[table.Name]; ok || duplicate {
+			return object.Errorf(object.CodeDuplicateTable, `relation "%s" already exists`, table.Name)
 		}
+		created[table.Name] = table.Columns
 	}
-	return nil
+	for name, tableRows := range rows {
+		columns, ok := created[name]
+		if t, exists := b.tables[name]; exists {
+			columns, ok = t.columns, true
+		}
+		if !ok {
+			return object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
+		}
+		for _, row := range tableRows {
+			if err := (object.Table{Name: name, Columns: columns}).CheckRow(row); err != nil {
+				return err
+			}
+		}
+	}
+	return b.commit(&change{tables: tables, inserted: rows})
+}
+
+// Delete deletes the rows in the table for which the function returns true, and returns the number of rows deleted.
+// If the function fails, no rows are deleted.
+func (b *Backend) Delete(name string, match func(object.Row) (bool, error)) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, ok := b.tables[name]
+	if !ok {
+		return 0, object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
+	}
+	var deleted []*version
+	for _, v := range t.versions {
+		if v.isDeleted() {
+			continue
+		}
+		ok, err := match(v.row)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			deleted = append(deleted, v)
+		}
+	}
+	if len(deleted) == 0 {
+		return 0, nil
+	}
+	if err := b.commit(&change{deleted: map[string][]*version{name: deleted}}); err != nil {
+		return 0, err
+	}
+	return len(deleted), nil
+}
+
+// delete marks the versions as deleted by the transaction with the ID, and vacuums the table if most of its versions are deleted
+func (b *Backend) delete(name string, versions []*version, id uint64) {
+	t := b.tables[name]
+	for _, v := range versions {
+		atomic.StoreUint64(&v.xmax, id)
+	}
+	t.deleted += len(versions)
+	if 2*t.deleted > len(t.versions) {
+		b.vacuum(t, b.horizon())
+	}
+}
+
+// snapshot returns the versions of the table, or nil if the table didn't exist as of the snapshot
+func (b *Backend) snapshot(name string, snapshot uint64) []*version {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	t, ok := b.tables[name]
+	if !ok || t.created > snapshot {
+		return nil
+	}
+	return t.versions
+}
+
+// Scan returns an iterator over the rows in the table.
+// Rows inserted or deleted after the scan has started are not seen by the iterator.
+func (b *Backend) Scan(name string) (object.RowIterator, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	t, ok := b.tables[name]
+	if !ok {
+		return nil, object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
+	}
+	return &versionIterator{versions: t.versions, snapshot: b.committed}, nil
+}
+
+// ScanPartitions returns n iterators over consecutive parts of the rows in the table, as of the same snapshot,
+// with the values of the columns at the positions, in that order. The iterators can be read at the same time.
+func (b *Backend) ScanPartitions(name string, columns []int, n int) ([]object.RowIterator, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	t, ok := b.tables[name]
+	if !ok {
+		return nil, object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
+	}
+	it := &versionIterator{versions: t.versions, snapshot: b.committed}
+	return it.partition(name, t.columns, columns, n)
+}
+
+// partition splits the rows of the iterator into n iterators over consecutive parts of them, with the values
+// of the columns at the positions. The rows inserted in a transaction are in the last part.
+func (it *versionIterator) partition(name string, tableColumns []object.Column, columns []int, n int) ([]object.RowIterator, error) {
+	all := len(columns) == len(tableColumns)
+	for j, i := range columns {
+		if i < 0 || i >= len(tableColumns) {
+			return nil, fmt.Errorf(`relation "%s" has no column %d`, name, i)
+		}
+		all = all && i == j
+	}
+	partitions := make([]object.RowIterator, n)
+	for k := range partitions {
+		from, to := len(it.versions)*k/n, len(it.versions)*(k+1)/n
+		part := &versionIterator{versions: it.versions[from:to:to], snapshot: it.snapshot, deleted: it.deleted}
+		if k == n-1 {
+			part.inserted = it.inserted
+		}
+		partitions[k] = part
+		if !all {
+			partitions[k] = newColumnIterator(part, name, tableColumns, columns)
+		}
+	}
+	return partitions, nil
+}
+
+// columnIterator returns rows with the values of the columns at the positions of the rows of another iterator
+type columnIterator struct {
+	rows       object.RowIterator
+	columns    []int
+	aliases    []string
+	tableNames []string
+}
+
+func newColumnIterator(rows object.RowIterator, name string, columns []object.Column, positions []int) *columnIterator {
+	it := &columnIterator{
+		rows:       rows,
+		columns:    positions,
+		aliases:    make([]string, len(positions)),
+		tableNames: make([]string, len(positions)),
+	}
+	for j, i := range positions {
+		it.aliases[j] = columns[i].Name
+		it.tableNames[j] = name
+	}
+	return it
+}
+
+func (it *columnIterator) Next() (*object.Row, error) {
+	row, err := it.rows.Next()
+	if row == nil || err != nil {
+		return nil, err
+	}
+	values := make([]object.Object, len(it.columns))
+	for j, i := range it.columns {
+		values[j] = row.Values[i]
+	}
+	return &object.Row{Aliases: it.aliases, Values: values, TableName: it.tableNames}, nil
 }
 
-func (b *Backend) Rows(name string) ([]object.Row, error) {
-	rows, ok := b.Tuples[name]
+func (it *columnIterator) Close() error { return it.rows.Close() }
+
+// RowCount returns the number of rows in the table.
+func (b *Backend) RowCount(name string) (int, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	t, ok := b.tables[name]
 	if !ok {
-		return nil, fmt.Errorf(`relation "%s" does not exist`, name)
+		return 0, object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
 	}
-	return rows, nil
+	return len(t.versions) - t.deleted, nil
 }
 
 func (b *Backend) Columns(name string) ([]object.Column, error) {
-	return b.Tables[name], nil
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if t, ok := b.tables[name]; ok {
+		return t.columns, nil
+	}
+	return nil, object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
+}
+
+// TableNames returns the names of all tables, sorted.
+func (b *Backend) TableNames() ([]string, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.tableNames(b.committed), nil
+}
+
+// tableNames returns the names of the tables as of the snapshot, sorted
+func (b *Backend) tableNames(snapshot uint64) []string {
+	names := make([]string, 0, len(b.tables))
+	for name, t := range b.tables {
+		if t.created <= snapshot {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetStatistics stores the statistics of the table, replacing any earlier statistics.
+func (b *Backend) SetStatistics(name string, stats *object.TableStatistics) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.tables[name]; !ok {
+		return object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
+	}
+	return b.commit(&change{statistics: map[string]*object.TableStatistics{name: stats}})
+}
+
+// Statistics returns the statistics of the table, or nil if the table hasn't been analyzed.
+func (b *Backend) Statistics(name string) (*object.TableStatistics, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if _, ok := b.tables[name]; !ok {
+		return nil, object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
+	}
+	return b.statistics[name], nil
+}
+
+// Begin starts a transaction, which reads a snapshot of the tables as they are now.
+func (b *Backend) Begin() (evaluator.Transaction, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := newTransaction(b, b.committed)
+	b.active[t] = true
+	return t, nil
+}
+
+// Vacuum removes the versions of rows that were deleted before the snapshot of every transaction in progress,
+// and returns the number of versions removed. Tables are also vacuumed when most of their versions are deleted.
+func (b *Backend) Vacuum() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	horizon := b.horizon()
+	removed := 0
+	for _, t := range b.tables {
+		removed += b.vacuum(t, horizon)
+	}
+	return removed
+}
+
+// horizon returns the oldest snapshot of the transactions in progress.
+// Versions deleted by the transaction with this ID, or earlier, are seen by no transaction.
+func (b *Backend) horizon() uint64 {
+	horizon := b.committed
+	for t := range b.active {
+		if t.snapshot < horizon {
+			horizon = t.snapshot
+		}
+	}
+	return horizon
+}
+
+// vacuum removes the versions of the table that were deleted as of the horizon, and returns the number removed.
+// The versions are copied to a new slice, since scans may be reading the old one.
+func (b *Backend) vacuum(t *table, horizon uint64) int {
+	if t.deleted == 0 {
+		return 0
+	}
+	versions := make([]*version, 0, len(t.versions)-t.deleted)
+	for _, v := range t.versions {
+		if xmax := atomic.LoadUint64(&v.xmax); xmax == 0 || xmax > horizon {
+			versions = append(versions, v)
+		}
+	}
+	removed := len(t.versions) - len(versions)
+	if removed == 0 {
+		return 0
+	}
+	t.versions = versions
+	t.deleted -= removed
+	return removed
+}
+
+// versionIterator returns the rows of the versions that are visible in the snapshot and not deleted
+// by the transaction reading them, followed by the rows inserted by that transaction
+type versionIterator struct {
+	versions []*version
+	snapshot uint64
+	deleted  map[*version]bool
+	inserted []object.Row
+}
+
+func (it *versionIterator) Next() (*object.Row, error) {
+	for len(it.versions) > 0 {
+		v := it.versions[0]
+		it.versions = it.versions[1:]
+		if v.visible(it.snapshot) && !it.deleted[v] {
+			row := v.row
+			return &row, nil
+		}
+	}
+	if len(it.inserted) > 0 {
+		row := it.inserted[0]
+		it.inserted = it.inserted[1:]
+		return &row, nil
+	}
+	return nil, nil
+}
+
+func (it *versionIterator) Close() error {
+	it.versions = nil
+	it.inserted = nil
+	return nil
 }
 
 func NewBackend() *Backend {
 	return &Backend{
-		Tables: make(map[string][]object.Column),
-		Tuples: make(map[string][]object.Row),
+		tables:     make(map[string]*table),
+		statistics: make(map[string]*object.TableStatistics),
+		active:     make(map[*Transaction]bool),
 	}
 }
+
+// NewDurableBackend returns a backend that keeps the tables in memory, and appends each change to a log
+// in the file with the suffix -wal. The log is checkpointed into a snapshot in the file when it gets large,
+// and on Close. Open reads the snapshot and replays the log.
+func NewDurableBackend(filename string) *Backend {
+	b := NewBackend()
+	b.log = &wal{filename: filename}
+	return b
+}
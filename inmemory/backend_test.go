@@ -0,0 +1,503 @@
+package inmemory_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/vegarsti/sql/backendtest"
+	"github.com/vegarsti/sql/evaluator"
+	"github.com/vegarsti/sql/inmemory"
+	"github.com/vegarsti/sql/object"
+)
+
+func count(t *testing.T, backend *inmemory.Backend, name string) int {
+	rows, err := object.Collect(mustScan(t, backend, name))
+	if err != nil {
+		t.Error(err)
+	}
+	for _, row := range rows {
+		if len(row.Aliases) != 1 || row.Aliases[0] != "a" || len(row.Values) != 1 {
+			t.Errorf("unexpected row %+v", row)
+		}
+	}
+	return len(rows)
+}
+
+func mustScan(t *testing.T, backend evaluator.Backend, name string) object.RowIterator {
+	it, err := backend.Scan(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return it
+}
+
+// TestConcurrentInsertAndScan is meant to be run with the race detector
+func TestConcurrentInsertAndScan(t *testing.T) {
+	backend := inmemory.NewBackend()
+	if err := backend.CreateTable("foo", []object.Column{{Name: "a", Type: object.INTEGER}}); err != nil {
+		t.Fatal(err)
+	}
+	const writers, readers, rowsPerWriter = 8, 8, 200
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rowsPerWriter; i++ {
+				row := object.Row{Values: []object.Object{&object.Integer{Value: int64(w*rowsPerWriter + i)}}}
+				if err := backend.Insert("foo", row); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			previous := 0
+			for i := 0; i < 50; i++ {
+				n := count(t, backend, "foo")
+				if n < previous {
+					t.Errorf("scan saw %d rows after seeing %d", n, previous)
+				}
+				previous = n
+				if _, err := backend.RowCount("foo"); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n := count(t, backend, "foo"); n != writers*rowsPerWriter {
+		t.Fatalf("expected %d rows, got %d", writers*rowsPerWriter, n)
+	}
+}
+
+func TestScanIsSnapshot(t *testing.T) {
+	backend := inmemory.NewBackend()
+	if err := backend.CreateTable("foo", []object.Column{{Name: "a", Type: object.INTEGER}}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		backend.Insert("foo", object.Row{Values: []object.Object{&object.Integer{Value: int64(i)}}})
+	}
+	it := mustScan(t, backend, "foo")
+	backend.Insert("foo", object.Row{Values: []object.Object{&object.Integer{Value: 3}}})
+	rows, err := object.Collect(it)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("expected the scan to see 3 rows, got %d", len(rows))
+	}
+}
+
+func TestConcurrentWriteBatch(t *testing.T) {
+	backend := inmemory.NewBackend()
+	if err := backend.CreateTable("foo", []object.Column{{Name: "a", Type: object.INTEGER}}); err != nil {
+		t.Fatal(err)
+	}
+	const batches, batchSize = 50, 10
+	var wg sync.WaitGroup
+	for b := 0; b < batches; b++ {
+		wg.Add(1)
+		go func(b int) {
+			defer wg.Done()
+			rows := make([]object.Row, batchSize)
+			for i := range rows {
+				rows[i] = object.Row{Values: []object.Object{&object.Integer{Value: int64(i)}}}
+			}
+			table := object.Table{Name: fmt.Sprintf("bar%d", b), Columns: []object.Column{{Name: "a", Type: object.INTEGER}}}
+			if err := backend.WriteBatch([]object.Table{table}, map[string][]object.Row{"foo": rows, table.Name: rows}); err != nil {
+				t.Error(err)
+			}
+		}(b)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// a batch is seen all at once, or not at all
+			if n := count(t, backend, "foo"); n%batchSize != 0 {
+				t.Errorf("scan saw %d rows, which is not a whole number of batches", n)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := count(t, backend, "foo"); n != batches*batchSize {
+		t.Fatalf("expected %d rows, got %d", batches*batchSize, n)
+	}
+
+	err := backend.WriteBatch(
+		[]object.Table{{Name: "baz", Columns: []object.Column{{Name: "a", Type: object.INTEGER}}}, {Name: "bar0"}},
+		map[string][]object.Row{"foo": {{Values: []object.Object{&object.Integer{Value: 1}}}}},
+	)
+	if err == nil || err.Error() != `relation "bar0" already exists` {
+		t.Fatalf("expected error for existing table, got %v", err)
+	}
+	if columns, _ := backend.Columns("baz"); columns != nil {
+		t.Fatalf("expected nothing to be written when the batch fails")
+	}
+	if n := count(t, backend, "foo"); n != batches*batchSize {
+		t.Fatalf("expected nothing to be written when the batch fails, got %d rows", n)
+	}
+}
+
+func insertNumbers(t *testing.T, backend *inmemory.Backend, n int) {
+	if err := backend.CreateTable("foo", []object.Column{{Name: "a", Type: object.INTEGER}}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if err := backend.Insert("foo", object.Row{Values: []object.Object{&object.Integer{Value: int64(i)}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func deleteWhere(f func(int64) bool) func(object.Row) (bool, error) {
+	return func(row object.Row) (bool, error) {
+		return f(row.Values[0].(*object.Integer).Value), nil
+	}
+}
+
+func TestTransactionSnapshot(t *testing.T) {
+	backend := inmemory.NewBackend()
+	insertNumbers(t, backend, 4)
+	tx, err := backend.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.Insert("foo", object.Row{Values: []object.Object{&object.Integer{Value: 4}}})
+	if _, err := backend.Delete("foo", deleteWhere(func(a int64) bool { return a < 2 })); err != nil {
+		t.Fatal(err)
+	}
+	backend.CreateTable("bar", []object.Column{{Name: "b", Type: object.INTEGER}})
+	if n := count(t, backend, "foo"); n != 3 {
+		t.Fatalf("expected 3 rows after the changes, got %d", n)
+	}
+	rows, err := object.Collect(mustScan(t, tx, "foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("expected the transaction to see 4 rows, got %d", len(rows))
+	}
+	if columns, _ := tx.Columns("bar"); columns != nil {
+		t.Fatalf("expected the transaction not to see a table created after it began")
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err == nil {
+		t.Fatalf("expected error for committing twice")
+	}
+}
+
+func TestDeleteConflict(t *testing.T) {
+	backend := inmemory.NewBackend()
+	insertNumbers(t, backend, 4)
+	first, _ := backend.Begin()
+	second, _ := backend.Begin()
+	third, _ := backend.Begin()
+	for _, tt := range []struct {
+		tx       evaluator.Transaction
+		f        func(int64) bool
+		expected error
+	}{
+		{first, func(a int64) bool { return a == 1 }, nil},
+		{second, func(a int64) bool { return a <= 1 }, evaluator.ErrSerializationFailure},
+		{third, func(a int64) bool { return a == 2 }, nil},
+	} {
+		if n, err := tt.tx.(evaluator.Deleter).Delete("foo", deleteWhere(tt.f)); err != nil || n == 0 {
+			t.Fatalf("expected rows to be deleted, got %d %v", n, err)
+		}
+		if err := tt.tx.Commit(); err != tt.expected {
+			t.Fatalf("expected commit to return %v, got %v", tt.expected, err)
+		}
+	}
+	if n := count(t, backend, "foo"); n != 2 {
+		t.Fatalf("expected 2 rows, got %d", n)
+	}
+}
+
+func TestVacuum(t *testing.T) {
+	backend := inmemory.NewBackend()
+	insertNumbers(t, backend, 10)
+	tx, _ := backend.Begin()
+	if n, err := backend.Delete("foo", deleteWhere(func(a int64) bool { return a%2 == 0 })); err != nil || n != 5 {
+		t.Fatalf("expected 5 rows to be deleted, got %d %v", n, err)
+	}
+	// the transaction can still see the deleted rows
+	if n := backend.Vacuum(); n != 0 {
+		t.Fatalf("expected no versions to be removed while the transaction is in progress, got %d", n)
+	}
+	rows, err := object.Collect(mustScan(t, tx, "foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 10 {
+		t.Fatalf("expected the transaction to see 10 rows, got %d", len(rows))
+	}
+	it := mustScan(t, backend, "foo")
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if n := backend.Vacuum(); n != 5 {
+		t.Fatalf("expected 5 versions to be removed, got %d", n)
+	}
+	// a scan that started before vacuuming reads the versions it started with
+	if rows, err := object.Collect(it); err != nil || len(rows) != 5 {
+		t.Fatalf("expected the scan to see 5 rows, got %d %v", len(rows), err)
+	}
+	if n, _ := backend.RowCount("foo"); n != 5 {
+		t.Fatalf("expected 5 rows, got %d", n)
+	}
+	// deleting most of the rows vacuums the table
+	backend.Delete("foo", deleteWhere(func(a int64) bool { return a > 1 }))
+	if n := backend.Vacuum(); n != 0 {
+		t.Fatalf("expected the table to have been vacuumed, but %d versions were removed", n)
+	}
+}
+
+// TestConcurrentTransactions is meant to be run with the race detector
+func TestConcurrentTransactions(t *testing.T) {
+	backend := inmemory.NewBackend()
+	const rows = 200
+	insertNumbers(t, backend, rows)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < rows; i += 4 {
+				tx, _ := backend.Begin()
+				if _, err := tx.(evaluator.Deleter).Delete("foo", deleteWhere(func(a int64) bool { return a == int64(i) })); err != nil {
+					t.Error(err)
+				}
+				if err := tx.Commit(); err != nil && err != evaluator.ErrSerializationFailure {
+					t.Error(err)
+				}
+			}
+		}(w)
+	}
+	for r := 0; r < 8; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				tx, _ := backend.Begin()
+				first, _ := object.Collect(mustScan(t, tx, "foo"))
+				second, _ := object.Collect(mustScan(t, tx, "foo"))
+				if len(first) != len(second) {
+					t.Errorf("a transaction saw %d rows and then %d", len(first), len(second))
+				}
+				tx.Commit()
+			}
+		}()
+	}
+	wg.Wait()
+	if n := count(t, backend, "foo"); n != 0 {
+		t.Fatalf("expected all rows to be deleted, got %d", n)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) evaluator.Backend {
+		backend := inmemory.NewBackend()
+		if err := backend.Open(); err != nil {
+			t.Fatal(err)
+		}
+		return backend
+	})
+}
+
+func TestDurableConformance(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) evaluator.Backend {
+		backend := inmemory.NewDurableBackend(filepath.Join(t.TempDir(), "test.db"))
+		backendtest.Open(t, backend)
+		return backend
+	})
+}
+
+// openDurable opens a durable backend. A test simulates a crash by opening the file again without closing the backend.
+func openDurable(t *testing.T, file string) *inmemory.Backend {
+	backend := inmemory.NewDurableBackend(file)
+	if err := backend.Open(); err != nil {
+		t.Fatal(err)
+	}
+	return backend
+}
+
+// numbers returns the values of the rows in the table foo
+func numbers(t *testing.T, backend evaluator.Backend) []int64 {
+	rows, err := object.Collect(mustScan(t, backend, "foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make([]int64, len(rows))
+	for i, row := range rows {
+		values[i] = row.Values[0].(*object.Integer).Value
+	}
+	return values
+}
+
+func checkNumbers(t *testing.T, backend evaluator.Backend, expected []int64) {
+	t.Helper()
+	if got := numbers(t, backend); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected rows %v. got=%v", expected, got)
+	}
+}
+
+func TestDurableRecovery(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.db")
+	backend := openDurable(t, file)
+	insertNumbers(t, backend, 10)
+	if _, err := backend.Delete("foo", deleteWhere(func(v int64) bool { return v%3 == 0 })); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := backend.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Insert("foo", object.Row{Values: []object.Object{&object.Integer{Value: 10}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.(evaluator.Deleter).Delete("foo", deleteWhere(func(v int64) bool { return v == 1 })); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	stats := &object.TableStatistics{RowCount: 6, Columns: []object.ColumnStatistics{
+		{Name: "a", DistinctCount: 6, Min: &object.Integer{Value: 2}, Max: &object.Integer{Value: 10}},
+	}}
+	if err := backend.SetStatistics("foo", stats); err != nil {
+		t.Fatal(err)
+	}
+	expected := []int64{2, 4, 5, 7, 8, 10}
+	checkNumbers(t, backend, expected)
+
+	recovered := openDurable(t, file)
+	defer recovered.Close()
+	checkNumbers(t, recovered, expected)
+	if n, err := recovered.RowCount("foo"); err != nil || n != len(expected) {
+		t.Fatalf("expected %d rows. got=%d, %v", len(expected), n, err)
+	}
+	if got, err := recovered.Statistics("foo"); err != nil || !reflect.DeepEqual(got, stats) {
+		t.Fatalf("expected statistics %+v. got=%+v, %v", stats, got, err)
+	}
+	// rows inserted after recovering are deleted by their row IDs like the others
+	if err := recovered.Insert("foo", object.Row{Values: []object.Object{&object.Integer{Value: 11}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recovered.Delete("foo", deleteWhere(func(v int64) bool { return v == 2 || v == 11 })); err != nil {
+		t.Fatal(err)
+	}
+	checkNumbers(t, openDurable(t, file), expected[1:])
+}
+
+func TestDurableTornRecord(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.db")
+	backend := openDurable(t, file)
+	insertNumbers(t, backend, 5)
+	info, err := os.Stat(file + "-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := info.Size()
+	if err := backend.Insert("foo", object.Row{Values: []object.Object{&object.Integer{Value: 5}}}); err != nil {
+		t.Fatal(err)
+	}
+	info, err = os.Stat(file + "-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordSize := info.Size() - size
+	wal, err := os.ReadFile(file + "-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the last record is lost wherever it is torn, and the log can be appended to afterwards
+	for _, cut := range []int64{1, 4, 8, recordSize / 2, recordSize - 1} {
+		t.Run(fmt.Sprintf("cut %d bytes", cut), func(t *testing.T) {
+			if err := os.WriteFile(file+"-wal", wal[:size+recordSize-cut], 0600); err != nil {
+				t.Fatal(err)
+			}
+			recovered := openDurable(t, file)
+			checkNumbers(t, recovered, []int64{0, 1, 2, 3, 4})
+			if err := recovered.Insert("foo", object.Row{Values: []object.Object{&object.Integer{Value: 6}}}); err != nil {
+				t.Fatal(err)
+			}
+			checkNumbers(t, openDurable(t, file), []int64{0, 1, 2, 3, 4, 6})
+		})
+	}
+
+	t.Run("corrupt record", func(t *testing.T) {
+		corrupt := append([]byte(nil), wal...)
+		corrupt[len(corrupt)-2] ^= 0xff
+		if err := os.WriteFile(file+"-wal", corrupt, 0600); err != nil {
+			t.Fatal(err)
+		}
+		checkNumbers(t, openDurable(t, file), []int64{0, 1, 2, 3, 4})
+	})
+
+	// a length beyond the end of the log is torn, and nothing is allocated for it
+	t.Run("corrupt length", func(t *testing.T) {
+		corrupt := append([]byte(nil), wal...)
+		binary.BigEndian.PutUint32(corrupt[size:], 0xffffffff)
+		if err := os.WriteFile(file+"-wal", corrupt, 0600); err != nil {
+			t.Fatal(err)
+		}
+		checkNumbers(t, openDurable(t, file), []int64{0, 1, 2, 3, 4})
+	})
+
+	// the records after a corrupt record would be lost, so it isn't taken to be the end of the log
+	t.Run("corrupt record before the last", func(t *testing.T) {
+		corrupt := append([]byte(nil), wal...)
+		corrupt[size-2] ^= 0xff
+		if err := os.WriteFile(file+"-wal", corrupt, 0600); err != nil {
+			t.Fatal(err)
+		}
+		err := inmemory.NewDurableBackend(file).Open()
+		if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+			t.Fatalf("expected a checksum mismatch. got=%v", err)
+		}
+	})
+}
+
+func TestDurableCheckpoint(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.db")
+	backend := openDurable(t, file)
+	insertNumbers(t, backend, 5)
+	if _, err := backend.Delete("foo", deleteWhere(func(v int64) bool { return v == 0 })); err != nil {
+		t.Fatal(err)
+	}
+	wal, err := os.ReadFile(file + "-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(file + "-wal"); err != nil || info.Size() != 0 {
+		t.Fatalf("expected the log to be empty after a checkpoint. got=%v, %v", info, err)
+	}
+
+	// the records in the snapshot are skipped if the log wasn't emptied after the snapshot was written
+	if err := os.WriteFile(file+"-wal", wal, 0600); err != nil {
+		t.Fatal(err)
+	}
+	backend = openDurable(t, file)
+	checkNumbers(t, backend, []int64{1, 2, 3, 4})
+	if _, err := backend.Delete("foo", deleteWhere(func(v int64) bool { return v == 1 })); err != nil {
+		t.Fatal(err)
+	}
+	checkNumbers(t, openDurable(t, file), []int64{2, 3, 4})
+}
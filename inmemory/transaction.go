@@ -0,0 +1,251 @@
+package inmemory
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/vegarsti/sql/evaluator"
+	"github.com/vegarsti/sql/object"
+)
+
+// Transaction reads a snapshot of the tables as they were when it began, together with its own changes,
+// which are applied when it commits. If another transaction has deleted a row that the transaction deleted,
+// and committed after the transaction began, Commit fails with evaluator.ErrSerializationFailure.
+// A transaction must not be used by several goroutines at the same time.
+type Transaction struct {
+	backend  *Backend
+	snapshot uint64
+	ended    bool
+
+	tables     map[string][]object.Column // tables created in the transaction
+	inserted   map[string][]object.Row    // rows inserted in the transaction
+	deleted    map[string][]*version      // versions deleted in the transaction
+	isDeleted  map[*version]bool
+	statistics map[string]*object.TableStatistics // statistics of tables created in the transaction
+}
+
+func newTransaction(backend *Backend, snapshot uint64) *Transaction {
+	return &Transaction{
+		backend:    backend,
+		snapshot:   snapshot,
+		tables:     make(map[string][]object.Column),
+		inserted:   make(map[string][]object.Row),
+		deleted:    make(map[string][]*version),
+		isDeleted:  make(map[*version]bool),
+		statistics: make(map[string]*object.TableStatistics),
+	}
+}
+
+func (t *Transaction) Open() error {
+	return nil
+}
+
+// Close rolls back the transaction, unless it has ended.
+func (t *Transaction) Close() error {
+	if t.ended {
+		return nil
+	}
+	return t.Rollback()
+}
+
+// Commit applies the changes made in the transaction. Nothing is applied if another transaction
+// has created a table with the same name as a table created in the transaction, or has deleted
+// a row that was deleted in the transaction.
+func (t *Transaction) Commit() error {
+	if err := t.end(); err != nil {
+		return err
+	}
+	b := t.backend
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.active, t)
+	if len(t.tables) == 0 && len(t.inserted) == 0 && len(t.deleted) == 0 {
+		return nil
+	}
+	for name := range t.tables {
+		if _, ok := b.tables[name]; ok {
+			return object.Errorf(object.CodeDuplicateTable, `relation "%s" already exists`, name)
+		}
+	}
+	for _, versions := range t.deleted {
+		for _, v := range versions {
+			if v.isDeleted() {
+				return evaluator.ErrSerializationFailure
+			}
+		}
+	}
+	c := &change{inserted: t.inserted, deleted: t.deleted, statistics: t.statistics}
+	for name, columns := range t.tables {
+		c.tables = append(c.tables, object.Table{Name: name, Columns: columns})
+	}
+	return b.commit(c)
+}
+
+// Rollback throws away the changes made in the transaction.
+func (t *Transaction) Rollback() error {
+	if err := t.end(); err != nil {
+		return err
+	}
+	t.backend.mu.Lock()
+	defer t.backend.mu.Unlock()
+	delete(t.backend.active, t)
+	return nil
+}
+
+func (t *Transaction) end() error {
+	if t.ended {
+		return fmt.Errorf("transaction has already ended")
+	}
+	t.ended = true
+	return nil
+}
+
+// exists reports whether the table exists in the snapshot or was created in the transaction
+func (t *Transaction) exists(name string) bool {
+	_, err := t.Columns(name)
+	return err == nil
+}
+
+func (t *Transaction) CreateTable(name string, columns []object.Column) error {
+	if t.exists(name) {
+		return object.Errorf(object.CodeDuplicateTable, `relation "%s" already exists`, name)
+	}
+	t.tables[name] = columns
+	return nil
+}
+
+func (t *Transaction) Insert(name string, row object.Row) error {
+	columns, err := t.Columns(name)
+	if err != nil {
+		return err
+	}
+	if err := (object.Table{Name: name, Columns: columns}).CheckRow(row); err != nil {
+		return err
+	}
+	t.inserted[name] = append(t.inserted[name], withAliases(columns, row))
+	return nil
+}
+
+// Delete deletes the rows in the table for which the function returns true, and returns the number of rows deleted.
+// Rows in the snapshot are deleted for other transactions when the transaction commits.
+func (t *Transaction) Delete(name string, match func(object.Row) (bool, error)) (int, error) {
+	if !t.exists(name) {
+		return 0, object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
+	}
+	var deleted []*version
+	for _, v := range t.backend.snapshot(name, t.snapshot) {
+		if !v.visible(t.snapshot) || t.isDeleted[v] {
+			continue
+		}
+		ok, err := match(v.row)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			deleted = append(deleted, v)
+		}
+	}
+	var kept []object.Row
+	for _, row := range t.inserted[name] {
+		ok, err := match(row)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			kept = append(kept, row)
+		}
+	}
+	n := len(deleted) + len(t.inserted[name]) - len(kept)
+	for _, v := range deleted {
+		t.isDeleted[v] = true
+	}
+	t.deleted[name] = append(t.deleted[name], deleted...)
+	t.inserted[name] = kept
+	return n, nil
+}
+
+// Scan returns the rows in the snapshot that haven't been deleted in the transaction,
+// followed by the rows inserted in the transaction.
+func (t *Transaction) Scan(name string) (object.RowIterator, error) {
+	if !t.exists(name) {
+		return nil, object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
+	}
+	return &versionIterator{
+		versions: t.backend.snapshot(name, t.snapshot),
+		snapshot: t.snapshot,
+		deleted:  t.isDeleted,
+		inserted: t.inserted[name],
+	}, nil
+}
+
+// ScanPartitions is like Scan, but returns n iterators over consecutive parts of the rows, with the values
+// of the columns at the positions, in that order. The iterators can be read at the same time, as long as
+// the transaction isn't changed.
+func (t *Transaction) ScanPartitions(name string, columns []int, n int) ([]object.RowIterator, error) {
+	tableColumns, err := t.Columns(name)
+	if err != nil {
+		return nil, err
+	}
+	it := &versionIterator{
+		versions: t.backend.snapshot(name, t.snapshot),
+		snapshot: t.snapshot,
+		deleted:  t.isDeleted,
+		inserted: t.inserted[name],
+	}
+	return it.partition(name, tableColumns, columns, n)
+}
+
+// RowCount returns the number of rows in the table, counting rows that other transactions
+// have inserted or deleted since the snapshot, so it is only an estimate.
+func (t *Transaction) RowCount(name string) (int, error) {
+	if _, ok := t.tables[name]; ok {
+		return len(t.inserted[name]), nil
+	}
+	n, err := t.backend.RowCount(name)
+	if err != nil {
+		return 0, err
+	}
+	return n + len(t.inserted[name]) - len(t.deleted[name]), nil
+}
+
+func (t *Transaction) Columns(name string) ([]object.Column, error) {
+	if columns, ok := t.tables[name]; ok {
+		return columns, nil
+	}
+	t.backend.mu.RLock()
+	defer t.backend.mu.RUnlock()
+	if table, ok := t.backend.tables[name]; ok && table.created <= t.snapshot {
+		return table.columns, nil
+	}
+	return nil, object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
+}
+
+// TableNames returns the names of the tables in the snapshot and the tables created in the transaction, sorted.
+func (t *Transaction) TableNames() ([]string, error) {
+	t.backend.mu.RLock()
+	names := t.backend.tableNames(t.snapshot)
+	t.backend.mu.RUnlock()
+	for name := range t.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// SetStatistics stores the statistics of the table. Statistics are not versioned, so they are seen
+// by other transactions at once, except for the statistics of tables created in the transaction.
+func (t *Transaction) SetStatistics(name string, stats *object.TableStatistics) error {
+	if _, ok := t.tables[name]; ok {
+		t.statistics[name] = stats
+		return nil
+	}
+	return t.backend.SetStatistics(name, stats)
+}
+
+// Statistics returns the statistics of the table, or nil if the table hasn't been analyzed.
+func (t *Transaction) Statistics(name string) (*object.TableStatistics, error) {
+	if _, ok := t.tables[name]; ok {
+		return t.statistics[name], nil
+	}
+	return t.backend.Statistics(name)
+}
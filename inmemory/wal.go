@@ -0,0 +1,404 @@
+package inmemory
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/vegarsti/sql/object"
+)
+
+// A durable backend appends each change to the log before applying it, and syncs the log,
+// so a change is kept once the statement or transaction making it has returned.
+// A record in the log is
+//
+//	the length of the record as a uint32
+//	a CRC-32 checksum of the record
+//	the record as JSON, with the rows encoded by object.EncodeRow
+//
+// The log is checkpointed by writing a snapshot of the tables to a new file, which replaces the
+// snapshot file, and emptying the log. Records are numbered, and the snapshot has the number
+// of the last record in it, so records that are in the snapshot aren't replayed if the process
+// stops between writing the snapshot and emptying the log. If the process stops while a record
+// is appended, the torn record is thrown away, together with the change in it. A record that is
+// corrupt anywhere else in the log is an error when the log is replayed.
+
+// checkpointSize is the size the log grows to before it is checkpointed
+const checkpointSize = 16 << 20
+
+type wal struct {
+	// filename is the snapshot file, and the log is the file with the suffix -wal
+	filename string
+	file     *os.File
+	size     int64
+	// sequence is the number of the last record
+	sequence uint64
+}
+
+// record is a change in the log
+type record struct {
+	Sequence uint64
+	Tables   []object.Table      `json:",omitempty"`
+	Inserted map[string][][]byte `json:",omitempty"`
+	// Deleted is the row IDs of the rows deleted from each table
+	Deleted map[string][]uint64 `json:",omitempty"`
+	// Statistics are encoded by object.EncodeStatistics
+	Statistics map[string][]byte `json:",omitempty"`
+}
+
+// snapshot is the tables as of a record in the log
+type snapshot struct {
+	Sequence uint64
+	Tables   []snapshotTable
+}
+
+type snapshotTable struct {
+	Name       string
+	Columns    []object.Column
+	LastRowID  uint64
+	RowIDs     []uint64
+	Rows       [][]byte
+	Statistics []byte `json:",omitempty"`
+}
+
+// append appends the record to the log, and syncs it
+func (w *wal) append(r *record) error {
+	r.Sequence = w.sequence + 1
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("json marshal record: %w", err)
+	}
+	buf := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(payload))
+	buf = append(buf, payload...)
+	if _, err := w.file.WriteAt(buf, w.size); err != nil {
+		return fmt.Errorf("write wal: %w", err)
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("sync wal: %w", err)
+	}
+	w.size += int64(len(buf))
+	w.sequence = r.Sequence
+	return nil
+}
+
+// next reads the next record of the log, which has remaining bytes left, or returns nil at the end of the log.
+// A record cut off by the end of the log, or the last record if its checksum doesn't match, is torn,
+// and is the end of the log. Any other record that doesn't match its checksum is an error, since the
+// records after it would be lost. The length of a record is checked before its payload is read, so that
+// a corrupt length can't make it read more than is left of the log.
+func next(r io.Reader, remaining int64) (*record, int64, error) {
+	header := make([]byte, 8)
+	if remaining < int64(len(header)) {
+		return nil, 0, nil
+	}
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, 0, err
+	}
+	length := int64(binary.BigEndian.Uint32(header))
+	if length > remaining-int64(len(header)) {
+		return nil, 0, nil
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, 0, err
+	}
+	size := int64(len(header)) + length
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+		if size == remaining {
+			return nil, 0, nil
+		}
+		return nil, 0, fmt.Errorf("checksum mismatch in record of %d bytes, with %d bytes after it", size, remaining-size)
+	}
+	var rec record
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return nil, 0, fmt.Errorf("json unmarshal record: %w", err)
+	}
+	return &rec, size, nil
+}
+
+func (w *wal) close() error {
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("close wal: %w", err)
+	}
+	return nil
+}
+
+// encodeChange returns the record of the change
+func (b *Backend) encodeChange(c *change) (*record, error) {
+	r := &record{Tables: c.tables}
+	columns := func(name string) []object.Column {
+		for _, table := range c.tables {
+			if table.Name == name {
+				return table.Columns
+			}
+		}
+		return b.tables[name].columns
+	}
+	for name, rows := range c.inserted {
+		if r.Inserted == nil {
+			r.Inserted = make(map[string][][]byte)
+		}
+		for _, row := range rows {
+			encoded, err := object.EncodeRow(row, columns(name))
+			if err != nil {
+				return nil, fmt.Errorf("encode row: %w", err)
+			}
+			r.Inserted[name] = append(r.Inserted[name], encoded)
+		}
+	}
+	for name, versions := range c.deleted {
+		if r.Deleted == nil {
+			r.Deleted = make(map[string][]uint64)
+		}
+		for _, v := range versions {
+			r.Deleted[name] = append(r.Deleted[name], v.rowID)
+		}
+	}
+	for name, stats := range c.statistics {
+		if r.Statistics == nil {
+			r.Statistics = make(map[string][]byte)
+		}
+		encoded, err := object.EncodeStatistics(stats, columns(name))
+		if err != nil {
+			return nil, err
+		}
+		r.Statistics[name] = encoded
+	}
+	return r, nil
+}
+
+// decodeRecord returns the change in the record, which is applied to the tables as they are now
+func (b *Backend) decodeRecord(r *record) (*change, error) {
+	c := &change{
+		tables:     r.Tables,
+		inserted:   make(map[string][]object.Row),
+		deleted:    make(map[string][]*version),
+		statistics: make(map[string]*object.TableStatistics),
+	}
+	columns := func(name string) ([]object.Column, error) {
+		for _, table := range r.Tables {
+			if table.Name == name {
+				return table.Columns, nil
+			}
+		}
+		if t, ok := b.tables[name]; ok {
+			return t.columns, nil
+		}
+		return nil, fmt.Errorf("record %d: relation %s does not exist", r.Sequence, name)
+	}
+	for name, rows := range r.Inserted {
+		tableColumns, err := columns(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, encoded := range rows {
+			row, err := object.DecodeRow(encoded, tableColumns, nil, tableNames(name, tableColumns))
+			if err != nil {
+				return nil, fmt.Errorf("record %d: decode row: %w", r.Sequence, err)
+			}
+			c.inserted[name] = append(c.inserted[name], *row)
+		}
+	}
+	for name, rowIDs := range r.Deleted {
+		t, ok := b.tables[name]
+		if !ok {
+			return nil, fmt.Errorf("record %d: relation %s does not exist", r.Sequence, name)
+		}
+		for _, rowID := range rowIDs {
+			i := sort.Search(len(t.versions), func(i int) bool { return t.versions[i].rowID >= rowID })
+			if i == len(t.versions) || t.versions[i].rowID != rowID {
+				return nil, fmt.Errorf("record %d: relation %s has no row %d", r.Sequence, name, rowID)
+			}
+			c.deleted[name] = append(c.deleted[name], t.versions[i])
+		}
+	}
+	for name, encoded := range r.Statistics {
+		tableColumns, err := columns(name)
+		if err != nil {
+			return nil, err
+		}
+		if c.statistics[name], err = object.DecodeStatistics(encoded, tableColumns); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// tableNames returns the table name of each of the columns of a row
+func tableNames(name string, columns []object.Column) []string {
+	names := make([]string, len(columns))
+	for i := range names {
+		names[i] = name
+	}
+	return names
+}
+
+// recover reads the snapshot and replays the records after it, and opens the log for appending.
+// A torn record at the end of the log is cut off, and a corrupt record before it is an error.
+func (b *Backend) recover() error {
+	b.tables = make(map[string]*table)
+	b.statistics = make(map[string]*object.TableStatistics)
+	b.committed = 0
+	w := b.log
+	snap, err := readSnapshot(w.filename)
+	if err != nil {
+		return err
+	}
+	for _, st := range snap.Tables {
+		b.createTable(st.Name, st.Columns, 0)
+		t := b.tables[st.Name]
+		t.lastRowID = st.LastRowID
+		names := tableNames(st.Name, st.Columns)
+		for i, encoded := range st.Rows {
+			row, err := object.DecodeRow(encoded, st.Columns, nil, names)
+			if err != nil {
+				return fmt.Errorf("snapshot: decode row: %w", err)
+			}
+			t.versions = append(t.versions, &version{rowID: st.RowIDs[i], row: withAliases(st.Columns, *row)})
+		}
+		if st.Statistics != nil {
+			if b.statistics[st.Name], err = object.DecodeStatistics(st.Statistics, st.Columns); err != nil {
+				return fmt.Errorf("snapshot: %w", err)
+			}
+		}
+	}
+	w.sequence = snap.Sequence
+
+	file, err := os.OpenFile(w.filename+"-wal", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("open wal: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("stat wal: %w", err)
+	}
+	w.file, w.size = file, 0
+	reader := bufio.NewReader(file)
+	for {
+		r, n, err := next(reader, info.Size()-w.size)
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("read wal at offset %d: %w", w.size, err)
+		}
+		if r == nil {
+			break
+		}
+		w.size += n
+		if r.Sequence <= w.sequence {
+			continue
+		}
+		c, err := b.decodeRecord(r)
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("replay wal: %w", err)
+		}
+		b.apply(c)
+		w.sequence = r.Sequence
+	}
+	if err := file.Truncate(w.size); err != nil {
+		file.Close()
+		return fmt.Errorf("truncate wal: %w", err)
+	}
+	return nil
+}
+
+// readSnapshot reads the snapshot file, which is empty if it doesn't exist
+func readSnapshot(filename string) (*snapshot, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return &snapshot{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot: %w", err)
+	}
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("json unmarshal snapshot: %w", err)
+	}
+	return &snap, nil
+}
+
+// checkpoint writes a snapshot of the tables, and empties the log
+func (b *Backend) checkpoint() error {
+	w := b.log
+	snap := snapshot{Sequence: w.sequence}
+	for _, name := range b.tableNames(b.committed) {
+		t := b.tables[name]
+		st := snapshotTable{Name: name, Columns: t.columns, LastRowID: t.lastRowID}
+		for _, v := range t.versions {
+			if v.isDeleted() {
+				continue
+			}
+			encoded, err := object.EncodeRow(v.row, t.columns)
+			if err != nil {
+				return fmt.Errorf("checkpoint: encode row: %w", err)
+			}
+			st.RowIDs = append(st.RowIDs, v.rowID)
+			st.Rows = append(st.Rows, encoded)
+		}
+		if stats, ok := b.statistics[name]; ok && stats != nil {
+			encoded, err := object.EncodeStatistics(stats, t.columns)
+			if err != nil {
+				return fmt.Errorf("checkpoint: %w", err)
+			}
+			st.Statistics = encoded
+		}
+		snap.Tables = append(snap.Tables, st)
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("checkpoint: json marshal snapshot: %w", err)
+	}
+	if err := writeFile(w.filename, data); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	if err := w.file.Truncate(0); err != nil {
+		return fmt.Errorf("checkpoint: truncate wal: %w", err)
+	}
+	w.size = 0
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("checkpoint: sync wal: %w", err)
+	}
+	return nil
+}
+
+// writeFile replaces the file with the data, so that the file has either the old or the new data
+// if the process stops while it is written
+func writeFile(filename string, data []byte) error {
+	tmp := filename + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return err
+	}
+	// sync the directory, so the rename is durable before the log is emptied
+	dir, err := os.Open(filepath.Dir(filename))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
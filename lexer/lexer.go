@@ -38,6 +38,19 @@ var keywords = []token.TokenType{
 	token.NULL,
 	token.IS,
 	token.NOT,
+	token.GROUP,
+	token.EXPLAIN,
+	token.ANALYZE,
+	token.PREPARE,
+	token.EXECUTE,
+	token.DEALLOCATE,
+	token.ALL,
+	token.BEGIN,
+	token.COMMIT,
+	token.ROLLBACK,
+	token.DELETE,
+	token.SET,
+	token.TO,
 	token.TRUE,
 	token.FALSE,
 }
@@ -86,6 +99,18 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.HAT, l.ch)
 	case '%':
 		tok = newToken(token.PERCENT, l.ch)
+	case '?':
+		tok = newToken(token.PARAMETER, l.ch)
+	case '$':
+		position := l.position
+		l.readChar()
+		if !isDigit(l.ch) {
+			return token.Token{Type: token.ILLEGAL, Literal: l.input[position:l.position]}
+		}
+		for isDigit(l.ch) {
+			l.readChar()
+		}
+		return token.Token{Type: token.PARAMETER, Literal: l.input[position:l.position]}
 	case '>':
 		if l.input[l.position+1] == '=' {
 			tok = token.Token{Type: token.GREATERTHANOREQUALS, Literal: ">="}
@@ -151,6 +176,26 @@ func (l *Lexer) NextToken() token.Token {
 				return token.Token{Type: token.BOOLEAN_TYPE, Literal: token.BOOLEAN_TYPE}
 			}
 
+			// numeric type
+			if map[string]bool{token.NUMERIC_TYPE: true, "DECIMAL": true}[literal] {
+				return token.Token{Type: token.NUMERIC_TYPE, Literal: token.NUMERIC_TYPE}
+			}
+
+			// temporal types, where TIMESTAMP and TIME may be followed by WITH or WITHOUT TIME ZONE
+			switch literal {
+			case token.DATE_TYPE, token.INTERVAL_TYPE, token.TIMESTAMPTZ_TYPE:
+				return token.Token{Type: token.TokenType(literal), Literal: literal}
+			case token.TIMESTAMP_TYPE:
+				if l.readWords("with", "time", "zone") {
+					return token.Token{Type: token.TIMESTAMPTZ_TYPE, Literal: token.TIMESTAMPTZ_TYPE}
+				}
+				l.readWords("without", "time", "zone")
+				return token.Token{Type: token.TIMESTAMP_TYPE, Literal: token.TIMESTAMP_TYPE}
+			case token.TIME_TYPE:
+				l.readWords("without", "time", "zone")
+				return token.Token{Type: token.TIME_TYPE, Literal: token.TIME_TYPE}
+			}
+
 			for _, keyword := range keywords {
 				if literal == string(keyword) {
 					return token.Token{Type: keyword, Literal: literal}
@@ -279,6 +324,32 @@ func isWhitespace(ch byte) bool {
 	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
 }
 
+// readWords reads the words, in any case and separated by whitespace, if they are next in the input,
+// and otherwise leaves the input as it was
+func (l *Lexer) readWords(words ...string) bool {
+	position, readPosition, ch := l.position, l.readPosition, l.ch
+	read := 0
+	for _, word := range words {
+		if !isWhitespace(l.ch) {
+			break
+		}
+		l.skipWhitespace()
+		start := l.position
+		for isLetter(l.ch) {
+			l.readChar()
+		}
+		if !strings.EqualFold(l.input[start:l.position], word) {
+			break
+		}
+		read++
+	}
+	if read == len(words) && !isLetter(l.ch) && l.ch != '_' && l.ch != '.' {
+		return true
+	}
+	l.position, l.readPosition, l.ch = position, readPosition, ch
+	return false
+}
+
 func (l *Lexer) skipWhitespace() {
 	for isWhitespace(l.ch) {
 		l.readChar()
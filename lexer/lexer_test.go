@@ -10,7 +10,7 @@ import (
 func TestExpressionValue(t *testing.T) {
 	input := `
 1 + 2 * (30 / 5) - 1 + 3.14 + 'abc' 1.0 'def' select SELECT SeLeCT an_identifier , AS as aS As create table text float integer insert into values from identifier_with_underscore;
-order by desc asc false true = != 2 and or limit offset where < <= > >= table_name.column_name bool boolean int double char text varchar string Identifier join on null is not || ^ %
+order by desc asc false true = != 2 and or limit offset where < <= > >= table_name.column_name bool boolean int double char text varchar string Identifier join on null is not || ^ % group explain analyze prepare execute deallocate all $1 ? $23 $ begin commit rollback
 `
 	tests := []struct {
 		expectedType    token.TokenType
@@ -89,6 +89,20 @@ order by desc asc false true = != 2 and or limit offset where < <= > >= table_na
 		{token.DOUBLEBAR, "||"},
 		{token.HAT, "^"},
 		{token.PERCENT, "%"},
+		{token.GROUP, "GROUP"},
+		{token.EXPLAIN, "EXPLAIN"},
+		{token.ANALYZE, "ANALYZE"},
+		{token.PREPARE, "PREPARE"},
+		{token.EXECUTE, "EXECUTE"},
+		{token.DEALLOCATE, "DEALLOCATE"},
+		{token.ALL, "ALL"},
+		{token.PARAMETER, "$1"},
+		{token.PARAMETER, "?"},
+		{token.PARAMETER, "$23"},
+		{token.ILLEGAL, "$"},
+		{token.BEGIN, "BEGIN"},
+		{token.COMMIT, "COMMIT"},
+		{token.ROLLBACK, "ROLLBACK"},
 	}
 	l := lexer.New(input)
 	for i, tt := range tests {
@@ -102,3 +116,64 @@ order by desc asc false true = != 2 and or limit offset where < <= > >= table_na
 		}
 	}
 }
+
+func TestTemporalTypes(t *testing.T) {
+	input := `date DATE '2024-01-01' time timestamp TIMESTAMP WITH TIME ZONE timestamp without time zone timestamptz interval time without  time zone timestamp with time_zone`
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.DATE_TYPE, "DATE"},
+		{token.DATE_TYPE, "DATE"},
+		{token.STRING_LITERAL, "2024-01-01"},
+		{token.TIME_TYPE, "TIME"},
+		{token.TIMESTAMP_TYPE, "TIMESTAMP"},
+		{token.TIMESTAMPTZ_TYPE, "TIMESTAMPTZ"},
+		{token.TIMESTAMP_TYPE, "TIMESTAMP"},
+		{token.TIMESTAMPTZ_TYPE, "TIMESTAMPTZ"},
+		{token.INTERVAL_TYPE, "INTERVAL"},
+		{token.TIME_TYPE, "TIME"},
+		{token.TIMESTAMP_TYPE, "TIMESTAMP"},
+		{token.IDENTIFIER, "with"},
+		{token.IDENTIFIER, "time_zone"},
+		{token.EOF, ""},
+	}
+	l := lexer.New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - token type wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - token literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestNumericTypes(t *testing.T) {
+	input := `numeric DECIMAL(10, 2) numerics`
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.NUMERIC_TYPE, "NUMERIC"},
+		{token.NUMERIC_TYPE, "NUMERIC"},
+		{token.LPAREN, "("},
+		{token.INT_LITERAL, "10"},
+		{token.COMMA, ","},
+		{token.INT_LITERAL, "2"},
+		{token.RPAREN, ")"},
+		{token.IDENTIFIER, "numerics"},
+		{token.EOF, ""},
+	}
+	l := lexer.New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - token type wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - token literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
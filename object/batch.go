@@ -0,0 +1,279 @@
+package object
+
+// BatchSize is the number of rows in a batch
+const BatchSize = 1024
+
+// Batch is up to BatchSize rows, stored as a vector for each column, for executing
+// a query a batch of rows at a time. Selection is the positions of the rows of the
+// batch that are selected, in order, and the other rows are left out of the result.
+// The vectors of a batch must not be changed, since they may be shared with the backend.
+type Batch struct {
+	Length    int
+	Vectors   []*Vector
+	Selection []int
+	// Aliases and TableName are the names of the columns, like in Row
+	Aliases   []string
+	TableName []string
+}
+
+// Vector is the values of a column in a batch. The values are in the slice of the type of the vector,
+// and Nulls is whether each value is NULL, or nil if none of them are. A vector of type NULL_OBJ
+// has only NULL values, and a vector with values of different types has them in Objects, with an empty Type.
+// Values of the other types, such as dates, are also in Objects, where the NULL values may be nil when Nulls is set.
+type Vector struct {
+	Type     ObjectType
+	Integers []int64
+	Floats   []float64
+	Strings  []string
+	Booleans []bool
+	Objects  []Object
+	Nulls    []bool
+}
+
+// BatchIterator gives access to the rows of a table a batch at a time.
+// NextBatch returns the next batch, or nil when there are no more rows.
+type BatchIterator interface {
+	NextBatch() (*Batch, error)
+	Close() error
+}
+
+// selectAll is the positions of the rows of a full batch
+var selectAll = func() []int {
+	s := make([]int, BatchSize)
+	for i := range s {
+		s[i] = i
+	}
+	return s
+}()
+
+// SelectAll returns the selection of the first n rows of a batch, which must not be changed.
+func SelectAll(n int) []int {
+	return selectAll[:n:n]
+}
+
+// IsNull reports whether the value at the position is NULL
+func (v *Vector) IsNull(i int) bool {
+	switch {
+	case v.Type == NULL_OBJ:
+		return true
+	case v.Nulls != nil:
+		return v.Nulls[i]
+	case v.Objects != nil:
+		return v.Objects[i].Type() == NULL_OBJ
+	}
+	return false
+}
+
+// Value returns the value at the position as an object
+func (v *Vector) Value(i int) Object {
+	if v.IsNull(i) {
+		return NULL
+	}
+	switch v.Type {
+	case INTEGER_OBJ:
+		return &Integer{Value: v.Integers[i]}
+	case FLOAT_OBJ:
+		return &Float{Value: v.Floats[i]}
+	case STRING_OBJ:
+		return &String{Value: v.Strings[i]}
+	case BOOLEAN_OBJ:
+		return &Boolean{Value: v.Booleans[i]}
+	}
+	return v.Objects[i]
+}
+
+// NewVector returns a vector of the type for a batch of length rows, without any NULL values
+func NewVector(t ObjectType, length int) *Vector {
+	v := &Vector{Type: t}
+	switch t {
+	case INTEGER_OBJ:
+		v.Integers = make([]int64, length)
+	case FLOAT_OBJ:
+		v.Floats = make([]float64, length)
+	case STRING_OBJ:
+		v.Strings = make([]string, length)
+	case BOOLEAN_OBJ:
+		v.Booleans = make([]bool, length)
+	case NULL_OBJ:
+	default:
+		v.Objects = make([]Object, length)
+	}
+	return v
+}
+
+// VectorOf returns a vector for a batch of length rows, with the values at the positions of the selection.
+// The vector has the type of the values that aren't NULL, unless they have different types.
+func VectorOf(values []Object, selection []int, length int) *Vector {
+	t := ObjectType(NULL_OBJ)
+	hasNulls := false
+	for _, value := range values {
+		switch value.Type() {
+		case NULL_OBJ:
+			hasNulls = true
+		case t:
+		default:
+			if t != NULL_OBJ {
+				t = ""
+			} else {
+				t = value.Type()
+			}
+		}
+		if t == "" {
+			break
+		}
+	}
+	v := NewVector(t, length)
+	if t == NULL_OBJ {
+		return v
+	}
+	if hasNulls && t != "" {
+		v.Nulls = make([]bool, length)
+	}
+	for k, value := range values {
+		i := selection[k]
+		switch value := value.(type) {
+		case *Null:
+			if v.Nulls != nil {
+				v.Nulls[i] = true
+				continue
+			}
+		case *Integer:
+			if t == INTEGER_OBJ {
+				v.Integers[i] = value.Value
+				continue
+			}
+		case *Float:
+			if t == FLOAT_OBJ {
+				v.Floats[i] = value.Value
+				continue
+			}
+		case *String:
+			if t == STRING_OBJ {
+				v.Strings[i] = value.Value
+				continue
+			}
+		case *Boolean:
+			if t == BOOLEAN_OBJ {
+				v.Booleans[i] = value.Value
+				continue
+			}
+		}
+		v.Objects[i] = value
+	}
+	return v
+}
+
+// Rows returns the selected rows of the batch. The values of a type are allocated together,
+// rather than one at a time.
+func (b *Batch) Rows() []Row {
+	n := len(b.Selection)
+	k := len(b.Vectors)
+	values := make([]Object, n*k)
+	for j, v := range b.Vectors {
+		v.box(values[j:], k, b.Selection)
+	}
+	rows := make([]Row, n)
+	for i := range rows {
+		rows[i] = Row{
+			Aliases:   b.Aliases,
+			Values:    values[i*k : (i+1)*k : (i+1)*k],
+			TableName: b.TableName,
+		}
+	}
+	return rows
+}
+
+// box sets dst[k*stride] to the value at the k'th position of the selection
+func (v *Vector) box(dst []Object, stride int, selection []int) {
+	switch v.Type {
+	case INTEGER_OBJ:
+		boxed := make([]Integer, len(selection))
+		for k, i := range selection {
+			boxed[k].Value = v.Integers[i]
+			dst[k*stride] = &boxed[k]
+		}
+	case FLOAT_OBJ:
+		boxed := make([]Float, len(selection))
+		for k, i := range selection {
+			boxed[k].Value = v.Floats[i]
+			dst[k*stride] = &boxed[k]
+		}
+	case STRING_OBJ:
+		boxed := make([]String, len(selection))
+		for k, i := range selection {
+			boxed[k].Value = v.Strings[i]
+			dst[k*stride] = &boxed[k]
+		}
+	case BOOLEAN_OBJ:
+		boxed := make([]Boolean, len(selection))
+		for k, i := range selection {
+			boxed[k].Value = v.Booleans[i]
+			dst[k*stride] = &boxed[k]
+		}
+	default:
+		for k, i := range selection {
+			dst[k*stride] = v.Value(i)
+		}
+		return
+	}
+	if v.Nulls != nil {
+		for k, i := range selection {
+			if v.Nulls[i] {
+				dst[k*stride] = NULL
+			}
+		}
+	}
+}
+
+// rowBatches makes batches of the rows of a row iterator
+type rowBatches struct {
+	rows RowIterator
+	done bool
+}
+
+// Batches returns an iterator over the rows of the row iterator, a batch at a time.
+// The columns of the batches have the aliases and table names of the first row of each batch.
+func Batches(rows RowIterator) BatchIterator {
+	return &rowBatches{rows: rows}
+}
+
+func (it *rowBatches) NextBatch() (*Batch, error) {
+	if it.done {
+		return nil, nil
+	}
+	var rows []*Row
+	for len(rows) < BatchSize {
+		row, err := it.rows.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			it.done = true
+			break
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	b := &Batch{
+		Length:    len(rows),
+		Selection: SelectAll(len(rows)),
+		Aliases:   rows[0].Aliases,
+		TableName: rows[0].TableName,
+		Vectors:   make([]*Vector, len(rows[0].Values)),
+	}
+	values := make([]Object, len(rows))
+	for j := range b.Vectors {
+		for i, row := range rows {
+			values[i] = row.Values[j]
+		}
+		b.Vectors[j] = VectorOf(values, b.Selection, b.Length)
+	}
+	return b, nil
+}
+
+func (it *rowBatches) Close() error {
+	it.done = true
+	return it.rows.Close()
+}
@@ -0,0 +1,324 @@
+package object
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// EncodeRow and DecodeRow store rows in a binary format given by the columns of the table,
+// so that backends store the column names and types once per table rather than once per row:
+//
+//	the format version, RowFormat
+//	a null bitmap with one bit per column, where a set bit means the value is NULL
+//	the values of the columns that are not NULL, in the order of the columns:
+//	  INTEGER  a signed varint
+//	  FLOAT    the 8 bytes of the IEEE 754 bits, big endian
+//	  STRING   the length as an unsigned varint, followed by the bytes
+//	  BOOLEAN  a byte, 1 for true and 0 for false
+//	  DATE     the number of days since 1970-01-01 as a signed varint
+//	  TIME, TIMESTAMP and TIMESTAMPTZ
+//	           the number of microseconds since midnight or 1970-01-01 as a signed varint
+//	  INTERVAL the months, days and microseconds as three signed varints
+//	  NUMERIC  the scale as an unsigned varint, followed by the absolute value of the coefficient as big-endian bytes,
+//	           prefixed by their length times two as an unsigned varint, plus one if the coefficient is negative
+const RowFormat byte = 1
+
+// ErrCorruptRow is returned by DecodeRow for data that doesn't hold a row of the columns
+var ErrCorruptRow = errors.New("corrupt row")
+
+// EncodeRow encodes the values of the row in the binary format
+func EncodeRow(row Row, columns []Column) ([]byte, error) {
+	if len(row.Values) != len(columns) {
+		return nil, fmt.Errorf("row has %d values, but the table has %d columns", len(row.Values), len(columns))
+	}
+	bitmapLength := (len(columns) + 7) / 8
+	buf := make([]byte, 1+bitmapLength, 1+bitmapLength+8*len(columns))
+	buf[0] = RowFormat
+	bitmap := buf[1:]
+	var scratch [binary.MaxVarintLen64]byte
+	for i, v := range row.Values {
+		if v.Type() == NULL_OBJ {
+			bitmap[i/8] |= 1 << (i % 8)
+			continue
+		}
+		switch v := v.(type) {
+		case *Integer:
+			if columns[i].Type == INTEGER {
+				buf = append(buf, scratch[:binary.PutVarint(scratch[:], v.Value)]...)
+				continue
+			}
+		case *Float:
+			if columns[i].Type == FLOAT {
+				binary.BigEndian.PutUint64(scratch[:], math.Float64bits(v.Value))
+				buf = append(buf, scratch[:8]...)
+				continue
+			}
+		case *String:
+			if columns[i].Type == STRING {
+				buf = append(buf, scratch[:binary.PutUvarint(scratch[:], uint64(len(v.Value)))]...)
+				buf = append(buf, v.Value...)
+				continue
+			}
+		case *Boolean:
+			if columns[i].Type == BOOLEAN {
+				if v.Value {
+					buf = append(buf, 1)
+				} else {
+					buf = append(buf, 0)
+				}
+				continue
+			}
+		case *Date:
+			if columns[i].Type == DATE {
+				buf = append(buf, scratch[:binary.PutVarint(scratch[:], v.Value)]...)
+				continue
+			}
+		case *Time:
+			if columns[i].Type == TIME {
+				buf = append(buf, scratch[:binary.PutVarint(scratch[:], v.Value)]...)
+				continue
+			}
+		case *Timestamp:
+			if columns[i].Type == TIMESTAMP {
+				buf = append(buf, scratch[:binary.PutVarint(scratch[:], v.Value)]...)
+				continue
+			}
+		case *TimestampTZ:
+			if columns[i].Type == TIMESTAMPTZ {
+				buf = append(buf, scratch[:binary.PutVarint(scratch[:], v.Value)]...)
+				continue
+			}
+		case *Interval:
+			if columns[i].Type == INTERVAL {
+				for _, n := range []int64{v.Months, v.Days, v.Microseconds} {
+					buf = append(buf, scratch[:binary.PutVarint(scratch[:], n)]...)
+				}
+				continue
+			}
+		case *Numeric:
+			if columns[i].Type == NUMERIC {
+				magnitude := v.Coefficient.Bytes()
+				header := uint64(len(magnitude)) << 1
+				if v.Coefficient.Sign() < 0 {
+					header |= 1
+				}
+				buf = append(buf, scratch[:binary.PutUvarint(scratch[:], uint64(v.Scale))]...)
+				buf = append(buf, scratch[:binary.PutUvarint(scratch[:], header)]...)
+				buf = append(buf, magnitude...)
+				continue
+			}
+		}
+		return nil, fmt.Errorf("cannot store %s value in %s column %s", v.Type(), columns[i].Type, columns[i].Name)
+	}
+	return buf, nil
+}
+
+// DecodeRow decodes a row encoded by EncodeRow. The aliases and table names are
+// given to every row, so they must not be changed.
+func DecodeRow(data []byte, columns []Column, aliases []string, tableNames []string) (*Row, error) {
+	if len(data) == 0 || data[0] != RowFormat {
+		return nil, errors.New("unknown row format")
+	}
+	bitmapLength := (len(columns) + 7) / 8
+	if len(data) < 1+bitmapLength {
+		return nil, ErrCorruptRow
+	}
+	bitmap := data[1 : 1+bitmapLength]
+	data = data[1+bitmapLength:]
+	row := &Row{Values: make([]Object, len(columns)), Aliases: aliases, TableName: tableNames}
+	for i, c := range columns {
+		if bitmap[i/8]&(1<<(i%8)) != 0 {
+			row.Values[i] = NULL
+			continue
+		}
+		switch c.Type {
+		case INTEGER:
+			v, n := binary.Varint(data)
+			if n <= 0 {
+				return nil, ErrCorruptRow
+			}
+			row.Values[i] = &Integer{Value: v}
+			data = data[n:]
+		case FLOAT:
+			if len(data) < 8 {
+				return nil, ErrCorruptRow
+			}
+			row.Values[i] = &Float{Value: math.Float64frombits(binary.BigEndian.Uint64(data))}
+			data = data[8:]
+		case STRING:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return nil, ErrCorruptRow
+			}
+			row.Values[i] = &String{Value: string(data[n : n+int(length)])}
+			data = data[n+int(length):]
+		case BOOLEAN:
+			if len(data) < 1 || data[0] > 1 {
+				return nil, ErrCorruptRow
+			}
+			row.Values[i] = &Boolean{Value: data[0] == 1}
+			data = data[1:]
+		case DATE, TIME, TIMESTAMP, TIMESTAMPTZ:
+			v, n := binary.Varint(data)
+			if n <= 0 {
+				return nil, ErrCorruptRow
+			}
+			switch c.Type {
+			case DATE:
+				row.Values[i] = &Date{Value: v}
+			case TIME:
+				row.Values[i] = &Time{Value: v}
+			case TIMESTAMP:
+				row.Values[i] = &Timestamp{Value: v}
+			default:
+				row.Values[i] = &TimestampTZ{Value: v}
+			}
+			data = data[n:]
+		case INTERVAL:
+			var fields [3]int64
+			for k := range fields {
+				v, n := binary.Varint(data)
+				if n <= 0 {
+					return nil, ErrCorruptRow
+				}
+				fields[k] = v
+				data = data[n:]
+			}
+			row.Values[i] = &Interval{Months: fields[0], Days: fields[1], Microseconds: fields[2]}
+		case NUMERIC:
+			scale, n := binary.Uvarint(data)
+			if n <= 0 || scale > MaxNumericPrecision {
+				return nil, ErrCorruptRow
+			}
+			data = data[n:]
+			header, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < header>>1 {
+				return nil, ErrCorruptRow
+			}
+			length := int(header >> 1)
+			coefficient := new(big.Int)
+			if length > 0 {
+				coefficient.SetBytes(data[n : n+length])
+			}
+			if header&1 == 1 {
+				coefficient.Neg(coefficient)
+			}
+			row.Values[i] = &Numeric{Coefficient: coefficient, Scale: int32(scale)}
+			data = data[n+length:]
+		default:
+			return nil, fmt.Errorf("cannot read %s column %s", c.Type, c.Name)
+		}
+	}
+	if len(data) != 0 {
+		return nil, ErrCorruptRow
+	}
+	return row, nil
+}
+
+// storedStatistics is how EncodeStatistics stores table statistics, as JSON. The minimum and
+// maximum values of a column are encoded like a row with the column as its only column.
+type storedStatistics struct {
+	RowCount int
+	Columns  []storedColumnStatistics
+}
+
+type storedColumnStatistics struct {
+	Name          string
+	DistinctCount int
+	NullFraction  float64
+	Min           []byte
+	Max           []byte
+}
+
+// EncodeStatistics encodes the statistics of a table with the columns
+func EncodeStatistics(stats *TableStatistics, columns []Column) ([]byte, error) {
+	stored := storedStatistics{RowCount: stats.RowCount}
+	for _, c := range stats.Columns {
+		column := []Column{columnNamed(columns, c.Name)}
+		min, err := encodeValue(c.Min, column)
+		if err != nil {
+			return nil, err
+		}
+		max, err := encodeValue(c.Max, column)
+		if err != nil {
+			return nil, err
+		}
+		stored.Columns = append(stored.Columns, storedColumnStatistics{
+			Name:          c.Name,
+			DistinctCount: c.DistinctCount,
+			NullFraction:  c.NullFraction,
+			Min:           min,
+			Max:           max,
+		})
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return nil, fmt.Errorf("json marshal statistics: %w", err)
+	}
+	return data, nil
+}
+
+// DecodeStatistics decodes statistics encoded by EncodeStatistics
+func DecodeStatistics(data []byte, columns []Column) (*TableStatistics, error) {
+	var stored storedStatistics
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("json unmarshal statistics: %w", err)
+	}
+	stats := &TableStatistics{RowCount: stored.RowCount}
+	for _, c := range stored.Columns {
+		column := []Column{columnNamed(columns, c.Name)}
+		min, err := decodeValue(c.Min, column)
+		if err != nil {
+			return nil, err
+		}
+		max, err := decodeValue(c.Max, column)
+		if err != nil {
+			return nil, err
+		}
+		stats.Columns = append(stats.Columns, ColumnStatistics{
+			Name:          c.Name,
+			DistinctCount: c.DistinctCount,
+			NullFraction:  c.NullFraction,
+			Min:           min,
+			Max:           max,
+		})
+	}
+	return stats, nil
+}
+
+// columnNamed returns the column with the name
+func columnNamed(columns []Column, name string) Column {
+	for _, c := range columns {
+		if c.Name == name {
+			return c
+		}
+	}
+	return Column{Name: name}
+}
+
+// encodeValue encodes a value of the column, where nil is no value
+func encodeValue(v Object, column []Column) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := EncodeRow(Row{Values: []Object{v}}, column)
+	if err != nil {
+		return nil, fmt.Errorf("encode statistics: %w", err)
+	}
+	return data, nil
+}
+
+func decodeValue(data []byte, column []Column) (Object, error) {
+	if data == nil {
+		return nil, nil
+	}
+	row, err := DecodeRow(data, column, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decode statistics: %w", err)
+	}
+	return row.Values[0], nil
+}
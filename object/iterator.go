@@ -0,0 +1,50 @@
+package object
+
+// RowIterator gives access to the rows of a table one at a time.
+// Next returns the next row, or nil when there are no more rows.
+// Close must be called when the caller is done with the iterator,
+// also if it stops before all rows have been read.
+type RowIterator interface {
+	Next() (*Row, error)
+	Close() error
+}
+
+// SliceIterator is a RowIterator over a slice of rows that are already in memory.
+type SliceIterator struct {
+	rows     []Row
+	position int
+}
+
+func NewSliceIterator(rows []Row) *SliceIterator {
+	return &SliceIterator{rows: rows}
+}
+
+func (it *SliceIterator) Next() (*Row, error) {
+	if it.position >= len(it.rows) {
+		return nil, nil
+	}
+	row := it.rows[it.position]
+	it.position++
+	return &row, nil
+}
+
+func (it *SliceIterator) Close() error {
+	it.rows = nil
+	return nil
+}
+
+// Collect drains the iterator and returns all rows. The iterator is closed afterwards.
+func Collect(it RowIterator) ([]Row, error) {
+	defer it.Close()
+	var rows []Row
+	for {
+		row, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			return rows, nil
+		}
+		rows = append(rows, *row)
+	}
+}
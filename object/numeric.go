@@ -0,0 +1,239 @@
+package object
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// MaxNumericPrecision is the largest precision of a NUMERIC(p, s) column, and the largest scale of a quotient
+const MaxNumericPrecision = 1000
+
+// divisionDigits is the smallest number of significant digits of a quotient
+const divisionDigits = 20
+
+// Numeric is an exact decimal number, the coefficient times 10 to the power of minus the scale,
+// so that 12.50 is 1250 with scale 2. The scale is the number of digits after the decimal point,
+// which is kept by arithmetic like in PostgreSQL, so that 12.50 + 1 is 13.50.
+// The coefficient must not be changed, since it may be shared by several numbers.
+type Numeric struct {
+	Coefficient *big.Int
+	Scale       int32
+}
+
+func (n *Numeric) Inspect() string  { return n.String() }
+func (n *Numeric) Type() ObjectType { return NUMERIC_OBJ }
+
+// SortValue is the number as the closest float, and numbers that are too close to be told apart by it
+// are sorted by Cmp
+func (n *Numeric) SortValue() float64 { return n.Float64() }
+
+// String is the number with Scale digits after the decimal point, such as 12.50
+func (n *Numeric) String() string {
+	digits := new(big.Int).Abs(n.Coefficient).String()
+	if n.Scale > 0 {
+		if len(digits) <= int(n.Scale) {
+			digits = strings.Repeat("0", int(n.Scale)-len(digits)+1) + digits
+		}
+		digits = digits[:len(digits)-int(n.Scale)] + "." + digits[len(digits)-int(n.Scale):]
+	}
+	if n.Coefficient.Sign() < 0 {
+		return "-" + digits
+	}
+	return digits
+}
+
+// Float64 is the float closest to the number
+func (n *Numeric) Float64() float64 {
+	f, _ := strconv.ParseFloat(n.String(), 64)
+	return f
+}
+
+// NumericOf is the integer as a number with scale 0
+func NumericOf(i int64) *Numeric {
+	return &Numeric{Coefficient: big.NewInt(i)}
+}
+
+// NumericOfFloat is the shortest decimal number that is read as the float, such as 0.1 for the float closest to 0.1
+func NumericOfFloat(f float64) (*Numeric, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, Errorf(CodeNumericValueOutOfRange, "cannot convert %s to numeric", strconv.FormatFloat(f, 'g', -1, 64))
+	}
+	return ParseNumeric(strconv.FormatFloat(f, 'f', -1, 64))
+}
+
+// ParseNumeric reads a decimal number such as 12.50, -0.5 or 1.5e3, where the scale is the number
+// of digits after the decimal point, less the exponent
+func ParseNumeric(s string) (*Numeric, error) {
+	text := strings.TrimSpace(s)
+	exponent := int64(0)
+	if i := strings.IndexAny(text, "eE"); i != -1 {
+		e, err := strconv.ParseInt(text[i+1:], 10, 32)
+		if err != nil {
+			return nil, invalidSyntax(NUMERIC, s)
+		}
+		text, exponent = text[:i], e
+	}
+	sign := ""
+	if text != "" && (text[0] == '-' || text[0] == '+') {
+		sign, text = text[:1], text[1:]
+	}
+	integerPart, fraction := text, ""
+	if i := strings.IndexByte(text, '.'); i != -1 {
+		integerPart, fraction = text[:i], text[i+1:]
+	}
+	if integerPart == "" && fraction == "" || !isDigits(integerPart) || !isDigits(fraction) {
+		return nil, invalidSyntax(NUMERIC, s)
+	}
+	coefficient, _ := new(big.Int).SetString(sign+"0"+integerPart+fraction, 10)
+	scale := int64(len(fraction)) - exponent
+	if scale < 0 {
+		if -scale > MaxNumericPrecision {
+			return nil, invalidSyntax(NUMERIC, s)
+		}
+		coefficient.Mul(coefficient, pow10(-scale))
+		scale = 0
+	}
+	if scale > MaxNumericPrecision {
+		return nil, invalidSyntax(NUMERIC, s)
+	}
+	return &Numeric{Coefficient: coefficient, Scale: int32(scale)}, nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func pow10(n int64) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(n), nil)
+}
+
+// aligned returns the coefficients of n and m with the larger of their scales, and that scale
+func (n *Numeric) aligned(m *Numeric) (*big.Int, *big.Int, int32) {
+	switch {
+	case n.Scale < m.Scale:
+		return new(big.Int).Mul(n.Coefficient, pow10(int64(m.Scale-n.Scale))), m.Coefficient, m.Scale
+	case n.Scale > m.Scale:
+		return n.Coefficient, new(big.Int).Mul(m.Coefficient, pow10(int64(n.Scale-m.Scale))), n.Scale
+	}
+	return n.Coefficient, m.Coefficient, n.Scale
+}
+
+// Cmp returns -1, 0 or 1 if n is less than, equal to or greater than m, so that 1.0 and 1.00 are equal
+func (n *Numeric) Cmp(m *Numeric) int {
+	a, b, _ := n.aligned(m)
+	return a.Cmp(b)
+}
+
+// Add returns n + m, with the larger of their scales
+func (n *Numeric) Add(m *Numeric) *Numeric {
+	a, b, scale := n.aligned(m)
+	return &Numeric{Coefficient: new(big.Int).Add(a, b), Scale: scale}
+}
+
+// Sub returns n - m, with the larger of their scales
+func (n *Numeric) Sub(m *Numeric) *Numeric {
+	a, b, scale := n.aligned(m)
+	return &Numeric{Coefficient: new(big.Int).Sub(a, b), Scale: scale}
+}
+
+// Mul returns n * m, with the sum of their scales
+func (n *Numeric) Mul(m *Numeric) *Numeric {
+	return &Numeric{Coefficient: new(big.Int).Mul(n.Coefficient, m.Coefficient), Scale: n.Scale + m.Scale}
+}
+
+// Neg returns -n
+func (n *Numeric) Neg() *Numeric {
+	return &Numeric{Coefficient: new(big.Int).Neg(n.Coefficient), Scale: n.Scale}
+}
+
+// Quo returns n / m rounded half away from zero, with at least 20 significant digits and no fewer digits after
+// the decimal point than n and m have, so that 1 / 3 is 0.33333333333333333333 and 10.00 / 4 is 2.5000000000000000000.
+// m must not be zero.
+func (n *Numeric) Quo(m *Numeric) *Numeric {
+	// the first digit of the quotient is at most one place after the difference of the first digits of n and m
+	leading := n.leadingDigit() - m.leadingDigit()
+	scale := int64(divisionDigits) - leading
+	if scale < int64(n.Scale) {
+		scale = int64(n.Scale)
+	}
+	if scale < int64(m.Scale) {
+		scale = int64(m.Scale)
+	}
+	if scale < 0 {
+		scale = 0
+	}
+	if scale > MaxNumericPrecision {
+		scale = MaxNumericPrecision
+	}
+	// n / m = (n.Coefficient * 10^m.Scale) / (m.Coefficient * 10^n.Scale), and the quotient has scale more digits
+	numerator := new(big.Int).Mul(n.Coefficient, pow10(int64(m.Scale)+scale))
+	denominator := new(big.Int).Mul(m.Coefficient, pow10(int64(n.Scale)))
+	return &Numeric{Coefficient: roundedQuo(numerator, denominator), Scale: int32(scale)}
+}
+
+// Rem returns the remainder of n / m truncated to an integer, which has the sign of n, with the larger of their scales.
+// m must not be zero.
+func (n *Numeric) Rem(m *Numeric) *Numeric {
+	a, b, scale := n.aligned(m)
+	return &Numeric{Coefficient: new(big.Int).Rem(a, b), Scale: scale}
+}
+
+// Round returns n rounded half away from zero to the scale, or with zeros added if the scale is larger than that of n
+func (n *Numeric) Round(scale int32) *Numeric {
+	if scale >= n.Scale {
+		return &Numeric{Coefficient: new(big.Int).Mul(n.Coefficient, pow10(int64(scale-n.Scale))), Scale: scale}
+	}
+	return &Numeric{Coefficient: roundedQuo(n.Coefficient, pow10(int64(n.Scale-scale))), Scale: scale}
+}
+
+// IntegerDigits is the number of digits before the decimal point, which is 0 for numbers between -1 and 1
+func (n *Numeric) IntegerDigits() int {
+	if digits := n.leadingDigit() + 1; digits > 0 {
+		return int(digits)
+	}
+	return 0
+}
+
+// leadingDigit is the position of the first digit that isn't zero, where 0 is the ones and -1 the tenths
+func (n *Numeric) leadingDigit() int64 {
+	if n.Coefficient.Sign() == 0 {
+		return 0
+	}
+	return int64(len(new(big.Int).Abs(n.Coefficient).String())) - int64(n.Scale) - 1
+}
+
+// Normalized is the number without zeros at the end of its fraction, such as 1.5 for 1.50, so that it is the same
+// text for equal numbers
+func (n *Numeric) Normalized() string {
+	s := n.String()
+	if n.Scale > 0 {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	if s == "-0" {
+		return "0"
+	}
+	return s
+}
+
+// roundedQuo returns a / b rounded half away from zero
+func roundedQuo(a *big.Int, b *big.Int) *big.Int {
+	q, r := new(big.Int).QuoRem(a, b, new(big.Int))
+	if r.Sign() == 0 {
+		return q
+	}
+	if new(big.Int).Mul(new(big.Int).Abs(r), big.NewInt(2)).Cmp(new(big.Int).Abs(b)) >= 0 {
+		if a.Sign()*b.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q
+}
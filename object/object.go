@@ -1,6 +1,7 @@
 package object
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"strings"
@@ -18,6 +19,14 @@ const (
 	NULL_OBJ    = "NULL"
 	ERROR_OBJ   = "ERROR"
 	OK_OBJ      = "OK"
+
+	DATE_OBJ        = "DATE"
+	TIME_OBJ        = "TIME"
+	TIMESTAMP_OBJ   = "TIMESTAMP"
+	TIMESTAMPTZ_OBJ = "TIMESTAMPTZ"
+	INTERVAL_OBJ    = "INTERVAL"
+
+	NUMERIC_OBJ = "NUMERIC"
 )
 
 type Object interface {
@@ -50,7 +59,10 @@ func (r *Row) SortValue() float64 { panic("a row doesn't have a sort value") }
 
 type Result struct {
 	Aliases []string
-	Rows    []*Row
+	// Types are the data types of the columns. A type is empty if it isn't known,
+	// such as for a column that is always null.
+	Types []DataType
+	Rows  []*Row
 }
 
 func (r *Result) Inspect() string {
@@ -78,6 +90,14 @@ const (
 	INTEGER = "INTEGER"
 	FLOAT   = "FLOAT"
 	BOOLEAN = "BOOLEAN"
+
+	DATE        = "DATE"
+	TIME        = "TIME"
+	TIMESTAMP   = "TIMESTAMP"
+	TIMESTAMPTZ = "TIMESTAMPTZ"
+	INTERVAL    = "INTERVAL"
+
+	NUMERIC = "NUMERIC"
 )
 
 func DataTypeFromString(s string) DataType {
@@ -90,6 +110,18 @@ func DataTypeFromString(s string) DataType {
 		return INTEGER
 	case "BOOL", "BOOLEAN":
 		return BOOLEAN
+	case "DATE":
+		return DATE
+	case "TIME":
+		return TIME
+	case "TIMESTAMP":
+		return TIMESTAMP
+	case "TIMESTAMPTZ":
+		return TIMESTAMPTZ
+	case "INTERVAL":
+		return INTERVAL
+	case "NUMERIC", "DECIMAL":
+		return NUMERIC
 	default:
 		return ""
 	}
@@ -103,6 +135,27 @@ type Table struct {
 type Column struct {
 	Name string
 	Type DataType
+	// Precision and Scale are the largest number of digits of the values of a NUMERIC(p, s) column,
+	// and the number of digits after the decimal point. Precision is 0 if the values aren't limited.
+	Precision int `json:",omitempty"`
+	Scale     int `json:",omitempty"`
+}
+
+// CheckRow returns an error unless the row has a value for each column of the table,
+// which is NULL or of the type of the column. Backends check the rows they store with it.
+func (t Table) CheckRow(row Row) error {
+	if len(row.Values) != len(t.Columns) {
+		return fmt.Errorf(`relation "%s" has %d columns but the row has %d values`, t.Name, len(t.Columns), len(row.Values))
+	}
+	for i, v := range row.Values {
+		if v.Type() == NULL_OBJ {
+			continue
+		}
+		if DataTypeFromString(string(v.Type())) != t.Columns[i].Type {
+			return Errorf(CodeDatatypeMismatch, `column "%s" of relation "%s" is of type %s but the value is of type %s`, t.Columns[i].Name, t.Name, t.Columns[i].Type, v.Type())
+		}
+	}
+	return nil
 }
 
 type Integer struct {
@@ -167,15 +220,63 @@ func (s *String) SortValue() float64 {
 	return sortValue
 }
 
+// SQLSTATE codes of errors, as in PostgreSQL
+const (
+	CodeFeatureNotSupported        = "0A000"
+	CodeNumericValueOutOfRange     = "22003"
+	CodeInvalidDatetimeFormat      = "22007"
+	CodeDivisionByZero             = "22012"
+	CodeInvalidParameterValue      = "22023"
+	CodeInvalidTextRepresentation  = "22P02"
+	CodeActiveTransaction          = "25001"
+	CodeNoActiveTransaction        = "25P01"
+	CodeInvalidStatementName       = "26000"
+	CodeSerializationFailure       = "40001"
+	CodeSyntaxError                = "42601"
+	CodeAmbiguousColumn            = "42702"
+	CodeDuplicateAlias             = "42712"
+	CodeUndefinedColumn            = "42703"
+	CodeUndefinedObject            = "42704"
+	CodeGroupingError              = "42803"
+	CodeDatatypeMismatch           = "42804"
+	CodeUndefinedFunction          = "42883"
+	CodeUndefinedTable             = "42P01"
+	CodeUndefinedParameter         = "42P02"
+	CodeDuplicatePreparedStatement = "42P05"
+	CodeDuplicateTable             = "42P07"
+	CodeQueryCanceled              = "57014"
+)
+
+// Error is an error from evaluating a statement. It is also an error, so that it can be returned
+// from the backends and operators and keep its code.
 type Error struct {
 	Message string
+	// Code is the SQLSTATE code of the error, or empty if it has none
+	Code string
+}
+
+// Errorf returns an error with the code and a message formatted as fmt.Sprintf
+func Errorf(code string, format string, a ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...), Code: code}
+}
+
+// ErrorCode returns the code of the Error the error is, or wraps, or an empty code if there is none
+func ErrorCode(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ""
 }
 
 func (e *Error) Type() ObjectType   { return ERROR_OBJ }
 func (e *Error) Inspect() string    { return "ERROR: " + e.Message }
 func (e *Error) SortValue() float64 { panic("an error doesn't have a sort value") }
+func (e *Error) Error() string      { return e.Message }
 
 type OK struct {
+	// RowsAffected is the number of rows changed by the statement
+	RowsAffected int64
 }
 
 func (ok *OK) Type() ObjectType   { return OK_OBJ }
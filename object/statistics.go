@@ -0,0 +1,30 @@
+package object
+
+// TableStatistics describes the contents of a table at the time it was analyzed.
+// They are computed by ANALYZE, and used by the planner to estimate the number of rows returned by scans.
+type TableStatistics struct {
+	RowCount int
+	Columns  []ColumnStatistics
+}
+
+// ColumnStatistics describes the values in a column.
+// Min and Max are nil if the column contains only nulls.
+type ColumnStatistics struct {
+	Name string
+	// DistinctCount is an estimate of the number of distinct non-null values
+	DistinctCount int
+	// NullFraction is the fraction of the rows where the column is null
+	NullFraction float64
+	Min          Object
+	Max          Object
+}
+
+// Column returns the statistics of the column with the given name, or nil if there is no such column.
+func (s *TableStatistics) Column(name string) *ColumnStatistics {
+	for i := range s.Columns {
+		if s.Columns[i].Name == name {
+			return &s.Columns[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,481 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MicrosecondsPerSecond = 1000000
+	MicrosecondsPerDay    = 24 * 60 * 60 * MicrosecondsPerSecond
+	// DaysPerMonth is the number of days in a month of an interval, when the interval is compared
+	// or its months are split into days, like in PostgreSQL
+	DaysPerMonth = 30
+)
+
+// IsTemporal reports whether the values of the type are dates, times, timestamps or intervals
+func IsTemporal(t ObjectType) bool {
+	switch t {
+	case DATE_OBJ, TIME_OBJ, TIMESTAMP_OBJ, TIMESTAMPTZ_OBJ, INTERVAL_OBJ:
+		return true
+	}
+	return false
+}
+
+// Date is a day, as the number of days since 1970-01-01
+type Date struct {
+	Value int64
+}
+
+func (d *Date) Inspect() string    { return "'" + d.String() + "'" }
+func (d *Date) Type() ObjectType   { return DATE_OBJ }
+func (d *Date) SortValue() float64 { return float64(d.Value) }
+
+// String is the date as PostgreSQL prints it, such as 2024-01-31
+func (d *Date) String() string {
+	t := d.GoTime()
+	return formatDate(t) + era(t)
+}
+
+// GoTime is the start of the day in UTC
+func (d *Date) GoTime() time.Time { return time.Unix(d.Value*24*60*60, 0).UTC() }
+
+// DateOf is the day of the time, in the location of the time
+func DateOf(t time.Time) *Date {
+	return &Date{Value: floorDiv(MicrosecondsOf(wallClock(t)), MicrosecondsPerDay)}
+}
+
+// Time is a time of day, as the number of microseconds since midnight
+type Time struct {
+	Value int64
+}
+
+func (t *Time) Inspect() string    { return "'" + t.String() + "'" }
+func (t *Time) Type() ObjectType   { return TIME_OBJ }
+func (t *Time) SortValue() float64 { return float64(t.Value) }
+
+// String is the time as PostgreSQL prints it, such as 10:30:00 or 10:30:00.25
+func (t *Time) String() string { return formatClock(uint64(t.Value)) }
+
+// Timestamp is a date and time of day without a time zone, as the number of microseconds since 1970-01-01 00:00:00
+type Timestamp struct {
+	Value int64
+}
+
+func (t *Timestamp) Inspect() string    { return "'" + t.String() + "'" }
+func (t *Timestamp) Type() ObjectType   { return TIMESTAMP_OBJ }
+func (t *Timestamp) SortValue() float64 { return float64(t.Value) }
+
+// String is the timestamp as PostgreSQL prints it, such as 2024-01-31 10:30:00
+func (t *Timestamp) String() string {
+	gt := t.GoTime()
+	return formatDate(gt) + " " + formatClock(uint64(floorMod(t.Value, MicrosecondsPerDay))) + era(gt)
+}
+
+// GoTime is the time in UTC with the date and time of day of the timestamp
+func (t *Timestamp) GoTime() time.Time { return TimeOf(t.Value) }
+
+// TimestampOf is the date and time of day of the time, in the location of the time
+func TimestampOf(t time.Time) *Timestamp { return &Timestamp{Value: MicrosecondsOf(wallClock(t))} }
+
+// TimestampTZ is a point in time, as the number of microseconds since 1970-01-01 00:00:00 UTC. It is printed in UTC.
+type TimestampTZ struct {
+	Value int64
+}
+
+func (t *TimestampTZ) Inspect() string    { return "'" + t.String() + "'" }
+func (t *TimestampTZ) Type() ObjectType   { return TIMESTAMPTZ_OBJ }
+func (t *TimestampTZ) SortValue() float64 { return float64(t.Value) }
+
+// String is the time in UTC as PostgreSQL prints it, such as 2024-01-31 10:30:00+00
+func (t *TimestampTZ) String() string {
+	gt := t.GoTime()
+	return formatDate(gt) + " " + formatClock(uint64(floorMod(t.Value, MicrosecondsPerDay))) + "+00" + era(gt)
+}
+
+// GoTime is the time in UTC
+func (t *TimestampTZ) GoTime() time.Time { return TimeOf(t.Value) }
+
+// TimestampTZOf is the point in time of the time
+func TimestampTZOf(t time.Time) *TimestampTZ { return &TimestampTZ{Value: MicrosecondsOf(t)} }
+
+// Interval is a span of time in months, days and microseconds. They are kept apart, since the number of days
+// in a month and the number of hours in a day depend on the time the interval is added to.
+type Interval struct {
+	Months       int64
+	Days         int64
+	Microseconds int64
+}
+
+func (i *Interval) Inspect() string    { return "'" + i.String() + "'" }
+func (i *Interval) Type() ObjectType   { return INTERVAL_OBJ }
+func (i *Interval) SortValue() float64 { return float64(i.Span()) }
+
+// Span is the length of the interval in microseconds, with DaysPerMonth days in a month, which is what intervals are compared by
+func (i *Interval) Span() int64 {
+	return (i.Months*DaysPerMonth+i.Days)*MicrosecondsPerDay + i.Microseconds
+}
+
+// String is the interval as PostgreSQL prints it, such as 1 year 2 mons 3 days 04:05:06
+func (i *Interval) String() string {
+	var parts []string
+	plural := func(n int64, unit string) {
+		if n == 1 {
+			parts = append(parts, "1 "+unit)
+		} else if n != 0 {
+			parts = append(parts, fmt.Sprintf("%d %ss", n, unit))
+		}
+	}
+	plural(i.Months/12, "year")
+	plural(i.Months%12, "mon")
+	plural(i.Days, "day")
+	if i.Microseconds != 0 || len(parts) == 0 {
+		if i.Microseconds < 0 {
+			// negated as unsigned, which is also right for the smallest int64
+			parts = append(parts, "-"+formatClock(-uint64(i.Microseconds)))
+		} else {
+			parts = append(parts, formatClock(uint64(i.Microseconds)))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// PointInTime returns the number of microseconds since 1970-01-01 00:00:00 of a date or timestamp,
+// where a date is at midnight and a timestamp without a time zone is in UTC
+func PointInTime(v Object) (int64, bool) {
+	switch v := v.(type) {
+	case *Date:
+		return v.Value * MicrosecondsPerDay, true
+	case *Timestamp:
+		return v.Value, true
+	case *TimestampTZ:
+		return v.Value, true
+	}
+	return 0, false
+}
+
+// TimeOf is the time in UTC of a number of microseconds since 1970-01-01 00:00:00 UTC
+func TimeOf(microseconds int64) time.Time {
+	seconds := floorDiv(microseconds, MicrosecondsPerSecond)
+	return time.Unix(seconds, (microseconds-seconds*MicrosecondsPerSecond)*1000).UTC()
+}
+
+// MicrosecondsOf is the number of microseconds from 1970-01-01 00:00:00 UTC to the time
+func MicrosecondsOf(t time.Time) int64 {
+	return t.Unix()*MicrosecondsPerSecond + int64(t.Nanosecond()/1000)
+}
+
+// wallClock is the time in UTC with the date and time of day of the time in its location
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+func floorDiv(a int64, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+func floorMod(a int64, b int64) int64 {
+	return a - floorDiv(a, b)*b
+}
+
+// formatDate formats the date of the time, with years before 1 AD counted from 1 BC, which era gives
+func formatDate(t time.Time) string {
+	year := t.Year()
+	if year <= 0 {
+		year = 1 - year
+	}
+	return fmt.Sprintf("%04d-%02d-%02d", year, t.Month(), t.Day())
+}
+
+func era(t time.Time) string {
+	if t.Year() <= 0 {
+		return " BC"
+	}
+	return ""
+}
+
+// formatClock formats a number of microseconds as hours, minutes and seconds, with the fraction of a second if it isn't 0
+func formatClock(microseconds uint64) string {
+	seconds := microseconds / MicrosecondsPerSecond
+	clock := fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	if fraction := microseconds % MicrosecondsPerSecond; fraction != 0 {
+		clock += "." + strings.TrimRight(fmt.Sprintf("%06d", fraction), "0")
+	}
+	return clock
+}
+
+func invalidSyntax(t DataType, s string) error {
+	code := CodeInvalidDatetimeFormat
+	if t == NUMERIC {
+		code = CodeInvalidTextRepresentation
+	}
+	return Errorf(code, `invalid input syntax for type %s: "%s"`, strings.ToLower(string(t)), s)
+}
+
+// ParseDate reads a date such as 2024-01-31, which may be followed by a time of day that is left out
+func ParseDate(s string) (*Date, error) {
+	date, _, _, ok := parseDateTime(s)
+	if !ok {
+		return nil, invalidSyntax(DATE, s)
+	}
+	return &Date{Value: date}, nil
+}
+
+// ParseTime reads a time of day such as 10:30, 10:30:15 or 10:30:15.25, where a time zone is left out
+func ParseTime(s string) (*Time, error) {
+	clock, _, ok := parseClock(strings.TrimSpace(s))
+	if !ok {
+		return nil, invalidSyntax(TIME, s)
+	}
+	return &Time{Value: clock}, nil
+}
+
+// ParseTimestamp reads a date, optionally followed by a time of day such as 2024-01-31 10:30:15,
+// where a time zone is left out
+func ParseTimestamp(s string) (*Timestamp, error) {
+	date, clock, _, ok := parseDateTime(s)
+	if !ok {
+		return nil, invalidSyntax(TIMESTAMP, s)
+	}
+	return &Timestamp{Value: date*MicrosecondsPerDay + clock}, nil
+}
+
+// ParseTimestampTZ reads a timestamp like ParseTimestamp, followed by the offset of its time zone from UTC,
+// such as +02, -05:30 or Z. A timestamp without an offset is in UTC.
+func ParseTimestampTZ(s string) (*TimestampTZ, error) {
+	date, clock, offset, ok := parseDateTime(s)
+	if !ok {
+		return nil, invalidSyntax(TIMESTAMPTZ, s)
+	}
+	return &TimestampTZ{Value: date*MicrosecondsPerDay + clock - offset*MicrosecondsPerSecond}, nil
+}
+
+// parseDateTime reads a date, optionally followed by a time of day and a time zone offset in seconds,
+// and by BC for years before 1 AD, returning the number of days since 1970-01-01 and microseconds since midnight
+func parseDateTime(s string) (int64, int64, int64, bool) {
+	s = strings.TrimSpace(s)
+	bc := len(s) > 3 && strings.EqualFold(s[len(s)-3:], " BC")
+	if bc {
+		s = strings.TrimSpace(s[:len(s)-3])
+	}
+	datePart, clockPart := s, ""
+	if i := strings.IndexAny(s, " T"); i != -1 {
+		datePart, clockPart = s[:i], strings.TrimSpace(s[i+1:])
+	}
+	fields := strings.Split(datePart, "-")
+	if len(fields) != 3 {
+		return 0, 0, 0, false
+	}
+	var numbers [3]int
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil || f == "" || f[0] == '+' || f[0] == '-' {
+			return 0, 0, 0, false
+		}
+		numbers[i] = n
+	}
+	year, month, day := numbers[0], time.Month(numbers[1]), numbers[2]
+	if year < 1 {
+		return 0, 0, 0, false
+	}
+	if bc {
+		// there is no year 0, so 1 BC is the year 0 of time.Time
+		year = 1 - year
+	}
+	t := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	if month < 1 || month > 12 || day < 1 || t.Month() != month {
+		return 0, 0, 0, false
+	}
+	date := floorDiv(t.Unix(), 24*60*60)
+	if clockPart == "" {
+		return date, 0, 0, true
+	}
+	clock, offset, ok := parseClock(clockPart)
+	return date, clock, offset, ok
+}
+
+// parseClock reads a time of day, optionally followed by a time zone offset, and returns
+// the number of microseconds since midnight and the offset in seconds
+func parseClock(s string) (int64, int64, bool) {
+	var offset int64
+	switch {
+	case strings.HasSuffix(s, "Z"):
+		s = s[:len(s)-1]
+	case strings.HasSuffix(strings.ToUpper(s), "UTC"):
+		s = strings.TrimSpace(s[:len(s)-3])
+	default:
+		if i := strings.LastIndexAny(s, "+-"); i > 0 {
+			var ok bool
+			if offset, ok = parseOffset(s[i:]); !ok {
+				return 0, 0, false
+			}
+			s = strings.TrimSpace(s[:i])
+		}
+	}
+	fields := strings.Split(s, ":")
+	if len(fields) < 2 || len(fields) > 3 {
+		return 0, 0, false
+	}
+	hours, err := strconv.Atoi(fields[0])
+	if err != nil || hours < 0 || hours > 23 {
+		return 0, 0, false
+	}
+	minutes, err := strconv.Atoi(fields[1])
+	if err != nil || minutes < 0 || minutes > 59 || len(fields[1]) != 2 {
+		return 0, 0, false
+	}
+	var seconds float64
+	if len(fields) == 3 {
+		seconds, err = strconv.ParseFloat(fields[2], 64)
+		if err != nil || seconds < 0 || seconds >= 60 || len(fields[2]) < 2 || fields[2][0] == '+' {
+			return 0, 0, false
+		}
+	}
+	clock := int64(hours*3600+minutes*60)*MicrosecondsPerSecond + int64(math.Round(seconds*MicrosecondsPerSecond))
+	return clock, offset, true
+}
+
+// parseOffset reads a time zone offset from UTC such as +02, +0200 or -05:30, and returns it in seconds
+func parseOffset(s string) (int64, bool) {
+	sign := int64(1)
+	if s[0] == '-' {
+		sign = -1
+	}
+	digits := strings.Replace(s[1:], ":", "", 1)
+	if (len(digits) != 2 && len(digits) != 4) || strings.Trim(digits, "0123456789") != "" {
+		return 0, false
+	}
+	hours, _ := strconv.Atoi(digits[:2])
+	minutes := 0
+	if len(digits) == 4 {
+		minutes, _ = strconv.Atoi(digits[2:])
+	}
+	if hours > 15 || minutes > 59 {
+		return 0, false
+	}
+	return sign * int64(hours*3600+minutes*60), true
+}
+
+// intervalUnit is the months, days and microseconds in one of a unit of an interval
+type intervalUnit struct {
+	months       float64
+	days         float64
+	microseconds float64
+}
+
+var intervalUnits = func() map[string]intervalUnit {
+	units := make(map[string]intervalUnit)
+	for _, u := range []struct {
+		names []string
+		unit  intervalUnit
+	}{
+		{[]string{"microsecond", "microseconds", "us", "usec", "usecs"}, intervalUnit{microseconds: 1}},
+		{[]string{"millisecond", "milliseconds", "ms", "msec", "msecs"}, intervalUnit{microseconds: 1000}},
+		{[]string{"second", "seconds", "s", "sec", "secs"}, intervalUnit{microseconds: MicrosecondsPerSecond}},
+		{[]string{"minute", "minutes", "m", "min", "mins"}, intervalUnit{microseconds: 60 * MicrosecondsPerSecond}},
+		{[]string{"hour", "hours", "h", "hr", "hrs"}, intervalUnit{microseconds: 60 * 60 * MicrosecondsPerSecond}},
+		{[]string{"day", "days", "d"}, intervalUnit{days: 1}},
+		{[]string{"week", "weeks", "w"}, intervalUnit{days: 7}},
+		{[]string{"month", "months", "mon", "mons"}, intervalUnit{months: 1}},
+		{[]string{"year", "years", "y", "yr", "yrs"}, intervalUnit{months: 12}},
+		{[]string{"decade", "decades"}, intervalUnit{months: 120}},
+		{[]string{"century", "centuries"}, intervalUnit{months: 1200}},
+		{[]string{"millennium", "millennia", "millenniums"}, intervalUnit{months: 12000}},
+	} {
+		for _, name := range u.names {
+			units[name] = u.unit
+		}
+	}
+	return units
+}()
+
+// ParseInterval reads an interval as quantities of units, such as 1 year 2 months 3 days, 1.5 hours or 2 weeks ago,
+// where a time of day such as 04:05:06 is hours, minutes and seconds, and a number without a unit is seconds
+func ParseInterval(s string) (*Interval, error) {
+	fields := strings.Fields(strings.ToLower(s))
+	if len(fields) > 0 && fields[0] == "@" {
+		fields = fields[1:]
+	}
+	ago := len(fields) > 0 && fields[len(fields)-1] == "ago"
+	if ago {
+		fields = fields[:len(fields)-1]
+	}
+	if len(fields) == 0 {
+		return nil, invalidSyntax(INTERVAL, s)
+	}
+	interval := &Interval{}
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		if strings.Contains(field, ":") {
+			sign := int64(1)
+			if field[0] == '-' || field[0] == '+' {
+				if field[0] == '-' {
+					sign = -1
+				}
+				field = field[1:]
+			}
+			microseconds, ok := parseSpan(field)
+			if !ok {
+				return nil, invalidSyntax(INTERVAL, s)
+			}
+			interval.Microseconds += sign * microseconds
+			continue
+		}
+		number, unit := field, "second"
+		if j := strings.IndexFunc(field, func(r rune) bool { return r >= 'a' && r <= 'z' }); j > 0 {
+			number, unit = field[:j], field[j:]
+		} else if i+1 < len(fields) {
+			if _, ok := intervalUnits[fields[i+1]]; ok {
+				unit = fields[i+1]
+				i++
+			}
+		}
+		n, err := strconv.ParseFloat(number, 64)
+		u, ok := intervalUnits[unit]
+		if err != nil || !ok || math.IsInf(n, 0) || math.IsNaN(n) {
+			return nil, invalidSyntax(INTERVAL, s)
+		}
+		months := n * u.months
+		wholeMonths := math.Trunc(months)
+		days := n*u.days + (months-wholeMonths)*DaysPerMonth
+		wholeDays := math.Trunc(days)
+		interval.Months += int64(wholeMonths)
+		interval.Days += int64(wholeDays)
+		interval.Microseconds += int64(math.Round(n*u.microseconds + (days-wholeDays)*MicrosecondsPerDay))
+	}
+	if ago {
+		interval = &Interval{Months: -interval.Months, Days: -interval.Days, Microseconds: -interval.Microseconds}
+	}
+	return interval, nil
+}
+
+// parseSpan reads hours and minutes, and optionally seconds, such as 04:05 or 100:05:06.5, as microseconds
+func parseSpan(s string) (int64, bool) {
+	fields := strings.Split(s, ":")
+	if len(fields) < 2 || len(fields) > 3 {
+		return 0, false
+	}
+	hours, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	minutes, err := strconv.ParseUint(fields[1], 10, 32)
+	if err != nil || minutes > 59 {
+		return 0, false
+	}
+	var seconds float64
+	if len(fields) == 3 {
+		seconds, err = strconv.ParseFloat(fields[2], 64)
+		if err != nil || seconds < 0 || seconds >= 60 || fields[2][0] == '+' {
+			return 0, false
+		}
+	}
+	return int64(hours*3600+minutes*60)*MicrosecondsPerSecond + int64(math.Round(seconds*MicrosecondsPerSecond)), true
+}
@@ -0,0 +1,414 @@
+// Package paged stores the tables in a single file of pages, without depending on another database.
+//
+// The pages are read through a buffer pool, which keeps the most recently used pages in memory.
+// Each table is a B+tree of its rows, keyed by a row ID, which is the number of rows ever inserted
+// in the table when the row was inserted, so the rows are read in the order they were inserted.
+// The catalog is a B+tree of the tables, keyed by table ID, whose values are the names, columns,
+// root pages and statistics of the tables as JSON. Pages that are no longer used, such as the overflow
+// pages of deleted rows, are put on a free list, and reused before the file grows.
+//
+// Each write, such as an insert or a batch, is atomic and durable: the pages it changed are
+// appended to a write-ahead log, which is synced before the write returns. Opening the file
+// replays the log, so the writes that returned before a crash are kept, and a write that was
+// interrupted is thrown away. The log is checkpointed into the file when it gets large, and on Close.
+package paged
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/vegarsti/sql/object"
+)
+
+// Backend stores the tables in a file of pages. It is safe for concurrent use.
+// Writes are serialized, and a scan reads the rows inserted before it started,
+// without holding the lock between the leaves it reads.
+type Backend struct {
+	file string
+	// mu guards the pager and the catalog
+	mu     sync.Mutex
+	pager  *pager
+	tables map[string]*tableEntry
+}
+
+// tableEntry is a table in the catalog
+type tableEntry struct {
+	ID      uint64
+	Name    string
+	Columns []object.Column
+	Root    pageID
+	// LastRowID is the row ID of the last row inserted
+	LastRowID uint64
+	RowCount  int
+	// Statistics is encoded by object.EncodeStatistics
+	Statistics []byte `json:",omitempty"`
+}
+
+func NewBackend(filename string) *Backend {
+	return &Backend{file: filename}
+}
+
+// Open opens the file, creating it if it doesn't exist, and replays the write-ahead log
+func (b *Backend) Open() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p, err := openPager(b.file)
+	if err != nil {
+		return err
+	}
+	b.pager = p
+	if err := b.loadCatalog(); err != nil {
+		p.close()
+		return err
+	}
+	return nil
+}
+
+// Close checkpoints the write-ahead log into the file, and closes it
+func (b *Backend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	err := b.pager.close()
+	b.pager = nil
+	return err
+}
+
+// loadCatalog reads the tables from the catalog tree
+func (b *Backend) loadCatalog() error {
+	root, err := b.pager.header(headerCatalog)
+	if err != nil {
+		return err
+	}
+	b.tables = make(map[string]*tableEntry)
+	return b.pager.walk(pageID(root), func(e entry) error {
+		value, err := b.pager.value(e)
+		if err != nil {
+			return err
+		}
+		var t tableEntry
+		if err := json.Unmarshal(value, &t); err != nil {
+			return fmt.Errorf("json unmarshal table: %w", err)
+		}
+		b.tables[t.Name] = &t
+		return nil
+	})
+}
+
+// saveTable writes the table to the catalog
+func (b *Backend) saveTable(t *tableEntry) error {
+	value, err := json.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("json marshal table: %w", err)
+	}
+	root, err := b.pager.header(headerCatalog)
+	if err != nil {
+		return err
+	}
+	newRoot, err := b.pager.put(pageID(root), t.ID, value)
+	if err != nil {
+		return err
+	}
+	if newRoot != pageID(root) {
+		return b.pager.setHeader(headerCatalog, uint32(newRoot))
+	}
+	return nil
+}
+
+// update runs f as a write, which is committed if f returns nil and rolled back otherwise
+func (b *Backend) update(f func() error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pager.begin()
+	if err := f(); err != nil {
+		b.pager.rollback()
+		// the tables in the catalog may have been changed by the write
+		if loadErr := b.loadCatalog(); loadErr != nil {
+			return fmt.Errorf("%v, and reloading the catalog failed: %w", err, loadErr)
+		}
+		return err
+	}
+	if err := b.pager.commit(); err != nil {
+		if loadErr := b.loadCatalog(); loadErr != nil {
+			return fmt.Errorf("%v, and reloading the catalog failed: %w", err, loadErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (b *Backend) CreateTable(name string, columns []object.Column) error {
+	return b.update(func() error {
+		return b.createTable(name, columns)
+	})
+}
+
+func (b *Backend) createTable(name string, columns []object.Column) error {
+	if _, ok := b.tables[name]; ok {
+		return object.Errorf(object.CodeDuplicateTable, `relation "%s" already exists`, name)
+	}
+	id, err := b.pager.nextTableID()
+	if err != nil {
+		return err
+	}
+	root, err := b.pager.newPage(leafPage)
+	if err != nil {
+		return err
+	}
+	if err := b.pager.writeNode(root, &leaf{}); err != nil {
+		return err
+	}
+	t := &tableEntry{ID: id, Name: name, Columns: columns, Root: root}
+	b.tables[name] = t
+	return b.saveTable(t)
+}
+
+func (b *Backend) Insert(name string, row object.Row) error {
+	return b.update(func() error {
+		return b.insert(name, []object.Row{row})
+	})
+}
+
+// insert appends the rows to the table
+func (b *Backend) insert(name string, rows []object.Row) error {
+	t, ok := b.tables[name]
+	if !ok {
+		return object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
+	}
+	for _, row := range rows {
+		if err := (object.Table{Name: name, Columns: t.Columns}).CheckRow(row); err != nil {
+			return err
+		}
+		value, err := object.EncodeRow(row, t.Columns)
+		if err != nil {
+			return fmt.Errorf("encode row: %w", err)
+		}
+		t.LastRowID++
+		if t.Root, err = b.pager.put(t.Root, t.LastRowID, value); err != nil {
+			return err
+		}
+		t.RowCount++
+	}
+	return b.saveTable(t)
+}
+
+// WriteBatch creates the tables and inserts the rows in a single write,
+// so that other sessions see all of the changes or none of them.
+func (b *Backend) WriteBatch(tables []object.Table, rows map[string][]object.Row) error {
+	return b.update(func() error {
+		for _, table := range tables {
+			if err := b.createTable(table.Name, table.Columns); err != nil {
+				return err
+			}
+		}
+		for name, tableRows := range rows {
+			if err := b.insert(name, tableRows); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Delete deletes the rows for which match returns true, in a single write.
+// Nothing is deleted if match returns an error.
+func (b *Backend) Delete(name string, match func(object.Row) (bool, error)) (int, error) {
+	var deleted int
+	err := b.update(func() error {
+		t, ok := b.tables[name]
+		if !ok {
+			return object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
+		}
+		aliases, tableNames := rowNames(t)
+		root, n, err := b.pager.deleteWhere(t.Root, func(e entry) (bool, error) {
+			value, err := b.pager.value(e)
+			if err != nil {
+				return false, err
+			}
+			row, err := object.DecodeRow(value, t.Columns, aliases, tableNames)
+			if err != nil {
+				return false, fmt.Errorf("decode row: %w", err)
+			}
+			return match(*row)
+		})
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		t.Root = root
+		t.RowCount -= n
+		deleted = n
+		return b.saveTable(t)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// rowNames returns the aliases and table names of the rows of the table
+func rowNames(t *tableEntry) ([]string, []string) {
+	aliases := make([]string, len(t.Columns))
+	tableNames := make([]string, len(t.Columns))
+	for i, c := range t.Columns {
+		aliases[i] = c.Name
+		tableNames[i] = t.Name
+	}
+	return aliases, tableNames
+}
+
+// Scan returns an iterator over the rows in the table. The iterator reads a leaf at a time,
+// and sees the rows that were inserted when the scan started, unless they are deleted
+// before it reads them.
+func (b *Backend) Scan(name string) (object.RowIterator, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, ok := b.tables[name]
+	if !ok {
+		return nil, object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
+	}
+	it := &rowIterator{backend: b, name: name, last: t.LastRowID, columns: t.Columns}
+	it.aliases, it.tableNames = rowNames(t)
+	return it, nil
+}
+
+type rowIterator struct {
+	backend *Backend
+	name    string
+	columns []object.Column
+	// the aliases and table names of every row, which are the same for all rows
+	aliases    []string
+	tableNames []string
+	// from is the smallest row ID that hasn't been read, and last is the last row ID the scan sees
+	from, last uint64
+	// values is the encoded rows read from the last leaf, which haven't been returned
+	values [][]byte
+	done   bool
+}
+
+func (it *rowIterator) Next() (*object.Row, error) {
+	for len(it.values) == 0 {
+		if it.done {
+			return nil, nil
+		}
+		if err := it.read(); err != nil {
+			it.Close()
+			return nil, err
+		}
+	}
+	row, err := object.DecodeRow(it.values[0], it.columns, it.aliases, it.tableNames)
+	if err != nil {
+		it.Close()
+		return nil, fmt.Errorf("decode row: %w", err)
+	}
+	it.values = it.values[1:]
+	return row, nil
+}
+
+// read reads the rows of the next leaf
+func (it *rowIterator) read() error {
+	b := it.backend
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.pager == nil {
+		return fmt.Errorf("scan: backend is closed")
+	}
+	// the root changes when the tree grows or shrinks
+	entries, next, more, err := b.pager.seek(b.tables[it.name].Root, it.from)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.key < it.from {
+			continue
+		}
+		if e.key > it.last {
+			it.done = true
+			break
+		}
+		value, err := b.pager.value(e)
+		if err != nil {
+			return err
+		}
+		it.values = append(it.values, value)
+	}
+	if !more {
+		it.done = true
+	}
+	it.from = next
+	// the values have been copied, so the pages can be evicted
+	return b.pager.shrink()
+}
+
+func (it *rowIterator) Close() error {
+	it.values = nil
+	it.done = true
+	return nil
+}
+
+// Columns returns the columns of the table
+func (b *Backend) Columns(name string) ([]object.Column, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, ok := b.tables[name]
+	if !ok {
+		return nil, object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
+	}
+	return t.Columns, nil
+}
+
+// RowCount returns the number of rows in the table, which is kept in the catalog.
+func (b *Backend) RowCount(name string) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, ok := b.tables[name]
+	if !ok {
+		return 0, object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
+	}
+	return t.RowCount, nil
+}
+
+// TableNames returns the names of all tables, sorted.
+func (b *Backend) TableNames() ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	names := make([]string, 0, len(b.tables))
+	for name := range b.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// SetStatistics stores the statistics of the table in the catalog.
+func (b *Backend) SetStatistics(name string, stats *object.TableStatistics) error {
+	return b.update(func() error {
+		t, ok := b.tables[name]
+		if !ok {
+			return object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
+		}
+		encoded, err := object.EncodeStatistics(stats, t.Columns)
+		if err != nil {
+			return err
+		}
+		t.Statistics = encoded
+		return b.saveTable(t)
+	})
+}
+
+// Statistics returns the statistics of the table, or nil if the table hasn't been analyzed.
+func (b *Backend) Statistics(name string) (*object.TableStatistics, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, ok := b.tables[name]
+	if !ok {
+		return nil, object.Errorf(object.CodeUndefinedTable, `relation "%s" does not exist`, name)
+	}
+	if t.Statistics == nil {
+		return nil, nil
+	}
+	return object.DecodeStatistics(t.Statistics, t.Columns)
+}
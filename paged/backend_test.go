@@ -0,0 +1,137 @@
+package paged_test
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/vegarsti/sql/backendtest"
+	"github.com/vegarsti/sql/evaluator"
+	"github.com/vegarsti/sql/object"
+	"github.com/vegarsti/sql/paged"
+)
+
+func openBackend(t *testing.T) *paged.Backend {
+	backend := paged.NewBackend(filepath.Join(t.TempDir(), "test.db"))
+	backendtest.OpenWithFoo(t, backend)
+	return backend
+}
+
+func TestConformance(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) evaluator.Backend {
+		backend := paged.NewBackend(filepath.Join(t.TempDir(), "test.db"))
+		backendtest.Open(t, backend)
+		return backend
+	})
+}
+
+// TestConcurrentWriteBatchAndScan is meant to be run with the race detector
+func TestConcurrentWriteBatchAndScan(t *testing.T) {
+	backend := openBackend(t)
+	// batches large enough to span several leaves
+	const batches, batchSize = 10, 500
+	var wg sync.WaitGroup
+	for b := 0; b < batches; b++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			rows := make([]object.Row, batchSize)
+			for i := range rows {
+				rows[i] = backendtest.IntegerRow(i)
+			}
+			if err := backend.WriteBatch(nil, map[string][]object.Row{"foo": rows}); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			// a batch is seen all at once, or not at all
+			if n := backendtest.Count(t, backend, "foo"); n%batchSize != 0 {
+				t.Errorf("scan saw %d rows, which is not a whole number of batches", n)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := backendtest.Count(t, backend, "foo"); n != batches*batchSize {
+		t.Fatalf("expected %d rows, got %d", batches*batchSize, n)
+	}
+}
+
+func TestManyRows(t *testing.T) {
+	backend := openBackend(t)
+	// enough rows for the tree to be three levels deep
+	const n = 100000
+	rows := make([]object.Row, n)
+	for i := range rows {
+		rows[i] = backendtest.IntegerRow(i)
+	}
+	if err := backend.WriteBatch(nil, map[string][]object.Row{"foo": rows}); err != nil {
+		t.Fatal(err)
+	}
+	it, err := backend.Scan("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanned, err := object.Collect(it)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scanned) != n {
+		t.Fatalf("expected %d rows. got=%d", n, len(scanned))
+	}
+	for i, row := range scanned {
+		if v := row.Values[0].(*object.Integer).Value; v != int64(i) {
+			t.Fatalf("expected row %d to be %d. got=%d", i, i, v)
+		}
+	}
+
+	// deleting all but a few rows frees the leaves, and lowers the tree
+	deleted, err := backend.Delete("foo", func(row object.Row) (bool, error) {
+		return row.Values[0].(*object.Integer).Value%25000 != 0, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != n-4 {
+		t.Fatalf("expected %d rows to be deleted. got=%d", n-4, deleted)
+	}
+	if rowCount, err := backend.RowCount("foo"); err != nil || rowCount != 4 {
+		t.Fatalf("expected 4 rows. got=%d, %v", rowCount, err)
+	}
+	if got := backendtest.Count(t, backend, "foo"); got != 4 {
+		t.Fatalf("expected 4 rows to be scanned. got=%d", got)
+	}
+}
+
+func TestLongValues(t *testing.T) {
+	backend := openBackend(t)
+	columns := []object.Column{{Name: "s", Type: object.STRING}}
+	if err := backend.CreateTable("bar", columns); err != nil {
+		t.Fatal(err)
+	}
+	// values stored in the leaves, in one overflow page, and in a chain of overflow pages
+	lengths := []int{0, 1000, 1001, 4000, 100000}
+	for _, length := range lengths {
+		row := object.Row{Values: []object.Object{&object.String{Value: strings.Repeat("x", length)}}}
+		if err := backend.Insert("bar", row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	it, err := backend.Scan("bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := object.Collect(it)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(lengths) {
+		t.Fatalf("expected %d rows. got=%d", len(lengths), len(rows))
+	}
+	for i, row := range rows {
+		if s := row.Values[0].(*object.String).Value; s != strings.Repeat("x", lengths[i]) {
+			t.Fatalf("expected row %d to have %d bytes. got=%d", i, lengths[i], len(s))
+		}
+	}
+}
@@ -0,0 +1,491 @@
+package paged
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// Tables and the catalog are B+trees keyed by uint64, with the values in the leaves.
+//
+// A leaf page is its kind, the number of entries as a uint16, and the entries in key order:
+//
+//	key      uint64
+//	inline   a byte, 1 if the value is in the leaf and 0 if it is in overflow pages
+//	value    the length as a uint16 followed by the value, if it is inline,
+//	         or the length as a uint32 followed by the first overflow page
+//
+// Values longer than maxInline are stored in a chain of overflow pages, each holding its kind,
+// the next overflow page or 0, the length of its part of the value as a uint16, and the part.
+//
+// An internal page is its kind, the number of keys n as a uint16, the first child, and n pairs of
+// a key and a child. The child after a key holds the keys from it up to the next key.
+//
+// Deleting entries doesn't merge pages that are less than full. A page is freed once it is empty.
+const (
+	nodeHeaderSize     = 1 + 2
+	entryHeaderSize    = 8 + 1 + 2
+	overflowEntrySize  = 8 + 1 + 4 + 4
+	maxInline          = 1000
+	overflowHeaderSize = 1 + 4 + 2
+	internalEntrySize  = 8 + 4
+)
+
+type entry struct {
+	key   uint64
+	value []byte // the value, if it is inline
+	// the length and first page of a value that is stored in overflow pages
+	length   uint32
+	overflow pageID
+}
+
+func (e entry) size() int {
+	if e.overflow != 0 {
+		return overflowEntrySize
+	}
+	return entryHeaderSize + len(e.value)
+}
+
+type leaf struct {
+	entries []entry
+}
+
+func (l *leaf) size() int {
+	n := nodeHeaderSize
+	for _, e := range l.entries {
+		n += e.size()
+	}
+	return n
+}
+
+// decodeLeaf decodes the leaf page. The inline values are copied, so the page can change afterwards.
+func decodeLeaf(data []byte) *leaf {
+	n := int(binary.BigEndian.Uint16(data[1:]))
+	l := &leaf{entries: make([]entry, n)}
+	data = data[nodeHeaderSize:]
+	for i := range l.entries {
+		e := &l.entries[i]
+		e.key = binary.BigEndian.Uint64(data)
+		if data[8] == 1 {
+			length := int(binary.BigEndian.Uint16(data[9:]))
+			e.value = append([]byte(nil), data[entryHeaderSize:entryHeaderSize+length]...)
+			data = data[entryHeaderSize+length:]
+			continue
+		}
+		e.length = binary.BigEndian.Uint32(data[9:])
+		e.overflow = pageID(binary.BigEndian.Uint32(data[13:]))
+		data = data[overflowEntrySize:]
+	}
+	return l
+}
+
+func (l *leaf) encode(data []byte) {
+	data[0] = leafPage
+	binary.BigEndian.PutUint16(data[1:], uint16(len(l.entries)))
+	offset := nodeHeaderSize
+	for _, e := range l.entries {
+		binary.BigEndian.PutUint64(data[offset:], e.key)
+		if e.overflow == 0 {
+			data[offset+8] = 1
+			binary.BigEndian.PutUint16(data[offset+9:], uint16(len(e.value)))
+			offset += entryHeaderSize + copy(data[offset+entryHeaderSize:], e.value)
+			continue
+		}
+		data[offset+8] = 0
+		binary.BigEndian.PutUint32(data[offset+9:], e.length)
+		binary.BigEndian.PutUint32(data[offset+13:], uint32(e.overflow))
+		offset += overflowEntrySize
+	}
+	for i := offset; i < len(data); i++ {
+		data[i] = 0
+	}
+}
+
+type internal struct {
+	keys     []uint64
+	children []pageID
+}
+
+func decodeInternal(data []byte) *internal {
+	n := int(binary.BigEndian.Uint16(data[1:]))
+	node := &internal{keys: make([]uint64, n), children: make([]pageID, n+1)}
+	node.children[0] = pageID(binary.BigEndian.Uint32(data[nodeHeaderSize:]))
+	for i := 0; i < n; i++ {
+		offset := nodeHeaderSize + 4 + i*internalEntrySize
+		node.keys[i] = binary.BigEndian.Uint64(data[offset:])
+		node.children[i+1] = pageID(binary.BigEndian.Uint32(data[offset+8:]))
+	}
+	return node
+}
+
+func (node *internal) size() int {
+	return nodeHeaderSize + 4 + len(node.keys)*internalEntrySize
+}
+
+func (node *internal) encode(data []byte) {
+	data[0] = internalPage
+	binary.BigEndian.PutUint16(data[1:], uint16(len(node.keys)))
+	binary.BigEndian.PutUint32(data[nodeHeaderSize:], uint32(node.children[0]))
+	offset := nodeHeaderSize + 4
+	for i, key := range node.keys {
+		binary.BigEndian.PutUint64(data[offset:], key)
+		binary.BigEndian.PutUint32(data[offset+8:], uint32(node.children[i+1]))
+		offset += internalEntrySize
+	}
+	for i := offset; i < len(data); i++ {
+		data[i] = 0
+	}
+}
+
+// child returns the position of the child holding the key
+func (node *internal) child(key uint64) int {
+	return sort.Search(len(node.keys), func(i int) bool { return node.keys[i] > key })
+}
+
+// leafEnd returns the offset after the last entry of the leaf page, and the key of the last entry
+func leafEnd(data []byte) (int, uint64) {
+	n := int(binary.BigEndian.Uint16(data[1:]))
+	offset, last := nodeHeaderSize, uint64(0)
+	for i := 0; i < n; i++ {
+		last = binary.BigEndian.Uint64(data[offset:])
+		if data[offset+8] == 1 {
+			offset += entryHeaderSize + int(binary.BigEndian.Uint16(data[offset+9:]))
+		} else {
+			offset += overflowEntrySize
+		}
+	}
+	return offset, last
+}
+
+// appendToLeaf appends an inline entry at the end of the leaf page, without decoding it,
+// which is how rows are usually inserted
+func (p *pager) appendToLeaf(id pageID, end int, key uint64, value []byte) error {
+	data, err := p.write(id)
+	if err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint16(data[1:], binary.BigEndian.Uint16(data[1:])+1)
+	binary.BigEndian.PutUint64(data[end:], key)
+	data[end+8] = 1
+	binary.BigEndian.PutUint16(data[end+9:], uint16(len(value)))
+	copy(data[end+entryHeaderSize:], value)
+	return nil
+}
+
+// writeNode encodes the node in the page
+func (p *pager) writeNode(id pageID, node interface{ encode([]byte) }) error {
+	data, err := p.write(id)
+	if err != nil {
+		return err
+	}
+	node.encode(data)
+	return nil
+}
+
+// newEntry returns the entry for the value, storing it in overflow pages if it is too long to be inline
+func (p *pager) newEntry(key uint64, value []byte) (entry, error) {
+	if len(value) <= maxInline {
+		return entry{key: key, value: value}, nil
+	}
+	e := entry{key: key, length: uint32(len(value))}
+	// the chain is written from its end, so each page knows the next one
+	partSize := pageSize - overflowHeaderSize
+	var next pageID
+	for end := len(value); end > 0; {
+		start := (end - 1) / partSize * partSize
+		id, data, err := p.allocate()
+		if err != nil {
+			return entry{}, err
+		}
+		data[0] = overflowPage
+		binary.BigEndian.PutUint32(data[1:], uint32(next))
+		binary.BigEndian.PutUint16(data[5:], uint16(end-start))
+		copy(data[overflowHeaderSize:], value[start:end])
+		next = id
+		end = start
+	}
+	e.overflow = next
+	return e, nil
+}
+
+// value returns the value of the entry, reading it from the overflow pages if it isn't inline
+func (p *pager) value(e entry) ([]byte, error) {
+	if e.overflow == 0 {
+		return e.value, nil
+	}
+	value := make([]byte, 0, e.length)
+	for id := e.overflow; id != 0; {
+		data, err := p.page(id)
+		if err != nil {
+			return nil, err
+		}
+		if data[0] != overflowPage {
+			return nil, fmt.Errorf("page %d is not an overflow page", id)
+		}
+		length := int(binary.BigEndian.Uint16(data[5:]))
+		value = append(value, data[overflowHeaderSize:overflowHeaderSize+length]...)
+		id = pageID(binary.BigEndian.Uint32(data[1:]))
+	}
+	if len(value) != int(e.length) {
+		return nil, fmt.Errorf("value of key %d has %d bytes, expected %d", e.key, len(value), e.length)
+	}
+	return value, nil
+}
+
+// freeEntry frees the overflow pages of the entry
+func (p *pager) freeEntry(e entry) error {
+	for id := e.overflow; id != 0; {
+		data, err := p.page(id)
+		if err != nil {
+			return err
+		}
+		next := pageID(binary.BigEndian.Uint32(data[1:]))
+		if err := p.free(id); err != nil {
+			return err
+		}
+		id = next
+	}
+	return nil
+}
+
+// split is the new page to the right of a page that was split, and its smallest key
+type split struct {
+	key  uint64
+	page pageID
+}
+
+// put sets the value of the key in the tree, and returns the root,
+// which is a new page if the old root was split
+func (p *pager) put(root pageID, key uint64, value []byte) (pageID, error) {
+	s, err := p.putInto(root, key, value)
+	if err != nil || s == nil {
+		return root, err
+	}
+	newRoot, err := p.newPage(internalPage)
+	if err != nil {
+		return 0, err
+	}
+	node := &internal{keys: []uint64{s.key}, children: []pageID{root, s.page}}
+	return newRoot, p.writeNode(newRoot, node)
+}
+
+func (p *pager) putInto(id pageID, key uint64, value []byte) (*split, error) {
+	data, err := p.page(id)
+	if err != nil {
+		return nil, err
+	}
+	switch data[0] {
+	case leafPage:
+		if end, last := leafEnd(data); len(value) <= maxInline && (end == nodeHeaderSize || key > last) && end+entryHeaderSize+len(value) <= pageSize {
+			return nil, p.appendToLeaf(id, end, key, value)
+		}
+		return p.putInLeaf(id, decodeLeaf(data), key, value)
+	case internalPage:
+		node := decodeInternal(data)
+		i := node.child(key)
+		s, err := p.putInto(node.children[i], key, value)
+		if err != nil || s == nil {
+			return nil, err
+		}
+		node.keys = append(node.keys, 0)
+		copy(node.keys[i+1:], node.keys[i:])
+		node.keys[i] = s.key
+		node.children = append(node.children, 0)
+		copy(node.children[i+2:], node.children[i+1:])
+		node.children[i+1] = s.page
+		if node.size() <= pageSize {
+			return nil, p.writeNode(id, node)
+		}
+		// the middle key moves up to the parent
+		middle := len(node.keys) / 2
+		right := &internal{
+			keys:     append([]uint64(nil), node.keys[middle+1:]...),
+			children: append([]pageID(nil), node.children[middle+1:]...),
+		}
+		s = &split{key: node.keys[middle]}
+		node.keys, node.children = node.keys[:middle], node.children[:middle+1]
+		if s.page, err = p.newPage(internalPage); err != nil {
+			return nil, err
+		}
+		if err := p.writeNode(s.page, right); err != nil {
+			return nil, err
+		}
+		return s, p.writeNode(id, node)
+	}
+	return nil, fmt.Errorf("page %d is not a tree page", id)
+}
+
+func (p *pager) putInLeaf(id pageID, l *leaf, key uint64, value []byte) (*split, error) {
+	e, err := p.newEntry(key, value)
+	if err != nil {
+		return nil, err
+	}
+	i := sort.Search(len(l.entries), func(i int) bool { return l.entries[i].key >= key })
+	if i < len(l.entries) && l.entries[i].key == key {
+		if err := p.freeEntry(l.entries[i]); err != nil {
+			return nil, err
+		}
+		l.entries[i] = e
+	} else {
+		l.entries = append(l.entries, entry{})
+		copy(l.entries[i+1:], l.entries[i:])
+		l.entries[i] = e
+	}
+	if l.size() <= pageSize {
+		return nil, p.writeNode(id, l)
+	}
+	// Rows are appended in key order, so the new entry is usually the last one. Then it is put
+	// alone in the new leaf, which leaves the full leaf full, rather than two half-full leaves.
+	middle := len(l.entries) - 1
+	if i != middle {
+		size := nodeHeaderSize
+		for middle = 0; size < pageSize/2; middle++ {
+			size += l.entries[middle].size()
+		}
+	}
+	right := &leaf{entries: append([]entry(nil), l.entries[middle:]...)}
+	l.entries = l.entries[:middle]
+	s := &split{key: right.entries[0].key}
+	if s.page, err = p.newPage(leafPage); err != nil {
+		return nil, err
+	}
+	if err := p.writeNode(s.page, right); err != nil {
+		return nil, err
+	}
+	return s, p.writeNode(id, l)
+}
+
+// seek returns the entries of the leaf that holds the key, and the smallest key of the leaf
+// after it. more is false if it is the last leaf.
+func (p *pager) seek(root pageID, key uint64) (entries []entry, next uint64, more bool, err error) {
+	id := root
+	for {
+		data, err := p.page(id)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		switch data[0] {
+		case leafPage:
+			return decodeLeaf(data).entries, next, more, nil
+		case internalPage:
+			node := decodeInternal(data)
+			i := node.child(key)
+			if i < len(node.keys) {
+				next, more = node.keys[i], true
+			}
+			id = node.children[i]
+		default:
+			return nil, 0, false, fmt.Errorf("page %d is not a tree page", id)
+		}
+	}
+}
+
+// walk calls f with each entry of the tree, in key order
+func (p *pager) walk(root pageID, f func(entry) error) error {
+	for key, more := uint64(0), true; more; {
+		entries, next, nextMore, err := p.seek(root, key)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := f(e); err != nil {
+				return err
+			}
+		}
+		key, more = next, nextMore
+	}
+	return nil
+}
+
+// deleteWhere deletes the entries for which match returns true, and returns the root, which
+// is a different page if the tree got lower, and the number of entries deleted
+func (p *pager) deleteWhere(root pageID, match func(entry) (bool, error)) (pageID, int, error) {
+	n, empty, err := p.deleteFrom(root, match)
+	if err != nil {
+		return 0, 0, err
+	}
+	if empty {
+		// the children of the root have been freed
+		return root, n, p.writeNode(root, &leaf{})
+	}
+	for {
+		data, err := p.page(root)
+		if err != nil {
+			return 0, 0, err
+		}
+		if data[0] != internalPage {
+			return root, n, nil
+		}
+		node := decodeInternal(data)
+		if len(node.children) > 1 {
+			return root, n, nil
+		}
+		if err := p.free(root); err != nil {
+			return 0, 0, err
+		}
+		root = node.children[0]
+	}
+}
+
+// deleteFrom deletes the matching entries below the page, and reports whether it is empty afterwards.
+// The children that become empty are freed.
+func (p *pager) deleteFrom(id pageID, match func(entry) (bool, error)) (int, bool, error) {
+	data, err := p.page(id)
+	if err != nil {
+		return 0, false, err
+	}
+	switch data[0] {
+	case leafPage:
+		l := decodeLeaf(data)
+		kept := l.entries[:0]
+		for _, e := range l.entries {
+			ok, err := match(e)
+			if err != nil {
+				return 0, false, err
+			}
+			if !ok {
+				kept = append(kept, e)
+				continue
+			}
+			if err := p.freeEntry(e); err != nil {
+				return 0, false, err
+			}
+		}
+		n := len(l.entries) - len(kept)
+		if n == 0 {
+			return 0, false, nil
+		}
+		l.entries = kept
+		return n, len(kept) == 0, p.writeNode(id, l)
+	case internalPage:
+		node := decodeInternal(data)
+		deleted := 0
+		// each child but the first keeps the key before it; the first child has no key before it
+		kept := &internal{}
+		for i, child := range node.children {
+			n, empty, err := p.deleteFrom(child, match)
+			if err != nil {
+				return 0, false, err
+			}
+			deleted += n
+			if empty {
+				if err := p.free(child); err != nil {
+					return 0, false, err
+				}
+				continue
+			}
+			if len(kept.children) > 0 {
+				kept.keys = append(kept.keys, node.keys[i-1])
+			}
+			kept.children = append(kept.children, child)
+		}
+		if len(kept.children) == len(node.children) {
+			return deleted, false, nil
+		}
+		if len(kept.children) == 0 {
+			return deleted, true, nil
+		}
+		return deleted, false, p.writeNode(id, kept)
+	}
+	return 0, false, fmt.Errorf("page %d is not a tree page", id)
+}
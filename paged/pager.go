@@ -0,0 +1,389 @@
+package paged
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// The data file is a sequence of pages of pageSize bytes, identified by their position in the file.
+// Page 0 is the header page:
+//
+//	magic              the 8 bytes of fileMagic
+//	page count         uint32, the number of pages in the file, including free pages
+//	free list          uint32, the first free page, or 0 if there are none
+//	catalog            uint32, the root page of the catalog tree
+//	next table ID      uint64
+//
+// The first byte of every other page is its kind. Free pages are a linked list,
+// where each free page holds the ID of the next one after its kind.
+const (
+	pageSize  = 4096
+	fileMagic = "sqlpage1"
+
+	headerPageCount   = 8
+	headerFreeList    = 12
+	headerCatalog     = 16
+	headerNextTableID = 20
+)
+
+const (
+	leafPage     byte = 1
+	internalPage byte = 2
+	overflowPage byte = 3
+	freePage     byte = 4
+)
+
+type pageID uint32
+
+// defaultPoolPages is the number of pages the buffer pool keeps in memory between writes
+const defaultPoolPages = 256
+
+// walLimit is the size the write-ahead log grows to before it is checkpointed
+const walLimit = 4 << 20
+
+// frame is a page in the buffer pool
+type frame struct {
+	data []byte
+	// dirty is whether the page has been changed since it was written to the data file
+	dirty bool
+	// used is when the page was last used, for evicting the least recently used pages
+	used uint64
+}
+
+// original is the contents of a page before the write in progress changed it
+type original struct {
+	data  []byte
+	dirty bool
+}
+
+// pager reads and writes pages through the buffer pool, and makes the changes of a write
+// durable and atomic with the write-ahead log. It is not safe for concurrent use.
+//
+// Pages changed by the write in progress stay in the pool until it commits or rolls back,
+// so the pool can grow beyond its capacity during a write. It shrinks back when the write ends,
+// or when a reader is done with the pages it read, and the least recently used pages are evicted, after writing them to the data file if they are dirty.
+// That is safe because they are in the log, so the data file only has pages of committed writes.
+type pager struct {
+	file     *os.File
+	wal      *wal
+	frames   map[pageID]*frame
+	capacity int
+	walLimit int64
+	clock    uint64
+	// originals is the pages changed by the write in progress, with their contents before it began.
+	// It is nil if no write is in progress, and a page allocated by the write has no original.
+	originals map[pageID]*original
+}
+
+// openPager opens the data file, creating it if it doesn't exist, and replays the write-ahead log
+func openPager(filename string) (*pager, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	p := &pager{file: file, frames: make(map[pageID]*frame), capacity: defaultPoolPages, walLimit: walLimit}
+	if err := p.recover(filename + "-wal"); err != nil {
+		p.file.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// recover writes the pages of the committed writes in the log to the data file,
+// and creates the header page and the catalog if the file is new
+func (p *pager) recover(walFilename string) error {
+	w, pages, err := openWAL(walFilename)
+	if err != nil {
+		return err
+	}
+	p.wal = w
+	for id, data := range pages {
+		if _, err := p.file.WriteAt(data, int64(id)*pageSize); err != nil {
+			w.close()
+			return fmt.Errorf("replay wal: %w", err)
+		}
+	}
+	if err := p.file.Sync(); err != nil {
+		w.close()
+		return fmt.Errorf("sync: %w", err)
+	}
+	if err := w.reset(); err != nil {
+		w.close()
+		return err
+	}
+	info, err := p.file.Stat()
+	if err != nil {
+		w.close()
+		return fmt.Errorf("stat: %w", err)
+	}
+	if info.Size() == 0 {
+		if err := p.create(); err != nil {
+			w.close()
+			return err
+		}
+	}
+	header, err := p.page(0)
+	if err != nil {
+		w.close()
+		return err
+	}
+	if string(header[:len(fileMagic)]) != fileMagic {
+		w.close()
+		return errors.New("not a database file")
+	}
+	return nil
+}
+
+// create writes the header page, and an empty leaf as the root of the catalog
+func (p *pager) create() error {
+	p.begin()
+	_, header := p.extend(0)
+	copy(header, fileMagic)
+	binary.BigEndian.PutUint32(header[headerPageCount:], 1)
+	binary.BigEndian.PutUint64(header[headerNextTableID:], 1)
+	catalog, err := p.newPage(leafPage)
+	if err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(header[headerCatalog:], uint32(catalog))
+	return p.commit()
+}
+
+// page returns the contents of the page, which must not be changed
+func (p *pager) page(id pageID) ([]byte, error) {
+	p.clock++
+	if f, ok := p.frames[id]; ok {
+		f.used = p.clock
+		return f.data, nil
+	}
+	data := make([]byte, pageSize)
+	if _, err := p.file.ReadAt(data, int64(id)*pageSize); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("page %d is beyond the end of the file", id)
+		}
+		return nil, fmt.Errorf("read page %d: %w", id, err)
+	}
+	p.frames[id] = &frame{data: data, used: p.clock}
+	return data, nil
+}
+
+// write returns the contents of the page for the write in progress to change
+func (p *pager) write(id pageID) ([]byte, error) {
+	if p.originals == nil {
+		panic("paged: write outside of a write")
+	}
+	data, err := p.page(id)
+	if err != nil {
+		return nil, err
+	}
+	f := p.frames[id]
+	if _, ok := p.originals[id]; !ok {
+		p.originals[id] = &original{data: append([]byte(nil), data...), dirty: f.dirty}
+	}
+	f.dirty = true
+	return data, nil
+}
+
+// allocate returns a page for the write in progress, from the free list if it isn't empty.
+// The contents of the page are zero.
+func (p *pager) allocate() (pageID, []byte, error) {
+	header, err := p.write(0)
+	if err != nil {
+		return 0, nil, err
+	}
+	if free := pageID(binary.BigEndian.Uint32(header[headerFreeList:])); free != 0 {
+		data, err := p.write(free)
+		if err != nil {
+			return 0, nil, err
+		}
+		if data[0] != freePage {
+			return 0, nil, fmt.Errorf("page %d on the free list is not free", free)
+		}
+		copy(header[headerFreeList:], data[1:5])
+		for i := range data {
+			data[i] = 0
+		}
+		return free, data, nil
+	}
+	count := binary.BigEndian.Uint32(header[headerPageCount:])
+	binary.BigEndian.PutUint32(header[headerPageCount:], count+1)
+	id, data := p.extend(pageID(count))
+	return id, data, nil
+}
+
+// extend adds a page at the end of the file for the write in progress
+func (p *pager) extend(id pageID) (pageID, []byte) {
+	p.clock++
+	data := make([]byte, pageSize)
+	p.frames[id] = &frame{data: data, dirty: true, used: p.clock}
+	p.originals[id] = nil
+	return id, data
+}
+
+// newPage allocates a page of the kind
+func (p *pager) newPage(kind byte) (pageID, error) {
+	id, data, err := p.allocate()
+	if err != nil {
+		return 0, err
+	}
+	data[0] = kind
+	return id, nil
+}
+
+// free puts the page on the free list
+func (p *pager) free(id pageID) error {
+	header, err := p.write(0)
+	if err != nil {
+		return err
+	}
+	data, err := p.write(id)
+	if err != nil {
+		return err
+	}
+	for i := range data {
+		data[i] = 0
+	}
+	data[0] = freePage
+	copy(data[1:5], header[headerFreeList:headerFreeList+4])
+	binary.BigEndian.PutUint32(header[headerFreeList:], uint32(id))
+	return nil
+}
+
+// header returns the value at the offset in the header page
+func (p *pager) header(offset int) (uint32, error) {
+	header, err := p.page(0)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(header[offset:]), nil
+}
+
+// setHeader sets the value at the offset in the header page
+func (p *pager) setHeader(offset int, v uint32) error {
+	header, err := p.write(0)
+	if err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(header[offset:], v)
+	return nil
+}
+
+// nextTableID returns a new table ID
+func (p *pager) nextTableID() (uint64, error) {
+	header, err := p.write(0)
+	if err != nil {
+		return 0, err
+	}
+	id := binary.BigEndian.Uint64(header[headerNextTableID:])
+	binary.BigEndian.PutUint64(header[headerNextTableID:], id+1)
+	return id, nil
+}
+
+// begin starts a write
+func (p *pager) begin() {
+	p.originals = make(map[pageID]*original)
+}
+
+// commit makes the changes of the write durable by appending them to the log.
+// If that fails, the changes are rolled back.
+func (p *pager) commit() error {
+	pages := make(map[pageID][]byte, len(p.originals))
+	for id := range p.originals {
+		pages[id] = p.frames[id].data
+	}
+	if len(pages) > 0 {
+		if err := p.wal.commit(pages); err != nil {
+			p.rollback()
+			return err
+		}
+	}
+	p.originals = nil
+	if p.wal.size > p.walLimit {
+		if err := p.checkpoint(); err != nil {
+			return err
+		}
+	}
+	return p.shrink()
+}
+
+// rollback throws away the changes of the write
+func (p *pager) rollback() {
+	for id, o := range p.originals {
+		if o == nil {
+			delete(p.frames, id)
+			continue
+		}
+		f := p.frames[id]
+		copy(f.data, o.data)
+		f.dirty = o.dirty
+	}
+	p.originals = nil
+	// the pages evicted now were committed, so an error writing them is seen by the next write or checkpoint
+	p.shrink()
+}
+
+// checkpoint writes the dirty pages to the data file and empties the log
+func (p *pager) checkpoint() error {
+	ids := make([]pageID, 0)
+	for id, f := range p.frames {
+		if f.dirty {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		if _, err := p.file.WriteAt(p.frames[id].data, int64(id)*pageSize); err != nil {
+			return fmt.Errorf("checkpoint: write page %d: %w", id, err)
+		}
+	}
+	if err := p.file.Sync(); err != nil {
+		return fmt.Errorf("checkpoint: sync: %w", err)
+	}
+	for _, id := range ids {
+		p.frames[id].dirty = false
+	}
+	return p.wal.reset()
+}
+
+// shrink evicts the least recently used pages until the pool is within its capacity
+func (p *pager) shrink() error {
+	if len(p.frames) <= p.capacity {
+		return nil
+	}
+	ids := make([]pageID, 0, len(p.frames))
+	for id := range p.frames {
+		if _, changing := p.originals[id]; !changing && id != 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return p.frames[ids[i]].used < p.frames[ids[j]].used })
+	for _, id := range ids {
+		if len(p.frames) <= p.capacity {
+			break
+		}
+		f := p.frames[id]
+		if f.dirty {
+			if _, err := p.file.WriteAt(f.data, int64(id)*pageSize); err != nil {
+				return fmt.Errorf("evict page %d: %w", id, err)
+			}
+		}
+		delete(p.frames, id)
+	}
+	return nil
+}
+
+// close checkpoints, so that the log is empty, and closes the files
+func (p *pager) close() error {
+	err := p.checkpoint()
+	if walErr := p.wal.close(); err == nil && walErr != nil {
+		err = fmt.Errorf("close wal: %w", walErr)
+	}
+	if fileErr := p.file.Close(); err == nil && fileErr != nil {
+		err = fmt.Errorf("close: %w", fileErr)
+	}
+	return err
+}
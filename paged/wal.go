@@ -0,0 +1,147 @@
+package paged
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+)
+
+// The write-ahead log holds the pages changed by each write since the last checkpoint.
+// A write appends a record with the new contents of each page it changed, followed by a
+// commit record, and syncs the log before it returns. The pages are written to the data
+// file later, so a write is durable once its commit record is in the log.
+//
+// A record is
+//
+//	a CRC-32 checksum of the rest of the record
+//	the kind of record, walPage or walCommit
+//	the page ID
+//	the contents of the page, for walPage records
+//
+// Opening replays the pages of the writes that were committed. A write whose commit record
+// is missing or torn, because the process stopped while it was appended, is thrown away.
+const (
+	walPage   byte = 1
+	walCommit byte = 2
+
+	walHeaderSize = 4 + 1 + 4
+)
+
+type wal struct {
+	file *os.File
+	// size is the length of the log, which is checkpointed when it grows beyond the limit
+	size int64
+}
+
+// openWAL opens the log, and returns the contents of the pages in the writes that were committed
+func openWAL(filename string) (*wal, map[pageID][]byte, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open wal: %w", err)
+	}
+	pages, err := readWAL(bufio.NewReader(file))
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("read wal: %w", err)
+	}
+	return &wal{file: file}, pages, nil
+}
+
+// readWAL reads records until the end of the log or the first record that is torn or corrupt
+func readWAL(r io.Reader) (map[pageID][]byte, error) {
+	committed := make(map[pageID][]byte)
+	pending := make(map[pageID][]byte)
+	header := make([]byte, walHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return committed, nil
+			}
+			return nil, err
+		}
+		checksum := crc32.NewIEEE()
+		checksum.Write(header[4:])
+		id := pageID(binary.BigEndian.Uint32(header[5:]))
+		var data []byte
+		switch header[4] {
+		case walPage:
+			data = make([]byte, pageSize)
+			if _, err := io.ReadFull(r, data); err != nil {
+				if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+					return committed, nil
+				}
+				return nil, err
+			}
+			checksum.Write(data)
+		case walCommit:
+		default:
+			return committed, nil
+		}
+		if checksum.Sum32() != binary.BigEndian.Uint32(header) {
+			return committed, nil
+		}
+		if data != nil {
+			pending[id] = data
+			continue
+		}
+		for id, data := range pending {
+			committed[id] = data
+		}
+		pending = make(map[pageID][]byte)
+	}
+}
+
+// appendRecord appends a record to the buffer
+func appendRecord(buf []byte, kind byte, id pageID, data []byte) []byte {
+	var header [walHeaderSize]byte
+	header[4] = kind
+	binary.BigEndian.PutUint32(header[5:], uint32(id))
+	checksum := crc32.NewIEEE()
+	checksum.Write(header[4:])
+	checksum.Write(data)
+	binary.BigEndian.PutUint32(header[:], checksum.Sum32())
+	return append(append(buf, header[:]...), data...)
+}
+
+// commit appends the pages and a commit record, and syncs the log
+func (w *wal) commit(pages map[pageID][]byte) error {
+	ids := make([]pageID, 0, len(pages))
+	for id := range pages {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	buf := make([]byte, 0, len(ids)*(walHeaderSize+pageSize)+walHeaderSize)
+	for _, id := range ids {
+		buf = appendRecord(buf, walPage, id, pages[id])
+	}
+	buf = appendRecord(buf, walCommit, 0, nil)
+	if _, err := w.file.WriteAt(buf, w.size); err != nil {
+		return fmt.Errorf("write wal: %w", err)
+	}
+	w.size += int64(len(buf))
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("sync wal: %w", err)
+	}
+	return nil
+}
+
+// reset empties the log, once its pages are in the data file
+func (w *wal) reset() error {
+	if err := w.file.Truncate(0); err != nil {
+		return fmt.Errorf("truncate wal: %w", err)
+	}
+	w.size = 0
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("sync wal: %w", err)
+	}
+	return nil
+}
+
+func (w *wal) close() error {
+	return w.file.Close()
+}
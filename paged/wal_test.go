@@ -0,0 +1,230 @@
+package paged
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vegarsti/sql/object"
+)
+
+var columns = []object.Column{{Name: "a", Type: object.INTEGER}, {Name: "s", Type: object.STRING}}
+
+func newRow(i int, s string) object.Row {
+	return object.Row{Values: []object.Object{&object.Integer{Value: int64(i)}, &object.String{Value: s}}}
+}
+
+func openFile(t *testing.T, file string) *Backend {
+	t.Helper()
+	b := NewBackend(file)
+	if err := b.Open(); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// crash closes the files without checkpointing, as if the process stopped
+func crash(t *testing.T, b *Backend) {
+	t.Helper()
+	if err := b.pager.wal.close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.pager.file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b.pager = nil
+}
+
+// values returns the integer values of the rows in the table foo
+func values(t *testing.T, b *Backend) []int64 {
+	t.Helper()
+	it, err := b.Scan("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := object.Collect(it)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vs := make([]int64, len(rows))
+	for i, row := range rows {
+		vs[i] = row.Values[0].(*object.Integer).Value
+	}
+	return vs
+}
+
+func checkValues(t *testing.T, b *Backend, n int) {
+	t.Helper()
+	vs := values(t, b)
+	if len(vs) != n {
+		t.Fatalf("expected %d rows. got=%d", n, len(vs))
+	}
+	for i, v := range vs {
+		if v != int64(i) {
+			t.Fatalf("expected row %d to be %d. got=%d", i, i, v)
+		}
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.db")
+	b := openFile(t, file)
+	if err := b.CreateTable("foo", columns); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := b.Insert("foo", newRow(i, strings.Repeat("x", i*50))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	crash(t, b)
+	if info, err := os.Stat(file + "-wal"); err != nil || info.Size() == 0 {
+		t.Fatalf("expected the writes to be in the log. got=%v, %v", info, err)
+	}
+
+	b = openFile(t, file)
+	defer b.Close()
+	checkValues(t, b, 100)
+	if n, err := b.RowCount("foo"); err != nil || n != 100 {
+		t.Fatalf("expected 100 rows. got=%d, %v", n, err)
+	}
+	if info, err := os.Stat(file + "-wal"); err != nil || info.Size() != 0 {
+		t.Fatalf("expected the log to be empty after replaying it. got=%v, %v", info, err)
+	}
+}
+
+func TestRecoveryThrowsAwayTornWrite(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		damage func(wal []byte) []byte
+	}{
+		{"missing commit record", func(wal []byte) []byte { return wal[:len(wal)-walHeaderSize] }},
+		{"torn commit record", func(wal []byte) []byte { return wal[:len(wal)-1] }},
+		{"torn page record", func(wal []byte) []byte { return wal[:len(wal)-walHeaderSize-pageSize/2] }},
+		{"corrupt page record", func(wal []byte) []byte {
+			wal[len(wal)-walHeaderSize-10]++
+			return wal
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "test.db")
+			b := openFile(t, file)
+			if err := b.CreateTable("foo", columns); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 10; i++ {
+				if err := b.Insert("foo", newRow(i, "")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			crash(t, b)
+			wal, err := os.ReadFile(file + "-wal")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(file+"-wal", tc.damage(wal), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			// the last insert is lost, and the others are kept
+			b = openFile(t, file)
+			defer b.Close()
+			checkValues(t, b, 9)
+			if err := b.Insert("foo", newRow(9, "")); err != nil {
+				t.Fatal(err)
+			}
+			checkValues(t, b, 10)
+		})
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.db")
+	b := openFile(t, file)
+	b.pager.walLimit = 10 * pageSize
+	if err := b.CreateTable("foo", columns); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := b.Insert("foo", newRow(i, strings.Repeat("x", 500))); err != nil {
+			t.Fatal(err)
+		}
+		if b.pager.wal.size > b.pager.walLimit {
+			t.Fatalf("expected the log to be checkpointed when it is larger than %d bytes. got=%d", b.pager.walLimit, b.pager.wal.size)
+		}
+	}
+	crash(t, b)
+	b = openFile(t, file)
+	defer b.Close()
+	checkValues(t, b, 100)
+}
+
+func TestBufferPoolEviction(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.db")
+	b := openFile(t, file)
+	b.pager.capacity = 4
+	if err := b.CreateTable("foo", columns); err != nil {
+		t.Fatal(err)
+	}
+	rows := make([]object.Row, 1000)
+	for i := range rows {
+		rows[i] = newRow(i, strings.Repeat("x", 100))
+	}
+	// a write keeps the pages it changes, so the pool grows beyond its capacity until the write ends
+	if err := b.WriteBatch(nil, map[string][]object.Row{"foo": rows}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(b.pager.frames); n > 4 {
+		t.Fatalf("expected at most 4 pages in the pool after the write. got=%d", n)
+	}
+	if err := b.Insert("foo", newRow(1000, "")); err != nil {
+		t.Fatal(err)
+	}
+	checkValues(t, b, 1001)
+	if n := len(b.pager.frames); n > 4 {
+		t.Fatalf("expected at most 4 pages in the pool after the scan. got=%d", n)
+	}
+
+	// pages evicted before a checkpoint were written to the data file, and the rest are in the log
+	crash(t, b)
+	b = openFile(t, file)
+	defer b.Close()
+	checkValues(t, b, 1001)
+}
+
+func TestFreePagesAreReused(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.db")
+	b := openFile(t, file)
+	defer b.Close()
+	if err := b.CreateTable("foo", columns); err != nil {
+		t.Fatal(err)
+	}
+	insertLong := func() {
+		for i := 0; i < 10; i++ {
+			if err := b.Insert("foo", newRow(i, strings.Repeat("x", 10000))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	pageCount := func() uint32 {
+		n, err := b.pager.header(headerPageCount)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	insertLong()
+	before := pageCount()
+	if _, err := b.Delete("foo", func(object.Row) (bool, error) { return true, nil }); err != nil {
+		t.Fatal(err)
+	}
+	if free, err := b.pager.header(headerFreeList); err != nil || free == 0 {
+		t.Fatalf("expected the overflow pages of the deleted rows to be free. got=%d, %v", free, err)
+	}
+	insertLong()
+	if after := pageCount(); after != before {
+		t.Fatalf("expected the file to have %d pages after reusing the free pages. got=%d", before, after)
+	}
+	checkValues(t, b, 10)
+}
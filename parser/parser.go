@@ -33,6 +33,9 @@ type Parser struct {
 
 	errors []string
 
+	// parameters is the number of ? parameters seen so far in the current statement
+	parameters int
+
 	prefixParseFns  map[token.TokenType]prefixParseFn
 	postfixParseFns map[token.TokenType]postfixParseFn
 	infixParseFns   map[token.TokenType]infixParseFn
@@ -56,6 +59,10 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.NOT, p.parsePrefixExpression)
+	p.registerPrefix(token.PARAMETER, p.parseParameter)
+	for _, t := range literalTypes {
+		p.registerPrefix(t, p.parseTypedLiteral)
+	}
 
 	p.postfixParseFns = make(map[token.TokenType]postfixParseFn)
 	p.registerPostfix(token.IS, p.parseNullIsPostfixExpression)
@@ -139,6 +146,7 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program.Statements = []ast.Statement{}
 
 	for p.curToken.Type != token.EOF {
+		p.parameters = 0
 		stmt := p.parseStatement()
 		// got errors, abort parsing
 		if stmt == nil {
@@ -197,7 +205,22 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 	return lit
 }
 
+// literalTypes are the types of typed literals
+var literalTypes = []token.TokenType{token.DATE_TYPE, token.TIME_TYPE, token.TIMESTAMP_TYPE, token.TIMESTAMPTZ_TYPE, token.INTERVAL_TYPE, token.NUMERIC_TYPE}
+
+func (p *Parser) parseTypedLiteral() ast.Expression {
+	lit := &ast.TypedLiteral{Token: p.curToken}
+	if !p.expectPeek(token.STRING_LITERAL) {
+		return nil
+	}
+	lit.Value = p.curToken.Literal
+	return lit
+}
+
 func (p *Parser) parseIdentifier() ast.Expression {
+	if p.peekTokenIs(token.LPAREN) {
+		return p.parseCallExpression()
+	}
 	lit := &ast.Identifier{
 		Token: p.curToken,
 		Value: p.curToken.Literal,
@@ -205,6 +228,59 @@ func (p *Parser) parseIdentifier() ast.Expression {
 	return lit
 }
 
+func (p *Parser) parseCallExpression() ast.Expression {
+	call := &ast.CallExpression{
+		Token:     p.curToken,
+		Function:  p.curToken.Literal,
+		Arguments: make([]ast.Expression, 0),
+	}
+	p.nextToken()
+	if p.peekTokenIs(token.ASTERISK) {
+		p.nextToken()
+		call.Star = true
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+		return call
+	}
+	if p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		return call
+	}
+	p.nextToken()
+	argument := p.parseExpression(LOWEST)
+	if argument == nil {
+		return nil
+	}
+	call.Arguments = append(call.Arguments, argument)
+	// extract(field from source) is the call extract('field', source)
+	if call.Function == "extract" && p.peekTokenIs(token.FROM) {
+		if field, ok := argument.(*ast.Identifier); ok && field.Table == "" {
+			call.Arguments[0] = &ast.StringLiteral{Token: token.Token{Type: token.STRING_LITERAL, Literal: field.Value}, Value: field.Value}
+		}
+		p.nextToken()
+		p.nextToken()
+		source := p.parseExpression(LOWEST)
+		if source == nil {
+			return nil
+		}
+		call.Arguments = append(call.Arguments, source)
+	}
+	for p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		p.nextToken()
+		argument := p.parseExpression(LOWEST)
+		if argument == nil {
+			return nil
+		}
+		call.Arguments = append(call.Arguments, argument)
+	}
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	return call
+}
+
 func (p *Parser) parseQualifiedIdentifier() ast.Expression {
 	split := strings.Split(p.curToken.Literal, ".")
 	lit := &ast.Identifier{
@@ -215,6 +291,23 @@ func (p *Parser) parseQualifiedIdentifier() ast.Expression {
 	return lit
 }
 
+// parseParameter parses $n as parameter n, and ? as the parameter following the previous ?
+func (p *Parser) parseParameter() ast.Expression {
+	parameter := &ast.Parameter{Token: p.curToken}
+	if p.curToken.Literal == "?" {
+		p.parameters++
+		parameter.Index = p.parameters
+		return parameter
+	}
+	index, err := strconv.Atoi(strings.TrimPrefix(p.curToken.Literal, "$"))
+	if err != nil || index < 1 {
+		p.errors = append(p.errors, fmt.Sprintf("there is no parameter %s", p.curToken.Literal))
+		return nil
+	}
+	parameter.Index = index
+	return parameter
+}
+
 func (p *Parser) parseNull() ast.Expression {
 	return ast.NULL
 }
@@ -227,12 +320,160 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseCreateTableStatement()
 	case token.INSERT:
 		return p.parseInsertStatement()
+	case token.DELETE:
+		return p.parseDeleteStatement()
+	case token.EXPLAIN:
+		return p.parseExplainStatement()
+	case token.ANALYZE:
+		return p.parseAnalyzeStatement()
+	case token.PREPARE:
+		return p.parsePrepareStatement()
+	case token.EXECUTE:
+		return p.parseExecuteStatement()
+	case token.DEALLOCATE:
+		return p.parseDeallocateStatement()
+	case token.SET:
+		return p.parseSetStatement()
+	case token.BEGIN, token.COMMIT, token.ROLLBACK:
+		stmt := &ast.TransactionStatement{Token: p.curToken}
+		if !p.expectPeekIsEndOfStatement() {
+			return nil
+		}
+		return stmt
 	default:
 		p.errors = append(p.errors, fmt.Sprintf("expected start of statement, got %s token with literal %s", p.curToken.Type, p.curToken.Literal))
 		return nil
 	}
 }
 
+func (p *Parser) parseExplainStatement() ast.Statement {
+	stmt := &ast.ExplainStatement{}
+	if p.peekTokenIs(token.ANALYZE) {
+		p.nextToken()
+		stmt.Analyze = true
+	}
+	if !p.expectPeek(token.SELECT) {
+		return nil
+	}
+	selectStatement := p.parseSelectStatement()
+	if selectStatement == nil {
+		return nil
+	}
+	stmt.Statement = selectStatement
+	return stmt
+}
+
+func (p *Parser) parseAnalyzeStatement() ast.Statement {
+	stmt := &ast.AnalyzeStatement{}
+	if p.peekTokenIs(token.IDENTIFIER) {
+		p.nextToken()
+		stmt.TableName = p.curToken.Literal
+	}
+	if !p.expectPeekIsEndOfStatement() {
+		return nil
+	}
+	return stmt
+}
+
+func (p *Parser) parsePrepareStatement() ast.Statement {
+	stmt := &ast.PrepareStatement{}
+	if !p.expectPeek(token.IDENTIFIER) {
+		return nil
+	}
+	stmt.Name = p.curToken.Literal
+	if !p.expectPeek(token.AS) {
+		return nil
+	}
+	p.nextToken()
+	switch p.curToken.Type {
+	case token.SELECT, token.INSERT, token.DELETE, token.EXPLAIN:
+	default:
+		p.errors = append(p.errors, fmt.Sprintf("expected SELECT, INSERT, DELETE or EXPLAIN statement to prepare, got %s token with literal %s", p.curToken.Type, p.curToken.Literal))
+		return nil
+	}
+	statement := p.parseStatement()
+	if statement == nil {
+		return nil
+	}
+	stmt.Statement = statement
+	return stmt
+}
+
+func (p *Parser) parseExecuteStatement() ast.Statement {
+	stmt := &ast.ExecuteStatement{Arguments: make([]ast.Expression, 0)}
+	if !p.expectPeek(token.IDENTIFIER) {
+		return nil
+	}
+	stmt.Name = p.curToken.Literal
+	if p.peekTokenIs(token.LPAREN) {
+		p.nextToken()
+		p.nextToken()
+		argument := p.parseExpression(LOWEST)
+		if argument == nil {
+			return nil
+		}
+		stmt.Arguments = append(stmt.Arguments, argument)
+		for p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+			p.nextToken()
+			argument := p.parseExpression(LOWEST)
+			if argument == nil {
+				return nil
+			}
+			stmt.Arguments = append(stmt.Arguments, argument)
+		}
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+	}
+	if !p.expectPeekIsEndOfStatement() {
+		return nil
+	}
+	return stmt
+}
+
+func (p *Parser) parseDeallocateStatement() ast.Statement {
+	stmt := &ast.DeallocateStatement{}
+	if p.peekTokenIs(token.PREPARE) {
+		p.nextToken()
+	}
+	if p.peekTokenIs(token.ALL) {
+		p.nextToken()
+		stmt.All = true
+	} else {
+		if !p.expectPeek(token.IDENTIFIER) {
+			return nil
+		}
+		stmt.Name = p.curToken.Literal
+	}
+	if !p.expectPeekIsEndOfStatement() {
+		return nil
+	}
+	return stmt
+}
+
+func (p *Parser) parseSetStatement() ast.Statement {
+	stmt := &ast.SetStatement{}
+	if !p.expectPeek(token.IDENTIFIER) {
+		return nil
+	}
+	stmt.Name = p.curToken.Literal
+	if p.peekTokenIs(token.TO) {
+		p.nextToken()
+	} else if !p.expectPeek(token.EQUALS) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+	if stmt.Value == nil {
+		return nil
+	}
+	if !p.expectPeekIsEndOfStatement() {
+		return nil
+	}
+	return stmt
+}
+
 func (p *Parser) parseElementInSelect() (ast.Expression, string) {
 	p.nextToken()
 	expr := p.parseExpression(LOWEST)
@@ -375,6 +616,7 @@ func (p *Parser) parseSelectStatement() ast.Statement {
 		Aliases:     make([]string, 0),
 		From:        make([]*ast.From, 0),
 		OrderBy:     make([]*ast.OrderByExpression, 0),
+		GroupBy:     make([]ast.Expression, 0),
 		Limit:       nil,
 		Where:       nil,
 	}
@@ -417,6 +659,28 @@ func (p *Parser) parseSelectStatement() ast.Statement {
 		stmt.Where = p.parseExpression(LOWEST)
 	}
 
+	if p.peekToken.Type == token.GROUP {
+		p.nextToken()
+		if !p.expectPeek(token.BY) {
+			return nil
+		}
+		p.nextToken()
+		groupBy := p.parseExpression(LOWEST)
+		if groupBy == nil {
+			return nil
+		}
+		stmt.GroupBy = append(stmt.GroupBy, groupBy)
+		for p.peekToken.Type == token.COMMA {
+			p.nextToken()
+			p.nextToken()
+			groupBy := p.parseExpression(LOWEST)
+			if groupBy == nil {
+				return nil
+			}
+			stmt.GroupBy = append(stmt.GroupBy, groupBy)
+		}
+	}
+
 	if p.peekToken.Type == token.ORDER {
 		p.nextToken()
 		if !p.expectPeek(token.BY) {
@@ -463,7 +727,11 @@ func (p *Parser) parseSelectStatement() ast.Statement {
 }
 
 func (p *Parser) expectPeekType() bool {
-	if !(p.peekToken.Type == token.STRING_TYPE || p.peekToken.Type == token.FLOAT_TYPE || p.peekToken.Type == token.INTEGER_TYPE || p.peekToken.Type == token.BOOLEAN_TYPE) {
+	isLiteralType := false
+	for _, t := range literalTypes {
+		isLiteralType = isLiteralType || p.peekToken.Type == t
+	}
+	if !(p.peekToken.Type == token.STRING_TYPE || p.peekToken.Type == token.FLOAT_TYPE || p.peekToken.Type == token.INTEGER_TYPE || p.peekToken.Type == token.BOOLEAN_TYPE || isLiteralType) {
 		p.errors = append(p.errors, fmt.Sprintf("expected type, got %s token with literal %s", p.peekToken.Type, p.peekToken.Literal))
 		return false
 	}
@@ -471,6 +739,35 @@ func (p *Parser) expectPeekType() bool {
 	return true
 }
 
+// parseTypeModifiers parses the precision and optional scale in parentheses after NUMERIC, as in NUMERIC(10, 2),
+// and returns nil if there are none
+func (p *Parser) parseTypeModifiers() ([]int64, bool) {
+	if p.curToken.Type != token.NUMERIC_TYPE || p.peekToken.Type != token.LPAREN {
+		return nil, true
+	}
+	p.nextToken()
+	var modifiers []int64
+	for len(modifiers) < 2 {
+		if !p.expectPeek(token.INT_LITERAL) {
+			return nil, false
+		}
+		value, err := strconv.ParseInt(p.curToken.Literal, 10, 64)
+		if err != nil {
+			p.errors = append(p.errors, fmt.Sprintf("could not parse %q as integer", p.curToken.Literal))
+			return nil, false
+		}
+		modifiers = append(modifiers, value)
+		if p.peekToken.Type != token.COMMA {
+			break
+		}
+		p.nextToken()
+	}
+	if !p.expectPeek(token.RPAREN) {
+		return nil, false
+	}
+	return modifiers, true
+}
+
 func (p *Parser) parseCreateTableStatement() ast.Statement {
 	stmt := &ast.CreateTableStatement{
 		ColumnNames: make([]string, 0),
@@ -499,6 +796,11 @@ func (p *Parser) parseCreateTableStatement() ast.Statement {
 		return nil
 	}
 	stmt.ColumnTypes = append(stmt.ColumnTypes, p.curToken)
+	modifiers, ok := p.parseTypeModifiers()
+	if !ok {
+		return nil
+	}
+	stmt.ColumnTypeModifiers = append(stmt.ColumnTypeModifiers, modifiers)
 
 	for p.peekToken.Type == token.COMMA {
 		p.nextToken()
@@ -511,6 +813,11 @@ func (p *Parser) parseCreateTableStatement() ast.Statement {
 			return nil
 		}
 		stmt.ColumnTypes = append(stmt.ColumnTypes, p.curToken)
+		modifiers, ok := p.parseTypeModifiers()
+		if !ok {
+			return nil
+		}
+		stmt.ColumnTypeModifiers = append(stmt.ColumnTypeModifiers, modifiers)
 	}
 
 	if !p.expectPeek(token.RPAREN) {
@@ -563,6 +870,29 @@ func (p *Parser) parseInsertStatement() ast.Statement {
 	return stmt
 }
 
+func (p *Parser) parseDeleteStatement() ast.Statement {
+	stmt := &ast.DeleteStatement{}
+	if !p.expectPeek(token.FROM) {
+		return nil
+	}
+	if !p.expectPeek(token.IDENTIFIER) {
+		return nil
+	}
+	stmt.TableName = p.curToken.Literal
+	if p.peekTokenIs(token.WHERE) {
+		p.nextToken()
+		p.nextToken()
+		stmt.Where = p.parseExpression(LOWEST)
+		if stmt.Where == nil {
+			return nil
+		}
+	}
+	if !p.expectPeekIsEndOfStatement() {
+		return nil
+	}
+	return stmt
+}
+
 func (p *Parser) parseInsertRow() []ast.Expression {
 	if !p.expectPeek(token.LPAREN) {
 		return nil
@@ -1055,6 +1055,296 @@ func TestSelectWhere(t *testing.T) {
 	}
 }
 
+func TestSelectGroupBy(t *testing.T) {
+	input := "select a, count(*), sum(b + 1), max(c) from foo where a > 1 group by a, c order by count(*) desc"
+	l := lexer.New(input)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statements. got=%d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.SelectStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.SelectStatement. got=%T", program.Statements[0])
+	}
+
+	expectedExpressions := []string{"a", "count(*)", "sum((b + 1))", "max(c)"}
+	if len(stmt.Expressions) != len(expectedExpressions) {
+		t.Fatalf("stmt does not contain %d expressions. got=%d", len(expectedExpressions), len(stmt.Expressions))
+	}
+	for i, expected := range expectedExpressions {
+		if stmt.Expressions[i].String() != expected {
+			t.Fatalf("expected expression %d to be %s. got=%s", i, expected, stmt.Expressions[i].String())
+		}
+	}
+	call, ok := stmt.Expressions[1].(*ast.CallExpression)
+	if !ok {
+		t.Fatalf("exp not *ast.CallExpression. got=%T", stmt.Expressions[1])
+	}
+	if call.Function != "count" || !call.Star || len(call.Arguments) != 0 {
+		t.Fatalf("expected count(*). got=%+v", call)
+	}
+
+	expectedGroupBy := []string{"a", "c"}
+	if len(stmt.GroupBy) != len(expectedGroupBy) {
+		t.Fatalf("stmt.GroupBy not length %d. got=%d", len(expectedGroupBy), len(stmt.GroupBy))
+	}
+	for i, expected := range expectedGroupBy {
+		if stmt.GroupBy[i].String() != expected {
+			t.Fatalf("expected group by expression %d to be %s. got=%s", i, expected, stmt.GroupBy[i].String())
+		}
+	}
+
+	if len(stmt.OrderBy) != 1 || stmt.OrderBy[0].Expression.String() != "count(*)" || !stmt.OrderBy[0].Descending {
+		t.Fatalf("expected order by count(*) desc. got=%+v", stmt.OrderBy)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedAnalyze bool
+		expectedString  string
+	}{
+		{"explain select a from foo", false, "EXPLAIN SELECT a"},
+		{"explain analyze select a, b from foo where a > 1", true, "EXPLAIN ANALYZE SELECT a, b"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statements. got=%d", len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.ExplainStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ExplainStatement. got=%T", program.Statements[0])
+		}
+		if stmt.Analyze != tt.expectedAnalyze {
+			t.Fatalf("expected analyze to be %t. got=%t", tt.expectedAnalyze, stmt.Analyze)
+		}
+		if _, ok := stmt.Statement.(*ast.SelectStatement); !ok {
+			t.Fatalf("stmt.Statement is not ast.SelectStatement. got=%T", stmt.Statement)
+		}
+		if stmt.String() != tt.expectedString {
+			t.Fatalf("expected %q. got=%q", tt.expectedString, stmt.String())
+		}
+	}
+}
+
+func TestExplainError(t *testing.T) {
+	l := lexer.New("explain insert into foo values (1)")
+	p := parser.New(l)
+	p.ParseProgram()
+	expected := []string{"expected next token to be SELECT, got INSERT 'INSERT' instead"}
+	if strings.Join(p.Errors(), "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected errors %v. got=%v", expected, p.Errors())
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		input             string
+		expectedTableName string
+	}{
+		{"analyze", ""},
+		{"analyze foo;", "foo"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statements. got=%d", len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.AnalyzeStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.AnalyzeStatement. got=%T", program.Statements[0])
+		}
+		if stmt.TableName != tt.expectedTableName {
+			t.Fatalf("expected table name %q. got=%q", tt.expectedTableName, stmt.TableName)
+		}
+	}
+}
+
+func TestDelete(t *testing.T) {
+	tests := []struct {
+		input             string
+		expectedTableName string
+		expectedWhere     string
+	}{
+		{"delete from foo", "foo", ""},
+		{"delete from foo where a > 1 and b = 'x';", "foo", "((a > 1) AND (b = 'x'))"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statements. got=%d", len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.DeleteStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.DeleteStatement. got=%T", program.Statements[0])
+		}
+		if stmt.TableName != tt.expectedTableName {
+			t.Fatalf("expected table name %q. got=%q", tt.expectedTableName, stmt.TableName)
+		}
+		where := ""
+		if stmt.Where != nil {
+			where = stmt.Where.String()
+		}
+		if where != tt.expectedWhere {
+			t.Fatalf("expected where %q. got=%q", tt.expectedWhere, where)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"set statement_timeout = 100", "SET statement_timeout = 100"},
+		{"set statement_timeout to '1s';", "SET statement_timeout = '1s'"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statements. got=%d", len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.SetStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.SetStatement. got=%T", program.Statements[0])
+		}
+		if stmt.String() != tt.expected {
+			t.Fatalf("expected %q. got=%q", tt.expected, stmt.String())
+		}
+	}
+}
+
+func TestParameters(t *testing.T) {
+	input := "select $2, ? + ? from foo where a = $1; insert into foo values (?, $3)"
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.SelectStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.SelectStatement. got=%T", program.Statements[0])
+	}
+	parameter, ok := stmt.Expressions[0].(*ast.Parameter)
+	if !ok {
+		t.Fatalf("exp not *ast.Parameter. got=%T", stmt.Expressions[0])
+	}
+	if parameter.Index != 2 || parameter.TokenLiteral() != "$2" {
+		t.Fatalf("expected parameter 2 written as $2. got=%d written as %s", parameter.Index, parameter.TokenLiteral())
+	}
+	// ? parameters are numbered in order, and the numbering starts over for each statement
+	if stmt.Expressions[1].String() != "($1 + $2)" {
+		t.Fatalf("expected ($1 + $2). got=%s", stmt.Expressions[1].String())
+	}
+	if stmt.Where.String() != "(a = $1)" {
+		t.Fatalf("expected (a = $1). got=%s", stmt.Where.String())
+	}
+	insert, ok := program.Statements[1].(*ast.InsertStatement)
+	if !ok {
+		t.Fatalf("program.Statements[1] is not ast.InsertStatement. got=%T", program.Statements[1])
+	}
+	if insert.String() != "INSERT INTO foo VALUES ($1, $3)" {
+		t.Fatalf("expected INSERT INTO foo VALUES ($1, $3). got=%s", insert.String())
+	}
+}
+
+func TestPreparedStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"prepare q as select a from foo where b = $1", "PREPARE q AS SELECT a"},
+		{"prepare q as insert into foo values (?, ?)", "PREPARE q AS INSERT INTO foo VALUES ($1, $2)"},
+		{"prepare q as explain select $1", "PREPARE q AS EXPLAIN SELECT $1"},
+		{"prepare q as delete from foo where a = ?", "PREPARE q AS DELETE FROM foo WHERE (a = $1)"},
+		{"execute q", "EXECUTE q"},
+		{"execute q (1, 'a', -2.5)", "EXECUTE q (1, 'a', (-2.5))"},
+		{"deallocate q", "DEALLOCATE q"},
+		{"deallocate prepare q", "DEALLOCATE q"},
+		{"deallocate all", "DEALLOCATE ALL"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if len(program.Statements) != 1 {
+			t.Fatalf("%s: program.Statements does not contain 1 statements. got=%d", tt.input, len(program.Statements))
+		}
+		if program.Statements[0].String() != tt.expected {
+			t.Fatalf("%s: expected %q. got=%q", tt.input, tt.expected, program.Statements[0].String())
+		}
+	}
+}
+
+func TestTransactionStatements(t *testing.T) {
+	input := "begin; commit; rollback"
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	expected := []token.TokenType{token.BEGIN, token.COMMIT, token.ROLLBACK}
+	if len(program.Statements) != len(expected) {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d", len(expected), len(program.Statements))
+	}
+	for i, stmt := range program.Statements {
+		transaction, ok := stmt.(*ast.TransactionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[%d] is not ast.TransactionStatement. got=%T", i, stmt)
+		}
+		if transaction.Token.Type != expected[i] {
+			t.Fatalf("expected %s. got=%s", expected[i], transaction.Token.Type)
+		}
+	}
+}
+
+func TestPreparedStatementErrors(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"select $0", "there is no parameter $0"},
+		{"prepare q as create table foo (a int)", "expected SELECT, INSERT, DELETE or EXPLAIN statement to prepare, got CREATE token with literal CREATE"},
+		{"prepare q select 1", "expected next token to be AS, got SELECT 'SELECT' instead"},
+		{"execute q (1", "expected next token to be ), got EOF '' instead"},
+		{"deallocate", "expected next token to be IDENTIFIER, got EOF '' instead"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		p.ParseProgram()
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.expectedError {
+			t.Fatalf("%s: expected error %q. got=%v", tt.input, tt.expectedError, p.Errors())
+		}
+	}
+}
+
 func TestCreateTable(t *testing.T) {
 	input := "create table foo (a text, b integer, c float, d bool, e boolean, f int)"
 	l := lexer.New(input)
@@ -1388,3 +1678,75 @@ func TestSelectJoin(t *testing.T) {
 		t.Fatalf("stmt.From[1].Join.With.Join.With.Join.Predicate is not %s. got=%s", expectedJoinPred4, stmt.From[1].Join.With.Join.With.Join.Predicate)
 	}
 }
+
+func TestTemporal(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"select date '2024-01-01'", "DATE '2024-01-01'"},
+		{"select timestamp '2024-01-01 10:00' + interval '1 day'", "(TIMESTAMP '2024-01-01 10:00' + INTERVAL '1 day')"},
+		{"select timestamp with time zone '2024-01-01 10:00+02'", "TIMESTAMPTZ '2024-01-01 10:00+02'"},
+		{"select time '10:00' < a", "(TIME '10:00' < a)"},
+		{"select now()", "now()"},
+		{"select date_trunc('month', a)", "date_trunc('month', a)"},
+		{"select extract(year from a)", "extract('year', a)"},
+		{"select extract(epoch from (a - b))", "extract('epoch', (a - b))"},
+	}
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		stmt, ok := program.Statements[0].(*ast.SelectStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.SelectStatement. got=%T", program.Statements[0])
+		}
+		if got := stmt.Expressions[0].String(); got != tt.expected {
+			t.Fatalf("%s: expected %q. got=%q", tt.input, tt.expected, got)
+		}
+	}
+
+	input := "create table foo (a date, b time, c timestamp, d timestamp with time zone, e timestamptz, f interval)"
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	expected := "CREATE TABLE foo (a DATE, b TIME, c TIMESTAMP, d TIMESTAMPTZ, e TIMESTAMPTZ, f INTERVAL)"
+	if got := program.Statements[0].String(); got != expected {
+		t.Fatalf("expected %q. got=%q", expected, got)
+	}
+
+	for _, input := range []string{"select date", "select interval 1"} {
+		p := parser.New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Fatalf("%s: expected a parser error", input)
+		}
+	}
+}
+
+func TestNumeric(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"create table foo (a numeric, b decimal(10), c numeric(10, 2))", "CREATE TABLE foo (a NUMERIC, b NUMERIC(10), c NUMERIC(10, 2))"},
+		{"select numeric '12.50' * a", "SELECT (NUMERIC '12.50' * a)"},
+		{"select decimal '1'", "SELECT NUMERIC '1'"},
+	}
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if got := program.Statements[0].String(); got != tt.expected {
+			t.Fatalf("%s: expected %q. got=%q", tt.input, tt.expected, got)
+		}
+	}
+
+	for _, input := range []string{"create table foo (a numeric())", "create table foo (a numeric(10, 2, 1))", "create table foo (a numeric('10'))", "create table foo (a integer(10))"} {
+		p := parser.New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Fatalf("%s: expected a parser error", input)
+		}
+	}
+}
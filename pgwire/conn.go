@@ -0,0 +1,663 @@
+package pgwire
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vegarsti/sql/ast"
+	"github.com/vegarsti/sql/evaluator"
+	"github.com/vegarsti/sql/lexer"
+	"github.com/vegarsti/sql/object"
+	"github.com/vegarsti/sql/parser"
+)
+
+// The codes sent instead of a protocol version in the first message, to ask for something else than a session
+const (
+	protocolVersion   = 3 << 16
+	cancelRequestCode = 80877102
+	sslRequestCode    = 80877103
+	gssEncRequestCode = 80877104
+)
+
+// maxStartupParameters is the most parameters read from the startup message
+const maxStartupParameters = 64
+
+// errTerminate is returned when the client ends the session
+var errTerminate = errors.New("terminate")
+
+// conn is a connection to a client
+type conn struct {
+	server     *Server
+	netConn    net.Conn
+	processID  int
+	secretKey  int32 // sent to the client, which must give it in a cancel request
+	r          *bufio.Reader
+	w          *bufio.Writer
+	session    *evaluator.Session
+	statements map[string]*statement
+	portals    map[string]*portal
+	// after an error in the extended query protocol, messages are ignored until the next Sync
+	ignoreUntilSync bool
+
+	// cancelMu guards cancel, which cancels the statements being evaluated, or is nil between queries
+	cancelMu sync.Mutex
+	cancel   context.CancelFunc
+}
+
+// statement is a statement prepared by a Parse message
+type statement struct {
+	prepared *evaluator.PreparedStatement // nil for an empty query
+	// parameterTypes are the types given by the client, or inferred from the statement
+	parameterTypes []int
+	// columns and columnTypes are nil for statements that don't return rows
+	columns     []string
+	columnTypes []int
+}
+
+// portal is a statement with arguments, which is executed by Execute messages.
+// The rows of the result are kept, so they can be fetched a few at a time.
+type portal struct {
+	statement *statement
+	arguments []interface{}
+	formats   []int16 // the format of each column
+	executed  bool
+	result    object.Object
+	rows      []*object.Row // rows that have not been sent yet
+}
+
+func (c *conn) serve() {
+	defer c.server.unregister(c)
+	defer func() {
+		if c.session.InTransaction() {
+			c.session.Rollback()
+		}
+	}()
+	if err := c.startup(); err != nil {
+		c.fail(err)
+		return
+	}
+	for {
+		typ, msg, err := readMessage(c.r)
+		if err != nil {
+			return
+		}
+		if c.ignoreUntilSync && typ != 'S' && typ != 'X' {
+			continue
+		}
+		err = c.handle(typ, msg)
+		if err == errTerminate {
+			return
+		}
+		var pgErr *pgError
+		if errors.As(err, &pgErr) && !pgErr.fatal {
+			c.sendError(pgErr)
+			// an error in the simple query protocol is followed by ReadyForQuery, in the extended protocol by Sync
+			if typ == 'Q' {
+				err = c.readyForQuery()
+			} else {
+				c.ignoreUntilSync = true
+				err = nil
+			}
+		}
+		if err != nil {
+			c.fail(err)
+			return
+		}
+	}
+}
+
+// fail sends a fatal error to the client before the connection is closed, unless the connection is broken
+func (c *conn) fail(err error) {
+	var pgErr *pgError
+	if !errors.As(err, &pgErr) {
+		return
+	}
+	pgErr.fatal = true
+	c.sendError(pgErr)
+	c.w.Flush()
+}
+
+// startup handles the first message, which asks for a session and sends the user name and other parameters.
+// The client may ask for encryption first, which is declined.
+func (c *conn) startup() error {
+	for {
+		data, err := readBody(c.r)
+		if err != nil {
+			return err
+		}
+		msg := &reader{data: data}
+		code, err := msg.int32()
+		if err != nil {
+			return protocolError(err)
+		}
+		switch code {
+		case sslRequestCode, gssEncRequestCode:
+			c.w.WriteByte('N')
+			if err := c.w.Flush(); err != nil {
+				return err
+			}
+			continue
+		case cancelRequestCode:
+			// the connection is only used for the cancel request, and is closed without a response
+			processID, err := msg.int32()
+			if err != nil {
+				return protocolError(err)
+			}
+			secretKey, err := msg.int32()
+			if err != nil {
+				return protocolError(err)
+			}
+			c.server.cancelQuery(int(processID), secretKey)
+			return errTerminate
+		}
+		if code>>16 != protocolVersion>>16 {
+			return &pgError{
+				code:    codeFeatureNotSupported,
+				message: fmt.Sprintf("unsupported frontend protocol %d.%d: server supports 3.0", code>>16, code&0xffff),
+			}
+		}
+		// the parameters, such as the user and database names, are not used
+		for i := 0; ; i++ {
+			key, err := msg.string()
+			if err != nil {
+				return protocolError(err)
+			}
+			if key == "" || i == maxStartupParameters {
+				break
+			}
+			if _, err := msg.string(); err != nil {
+				return protocolError(err)
+			}
+		}
+		c.send(newMessage('R').int32(0)) // AuthenticationOk
+		for _, parameter := range [][2]string{
+			{"server_version", "14.0"},
+			{"server_encoding", "UTF8"},
+			{"client_encoding", "UTF8"},
+			{"DateStyle", "ISO, MDY"},
+			{"integer_datetimes", "on"},
+			{"standard_conforming_strings", "on"},
+		} {
+			c.send(newMessage('S').string(parameter[0]).string(parameter[1]))
+		}
+		var key [4]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return err
+		}
+		c.secretKey = int32(binary.BigEndian.Uint32(key[:]))
+		c.server.register(c)
+		c.send(newMessage('K').int32(c.processID).int32(int(c.secretKey)))
+		return c.readyForQuery()
+	}
+}
+
+func (c *conn) handle(typ byte, msg *reader) error {
+	switch typ {
+	case 'Q':
+		query, err := msg.string()
+		if err != nil {
+			return protocolError(err)
+		}
+		if err := c.simpleQuery(query); err != nil {
+			return err
+		}
+		return c.readyForQuery()
+	case 'P':
+		return c.parse(msg)
+	case 'B':
+		return c.bind(msg)
+	case 'D':
+		return c.describe(msg)
+	case 'E':
+		return c.execute(msg)
+	case 'C':
+		return c.close(msg)
+	case 'H':
+		return c.w.Flush()
+	case 'S':
+		c.ignoreUntilSync = false
+		return c.readyForQuery()
+	case 'X':
+		return errTerminate
+	}
+	return &pgError{code: codeProtocolViolation, message: fmt.Sprintf("invalid frontend message type %d", typ), fatal: true}
+}
+
+func (c *conn) send(m *message) error {
+	return m.writeTo(c.w)
+}
+
+// readyForQuery tells the client whether a transaction is in progress, and sends the buffered messages
+func (c *conn) readyForQuery() error {
+	var status byte = 'I'
+	if c.session.InTransaction() {
+		status = 'T'
+	}
+	c.send(newMessage('Z').byte(status))
+	return c.w.Flush()
+}
+
+func (c *conn) sendError(e *pgError) error {
+	severity := "ERROR"
+	if e.fatal {
+		severity = "FATAL"
+	}
+	m := newMessage('E').
+		byte('S').string(severity).
+		byte('V').string(severity).
+		byte('C').string(e.code).
+		byte('M').string(e.message).
+		byte(0)
+	return c.send(m)
+}
+
+// aLongTimeAgo is a read deadline in the past, which makes a blocked read return at once
+var aLongTimeAgo = time.Unix(1, 0)
+
+// startQuery returns a context for evaluating statements, which is cancelled by a cancel request
+// for the connection, or when the client disconnects. The returned function must be called when
+// the statements are done, before the next message is read.
+//
+// A disconnect is noticed by reading from the connection while the statements are evaluated.
+// If the client sends another message instead, such as when queries are pipelined, the message
+// is kept in the buffer, and a disconnect is not noticed until the statements are done.
+func (c *conn) startQuery() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancelMu.Lock()
+	c.cancel = cancel
+	c.cancelMu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := c.r.Peek(1); err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			cancel()
+		}
+	}()
+	return ctx, func() {
+		c.netConn.SetReadDeadline(aLongTimeAgo)
+		<-done
+		c.netConn.SetReadDeadline(time.Time{})
+		c.cancelMu.Lock()
+		c.cancel = nil
+		c.cancelMu.Unlock()
+		cancel()
+	}
+}
+
+// cancelQuery cancels the statements being evaluated, if any
+func (c *conn) cancelQuery() {
+	c.cancelMu.Lock()
+	defer c.cancelMu.Unlock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+// simpleQuery evaluates the statements in the query one by one, and sends the result of each, until a statement fails
+func (c *conn) simpleQuery(query string) error {
+	p := parser.New(lexer.New(query))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return &pgError{code: codeSyntaxError, message: strings.Join(p.Errors(), "; ")}
+	}
+	if len(program.Statements) == 0 {
+		return c.send(newMessage('I')) // EmptyQueryResponse
+	}
+	ctx, done := c.startQuery()
+	defer done()
+	for _, stmt := range program.Statements {
+		result := c.session.EvalContext(ctx, stmt)
+		if errorObj, ok := result.(*object.Error); ok {
+			return newError(errorObj)
+		}
+		rows := 0
+		if r, ok := result.(*object.Result); ok {
+			oids := make([]int, len(r.Aliases))
+			for i := range oids {
+				if i < len(r.Types) {
+					oids[i] = typeOID(r.Types[i])
+				} else {
+					oids[i] = oidText
+				}
+			}
+			c.send(rowDescription(r.Aliases, oids, nil))
+			for _, row := range r.Rows {
+				if err := c.sendRow(row, oids, nil); err != nil {
+					return err
+				}
+			}
+			rows = len(r.Rows)
+		}
+		c.send(newMessage('C').string(commandTag(stmt, result, rows)))
+	}
+	return nil
+}
+
+// parse prepares a statement, and infers the types of the parameters the client didn't give the type of
+func (c *conn) parse(msg *reader) error {
+	name, err := msg.string()
+	if err != nil {
+		return protocolError(err)
+	}
+	query, err := msg.string()
+	if err != nil {
+		return protocolError(err)
+	}
+	n, err := msg.int16()
+	if err != nil {
+		return protocolError(err)
+	}
+	declared := make([]int, n)
+	for i := range declared {
+		oid, err := msg.int32()
+		if err != nil {
+			return protocolError(err)
+		}
+		declared[i] = int(oid)
+	}
+	if _, ok := c.statements[name]; ok && name != "" {
+		return &pgError{code: object.CodeDuplicatePreparedStatement, message: fmt.Sprintf(`prepared statement "%s" already exists`, name)}
+	}
+	s := &statement{}
+	if strings.TrimSpace(query) != "" {
+		prepared, err := c.session.Prepare(query)
+		if err != nil {
+			return &pgError{code: codeSyntaxError, message: err.Error()}
+		}
+		description, err := prepared.Describe()
+		if err != nil {
+			return newError(err)
+		}
+		s.prepared = prepared
+		s.parameterTypes = make([]int, len(description.Parameters))
+		if len(declared) > len(s.parameterTypes) {
+			s.parameterTypes = make([]int, len(declared))
+		}
+		for i := range s.parameterTypes {
+			switch {
+			case i < len(declared) && declared[i] != 0:
+				s.parameterTypes[i] = declared[i]
+			case i < len(description.Parameters):
+				s.parameterTypes[i] = typeOID(description.Parameters[i])
+			default:
+				s.parameterTypes[i] = oidText
+			}
+		}
+		if description.Columns != nil {
+			s.columns = description.Columns
+			s.columnTypes = make([]int, len(description.Columns))
+			for i, t := range description.Types {
+				s.columnTypes[i] = typeOID(t)
+			}
+		}
+	}
+	c.statements[name] = s
+	return c.send(newMessage('1')) // ParseComplete
+}
+
+// bind creates a portal from a prepared statement and the values of its parameters
+func (c *conn) bind(msg *reader) error {
+	portalName, err := msg.string()
+	if err != nil {
+		return protocolError(err)
+	}
+	statementName, err := msg.string()
+	if err != nil {
+		return protocolError(err)
+	}
+	parameterFormats, err := msg.int16s()
+	if err != nil {
+		return protocolError(err)
+	}
+	n, err := msg.int16()
+	if err != nil {
+		return protocolError(err)
+	}
+	values := make([][]byte, n)
+	for i := range values {
+		length, err := msg.int32()
+		if err != nil {
+			return protocolError(err)
+		}
+		if length == -1 {
+			continue
+		}
+		if values[i], err = msg.bytes(int(length)); err != nil {
+			return protocolError(err)
+		}
+		if values[i] == nil {
+			values[i] = []byte{}
+		}
+	}
+	resultFormats, err := msg.int16s()
+	if err != nil {
+		return protocolError(err)
+	}
+
+	s, ok := c.statements[statementName]
+	if !ok {
+		return &pgError{code: object.CodeInvalidStatementName, message: fmt.Sprintf(`prepared statement "%s" does not exist`, statementName)}
+	}
+	if _, ok := c.portals[portalName]; ok && portalName != "" {
+		return &pgError{code: codeDuplicateCursor, message: fmt.Sprintf(`portal "%s" already exists`, portalName)}
+	}
+	if len(values) != len(s.parameterTypes) {
+		return &pgError{
+			code:    codeProtocolViolation,
+			message: fmt.Sprintf(`bind message supplies %d parameters, but prepared statement "%s" requires %d`, len(values), statementName, len(s.parameterTypes)),
+		}
+	}
+	p := &portal{statement: s, arguments: make([]interface{}, len(values))}
+	for i, value := range values {
+		format, err := formatCode(parameterFormats, i, len(values))
+		if err != nil {
+			return err
+		}
+		argument, err := decodeValue(value, s.parameterTypes[i], format)
+		if err != nil {
+			return &pgError{code: codeInvalidParameterValue, message: fmt.Sprintf("parameter $%d: %s", i+1, err)}
+		}
+		p.arguments[i] = argument
+	}
+	p.formats = make([]int16, len(s.columns))
+	for i := range p.formats {
+		if p.formats[i], err = formatCode(resultFormats, i, len(s.columns)); err != nil {
+			return err
+		}
+	}
+	c.portals[portalName] = p
+	return c.send(newMessage('2')) // BindComplete
+}
+
+// formatCode returns the format of the value at the index, from a list of formats which
+// is empty for text, has a single format for all values, or one format for each value
+func formatCode(formats []int16, index int, n int) (int16, error) {
+	var format int16
+	switch len(formats) {
+	case 0:
+		format = formatText
+	case 1:
+		format = formats[0]
+	case n:
+		format = formats[index]
+	default:
+		return 0, &pgError{code: codeProtocolViolation, message: fmt.Sprintf("expected 0, 1 or %d format codes, got %d", n, len(formats))}
+	}
+	if format != formatText && format != formatBinary {
+		return 0, &pgError{code: codeProtocolViolation, message: fmt.Sprintf("unknown format code %d", format)}
+	}
+	return format, nil
+}
+
+// describe sends the types of the parameters and columns of a statement, or the columns of a portal
+func (c *conn) describe(msg *reader) error {
+	kind, err := msg.bytes(1)
+	if err != nil {
+		return protocolError(err)
+	}
+	name, err := msg.string()
+	if err != nil {
+		return protocolError(err)
+	}
+	switch kind[0] {
+	case 'S':
+		s, ok := c.statements[name]
+		if !ok {
+			return &pgError{code: object.CodeInvalidStatementName, message: fmt.Sprintf(`prepared statement "%s" does not exist`, name)}
+		}
+		m := newMessage('t').int16(len(s.parameterTypes)) // ParameterDescription
+		for _, oid := range s.parameterTypes {
+			m.int32(oid)
+		}
+		c.send(m)
+		return c.describeColumns(s, nil)
+	case 'P':
+		p, ok := c.portals[name]
+		if !ok {
+			return &pgError{code: codeInvalidCursorName, message: fmt.Sprintf(`portal "%s" does not exist`, name)}
+		}
+		return c.describeColumns(p.statement, p.formats)
+	}
+	return protocolError(fmt.Errorf("invalid describe kind %q", kind[0]))
+}
+
+func (c *conn) describeColumns(s *statement, formats []int16) error {
+	if s.columns == nil {
+		return c.send(newMessage('n')) // NoData
+	}
+	return c.send(rowDescription(s.columns, s.columnTypes, formats))
+}
+
+// execute sends the rows of a portal, at most maxRows at a time if maxRows is positive.
+// The statement is evaluated the first time the portal is executed.
+func (c *conn) execute(msg *reader) error {
+	name, err := msg.string()
+	if err != nil {
+		return protocolError(err)
+	}
+	maxRows, err := msg.int32()
+	if err != nil {
+		return protocolError(err)
+	}
+	p, ok := c.portals[name]
+	if !ok {
+		return &pgError{code: codeInvalidCursorName, message: fmt.Sprintf(`portal "%s" does not exist`, name)}
+	}
+	prepared := p.statement.prepared
+	if prepared == nil {
+		return c.send(newMessage('I')) // EmptyQueryResponse
+	}
+	if !p.executed {
+		ctx, done := c.startQuery()
+		p.result = prepared.ExecuteContext(ctx, p.arguments...)
+		done()
+		p.executed = true
+		if errorObj, ok := p.result.(*object.Error); ok {
+			return newError(errorObj)
+		}
+		if r, ok := p.result.(*object.Result); ok {
+			p.rows = r.Rows
+		}
+	}
+	rows := 0
+	for len(p.rows) > 0 && (maxRows <= 0 || rows < int(maxRows)) {
+		if err := c.sendRow(p.rows[0], p.statement.columnTypes, p.formats); err != nil {
+			return err
+		}
+		p.rows = p.rows[1:]
+		rows++
+	}
+	if len(p.rows) > 0 {
+		return c.send(newMessage('s')) // PortalSuspended
+	}
+	return c.send(newMessage('C').string(commandTag(prepared.Statement(), p.result, rows)))
+}
+
+func (c *conn) close(msg *reader) error {
+	kind, err := msg.bytes(1)
+	if err != nil {
+		return protocolError(err)
+	}
+	name, err := msg.string()
+	if err != nil {
+		return protocolError(err)
+	}
+	switch kind[0] {
+	case 'S':
+		delete(c.statements, name)
+	case 'P':
+		delete(c.portals, name)
+	default:
+		return protocolError(fmt.Errorf("invalid close kind %q", kind[0]))
+	}
+	return c.send(newMessage('3')) // CloseComplete
+}
+
+// rowDescription describes the columns. Without formats, all columns are sent as text.
+func rowDescription(columns []string, oids []int, formats []int16) *message {
+	m := newMessage('T').int16(len(columns))
+	for i, name := range columns {
+		var format int16
+		if i < len(formats) {
+			format = formats[i]
+		}
+		m.string(name)
+		m.int32(0) // the table, which is not known
+		m.int16(0) // the column number in the table
+		m.int32(oids[i])
+		m.int16(typeSize(oids[i]))
+		m.int32(-1) // the type modifier
+		m.int16(int(format))
+	}
+	return m
+}
+
+// sendRow sends a row, with the values encoded as the column types in the formats.
+// A value in a column of unknown type is sent as text.
+func (c *conn) sendRow(row *object.Row, oids []int, formats []int16) error {
+	m := newMessage('D').int16(len(row.Values))
+	for i, v := range row.Values {
+		oid := oidText
+		if i < len(oids) {
+			oid = oids[i]
+		}
+		var format int16
+		if i < len(formats) {
+			format = formats[i]
+		}
+		b, err := encodeValue(v, oid, format)
+		if err != nil {
+			return &pgError{code: object.CodeDatatypeMismatch, message: err.Error()}
+		}
+		m.value(b)
+	}
+	return c.send(m)
+}
+
+// commandTag is sent when a statement is done, and tells what the statement did and how many rows it returned or changed
+func commandTag(stmt ast.Statement, result object.Object, rows int) string {
+	switch stmt.(type) {
+	case *ast.SelectStatement, *ast.ExecuteStatement:
+		if _, ok := result.(*object.Result); ok {
+			return fmt.Sprintf("SELECT %d", rows)
+		}
+	case *ast.InsertStatement:
+		if ok, isOK := result.(*object.OK); isOK {
+			return fmt.Sprintf("INSERT 0 %d", ok.RowsAffected)
+		}
+	case *ast.DeleteStatement:
+		if ok, isOK := result.(*object.OK); isOK {
+			return fmt.Sprintf("DELETE %d", ok.RowsAffected)
+		}
+	}
+	return strings.ToUpper(stmt.TokenLiteral())
+}
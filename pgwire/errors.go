@@ -0,0 +1,40 @@
+package pgwire
+
+import "github.com/vegarsti/sql/object"
+
+// SQLSTATE codes of the errors of the protocol sent to clients.
+// The codes of the errors from the evaluator are on the errors, as object.Code constants.
+const (
+	codeProtocolViolation     = "08P01"
+	codeFeatureNotSupported   = "0A000"
+	codeInvalidParameterValue = "22023"
+	codeInvalidCursorName     = "34000"
+	codeSyntaxError           = "42601"
+	codeDuplicateCursor       = "42P03"
+	codeInternalError         = "XX000"
+)
+
+// pgError is an error sent to the client in an ErrorResponse
+type pgError struct {
+	code    string
+	message string
+	// fatal errors end the session, and the connection is closed after the error is sent
+	fatal bool
+}
+
+func (e *pgError) Error() string { return e.message }
+
+// newError returns the error for an error from the evaluator, with its code,
+// or the code of an internal error if it has none
+func newError(err error) *pgError {
+	code := object.ErrorCode(err)
+	if code == "" {
+		code = codeInternalError
+	}
+	return &pgError{code: code, message: err.Error()}
+}
+
+// protocolError is a malformed message, after which the connection can't be used
+func protocolError(err error) *pgError {
+	return &pgError{code: codeProtocolViolation, message: err.Error(), fatal: true}
+}
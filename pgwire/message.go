@@ -0,0 +1,154 @@
+package pgwire
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxMessageLength is the longest message a client can send, so that a broken client can't make the server allocate without bounds
+const maxMessageLength = 1 << 30
+
+var errMessageTooShort = errors.New("message is too short")
+
+// readMessage reads a message, which is a type byte followed by the length of the message and its contents
+func readMessage(r *bufio.Reader) (byte, *reader, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	data, err := readBody(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	return typ, &reader{data: data}, nil
+}
+
+// readBody reads the length of a message, and then its contents. Startup messages don't have a type byte.
+func readBody(r *bufio.Reader) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	n := int(binary.BigEndian.Uint32(length[:]))
+	if n < 4 || n > maxMessageLength {
+		return nil, fmt.Errorf("invalid message length %d", n)
+	}
+	data := make([]byte, n-4)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// reader reads the fields of a message
+type reader struct {
+	data []byte
+}
+
+func (r *reader) int16() (int16, error) {
+	if len(r.data) < 2 {
+		return 0, errMessageTooShort
+	}
+	v := int16(binary.BigEndian.Uint16(r.data))
+	r.data = r.data[2:]
+	return v, nil
+}
+
+func (r *reader) int32() (int32, error) {
+	if len(r.data) < 4 {
+		return 0, errMessageTooShort
+	}
+	v := int32(binary.BigEndian.Uint32(r.data))
+	r.data = r.data[4:]
+	return v, nil
+}
+
+// string reads a null-terminated string
+func (r *reader) string() (string, error) {
+	for i, b := range r.data {
+		if b == 0 {
+			s := string(r.data[:i])
+			r.data = r.data[i+1:]
+			return s, nil
+		}
+	}
+	return "", errMessageTooShort
+}
+
+func (r *reader) bytes(n int) ([]byte, error) {
+	if n < 0 || len(r.data) < n {
+		return nil, errMessageTooShort
+	}
+	v := r.data[:n]
+	r.data = r.data[n:]
+	return v, nil
+}
+
+// int16s reads a count followed by that many int16 values, which is how lists of format codes are sent
+func (r *reader) int16s() ([]int16, error) {
+	n, err := r.int16()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]int16, n)
+	for i := range values {
+		if values[i], err = r.int16(); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// message is a message to be sent to the client
+type message struct {
+	typ  byte
+	data []byte
+}
+
+func newMessage(typ byte) *message {
+	return &message{typ: typ}
+}
+
+func (m *message) byte(v byte) *message {
+	m.data = append(m.data, v)
+	return m
+}
+
+func (m *message) int16(v int) *message {
+	m.data = append(m.data, byte(v>>8), byte(v))
+	return m
+}
+
+func (m *message) int32(v int) *message {
+	m.data = append(m.data, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	return m
+}
+
+// string writes a null-terminated string
+func (m *message) string(s string) *message {
+	m.data = append(m.data, s...)
+	m.data = append(m.data, 0)
+	return m
+}
+
+// value writes the length of the value and the value, or -1 for null
+func (m *message) value(v []byte) *message {
+	if v == nil {
+		return m.int32(-1)
+	}
+	m.int32(len(v))
+	m.data = append(m.data, v...)
+	return m
+}
+
+func (m *message) writeTo(w *bufio.Writer) error {
+	w.WriteByte(m.typ)
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(m.data)+4))
+	w.Write(length[:])
+	_, err := w.Write(m.data)
+	return err
+}
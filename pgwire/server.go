@@ -0,0 +1,155 @@
+// Package pgwire serves a database over the PostgreSQL frontend/backend protocol (version 3),
+// so that psql and PostgreSQL client libraries can be used with it.
+//
+//	server := pgwire.NewServer(bolt.NewBackend("films.db"))
+//	err := server.ListenAndServe("localhost:5432")
+//
+// Both the simple and the extended query protocol are supported. There is no authentication,
+// and the user and database names sent by the client are ignored. A query is cancelled by a
+// cancel request from the client, or when the client disconnects.
+package pgwire
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/vegarsti/sql/evaluator"
+)
+
+// ErrServerClosed is returned by Serve after the server is closed.
+var ErrServerClosed = errors.New("pgwire: server closed")
+
+// Server serves a backend to clients. Each connection has its own session,
+// and thus its own prepared statements and transaction.
+type Server struct {
+	backend evaluator.Backend
+
+	connMu      sync.Mutex
+	listeners   map[net.Listener]struct{}
+	connections map[net.Conn]struct{}
+	sessions    map[int]*conn // connections that have started a session, by process ID
+	processID   int
+	closed      bool
+	wg          sync.WaitGroup
+}
+
+// NewServer returns a server for the backend, which must be open.
+func NewServer(backend evaluator.Backend) *Server {
+	return &Server{
+		backend:     backend,
+		listeners:   make(map[net.Listener]struct{}),
+		connections: make(map[net.Conn]struct{}),
+		sessions:    make(map[int]*conn),
+	}
+}
+
+// ListenAndServe listens on the TCP address and serves connections.
+func (s *Server) ListenAndServe(address string) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections from the listener, and serves each of them in its own goroutine.
+// The listener is closed when Serve returns.
+func (s *Server) Serve(l net.Listener) error {
+	defer l.Close()
+	s.connMu.Lock()
+	if s.closed {
+		s.connMu.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.connMu.Unlock()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			s.connMu.Lock()
+			defer s.connMu.Unlock()
+			delete(s.listeners, l)
+			if s.closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		s.connMu.Lock()
+		if s.closed {
+			s.connMu.Unlock()
+			c.Close()
+			return ErrServerClosed
+		}
+		s.connections[c] = struct{}{}
+		s.processID++
+		processID := s.processID
+		s.wg.Add(1)
+		s.connMu.Unlock()
+		go func() {
+			defer s.wg.Done()
+			newConn(s, c, processID).serve()
+			c.Close()
+			s.connMu.Lock()
+			delete(s.connections, c)
+			s.connMu.Unlock()
+		}()
+	}
+}
+
+// Close stops accepting connections, closes the open connections, and waits until they are done.
+// Transactions in progress are rolled back. The backend is not closed.
+func (s *Server) Close() error {
+	s.connMu.Lock()
+	s.closed = true
+	var err error
+	for l := range s.listeners {
+		if closeErr := l.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	for c := range s.connections {
+		c.Close()
+	}
+	s.connMu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// register makes the connection's queries cancellable by cancel requests
+func (s *Server) register(c *conn) {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	s.sessions[c.processID] = c
+}
+
+func (s *Server) unregister(c *conn) {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	delete(s.sessions, c.processID)
+}
+
+// cancelQuery cancels the query of the connection with the process ID, if the secret key is the connection's.
+// As in PostgreSQL, nothing is sent back, so a client can't tell whether the request was valid.
+func (s *Server) cancelQuery(processID int, secretKey int32) {
+	s.connMu.Lock()
+	c, ok := s.sessions[processID]
+	s.connMu.Unlock()
+	if ok && c.secretKey == secretKey {
+		c.cancelQuery()
+	}
+}
+
+func newConn(s *Server, c net.Conn, processID int) *conn {
+	return &conn{
+		server:     s,
+		netConn:    c,
+		processID:  processID,
+		r:          bufio.NewReader(c),
+		w:          bufio.NewWriter(c),
+		session:    evaluator.NewSession(s.backend),
+		statements: make(map[string]*statement),
+		portals:    make(map[string]*portal),
+	}
+}
@@ -0,0 +1,461 @@
+package pgwire_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vegarsti/sql/inmemory"
+	"github.com/vegarsti/sql/pgwire"
+)
+
+// client speaks just enough of the protocol to test the server. Messages received are written
+// as strings, such as "T name:23 value:25" for a RowDescription, so that they are easy to compare.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	// processID and secretKey are sent by the server at startup, and identify the session in a cancel request
+	processID int32
+	secretKey int32
+}
+
+func startServer(t *testing.T) string {
+	backend := inmemory.NewBackend()
+	if err := backend.Open(); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := pgwire.NewServer(backend)
+	done := make(chan error)
+	go func() { done <- server.Serve(l) }()
+	t.Cleanup(func() {
+		server.Close()
+		if err := <-done; err != pgwire.ErrServerClosed {
+			t.Errorf("expected ErrServerClosed, got %v", err)
+		}
+	})
+	return l.Addr().String()
+}
+
+func connect(t *testing.T, address string) *client {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	c := &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+	// ask for SSL first, like most clients do
+	c.write(0, int32(8), int32(80877103))
+	if b, err := c.r.ReadByte(); err != nil || b != 'N' {
+		t.Fatalf("expected SSL to be declined, got %q (%v)", b, err)
+	}
+	startup := []interface{}{int32(196608), "user", "test", "database", "test", ""}
+	c.write(0, append([]interface{}{int32(4 + size(startup))}, startup...)...)
+	messages := c.receive()
+	if messages[0] != "R 0" || messages[len(messages)-1] != "Z I" {
+		t.Fatalf("unexpected startup response %v", messages)
+	}
+	for _, m := range messages {
+		fmt.Sscanf(m, "K %d %d", &c.processID, &c.secretKey)
+	}
+	return c
+}
+
+func size(fields []interface{}) int {
+	n := 0
+	for _, f := range fields {
+		switch f := f.(type) {
+		case int16:
+			n += 2
+		case int32:
+			n += 4
+		case string:
+			n += len(f) + 1
+		case []byte:
+			n += len(f)
+		}
+	}
+	return n
+}
+
+// write sends a message of the type, or a message without a type byte if typ is 0
+func (c *client) write(typ byte, fields ...interface{}) {
+	var b []byte
+	if typ != 0 {
+		b = append(b, typ)
+		b = append(b, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(b[1:], uint32(4+size(fields)))
+	}
+	for _, f := range fields {
+		switch f := f.(type) {
+		case int16:
+			b = append(b, byte(f>>8), byte(f))
+		case int32:
+			b = append(b, byte(f>>24), byte(f>>16), byte(f>>8), byte(f))
+		case string:
+			b = append(append(b, f...), 0)
+		case []byte:
+			b = append(b, f...)
+		}
+	}
+	if _, err := c.conn.Write(b); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// receive reads messages until ReadyForQuery
+func (c *client) receive() []string {
+	var messages []string
+	for {
+		typ, err := c.r.ReadByte()
+		if err != nil {
+			c.t.Fatalf("read: %v", err)
+		}
+		var length [4]byte
+		if _, err := io.ReadFull(c.r, length[:]); err != nil {
+			c.t.Fatalf("read: %v", err)
+		}
+		data := make([]byte, binary.BigEndian.Uint32(length[:])-4)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			c.t.Fatalf("read: %v", err)
+		}
+		messages = append(messages, format(typ, data))
+		if typ == 'Z' {
+			return messages
+		}
+	}
+}
+
+// format writes the interesting parts of a message as a string
+func format(typ byte, data []byte) string {
+	fields := []string{string(typ)}
+	switch typ {
+	case 'T':
+		for i, n := 0, int(binary.BigEndian.Uint16(data)); i < n; i++ {
+			data = data[2:]
+			end := strings.IndexByte(string(data), 0)
+			name := string(data[:end])
+			data = data[end+1:]
+			oid := binary.BigEndian.Uint32(data[6:])
+			data = data[16:]
+			fields = append(fields, fmt.Sprintf("%s:%d", name, oid))
+		}
+	case 'D':
+		n := int(binary.BigEndian.Uint16(data))
+		data = data[2:]
+		for i := 0; i < n; i++ {
+			length := int32(binary.BigEndian.Uint32(data))
+			data = data[4:]
+			if length == -1 {
+				fields = append(fields, "NULL")
+				continue
+			}
+			fields = append(fields, fmt.Sprintf("%q", data[:length]))
+			data = data[length:]
+		}
+	case 't':
+		n := int(binary.BigEndian.Uint16(data))
+		for i := 0; i < n; i++ {
+			fields = append(fields, fmt.Sprint(binary.BigEndian.Uint32(data[2+4*i:])))
+		}
+	case 'E':
+		for data[0] != 0 {
+			end := strings.IndexByte(string(data), 0)
+			if data[0] == 'C' || data[0] == 'M' {
+				fields = append(fields, string(data[1:end]))
+			}
+			data = data[end+1:]
+		}
+	case 'K':
+		fields = append(fields, fmt.Sprint(int32(binary.BigEndian.Uint32(data))), fmt.Sprint(int32(binary.BigEndian.Uint32(data[4:]))))
+	case 'C', 'Z', 'R':
+		if typ == 'R' {
+			fields = append(fields, fmt.Sprint(binary.BigEndian.Uint32(data)))
+		} else {
+			fields = append(fields, strings.TrimRight(string(data), "\x00"))
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+func TestSimpleQuery(t *testing.T) {
+	c := connect(t, startServer(t))
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{
+			"create table films (title text, year integer, rating float, seen boolean)",
+			[]string{"C CREATE TABLE", "Z I"},
+		},
+		{
+			"insert into films values ('Alien', 1979, 8.5, true), ('Heat', 1995, 8.3, false); insert into films values ('Arrival', 2016, 7.9, true)",
+			[]string{"C INSERT 0 2", "C INSERT 0 1", "Z I"},
+		},
+		{
+			"select title, year, rating, seen, null from films where year > 1980 order by year",
+			[]string{
+				"T title:25 year:20 rating:701 seen:16 null:25",
+				`D "Heat" "1995" "8.3" "f" NULL`,
+				`D "Arrival" "2016" "7.9" "t" NULL`,
+				"C SELECT 2",
+				"Z I",
+			},
+		},
+		{"", []string{"I", "Z I"}},
+		{"select x from films", []string{`E 42703 column "x" does not exist`, "Z I"}},
+		{"select year / 0 from films", []string{"E 22012 division by zero", "Z I"}},
+		{"select title from films f, films g", []string{`E 42702 column reference "title" is ambiguous`, "Z I"}},
+		{"select no_such_function(year) from films", []string{"E 42883 function no_such_function does not exist", "Z I"}},
+		{"select from", []string{"E 42601 no prefix parse function for FROM token with literal 'FROM' found", "Z I"}},
+		{"select title from films where year", []string{"E 42804 argument of WHERE must be type boolean, not type integer: 1979", "Z I"}},
+		{"select count(*) from films where count(*) > 1", []string{"E 42803 aggregate functions are not allowed in WHERE", "Z I"}},
+		{"select date '2024-02-30'", []string{`E 22007 invalid input syntax for type date: "2024-02-30"`, "Z I"}},
+		{"set work_mem = 'lots'", []string{`E 22023 invalid value for parameter "work_mem": "lots"`, "Z I"}},
+		{"select 1; select a from foo; select 2", []string{"T 1:20", `D "1"`, "C SELECT 1", `E 42P01 relation "foo" does not exist`, "Z I"}},
+		{"begin; insert into films values ('Heat', 1995, 8.3, true)", []string{"C BEGIN", "C INSERT 0 1", "Z T"}},
+		{"rollback", []string{"C ROLLBACK", "Z I"}},
+		{"prepare q as select count(*) from films where year < $1; execute q (2000)", []string{"C PREPARE", "T count(*):20", `D "2"`, "C SELECT 1", "Z I"}},
+	}
+	for _, tt := range tests {
+		c.write('Q', tt.query)
+		if got := c.receive(); !reflect.DeepEqual(got, tt.expected) {
+			t.Fatalf("%s: expected\n%q\ngot\n%q", tt.query, tt.expected, got)
+		}
+	}
+}
+
+func TestExtendedQuery(t *testing.T) {
+	c := connect(t, startServer(t))
+	c.write('Q', "create table films (title text, year integer, rating float)")
+	c.receive()
+
+	// insert with parameters in text format, with the types inferred from the columns
+	c.write('P', "insert", "insert into films values ($1, $2, $3)", int16(0))
+	c.write('D', []byte{'S'}, "insert")
+	for _, film := range [][]string{{"Alien", "1979", "8.5"}, {"Heat", "1995", "8.3"}, {"Arrival", "2016", "7.9"}} {
+		c.write('B', "", "insert", int16(0), int16(3), int32(len(film[0])), []byte(film[0]), int32(len(film[1])), []byte(film[1]), int32(len(film[2])), []byte(film[2]), int16(0))
+		c.write('E', "", int32(0))
+	}
+	c.write('S')
+	expected := []string{"1", "t 25 20 701", "n", "2", "C INSERT 0 1", "2", "C INSERT 0 1", "2", "C INSERT 0 1", "Z I"}
+	if got := c.receive(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected\n%q\ngot\n%q", expected, got)
+	}
+
+	// query with a parameter in binary format, and the year in binary format, fetching one row at a time
+	c.write('P', "", "select title, year from films where year > $1 order by year", int16(1), int32(23))
+	c.write('D', []byte{'S'}, "")
+	c.write('B', "", "", int16(1), int16(1), int16(1), int32(4), []byte{0, 0, 0x07, 0xbc}, int16(2), int16(0), int16(1))
+	c.write('D', []byte{'P'}, "")
+	c.write('E', "", int32(1))
+	c.write('E', "", int32(1))
+	c.write('S')
+	expected = []string{
+		"1",
+		"t 23",
+		"T title:25 year:20",
+		"2",
+		"T title:25 year:20",
+		`D "Heat" "\x00\x00\x00\x00\x00\x00\a\xcb"`,
+		"s",
+		`D "Arrival" "\x00\x00\x00\x00\x00\x00\a\xe0"`,
+		"C SELECT 1",
+		"Z I",
+	}
+	if got := c.receive(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected\n%q\ngot\n%q", expected, got)
+	}
+
+	// after an error, messages are ignored until Sync
+	c.write('P', "", "select title from films where rating > $1", int16(0))
+	c.write('B', "", "", int16(0), int16(1), int32(3), []byte("abc"), int16(0))
+	c.write('E', "", int32(0))
+	c.write('S')
+	c.write('B', "", "missing", int16(0), int16(0), int16(0))
+	c.write('S')
+	c.write('P', "", "", int16(0))
+	c.write('B', "", "", int16(0), int16(0), int16(0))
+	c.write('E', "", int32(0))
+	c.write('C', []byte{'S'}, "insert")
+	c.write('S')
+	expected = []string{
+		"1",
+		`E 22023 parameter $1: invalid input syntax for type double precision: "abc"`,
+		"Z I",
+		`E 26000 prepared statement "missing" does not exist`,
+		"Z I",
+		"1", "2", "I", "3",
+		"Z I",
+	}
+	if got := c.receive(); !reflect.DeepEqual(got, expected[:3]) {
+		t.Fatalf("expected\n%q\ngot\n%q", expected[:3], got)
+	}
+	if got := c.receive(); !reflect.DeepEqual(got, expected[3:5]) {
+		t.Fatalf("expected\n%q\ngot\n%q", expected[3:5], got)
+	}
+	if got := c.receive(); !reflect.DeepEqual(got, expected[5:]) {
+		t.Fatalf("expected\n%q\ngot\n%q", expected[5:], got)
+	}
+}
+
+func TestTemporal(t *testing.T) {
+	c := connect(t, startServer(t))
+	c.write('Q', "create table events (d date, z timestamptz, i interval); insert into events values ('2024-01-31', '2024-01-31 10:30:00+02', '1 month 2 days')")
+	c.receive()
+	c.write('Q', "select d, z, i from events")
+	expected := []string{"T d:1082 z:1184 i:1186", `D "2024-01-31" "2024-01-31 08:30:00+00" "1 mon 2 days"`, "C SELECT 1", "Z I"}
+	if got := c.receive(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected\n%q\ngot\n%q", expected, got)
+	}
+
+	// a date parameter and result in binary format, counting days from 2000-01-01
+	c.write('P', "", "select d + 1, i from events where d = $1", int16(1), int32(1082))
+	c.write('B', "", "", int16(1), int16(1), int16(1), int32(4), []byte{0, 0, 0x22, 0x5c}, int16(1), int16(1))
+	c.write('E', "", int32(0))
+	c.write('S')
+	expected = []string{"1", "2", `D "\x00\x00\"]" "\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02\x00\x00\x00\x01"`, "C SELECT 1", "Z I"}
+	if got := c.receive(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected\n%q\ngot\n%q", expected, got)
+	}
+}
+
+func TestNumeric(t *testing.T) {
+	c := connect(t, startServer(t))
+	c.write('Q', "create table prices (p numeric(10, 2)); insert into prices values ('12.5'), (-1234.5678)")
+	c.receive()
+	c.write('Q', "select p from prices")
+	expected := []string{"T p:1700", `D "12.50"`, `D "-1234.57"`, "C SELECT 2", "Z I"}
+	if got := c.receive(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected\n%q\ngot\n%q", expected, got)
+	}
+
+	// a numeric parameter and result in binary format, with the digits in base 10000
+	c.write('P', "", "select p * 100 from prices where p = $1", int16(1), int32(1700))
+	c.write('B', "", "", int16(1), int16(1), int16(1), int32(12), []byte{0, 2, 0, 0, 0x40, 0, 0, 2, 0x04, 0xd2, 0x16, 0x44}, int16(1), int16(1))
+	c.write('E', "", int32(0))
+	c.write('S')
+	expected = []string{"1", "2", `D "\x00\x02\x00\x01@\x00\x00\x02\x00\f\r\x81"`, "C SELECT 1", "Z I"}
+	if got := c.receive(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected\n%q\ngot\n%q", expected, got)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	address := startServer(t)
+	a := connect(t, address)
+	b := connect(t, address)
+	tests := []struct {
+		client   *client
+		query    string
+		expected []string
+	}{
+		{a, "create table foo (a int); begin; insert into foo values (1)", []string{"C CREATE TABLE", "C BEGIN", "C INSERT 0 1", "Z T"}},
+		{b, "select a from foo", []string{"T a:20", "C SELECT 0", "Z I"}},
+		{a, "commit", []string{"C COMMIT", "Z I"}},
+		{b, "select a from foo", []string{"T a:20", `D "1"`, "C SELECT 1", "Z I"}},
+		// the first transaction to delete a row wins
+		{a, "begin; delete from foo where a = 1", []string{"C BEGIN", "C DELETE 1", "Z T"}},
+		{b, "begin; delete from foo", []string{"C BEGIN", "C DELETE 1", "Z T"}},
+		{a, "rollback; begin; insert into foo values (1); commit", []string{"C ROLLBACK", "C BEGIN", "C INSERT 0 1", "C COMMIT", "Z I"}},
+		{b, "commit", []string{"C COMMIT", "Z I"}},
+		{a, "begin; delete from foo where a = 1", []string{"C BEGIN", "C DELETE 1", "Z T"}},
+		{b, "delete from foo", []string{"C DELETE 1", "Z I"}},
+		{a, "commit", []string{"E 40001 could not serialize access due to concurrent update", "Z I"}},
+		{b, "insert into foo values (1)", []string{"C INSERT 0 1", "Z I"}},
+		// an open transaction is rolled back when the connection is closed
+		{b, "begin; insert into foo values (2)", []string{"C BEGIN", "C INSERT 0 1", "Z T"}},
+	}
+	for _, tt := range tests {
+		tt.client.write('Q', tt.query)
+		if got := tt.client.receive(); !reflect.DeepEqual(got, tt.expected) {
+			t.Fatalf("%s: expected\n%q\ngot\n%q", tt.query, tt.expected, got)
+		}
+	}
+	b.write('X')
+	if _, err := b.r.ReadByte(); err != io.EOF {
+		t.Fatalf("expected the connection to be closed, got %v", err)
+	}
+	a.write('Q', "select a from foo")
+	expected := []string{"T a:20", `D "1"`, "C SELECT 1", "Z I"}
+	if got := a.receive(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected\n%q\ngot\n%q", expected, got)
+	}
+}
+
+// createNumbers creates a table of 300 rows, so that a cross join of it with itself four times
+// has 8 billion rows, and only ends if it is cancelled
+func createNumbers(c *client) {
+	values := make([]string, 300)
+	for i := range values {
+		values[i] = fmt.Sprintf("(%d)", i)
+	}
+	c.write('Q', "create table foo (a int); insert into foo values "+strings.Join(values, ", "))
+	c.receive()
+}
+
+const crossJoin = "select count(*) from foo w, foo x, foo y, foo z"
+
+func TestCancelRequest(t *testing.T) {
+	address := startServer(t)
+	c := connect(t, address)
+	createNumbers(c)
+	c.write('Q', crossJoin)
+	// the request is sent until the query is cancelled, since it is ignored if it arrives before the query starts
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			conn, err := net.Dial("tcp", address)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			cancel := &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+			cancel.write(0, int32(16), int32(80877102), c.processID, c.secretKey)
+			// the server closes the connection without a response
+			if _, err := cancel.r.ReadByte(); err != io.EOF {
+				t.Errorf("expected the connection to be closed, got %v", err)
+			}
+			conn.Close()
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}()
+	expected := []string{"E 57014 canceling statement due to user request", "Z I"}
+	if got := c.receive(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected\n%q\ngot\n%q", expected, got)
+	}
+}
+
+func TestStatementTimeout(t *testing.T) {
+	c := connect(t, startServer(t))
+	createNumbers(c)
+	c.write('Q', "set statement_timeout = '10ms'; "+crossJoin)
+	expected := []string{"C SET", "E 57014 canceling statement due to statement timeout", "Z I"}
+	if got := c.receive(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected\n%q\ngot\n%q", expected, got)
+	}
+}
+
+// TestDisconnect only ends if the query is cancelled when the connection is closed,
+// since closing the server waits for the query to be done
+func TestDisconnect(t *testing.T) {
+	c := connect(t, startServer(t))
+	createNumbers(c)
+	c.write('Q', crossJoin)
+	c.conn.Close()
+}
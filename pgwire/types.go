@@ -0,0 +1,393 @@
+package pgwire
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/vegarsti/sql/object"
+)
+
+// The object identifiers of the PostgreSQL types that are used by the protocol
+const (
+	oidBool        = 16
+	oidInt8        = 20
+	oidInt2        = 21
+	oidInt4        = 23
+	oidText        = 25
+	oidFloat4      = 700
+	oidFloat8      = 701
+	oidUnknown     = 705
+	oidBpchar      = 1042
+	oidVarchar     = 1043
+	oidDate        = 1082
+	oidTime        = 1083
+	oidTimestamp   = 1114
+	oidTimestampTZ = 1184
+	oidInterval    = 1186
+	oidNumeric     = 1700
+)
+
+// The binary format of dates and timestamps counts from 2000-01-01, which is this many days after 1970-01-01
+const postgresEpochDays = 10957
+
+// Format codes for values
+const (
+	formatText   = 0
+	formatBinary = 1
+)
+
+// typeOID returns the PostgreSQL type of a data type. Values of unknown type are sent as text.
+func typeOID(t object.DataType) int {
+	switch t {
+	case object.INTEGER:
+		return oidInt8
+	case object.FLOAT:
+		return oidFloat8
+	case object.BOOLEAN:
+		return oidBool
+	case object.DATE:
+		return oidDate
+	case object.TIME:
+		return oidTime
+	case object.TIMESTAMP:
+		return oidTimestamp
+	case object.TIMESTAMPTZ:
+		return oidTimestampTZ
+	case object.INTERVAL:
+		return oidInterval
+	case object.NUMERIC:
+		return oidNumeric
+	}
+	return oidText
+}
+
+// typeSize is the size of values of the type in bytes, or -1 for types with variable size
+func typeSize(oid int) int {
+	switch oid {
+	case oidInt8, oidFloat8, oidTime, oidTimestamp, oidTimestampTZ:
+		return 8
+	case oidDate:
+		return 4
+	case oidInterval:
+		return 16
+	case oidBool:
+		return 1
+	}
+	return -1
+}
+
+// encodeValue encodes the value in the format, as a value of the type. Null is encoded as nil.
+func encodeValue(v object.Object, oid int, format int16) ([]byte, error) {
+	if v.Type() == object.NULL_OBJ {
+		return nil, nil
+	}
+	if format == formatText {
+		return []byte(textValue(v)), nil
+	}
+	switch v := v.(type) {
+	case *object.Integer:
+		if oid == oidInt8 {
+			b := make([]byte, 8)
+			binary.BigEndian.PutUint64(b, uint64(v.Value))
+			return b, nil
+		}
+	case *object.Float:
+		if oid == oidFloat8 {
+			b := make([]byte, 8)
+			binary.BigEndian.PutUint64(b, math.Float64bits(v.Value))
+			return b, nil
+		}
+	case *object.Boolean:
+		if oid == oidBool {
+			if v.Value {
+				return []byte{1}, nil
+			}
+			return []byte{0}, nil
+		}
+	case *object.Date:
+		if oid == oidDate {
+			b := make([]byte, 4)
+			binary.BigEndian.PutUint32(b, uint32(v.Value-postgresEpochDays))
+			return b, nil
+		}
+	case *object.Time:
+		if oid == oidTime {
+			b := make([]byte, 8)
+			binary.BigEndian.PutUint64(b, uint64(v.Value))
+			return b, nil
+		}
+	case *object.Timestamp:
+		if oid == oidTimestamp {
+			b := make([]byte, 8)
+			binary.BigEndian.PutUint64(b, uint64(v.Value-postgresEpochDays*object.MicrosecondsPerDay))
+			return b, nil
+		}
+	case *object.TimestampTZ:
+		if oid == oidTimestampTZ {
+			b := make([]byte, 8)
+			binary.BigEndian.PutUint64(b, uint64(v.Value-postgresEpochDays*object.MicrosecondsPerDay))
+			return b, nil
+		}
+	case *object.Interval:
+		if oid == oidInterval {
+			b := make([]byte, 16)
+			binary.BigEndian.PutUint64(b, uint64(v.Microseconds))
+			binary.BigEndian.PutUint32(b[8:], uint32(v.Days))
+			binary.BigEndian.PutUint32(b[12:], uint32(v.Months))
+			return b, nil
+		}
+	case *object.Numeric:
+		if oid == oidNumeric {
+			return encodeNumeric(v), nil
+		}
+	}
+	// the binary format of text is the text itself
+	if oid == oidText {
+		return []byte(textValue(v)), nil
+	}
+	return nil, fmt.Errorf("cannot encode %s as type %d in binary format", v.Type(), oid)
+}
+
+// textValue is the value in the text format, which is how PostgreSQL would print it
+func textValue(v object.Object) string {
+	switch v := v.(type) {
+	case *object.Integer:
+		return strconv.FormatInt(v.Value, 10)
+	case *object.Float:
+		return formatFloat(v.Value)
+	case *object.Boolean:
+		if v.Value {
+			return "t"
+		}
+		return "f"
+	case *object.String:
+		return v.Value
+	case *object.Date, *object.Time, *object.Timestamp, *object.TimestampTZ, *object.Interval, *object.Numeric:
+		return v.(fmt.Stringer).String()
+	}
+	return v.Inspect()
+}
+
+// formatFloat formats like PostgreSQL, which uses the shortest representation, and only uses an exponent for very small and large numbers
+func formatFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	if abs := math.Abs(f); abs == 0 || (abs >= 1e-4 && abs < 1e15) {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'e', -1, 64)
+}
+
+// decodeValue decodes a parameter value sent by the client as a value of the type. Nil is null.
+func decodeValue(b []byte, oid int, format int16) (object.Object, error) {
+	if b == nil {
+		return object.NULL, nil
+	}
+	if format == formatText {
+		return decodeText(string(b), oid)
+	}
+	if format != formatBinary {
+		return nil, fmt.Errorf("unknown format code %d", format)
+	}
+	switch oid {
+	case oidInt2, oidInt4, oidInt8:
+		switch len(b) {
+		case 2:
+			return &object.Integer{Value: int64(int16(binary.BigEndian.Uint16(b)))}, nil
+		case 4:
+			return &object.Integer{Value: int64(int32(binary.BigEndian.Uint32(b)))}, nil
+		case 8:
+			return &object.Integer{Value: int64(binary.BigEndian.Uint64(b))}, nil
+		}
+	case oidFloat4:
+		if len(b) == 4 {
+			return &object.Float{Value: float64(math.Float32frombits(binary.BigEndian.Uint32(b)))}, nil
+		}
+	case oidFloat8:
+		if len(b) == 8 {
+			return &object.Float{Value: math.Float64frombits(binary.BigEndian.Uint64(b))}, nil
+		}
+	case oidBool:
+		if len(b) == 1 {
+			return &object.Boolean{Value: b[0] != 0}, nil
+		}
+	case oidDate:
+		if len(b) == 4 {
+			return &object.Date{Value: int64(int32(binary.BigEndian.Uint32(b))) + postgresEpochDays}, nil
+		}
+	case oidTime:
+		if len(b) == 8 {
+			return &object.Time{Value: int64(binary.BigEndian.Uint64(b))}, nil
+		}
+	case oidTimestamp:
+		if len(b) == 8 {
+			return &object.Timestamp{Value: int64(binary.BigEndian.Uint64(b)) + postgresEpochDays*object.MicrosecondsPerDay}, nil
+		}
+	case oidTimestampTZ:
+		if len(b) == 8 {
+			return &object.TimestampTZ{Value: int64(binary.BigEndian.Uint64(b)) + postgresEpochDays*object.MicrosecondsPerDay}, nil
+		}
+	case oidInterval:
+		if len(b) == 16 {
+			return &object.Interval{
+				Microseconds: int64(binary.BigEndian.Uint64(b)),
+				Days:         int64(int32(binary.BigEndian.Uint32(b[8:]))),
+				Months:       int64(int32(binary.BigEndian.Uint32(b[12:]))),
+			}, nil
+		}
+	case oidNumeric:
+		return decodeNumeric(b)
+	case oidText, oidVarchar, oidBpchar, oidUnknown:
+		return &object.String{Value: string(b)}, nil
+	default:
+		return nil, fmt.Errorf("binary format is not supported for type %d", oid)
+	}
+	return nil, fmt.Errorf("invalid binary value of length %d for type %d", len(b), oid)
+}
+
+func decodeText(s string, oid int) (object.Object, error) {
+	switch oid {
+	case oidInt2, oidInt4, oidInt8:
+		i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid input syntax for type integer: "%s"`, s)
+		}
+		return &object.Integer{Value: i}, nil
+	case oidNumeric:
+		return object.ParseNumeric(s)
+	case oidFloat4, oidFloat8:
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid input syntax for type double precision: "%s"`, s)
+		}
+		return &object.Float{Value: f}, nil
+	case oidBool:
+		switch strings.ToLower(strings.TrimSpace(s)) {
+		case "t", "true", "y", "yes", "on", "1":
+			return &object.Boolean{Value: true}, nil
+		case "f", "false", "n", "no", "off", "0":
+			return &object.Boolean{Value: false}, nil
+		}
+		return nil, fmt.Errorf(`invalid input syntax for type boolean: "%s"`, s)
+	case oidDate:
+		return object.ParseDate(s)
+	case oidTime:
+		return object.ParseTime(s)
+	case oidTimestamp:
+		return object.ParseTimestamp(s)
+	case oidTimestampTZ:
+		return object.ParseTimestampTZ(s)
+	case oidInterval:
+		return object.ParseInterval(s)
+	}
+	return &object.String{Value: s}, nil
+}
+
+// The binary format of numerics is the number of digits, the weight of the first digit, the sign and the scale,
+// each as 16 bits, followed by the digits in base 10000, so that 12.5 is the digits 12 and 5000 with weight 0
+const (
+	numericBase     = 10000
+	numericPositive = 0x0000
+	numericNegative = 0x4000
+	numericNaN      = 0xC000
+)
+
+// encodeNumeric encodes the number in the binary format of numerics, without the digits that are zero at either end
+func encodeNumeric(n *object.Numeric) []byte {
+	digits := new(big.Int).Abs(n.Coefficient).String()
+	scale := int(n.Scale)
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	integerPart, fraction := digits[:len(digits)-scale], digits[len(digits)-scale:]
+	// pad the integer part on the left and the fraction on the right to whole base 10000 digits
+	integerPart = strings.Repeat("0", (4-len(integerPart)%4)%4) + integerPart
+	fraction += strings.Repeat("0", (4-len(fraction)%4)%4)
+	all := integerPart + fraction
+	groups := make([]uint16, len(all)/4)
+	for i := range groups {
+		g, _ := strconv.Atoi(all[4*i : 4*i+4])
+		groups[i] = uint16(g)
+	}
+	weight := len(integerPart)/4 - 1
+	for len(groups) > 0 && groups[0] == 0 {
+		groups = groups[1:]
+		weight--
+	}
+	for len(groups) > 0 && groups[len(groups)-1] == 0 {
+		groups = groups[:len(groups)-1]
+	}
+	if len(groups) == 0 {
+		weight = 0
+	}
+	sign := uint16(numericPositive)
+	if n.Coefficient.Sign() < 0 {
+		sign = numericNegative
+	}
+	b := make([]byte, 8+2*len(groups))
+	binary.BigEndian.PutUint16(b, uint16(len(groups)))
+	binary.BigEndian.PutUint16(b[2:], uint16(int16(weight)))
+	binary.BigEndian.PutUint16(b[4:], sign)
+	binary.BigEndian.PutUint16(b[6:], uint16(scale))
+	for i, g := range groups {
+		binary.BigEndian.PutUint16(b[8+2*i:], g)
+	}
+	return b
+}
+
+// decodeNumeric decodes a number in the binary format of numerics
+func decodeNumeric(b []byte) (object.Object, error) {
+	if len(b) < 8 {
+		return nil, fmt.Errorf("invalid binary value of length %d for type %d", len(b), oidNumeric)
+	}
+	count := int(binary.BigEndian.Uint16(b))
+	weight := int(int16(binary.BigEndian.Uint16(b[2:])))
+	sign := binary.BigEndian.Uint16(b[4:])
+	scale := int(binary.BigEndian.Uint16(b[6:]))
+	if len(b) != 8+2*count {
+		return nil, fmt.Errorf("invalid binary value of length %d for type %d", len(b), oidNumeric)
+	}
+	if sign == numericNaN {
+		return nil, fmt.Errorf("cannot convert NaN to numeric")
+	}
+	if sign != numericPositive && sign != numericNegative || scale > object.MaxNumericPrecision {
+		return nil, fmt.Errorf("invalid binary value for type %d", oidNumeric)
+	}
+	coefficient := new(big.Int)
+	for i := 0; i < count; i++ {
+		digit := binary.BigEndian.Uint16(b[8+2*i:])
+		if digit >= numericBase {
+			return nil, fmt.Errorf("invalid binary value for type %d", oidNumeric)
+		}
+		coefficient.Mul(coefficient, big.NewInt(numericBase))
+		coefficient.Add(coefficient, big.NewInt(int64(digit)))
+	}
+	if sign == numericNegative {
+		coefficient.Neg(coefficient)
+	}
+	// the last digit is worth 10000^(weight - count + 1)
+	exponent := 4 * (weight - count + 1)
+	if count == 0 {
+		exponent = 0
+	}
+	if -exponent > object.MaxNumericPrecision+4 || exponent > object.MaxNumericPrecision {
+		return nil, fmt.Errorf("invalid binary value for type %d", oidNumeric)
+	}
+	n := &object.Numeric{Coefficient: coefficient}
+	if exponent >= 0 {
+		n.Coefficient.Mul(n.Coefficient, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponent)), nil))
+	} else {
+		n.Scale = int32(-exponent)
+	}
+	return n.Round(int32(scale)), nil
+}
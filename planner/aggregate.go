@@ -0,0 +1,174 @@
+package planner
+
+import (
+	"fmt"
+
+	"github.com/vegarsti/sql/ast"
+	"github.com/vegarsti/sql/executor"
+	"github.com/vegarsti/sql/object"
+	"github.com/vegarsti/sql/token"
+)
+
+// isAggregation is true if the statement groups rows, either explicitly with GROUP BY,
+// or by using aggregate functions in the select list or ORDER BY
+func isAggregation(stmt *ast.SelectStatement) bool {
+	if len(stmt.GroupBy) != 0 {
+		return true
+	}
+	for _, e := range stmt.Expressions {
+		if containsAggregate(e) {
+			return true
+		}
+	}
+	for _, o := range stmt.OrderBy {
+		if containsAggregate(o.Expression) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAggregate(node ast.Expression) bool {
+	switch node := node.(type) {
+	case *ast.CallExpression:
+		if executor.IsAggregateFunction(node.Function) {
+			return true
+		}
+		for _, a := range node.Arguments {
+			if containsAggregate(a) {
+				return true
+			}
+		}
+	case *ast.PrefixExpression:
+		return containsAggregate(node.Right)
+	case *ast.InfixExpression:
+		return containsAggregate(node.Left) || containsAggregate(node.Right)
+	case *ast.PostfixExpression:
+		return containsAggregate(node.Left)
+	}
+	return false
+}
+
+// aggregateRewriter rewrites expressions that are evaluated after aggregation,
+// so that they refer to the columns of the rows returned by executor.Aggregate:
+// expressions in GROUP BY become references to the group columns, and
+// aggregate function calls become references to the aggregate columns.
+type aggregateRewriter struct {
+	groupBy    []ast.Expression
+	aggregates []*ast.CallExpression
+}
+
+func (r *aggregateRewriter) rewriteAll(expressions []ast.Expression) ([]ast.Expression, error) {
+	rewritten := make([]ast.Expression, len(expressions))
+	for i, e := range expressions {
+		var err error
+		if rewritten[i], err = r.rewrite(e); err != nil {
+			return nil, err
+		}
+	}
+	return rewritten, nil
+}
+
+func (r *aggregateRewriter) rewrite(node ast.Expression) (ast.Expression, error) {
+	key := expressionKey(node)
+	for i, e := range r.groupBy {
+		if expressionKey(e) == key {
+			return aggregateColumn(executor.GroupColumnTable(i), e), nil
+		}
+	}
+	switch node := node.(type) {
+	case *ast.CallExpression:
+		if !executor.IsAggregateFunction(node.Function) {
+			// a call of a scalar function is evaluated after aggregation, for the rewritten arguments,
+			// and the evaluator reports functions that don't exist
+			arguments, err := r.rewriteAll(node.Arguments)
+			if err != nil {
+				return nil, err
+			}
+			return &ast.CallExpression{Token: node.Token, Function: node.Function, Arguments: arguments, Star: node.Star}, nil
+		}
+		for _, a := range node.Arguments {
+			if containsAggregate(a) {
+				return nil, object.Errorf(object.CodeGroupingError, "aggregate function calls cannot be nested")
+			}
+		}
+		if node.Star && node.Function != "count" {
+			return nil, object.Errorf(object.CodeUndefinedFunction, "%s(*) is not allowed", node.Function)
+		}
+		if !node.Star && len(node.Arguments) != 1 {
+			return nil, object.Errorf(object.CodeUndefinedFunction, "function %s takes exactly one argument, got %d", node.Function, len(node.Arguments))
+		}
+		for i, a := range r.aggregates {
+			if expressionKey(a) == key {
+				return aggregateColumn(executor.AggregateColumnTable(i), a), nil
+			}
+		}
+		r.aggregates = append(r.aggregates, node)
+		return aggregateColumn(executor.AggregateColumnTable(len(r.aggregates)-1), node), nil
+	case *ast.Identifier:
+		return nil, object.Errorf(object.CodeGroupingError, `column "%s.%s" must appear in the GROUP BY clause or be used in an aggregate function`, node.Table, node.Value)
+	case *ast.PrefixExpression:
+		right, err := r.rewrite(node.Right)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.PrefixExpression{Token: node.Token, Operator: node.Operator, Right: right}, nil
+	case *ast.InfixExpression:
+		left, err := r.rewrite(node.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := r.rewrite(node.Right)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.InfixExpression{Token: node.Token, Left: left, Operator: node.Operator, Right: right}, nil
+	case *ast.PostfixExpression:
+		left, err := r.rewrite(node.Left)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.PostfixExpression{Token: node.Token, Operator: node.Operator, Left: left}, nil
+	}
+	// literals don't need to be rewritten
+	return node, nil
+}
+
+// aggregateColumn is an identifier referring to a group or aggregate column returned by executor.Aggregate
+func aggregateColumn(table string, replaced ast.Expression) *ast.Identifier {
+	return &ast.Identifier{
+		Token: token.Token{Type: token.IDENTIFIER, Literal: replaced.String()},
+		Value: replaced.String(),
+		Table: table,
+	}
+}
+
+// expressionKey returns a string that is equal for two expressions exactly when they are the same expression.
+// Unlike String(), identifiers include their table name.
+func expressionKey(node ast.Expression) string {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		return node.Table + "." + node.Value
+	case *ast.PrefixExpression:
+		return "(" + node.Operator + expressionKey(node.Right) + ")"
+	case *ast.InfixExpression:
+		return "(" + expressionKey(node.Left) + " " + node.Operator + " " + expressionKey(node.Right) + ")"
+	case *ast.PostfixExpression:
+		return "(" + expressionKey(node.Left) + " " + node.Operator + ")"
+	case *ast.CallExpression:
+		if node.Star {
+			return node.Function + "(*)"
+		}
+		key := node.Function + "("
+		for i, a := range node.Arguments {
+			if i > 0 {
+				key += ", "
+			}
+			key += expressionKey(a)
+		}
+		return key + ")"
+	case *ast.StringLiteral:
+		return "'" + node.Value + "'"
+	}
+	return fmt.Sprintf("%T %s", node, node.String())
+}
@@ -0,0 +1,564 @@
+// Package planner turns a select statement into a logical query plan.
+//
+// The plan decides which tables are joined in which order, and where each predicate is evaluated:
+// predicates on a single table are pushed down to the scan of that table,
+// and predicates on several tables are evaluated by the lowest join where all those tables are available.
+// The evaluator turns the logical plan into executor operators.
+package planner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vegarsti/sql/ast"
+	"github.com/vegarsti/sql/executor"
+	"github.com/vegarsti/sql/object"
+)
+
+// Node is a node in a logical query plan.
+type Node interface {
+	Children() []Node
+	String() string
+}
+
+// Estimator estimates the number of rows in a table.
+type Estimator interface {
+	EstimateRows(table string) int
+}
+
+// StatisticsEstimator is implemented by estimators that know the statistics of tables, as computed by ANALYZE.
+// Statistics returns nil for tables without statistics.
+type StatisticsEstimator interface {
+	Statistics(table string) *object.TableStatistics
+}
+
+// Values is a single empty row, used for a select without FROM.
+type Values struct{}
+
+func (v *Values) Children() []Node { return nil }
+func (v *Values) String() string   { return "Values" }
+
+// Scan reads a table and keeps the rows for which all the predicates are true.
+type Scan struct {
+	Table string
+	// Alias is the name the table is referenced by in the statement, if it has an alias
+	Alias      string
+	Predicates []ast.Expression
+	// Rows is the estimated number of rows returned by the scan
+	Rows int
+}
+
+// Name returns the name the identifiers of the statement refer to the table by: its alias, or the table name
+func (s *Scan) Name() string {
+	if s.Alias != "" {
+		return s.Alias
+	}
+	return s.Table
+}
+
+func (s *Scan) Children() []Node { return nil }
+func (s *Scan) String() string {
+	table := s.Table
+	if s.Alias != "" {
+		table += " " + s.Alias
+	}
+	if len(s.Predicates) == 0 {
+		return fmt.Sprintf("Scan %s (rows=%d)", table, s.Rows)
+	}
+	return fmt.Sprintf("Scan %s where %s (rows=%d)", table, expressionList(s.Predicates, " AND "), s.Rows)
+}
+
+// Join is an inner join of two plans. Predicates come from JOIN ... ON, and
+// Filters from WHERE. A join without predicates or filters is a cross join.
+type Join struct {
+	Left       Node
+	Right      Node
+	Predicates []ast.Expression
+	Filters    []ast.Expression
+}
+
+func (j *Join) Children() []Node { return []Node{j.Left, j.Right} }
+func (j *Join) String() string {
+	var conditions []ast.Expression
+	conditions = append(conditions, j.Predicates...)
+	conditions = append(conditions, j.Filters...)
+	if len(conditions) == 0 {
+		return "Cross Join"
+	}
+	return "Join on " + expressionList(conditions, " AND ")
+}
+
+// Filter keeps the rows for which all the predicates are true.
+type Filter struct {
+	Child      Node
+	Predicates []ast.Expression
+}
+
+func (f *Filter) Children() []Node { return []Node{f.Child} }
+func (f *Filter) String() string   { return "Filter " + expressionList(f.Predicates, " AND ") }
+
+// Aggregate groups rows, see executor.Aggregate for the rows it returns.
+type Aggregate struct {
+	Child      Node
+	GroupBy    []ast.Expression
+	Aggregates []*ast.CallExpression
+}
+
+func (a *Aggregate) Children() []Node { return []Node{a.Child} }
+func (a *Aggregate) String() string {
+	aggregates := make([]ast.Expression, len(a.Aggregates))
+	for i, call := range a.Aggregates {
+		aggregates[i] = call
+	}
+	if len(a.GroupBy) == 0 {
+		return "Aggregate " + expressionList(aggregates, ", ")
+	}
+	return "Aggregate " + expressionList(aggregates, ", ") + " group by " + expressionList(a.GroupBy, ", ")
+}
+
+// Sort orders rows by a list of expressions.
+type Sort struct {
+	Child Node
+	Keys  []*ast.OrderByExpression
+}
+
+func (s *Sort) Children() []Node { return []Node{s.Child} }
+func (s *Sort) String() string {
+	keys := make([]string, len(s.Keys))
+	for i, k := range s.Keys {
+		keys[i] = k.Expression.String()
+		if k.Descending {
+			keys[i] += " DESC"
+		}
+	}
+	return "Sort " + strings.Join(keys, ", ")
+}
+
+// Limit skips Offset rows and returns at most Limit rows. A nil Limit means no limit.
+type Limit struct {
+	Child  Node
+	Limit  *int
+	Offset int
+}
+
+func (l *Limit) Children() []Node { return []Node{l.Child} }
+func (l *Limit) String() string {
+	if l.Limit == nil {
+		return fmt.Sprintf("Limit offset %d", l.Offset)
+	}
+	return fmt.Sprintf("Limit %d offset %d", *l.Limit, l.Offset)
+}
+
+// Project evaluates the select list.
+type Project struct {
+	Child       Node
+	Expressions []ast.Expression
+	Aliases     []string
+}
+
+func (p *Project) Children() []Node { return []Node{p.Child} }
+func (p *Project) String() string   { return "Project " + expressionList(p.Expressions, ", ") }
+
+// Format returns the plan as an indented tree, one node per line.
+func Format(node Node) string {
+	var lines []string
+	var format func(Node, int)
+	format = func(node Node, depth int) {
+		lines = append(lines, strings.Repeat("  ", depth)+node.String())
+		for _, child := range node.Children() {
+			format(child, depth+1)
+		}
+	}
+	format(node, 0)
+	return strings.Join(lines, "\n")
+}
+
+// Plan returns the logical plan for a select statement.
+// The identifiers in the statement must have been normalized, so that all identifiers have a table name.
+func Plan(stmt *ast.SelectStatement, estimator Estimator) (Node, error) {
+	var plan Node = &Values{}
+	var where []ast.Expression
+	if stmt.Where != nil {
+		if containsAggregate(stmt.Where) {
+			return nil, object.Errorf(object.CodeGroupingError, "aggregate functions are not allowed in WHERE")
+		}
+		where = conjuncts(stmt.Where)
+	}
+	if len(stmt.From) != 0 {
+		var err error
+		plan, where, err = planJoins(stmt.From, where, estimator)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// predicates that don't reference any table are evaluated after the joins
+	if len(where) != 0 {
+		plan = &Filter{Child: plan, Predicates: where}
+	}
+
+	expressions := stmt.Expressions
+	orderBy := make([]*ast.OrderByExpression, len(stmt.OrderBy))
+	copy(orderBy, stmt.OrderBy)
+	if isAggregation(stmt) {
+		for _, e := range stmt.GroupBy {
+			if containsAggregate(e) {
+				return nil, object.Errorf(object.CodeGroupingError, "aggregate functions are not allowed in GROUP BY")
+			}
+		}
+		r := &aggregateRewriter{groupBy: stmt.GroupBy}
+		var err error
+		if expressions, err = r.rewriteAll(expressions); err != nil {
+			return nil, err
+		}
+		for i, o := range stmt.OrderBy {
+			e, err := r.rewrite(o.Expression)
+			if err != nil {
+				return nil, err
+			}
+			orderBy[i] = &ast.OrderByExpression{Expression: e, Descending: o.Descending}
+		}
+		plan = &Aggregate{Child: plan, GroupBy: stmt.GroupBy, Aggregates: r.aggregates}
+	}
+
+	if len(orderBy) != 0 {
+		plan = &Sort{Child: plan, Keys: orderBy}
+	}
+
+	if stmt.Limit != nil || stmt.Offset != nil {
+		offset := 0
+		if stmt.Offset != nil {
+			offset = *stmt.Offset
+		}
+		plan = &Limit{Child: plan, Limit: stmt.Limit, Offset: offset}
+	}
+
+	return &Project{Child: plan, Expressions: expressions, Aliases: stmt.Aliases}, nil
+}
+
+// predicate is a conjunct from WHERE or from JOIN ... ON, and the tables it references
+type predicate struct {
+	expression ast.Expression
+	tables     map[string]bool
+	fromOn     bool
+	placed     bool
+}
+
+// planJoins chooses the join order of the tables in the FROM clause, and places the predicates.
+// Comma separated tables and explicit joins are treated the same, since all joins are inner joins.
+// The predicates in where that are not placed in the joins are returned.
+func planJoins(froms []*ast.From, where []ast.Expression, estimator Estimator) (Node, []ast.Expression, error) {
+	var tables []string
+	scans := make(map[string]*Scan)
+	addScan := func(from *ast.From) {
+		scan := &Scan{Table: from.Table, Alias: from.TableAlias}
+		tables = append(tables, scan.Name())
+		scans[scan.Name()] = scan
+	}
+	var predicates []*predicate
+	for _, from := range froms {
+		addScan(from)
+		for from.Join != nil {
+			if containsAggregate(from.Join.Predicate) {
+				return nil, nil, object.Errorf(object.CodeGroupingError, "aggregate functions are not allowed in JOIN conditions")
+			}
+			for _, e := range conjuncts(from.Join.Predicate) {
+				predicates = append(predicates, &predicate{expression: e, tables: TablesInExpression(e), fromOn: true})
+			}
+			addScan(from.Join.With)
+			from = from.Join.With
+		}
+	}
+	var remaining []ast.Expression
+	for _, e := range where {
+		p := &predicate{expression: e, tables: TablesInExpression(e)}
+		if len(p.tables) == 0 {
+			remaining = append(remaining, e)
+			continue
+		}
+		predicates = append(predicates, p)
+	}
+
+	// push predicates on a single table from WHERE down to the scan
+	for _, table := range tables {
+		scan := scans[table]
+		for _, p := range predicates {
+			if !p.fromOn && len(p.tables) == 1 && p.tables[table] {
+				scan.Predicates = append(scan.Predicates, p.expression)
+				p.placed = true
+			}
+		}
+		scan.Rows = estimateScan(scan, estimator)
+	}
+
+	order := joinOrder(tables, scans, predicates)
+	var plan Node = scans[order[0]]
+	joined := map[string]bool{order[0]: true}
+	for i, table := range order[1:] {
+		joined[table] = true
+		join := &Join{Left: plan, Right: scans[table]}
+		for _, p := range predicates {
+			if p.placed {
+				continue
+			}
+			// predicates from ON that don't reference any table are evaluated in the first join
+			if !subset(p.tables, joined) && !(i == 0 && p.fromOn && len(p.tables) == 0) {
+				continue
+			}
+			if p.fromOn {
+				join.Predicates = append(join.Predicates, p.expression)
+			} else {
+				join.Filters = append(join.Filters, p.expression)
+			}
+			p.placed = true
+		}
+		plan = join
+	}
+	for _, p := range predicates {
+		if !p.placed {
+			// this is a predicate that references a table that is not in FROM,
+			// which the identifier normalization should have caught
+			return nil, nil, fmt.Errorf("could not place predicate %s", p.expression.String())
+		}
+	}
+	return plan, remaining, nil
+}
+
+// joinOrder orders the tables greedily: first the table with the fewest estimated rows,
+// then repeatedly the smallest of the tables that is connected by a predicate to the tables already joined,
+// to avoid cartesian products. If no table is connected, the smallest remaining table is used.
+// Ties are broken by the order the tables are listed in the statement.
+func joinOrder(tables []string, scans map[string]*Scan, predicates []*predicate) []string {
+	var order []string
+	joined := make(map[string]bool)
+	for len(order) < len(tables) {
+		best := ""
+		bestConnected := false
+		for _, table := range tables {
+			if joined[table] {
+				continue
+			}
+			connected := false
+			for _, p := range predicates {
+				if p.tables[table] && len(p.tables) > 1 && overlaps(p.tables, joined) {
+					connected = true
+				}
+			}
+			if best == "" ||
+				(connected && !bestConnected) ||
+				(connected == bestConnected && scans[table].Rows < scans[best].Rows) {
+				best = table
+				bestConnected = connected
+			}
+		}
+		order = append(order, best)
+		joined[best] = true
+	}
+	return order
+}
+
+// estimateScan estimates the number of rows returned by a scan:
+// each equality predicate is assumed to keep a tenth of the rows, and any other predicate a third,
+// unless the table has statistics that tell the selectivity of the predicate.
+func estimateScan(scan *Scan, estimator Estimator) int {
+	rows := float64(estimator.EstimateRows(scan.Table))
+	var stats *object.TableStatistics
+	if statisticsEstimator, ok := estimator.(StatisticsEstimator); ok {
+		stats = statisticsEstimator.Statistics(scan.Table)
+	}
+	for _, p := range scan.Predicates {
+		if selectivity, ok := estimateSelectivity(p, stats); ok {
+			rows *= selectivity
+		} else if infix, ok := p.(*ast.InfixExpression); ok && infix.Operator == "=" {
+			rows /= 10
+		} else {
+			rows /= 3
+		}
+	}
+	if rows < 1 {
+		return 1
+	}
+	return int(rows)
+}
+
+// estimateSelectivity estimates the fraction of rows for which the predicate is true, using the table statistics.
+// Equality with a constant keeps one distinct value, IS NULL and IS NOT NULL use the null fraction,
+// and numeric comparisons with a constant assume the values are evenly spread between the minimum and maximum.
+// ok is false if the statistics don't tell anything about the predicate.
+func estimateSelectivity(predicate ast.Expression, stats *object.TableStatistics) (float64, bool) {
+	if stats == nil {
+		return 0, false
+	}
+	columnStatistics := func(e ast.Expression) *object.ColumnStatistics {
+		identifier, ok := e.(*ast.Identifier)
+		if !ok {
+			return nil
+		}
+		return stats.Column(identifier.Value)
+	}
+	switch predicate := predicate.(type) {
+	case *ast.PostfixExpression:
+		c := columnStatistics(predicate.Left)
+		if c == nil {
+			return 0, false
+		}
+		if predicate.Operator == "IS NULL" {
+			return c.NullFraction, true
+		}
+		return 1 - c.NullFraction, true
+	case *ast.InfixExpression:
+		operator := predicate.Operator
+		c, constant := columnStatistics(predicate.Left), predicate.Right
+		if c == nil {
+			c, constant, operator = columnStatistics(predicate.Right), predicate.Left, flipComparison(operator)
+		}
+		if c == nil || len(TablesInExpression(constant)) != 0 {
+			return 0, false
+		}
+		nonNull := 1 - c.NullFraction
+		switch operator {
+		case "=":
+			if c.DistinctCount == 0 {
+				return 0, true
+			}
+			return nonNull / float64(c.DistinctCount), true
+		case "<", "<=", ">", ">=":
+			value, ok := numericValue(constant)
+			min, minOK := numericObject(c.Min)
+			max, maxOK := numericObject(c.Max)
+			if !ok || !minOK || !maxOK {
+				return 0, false
+			}
+			if max == min {
+				return nonNull / 2, true
+			}
+			below := (value - min) / (max - min)
+			if below < 0 {
+				below = 0
+			}
+			if below > 1 {
+				below = 1
+			}
+			if operator == "<" || operator == "<=" {
+				return nonNull * below, true
+			}
+			return nonNull * (1 - below), true
+		}
+	}
+	return 0, false
+}
+
+// flipComparison returns the operator for the comparison with the sides swapped, so that a < b is b > a.
+func flipComparison(operator string) string {
+	switch operator {
+	case "<":
+		return ">"
+	case "<=":
+		return ">="
+	case ">":
+		return "<"
+	case ">=":
+		return "<="
+	}
+	return operator
+}
+
+func numericValue(e ast.Expression) (float64, bool) {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		return float64(e.Value), true
+	case *ast.FloatLiteral:
+		return e.Value, true
+	case *ast.PrefixExpression:
+		if v, ok := numericValue(e.Right); ok && e.Operator == "-" {
+			return -v, true
+		}
+	}
+	return 0, false
+}
+
+func numericObject(o object.Object) (float64, bool) {
+	switch o := o.(type) {
+	case *object.Integer:
+		return float64(o.Value), true
+	case *object.Float:
+		return o.Value, true
+	}
+	return 0, false
+}
+
+// conjuncts splits an expression on AND
+func conjuncts(node ast.Expression) []ast.Expression {
+	if infix, ok := node.(*ast.InfixExpression); ok && infix.Operator == "AND" {
+		return append(conjuncts(infix.Left), conjuncts(infix.Right)...)
+	}
+	return []ast.Expression{node}
+}
+
+// TablesInExpression returns the set of tables referenced by the identifiers in the expression.
+func TablesInExpression(node ast.Expression) map[string]bool {
+	tables := make(map[string]bool)
+	var walk func(ast.Expression)
+	walk = func(node ast.Expression) {
+		switch node := node.(type) {
+		case *ast.Identifier:
+			if !executor.IsAggregateColumnTable(node.Table) {
+				tables[node.Table] = true
+			}
+		case *ast.PrefixExpression:
+			walk(node.Right)
+		case *ast.InfixExpression:
+			walk(node.Left)
+			walk(node.Right)
+		case *ast.PostfixExpression:
+			walk(node.Left)
+		case *ast.CallExpression:
+			for _, a := range node.Arguments {
+				walk(a)
+			}
+		}
+	}
+	walk(node)
+	return tables
+}
+
+// Tables returns the names of the tables read by the plan, as the identifiers refer to them.
+func Tables(node Node) map[string]bool {
+	tables := make(map[string]bool)
+	var walk func(Node)
+	walk = func(node Node) {
+		if scan, ok := node.(*Scan); ok {
+			tables[scan.Name()] = true
+		}
+		for _, child := range node.Children() {
+			walk(child)
+		}
+	}
+	walk(node)
+	return tables
+}
+
+func subset(a map[string]bool, b map[string]bool) bool {
+	for k := range a {
+		if !b[k] {
+			return false
+		}
+	}
+	return true
+}
+
+func overlaps(a map[string]bool, b map[string]bool) bool {
+	for k := range a {
+		if b[k] {
+			return true
+		}
+	}
+	return false
+}
+
+func expressionList(expressions []ast.Expression, separator string) string {
+	s := make([]string, len(expressions))
+	for i, e := range expressions {
+		s[i] = e.String()
+	}
+	return strings.Join(s, separator)
+}
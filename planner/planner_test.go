@@ -0,0 +1,198 @@
+package planner_test
+
+import (
+	"testing"
+
+	"github.com/vegarsti/sql/ast"
+	"github.com/vegarsti/sql/lexer"
+	"github.com/vegarsti/sql/object"
+	"github.com/vegarsti/sql/parser"
+	"github.com/vegarsti/sql/planner"
+)
+
+type estimator map[string]int
+
+func (e estimator) EstimateRows(table string) int { return e[table] }
+
+func TestPlan(t *testing.T) {
+	sizes := estimator{"small": 10, "medium": 100, "large": 1000}
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"select 1",
+			`Project 1
+  Values`,
+		},
+		{
+			"select 1 where false",
+			`Project 1
+  Filter FALSE
+    Values`,
+		},
+		{
+			"select large.a from large where large.a = 1 and (large.b > 2)",
+			`Project a
+  Scan large where (a = 1) AND (b > 2) (rows=33)`,
+		},
+		{
+			// predicates are pushed down, and the comma join becomes an inner join on the equality
+			"select large.a from large, small where large.id = small.id and small.b = 1 and (large.c < 1)",
+			`Project a
+  Join on (id = id)
+    Scan small where (b = 1) (rows=1)
+    Scan large where (c < 1) (rows=333)`,
+		},
+		{
+			// tables connected by predicates are joined before unconnected ones, even if they are larger
+			"select 1 from medium, large, small where small.id = large.id",
+			`Project 1
+  Cross Join
+    Join on (id = id)
+      Scan small (rows=10)
+      Scan large (rows=1000)
+    Scan medium (rows=100)`,
+		},
+		{
+			"select 1 from large join medium on large.id = medium.id join small on medium.id = small.id",
+			`Project 1
+  Join on (id = id)
+    Join on (id = id)
+      Scan small (rows=10)
+      Scan medium (rows=100)
+    Scan large (rows=1000)`,
+		},
+		{
+			// predicates from ON are not pushed down, and ON predicates without tables are evaluated in the first join
+			"select 1 from large join small on small.a = 1 and true",
+			`Project 1
+  Join on (a = 1) AND TRUE
+    Scan small (rows=10)
+    Scan large (rows=1000)`,
+		},
+		{
+			// the join order doesn't depend on the order in the statement
+			"select 1 from large, medium, small where (small.x = medium.x) or (small.y = medium.y)",
+			`Project 1
+  Cross Join
+    Join on ((x = x) OR (y = y))
+      Scan small (rows=10)
+      Scan medium (rows=100)
+    Scan large (rows=1000)`,
+		},
+		{
+			// a table listed twice is told apart by its aliases
+			"select 1 from large l join small on l.id = small.id, large m where small.a = 1",
+			`Project 1
+  Cross Join
+    Join on (id = id)
+      Scan small where (a = 1) (rows=1)
+      Scan large l (rows=1000)
+    Scan large m (rows=1000)`,
+		},
+		{
+			"select small.a, count(*) from small group by small.a order by count(*) desc limit 1",
+			`Project a, count(*)
+  Limit 1 offset 0
+    Sort count(*) DESC
+      Aggregate count(*) group by a
+        Scan small (rows=10)`,
+		},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("%s: parser errors: %v", tt.input, p.Errors())
+		}
+		plan, err := planner.Plan(program.Statements[0].(*ast.SelectStatement), sizes)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.input, err)
+		}
+		got := planner.Format(plan)
+		if got != tt.expected {
+			t.Fatalf("%s: expected plan\n%s\ngot\n%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+type statisticsEstimator struct {
+	estimator
+	stats map[string]*object.TableStatistics
+}
+
+func (e statisticsEstimator) Statistics(table string) *object.TableStatistics { return e.stats[table] }
+
+func TestPlanWithStatistics(t *testing.T) {
+	sizes := statisticsEstimator{
+		estimator: estimator{"t": 1000, "u": 1000},
+		stats: map[string]*object.TableStatistics{"t": {
+			RowCount: 1000,
+			Columns: []object.ColumnStatistics{
+				{Name: "id", DistinctCount: 1000, Min: &object.Integer{Value: 1}, Max: &object.Integer{Value: 1000}},
+				{Name: "kind", DistinctCount: 2, NullFraction: 0.5, Min: &object.String{Value: "a"}, Max: &object.String{Value: "b"}},
+			},
+		}},
+	}
+	tests := []struct {
+		input        string
+		expectedScan string
+	}{
+		{"select t.id from t", "Scan t (rows=1000)"},
+		{"select t.id from t where t.id = 7", "Scan t where (id = 7) (rows=1)"},
+		{"select t.id from t where t.kind = 'a'", "Scan t where (kind = 'a') (rows=250)"},
+		{"select t.id from t where 'a' = t.kind", "Scan t where ('a' = kind) (rows=250)"},
+		{"select t.id from t where t.kind is null", "Scan t where (kind IS NULL) (rows=500)"},
+		{"select t.id from t where t.kind is not null", "Scan t where (kind IS NOT NULL) (rows=500)"},
+		{"select t.id from t where t.id < 101", "Scan t where (id < 101) (rows=100)"},
+		{"select t.id from t where 101 > t.id", "Scan t where (101 > id) (rows=100)"},
+		{"select t.id from t where t.id >= 2000", "Scan t where (id >= 2000) (rows=1)"},
+		// without statistics for the column or the table, the defaults are used
+		{"select t.id from t where t.other = 1", "Scan t where (other = 1) (rows=100)"},
+		{"select u.id from u where u.id = 1", "Scan u where (id = 1) (rows=100)"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("%s: parser errors: %v", tt.input, p.Errors())
+		}
+		plan, err := planner.Plan(program.Statements[0].(*ast.SelectStatement), sizes)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.input, err)
+		}
+		scan := plan.Children()[0]
+		if scan.String() != tt.expectedScan {
+			t.Fatalf("%s: expected %q. got=%q", tt.input, tt.expectedScan, scan.String())
+		}
+	}
+}
+
+func TestPlanErrors(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"select 1 from small where count(*) > 1", "aggregate functions are not allowed in WHERE"},
+		{"select 1 from small join large on count(*) > 1", "aggregate functions are not allowed in JOIN conditions"},
+		{"select small.a from small group by count(*)", "aggregate functions are not allowed in GROUP BY"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("%s: parser errors: %v", tt.input, p.Errors())
+		}
+		_, err := planner.Plan(program.Statements[0].(*ast.SelectStatement), estimator{})
+		if err == nil {
+			t.Fatalf("%s: expected error", tt.input)
+		}
+		if err.Error() != tt.expectedError {
+			t.Fatalf("%s: expected error %q. got=%q", tt.input, tt.expectedError, err.Error())
+		}
+	}
+}
@@ -19,6 +19,7 @@ const (
 	QUALIFIEDIDENTIFIER = "QUALIFIEDIDENTIFIER"
 	BOOL_LITERAL        = "BOOL_LITERAL"
 	NULL                = "NULL"
+	PARAMETER           = "PARAMETER" // $1 or ?
 
 	// Operators
 	PLUS                = "+"
@@ -36,29 +37,42 @@ const (
 	PERCENT             = "%"
 
 	// Keywords
-	SELECT = "SELECT"
-	AS     = "AS"
-	CREATE = "CREATE"
-	TABLE  = "TABLE"
-	INSERT = "INSERT"
-	INTO   = "INTO"
-	VALUES = "VALUES"
-	FROM   = "FROM"
-	ORDER  = "ORDER"
-	BY     = "BY"
-	DESC   = "DESC"
-	ASC    = "ASC"
-	FALSE  = "FALSE"
-	TRUE   = "TRUE"
-	AND    = "AND"
-	OR     = "OR"
-	LIMIT  = "LIMIT"
-	OFFSET = "OFFSET"
-	WHERE  = "WHERE"
-	JOIN   = "JOIN"
-	ON     = "ON"
-	IS     = "IS"
-	NOT    = "NOT"
+	SELECT     = "SELECT"
+	AS         = "AS"
+	CREATE     = "CREATE"
+	TABLE      = "TABLE"
+	INSERT     = "INSERT"
+	INTO       = "INTO"
+	VALUES     = "VALUES"
+	FROM       = "FROM"
+	ORDER      = "ORDER"
+	BY         = "BY"
+	DESC       = "DESC"
+	ASC        = "ASC"
+	FALSE      = "FALSE"
+	TRUE       = "TRUE"
+	AND        = "AND"
+	OR         = "OR"
+	LIMIT      = "LIMIT"
+	OFFSET     = "OFFSET"
+	WHERE      = "WHERE"
+	JOIN       = "JOIN"
+	ON         = "ON"
+	IS         = "IS"
+	NOT        = "NOT"
+	GROUP      = "GROUP"
+	EXPLAIN    = "EXPLAIN"
+	ANALYZE    = "ANALYZE"
+	PREPARE    = "PREPARE"
+	EXECUTE    = "EXECUTE"
+	DEALLOCATE = "DEALLOCATE"
+	ALL        = "ALL"
+	BEGIN      = "BEGIN"
+	COMMIT     = "COMMIT"
+	ROLLBACK   = "ROLLBACK"
+	DELETE     = "DELETE"
+	SET        = "SET"
+	TO         = "TO"
 
 	// Types
 	STRING_TYPE  = "STRING"
@@ -66,6 +80,16 @@ const (
 	INTEGER_TYPE = "INTEGER"
 	BOOLEAN_TYPE = "BOOLEAN"
 
+	// Temporal types, which are also the types of literals such as DATE '2024-01-01'
+	DATE_TYPE        = "DATE"
+	TIME_TYPE        = "TIME"
+	TIMESTAMP_TYPE   = "TIMESTAMP"
+	TIMESTAMPTZ_TYPE = "TIMESTAMPTZ"
+	INTERVAL_TYPE    = "INTERVAL"
+
+	// NUMERIC or DECIMAL, the type of exact decimal numbers such as NUMERIC '12.50'
+	NUMERIC_TYPE = "NUMERIC"
+
 	// Delimiters
 	LPAREN    = "("
 	RPAREN    = ")"